@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -20,12 +21,16 @@ import (
 	"syscall"
 	"time"
 	"url-shortener/config"
+	"url-shortener/internal/access"
+	"url-shortener/internal/audit"
+	"url-shortener/internal/handler/gateway"
 	grpchandler "url-shortener/internal/handler/grpc"
 	resthandler "url-shortener/internal/handler/rest"
+	"url-shortener/internal/idempotency"
 	"url-shortener/internal/repository"
 	"url-shortener/internal/routes"
-	"url-shortener/internal/storage/db/queries"
 	"url-shortener/internal/usecase"
+	"url-shortener/internal/webhook"
 	shortener "url-shortener/pkg/api"
 )
 
@@ -50,22 +55,68 @@ func main() {
 		log.Fatalf("Failed to initialize: %s", err.Error())
 	}
 
-	logic := usecase.New(storage)
+	if cfg.DBConfig.Migrate == repository.MigrateOnly {
+		log.Println("Migrations are applied")
+
+		if err = storage.Shutdown(); err != nil {
+			log.Println("Failed to shutdown storage: ", err)
+		}
+
+		return
+	}
+
+	hooks, err := webhook.New(cfg.Webhooks, storage)
+	if err != nil {
+		log.Fatalf("Failed to initialize webhooks: %s", err.Error())
+	}
+
+	logic := usecase.New(storage).WithWebhooks(hooks)
 	router := gin.Default()
-	h := resthandler.NewHandler(cfg, logic)
+	guard := access.New(cfg.Access)
+	h := resthandler.NewHandler(cfg, logic).WithGuard(guard)
+	idem := idempotency.New(storage, cfg.IdempotencyTTL).WithGuard(guard)
 
-	public := router.Group("/")
-	routes.PublicRoutes(public, h)
+	public := router.Group("/", audit.Handler(guard, ""))
+	routes.PublicRoutes(public, h, idem)
+	routes.V2Routes(public, resthandler.NewHandlerV2(cfg, logic, guard), idem)
 
 	router.Use(gzip.Gzip(gzip.BestSpeed))
 
+	if cfg.GRPC != "" && cfg.GatewayPrefix != "" {
+		gw, err := gateway.New(context.Background(), cfg.GRPC, guard)
+		if err != nil {
+			log.Fatalf("Failed to initialize gateway: %s", err.Error())
+		}
+
+		routes.GatewayRoutes(public, cfg.GatewayPrefix, gw)
+	}
+
+	if cfg.AdminHost != "" {
+		go func() {
+			log.Println("Admin server is running on http://" + cfg.AdminHost)
+			admin := gin.Default()
+			routes.AdminRoutes(admin.Group("/"), h, guard)
+
+			err := admin.Run(cfg.AdminHost)
+			if err != nil {
+				log.Fatalf("admin server Run: %v", err)
+			}
+		}()
+	}
+
 	if cfg.GRPC != "" {
 		go func() {
 			log.Println("Server is running on grpc://" + cfg.GRPC)
-			grpcServer := grpc.NewServer()
-			ghandler := grpchandler.NewHandler(cfg, logic)
-
-			lis, err := net.Listen("tcp", cfg.Host)
+			grpcServer := grpc.NewServer(
+				grpc.ChainUnaryInterceptor(
+					guard.UnaryServerInterceptor(grpchandler.AdminMethods...),
+					idem.UnaryServerInterceptor(grpchandler.IdempotentMethods...),
+					audit.UnaryServerInterceptor(guard),
+				),
+			)
+			ghandler := grpchandler.NewHandler(cfg, logic).WithGuard(guard)
+
+			lis, err := net.Listen("tcp", cfg.GRPC)
 			if err != nil {
 				log.Fatalf("failed to listen: %v", err)
 			}
@@ -154,12 +205,11 @@ func main() {
 
 	log.Println("Shutdown Server ...")
 
-	err = storage.Shutdown()
-	if err != nil {
-		log.Println("Failed to shutdown storage: ", err)
+	if err = hooks.Close(); err != nil {
+		log.Println("Failed to close webhooks: ", err)
 	}
 
-	err = queries.Close()
+	err = storage.Shutdown()
 	if err != nil {
 		log.Println("Failed to shutdown storage: ", err)
 	}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"url-shortener/config"
+	"url-shortener/internal/repository"
+	"url-shortener/internal/storage"
+	boltstorage "url-shortener/internal/storage/bolt"
+	"url-shortener/internal/storage/db/migration"
+	"url-shortener/internal/storage/db/service"
+	filestorage "url-shortener/internal/storage/file"
+	mapstorage "url-shortener/internal/storage/map"
+	redisstorage "url-shortener/internal/storage/redis"
+	"url-shortener/internal/storage/shard"
+	"url-shortener/internal/transfer"
+	"url-shortener/internal/usecase"
+)
+
+// app runs the commands against the configured storage.
+type app struct {
+	cfg  *config.Config
+	in   io.Reader
+	out  io.Writer
+	json bool
+
+	storage storage.IStorage
+}
+
+var commands = map[string]func(a *app, ctx context.Context, args []string) error{
+	"lookup":    (*app).lookup,
+	"create":    (*app).create,
+	"disable":   (*app).disable,
+	"purge":     (*app).purge,
+	"list":      (*app).list,
+	"stats":     (*app).stats,
+	"migrate":   (*app).migrate,
+	"export":    (*app).export,
+	"import":    (*app).importLinks,
+	"rebalance": (*app).rebalance,
+}
+
+func newApp(cfg *config.Config, in io.Reader, out io.Writer, format string) (*app, error) {
+	switch cfg.DBConfig.DriverName {
+	case filestorage.FileStorageType, boltstorage.BoltStorageType, redisstorage.RedisStorageType,
+		"sqlite3", "postgres", "mysql":
+	case mapstorage.MapStorageType:
+		if cfg.DBConfig.Snapshot == "" && len(cfg.DBConfig.Shards) == 0 {
+			return nil, errors.New("storage \"map\" is not persistent without -snapshot")
+		}
+	default:
+		return nil, fmt.Errorf("storage %q is not persistent, use file, bolt, redis, sqlite3, postgres, mysql or map with -snapshot",
+			cfg.DBConfig.DriverName)
+	}
+
+	if format != "table" && format != "json" {
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+
+	return &app{cfg: cfg, in: in, out: out, json: format == "json"}, nil
+}
+
+func (a *app) run(ctx context.Context, args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	return cmd(a, ctx, args[1:])
+}
+
+// open builds the storage on the first call.
+func (a *app) open() (storage.IStorage, storage.IAdmin, error) {
+	if a.storage == nil {
+		st, err := repository.New(a.cfg.DBConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't open storage: %w", err)
+		}
+		a.storage = st
+	}
+
+	admin, ok := storage.As[storage.IAdmin](a.storage)
+	if !ok {
+		return nil, nil, fmt.Errorf("storage %q does not support admin operations", a.cfg.DBConfig.DriverName)
+	}
+
+	return a.storage, admin, nil
+}
+
+func (a *app) close() error {
+	if a.storage == nil {
+		return nil
+	}
+
+	return a.storage.Shutdown()
+}
+
+func (a *app) lookup(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: lookup <short>")
+	}
+
+	_, admin, err := a.open()
+	if err != nil {
+		return err
+	}
+
+	link, err := admin.GetLink(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	return a.print(link, linksTable(link))
+}
+
+func (a *app) create(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	owner := fs.String("owner", "", "-owner=session token of the user")
+	short := fs.String("short", "", "-short=id to use instead of a generated one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: create [-owner=token] [-short=id] <url>")
+	}
+
+	st, _, err := a.open()
+	if err != nil {
+		return err
+	}
+
+	var chars []string
+	if *short != "" {
+		chars = append(chars, *short)
+	}
+
+	id, err := usecase.New(st).CreateLink(ctx, fs.Arg(0), *owner, chars...)
+	if err != nil && !errors.Is(err, service.ErrExists) {
+		return err
+	}
+
+	link := struct {
+		OriginalURL string `json:"original_url"`
+		ShortURL    string `json:"short_url"`
+		Created     bool   `json:"created"`
+	}{fs.Arg(0), a.cfg.BaseURL + id, err == nil}
+
+	return a.print(link, table{
+		header: []string{"SHORT URL", "ORIGINAL URL", "CREATED"},
+		rows:   [][]string{{link.ShortURL, link.OriginalURL, strconv.FormatBool(link.Created)}},
+	})
+}
+
+func (a *app) disable(ctx context.Context, args []string) error {
+	return a.each(ctx, args, "disable", "disabled", storage.IAdmin.DisableLink)
+}
+
+func (a *app) purge(ctx context.Context, args []string) error {
+	return a.each(ctx, args, "purge", "purged", storage.IAdmin.PurgeLink)
+}
+
+// each applies fn to every short URL and reports the status of each one.
+func (a *app) each(ctx context.Context, args []string, name, done string,
+	fn func(storage.IAdmin, context.Context, string) error) error {
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s <short>...", name)
+	}
+
+	_, admin, err := a.open()
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		Short  string `json:"short"`
+		Status string `json:"status"`
+	}
+
+	results := make([]result, len(args))
+	t := table{header: []string{"SHORT", "STATUS"}}
+
+	var failed error
+	for i, short := range args {
+		results[i] = result{Short: short, Status: done}
+
+		if err = fn(admin, ctx, short); err != nil {
+			results[i].Status = err.Error()
+			failed = fmt.Errorf("can't %s some links", name)
+		}
+
+		t.rows = append(t.rows, []string{results[i].Short, results[i].Status})
+	}
+
+	if err = a.print(results, t); err != nil {
+		return err
+	}
+
+	return failed
+}
+
+func (a *app) list(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: list <owner>")
+	}
+
+	st, _, err := a.open()
+	if err != nil {
+		return err
+	}
+
+	links, err := usecase.New(st).GetAllLinksByCookie(ctx, args[0], a.cfg.BaseURL)
+	if err != nil {
+		return err
+	}
+
+	t := table{header: []string{"SHORT URL", "ORIGINAL URL"}}
+	for _, link := range links {
+		t.rows = append(t.rows, []string{link.ShortUrl, link.OriginalUrl})
+	}
+
+	return a.print(links, t)
+}
+
+func (a *app) stats(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: stats")
+	}
+
+	st, _, err := a.open()
+	if err != nil {
+		return err
+	}
+
+	stats, err := usecase.New(st).GetStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	return a.print(stats, table{
+		header: []string{"URLS", "USERS"},
+		rows:   [][]string{{strconv.Itoa(stats.URLs), strconv.Itoa(stats.Users)}},
+	})
+}
+
+func (a *app) migrate(_ context.Context, args []string) error {
+	const migrateUsage = "usage: migrate status | up [-dry-run] | down [-dry-run] <version>"
+
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "-dry-run to print the SQL without applying it")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	vendor := a.cfg.DBConfig.DriverName
+	if vendor == filestorage.FileStorageType {
+		return errors.New("file storage has no migrations")
+	}
+
+	db, err := sql.Open(string(vendor), a.cfg.DBConfig.DataSourceCred)
+	if err != nil {
+		return fmt.Errorf("can't open database: %w", err)
+	}
+	defer db.Close()
+
+	m, err := migration.New(db, vendor)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	var steps []migration.Step
+
+	switch {
+	case args[0] == "status" && fs.NArg() == 0:
+	case args[0] == "up" && fs.NArg() == 0:
+		if steps, err = m.PlanUp(); err == nil && !*dryRun {
+			err = m.Up()
+		}
+	case args[0] == "down" && fs.NArg() == 1:
+		version, parseErr := strconv.ParseUint(fs.Arg(0), 10, 32)
+		if parseErr != nil {
+			return fmt.Errorf("invalid version %q", fs.Arg(0))
+		}
+
+		if steps, err = m.PlanDownTo(uint(version)); err == nil && !*dryRun {
+			err = m.DownTo(uint(version))
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		return a.printSteps(steps)
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	pending := make([]string, len(status.Pending))
+	for i, version := range status.Pending {
+		pending[i] = strconv.FormatUint(uint64(version), 10)
+	}
+
+	return a.print(status, table{
+		header: []string{"VENDOR", "VERSION", "DIRTY", "LATEST", "PENDING"},
+		rows: [][]string{{status.Vendor, strconv.FormatUint(uint64(status.Version), 10),
+			strconv.FormatBool(status.Dirty), strconv.FormatUint(uint64(status.Latest), 10),
+			strings.Join(pending, ",")}},
+	})
+}
+
+// printSteps prints the SQL of the steps, it is what the dry-run shows.
+func (a *app) printSteps(steps []migration.Step) error {
+	if a.json {
+		return a.print(steps, table{})
+	}
+
+	for _, step := range steps {
+		direction := "down"
+		if step.Up {
+			direction = "up"
+		}
+
+		_, err := fmt.Fprintf(a.out, "-- %d_%s.%s.sql\n%s\n", step.Version, step.Name, direction,
+			strings.TrimSpace(step.SQL))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *app) export(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	path := fs.String("file", "", "-file=path, stdout by default")
+	name := fs.String("format", "jsonl", "-format=jsonl|csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := transfer.ParseFormat(*name)
+	if err != nil {
+		return err
+	}
+
+	_, admin, err := a.open()
+	if err != nil {
+		return err
+	}
+
+	w := a.out
+	if *path != "" {
+		file, err := os.Create(*path)
+		if err != nil {
+			return fmt.Errorf("can't create %s: %w", *path, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	_, err = transfer.Export(ctx, admin, w, f)
+
+	return err
+}
+
+func (a *app) importLinks(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	path := fs.String("file", "", "-file=path, stdin by default")
+	name := fs.String("format", "jsonl", "-format=jsonl|csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := transfer.ParseFormat(*name)
+	if err != nil {
+		return err
+	}
+
+	_, admin, err := a.open()
+	if err != nil {
+		return err
+	}
+
+	r := a.in
+	if *path != "" {
+		file, err := os.Open(*path)
+		if err != nil {
+			return fmt.Errorf("can't open %s: %w", *path, err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	count, err := transfer.Import(ctx, admin, r, f)
+	if err != nil {
+		return fmt.Errorf("%w, %d links were imported", err, count)
+	}
+
+	return a.print(struct {
+		Imported int `json:"imported"`
+	}{count}, table{header: []string{"IMPORTED"}, rows: [][]string{{strconv.Itoa(count)}}})
+}
+
+func (a *app) rebalance(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rebalance", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "-dry-run to list the links without moving them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return errors.New("usage: rebalance [-dry-run]")
+	}
+
+	st, _, err := a.open()
+	if err != nil {
+		return err
+	}
+
+	router, ok := storage.As[*shard.Router](st)
+	if !ok {
+		return errors.New("the storage is not sharded, set -shards")
+	}
+
+	moves := make([]shard.Move, 0)
+	moved, err := router.Rebalance(ctx, *dryRun, func(m shard.Move) {
+		moves = append(moves, m)
+	})
+	if err != nil {
+		return fmt.Errorf("%w, %d links were moved", err, moved)
+	}
+
+	t := table{header: []string{"SHORT", "FROM", "TO"}}
+	for _, m := range moves {
+		t.rows = append(t.rows, []string{m.Short, m.From, m.To})
+	}
+
+	return a.print(moves, t)
+}
@@ -0,0 +1,75 @@
+// Command shortenerctl is the operator tool of the url-shortener.
+//
+// It reads the same flags, environment and config file as the server, so the storage
+// is selected the same way:
+//
+//	shortenerctl -stype=sqlite3 -d=urls.db -o=json lookup zE
+//	DATABASE_DSN=... shortenerctl stats
+//	shortenerctl -f=urlshortener.txt export -file=links.jsonl
+//
+// Only persistent storages are supported: file, bolt, redis, sqlite3, postgres, mysql
+// and map with a snapshot.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"url-shortener/config"
+)
+
+const usage = `Usage: shortenerctl [server flags] [-o=table|json] <command> [arguments]
+
+Commands:
+  lookup <short>                           show the link
+  create [-owner=token] [-short=id] <url>  shorten the URL
+  disable <short>...                       mark links as deleted
+  purge <short>...                         remove links
+  list <owner>                             list links of the user
+  stats                                    count links and users
+  migrate status                           show applied and pending migrations
+  migrate up [-dry-run]                    apply pending migrations
+  migrate down [-dry-run] <version>        roll back migrations applied after version
+  export [-file=path] [-format=jsonl|csv]  write every link
+  import [-file=path] [-format=jsonl|csv]  read links written by export
+  rebalance [-dry-run]                     move links to their shards after adding shards
+
+Server flags:
+`
+
+var format = flag.String("o", "table", "-o=table|json")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	cfg := config.New()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		log.Fatal("no command was provided")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a, err := newApp(cfg, os.Stdin, os.Stdout, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = a.run(ctx, flag.Args())
+	if closeErr := a.close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"url-shortener/config"
+	"url-shortener/internal/repository"
+	"url-shortener/internal/storage"
+	filestorage "url-shortener/internal/storage/file"
+	"url-shortener/internal/storage/shard"
+	"url-shortener/internal/storage/wal"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestApp(t *testing.T, path, format string, in string) (*app, *bytes.Buffer) {
+	t.Helper()
+
+	cfg := &config.Config{
+		BaseURL:  "http://localhost/",
+		DBConfig: &repository.Config{DriverName: filestorage.FileStorageType, DataSourcePath: path},
+	}
+
+	out := &bytes.Buffer{}
+	a, err := newApp(cfg, strings.NewReader(in), out, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.close() })
+
+	return a, out
+}
+
+func TestApp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.txt")
+	ctx := context.Background()
+
+	err := os.WriteFile(path, []byte("1 - zE - https://ya.ru - alice - 1700000000\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		format  string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "create with id",
+			format: "json",
+			args:   []string{"create", "-owner=bob", "-short=go", "https://go.dev"},
+			want:   `{"original_url":"https://go.dev","short_url":"http://localhost/go","created":true}`,
+		},
+		{
+			name:   "lookup",
+			format: "json",
+			args:   []string{"lookup", "zE"},
+			want:   `{"short":"zE","long":"https://ya.ru","owner":"alice","deleted":false,"created_at":"2023-11-14T22:13:20Z","options":{}}`,
+		},
+		{
+			name:   "lookup table",
+			format: "table",
+			args:   []string{"lookup", "zE"},
+			want: "SHORT  LONG           OWNER  DELETED  CREATED\n" +
+				"zE     https://ya.ru  alice  false    2023-11-14T22:13:20Z\n",
+		},
+		{
+			name:    "lookup not found",
+			format:  "table",
+			args:    []string{"lookup", "nope"},
+			wantErr: true,
+		},
+		{
+			name:   "list",
+			format: "json",
+			args:   []string{"list", "bob"},
+			want:   `[{"original_url":"https://go.dev","short_url":"http://localhost/go"}]`,
+		},
+		{
+			name:   "stats",
+			format: "json",
+			args:   []string{"stats"},
+			want:   `{"urls":2,"users":2}`,
+		},
+		{
+			name:   "disable",
+			format: "json",
+			args:   []string{"disable", "zE"},
+			want:   `[{"short":"zE","status":"disabled"}]`,
+		},
+		{
+			name:   "disabled",
+			format: "json",
+			args:   []string{"lookup", "zE"},
+			want:   `{"short":"zE","long":"https://ya.ru","owner":"alice","deleted":true,"created_at":"2023-11-14T22:13:20Z","options":{}}`,
+		},
+		{
+			name:   "purge",
+			format: "json",
+			args:   []string{"purge", "go"},
+			want:   `[{"short":"go","status":"purged"}]`,
+		},
+		{
+			name:    "purge not found",
+			format:  "json",
+			args:    []string{"purge", "go"},
+			want:    `[{"short":"go","status":"URL not found"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "migrate file storage",
+			format:  "json",
+			args:    []string{"migrate", "up"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown command",
+			format:  "json",
+			args:    []string{"drop"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, out := newTestApp(t, path, tt.format, "")
+
+			err := a.run(ctx, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			switch {
+			case tt.want == "":
+			case tt.format == "json":
+				assert.JSONEq(t, tt.want, out.String())
+			default:
+				assert.Equal(t, tt.want, out.String())
+			}
+		})
+	}
+}
+
+func TestApp_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	src, _ := newTestApp(t, filepath.Join(dir, "src.txt"), "json", "")
+	for _, args := range [][]string{
+		{"create", "-owner=alice", "https://ya.ru"},
+		{"create", "-owner=bob", "https://go.dev"},
+		{"disable", "zE"},
+	} {
+		if err := src.run(ctx, args); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exported := &bytes.Buffer{}
+	src.out = exported
+	if err := src.run(ctx, []string{"export"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(exported.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.True(t, strings.HasPrefix(lines[0], `{"short":"zE","long":"https://ya.ru","owner":"alice","deleted":true,"created_at":"`), lines[0])
+		assert.True(t, strings.HasPrefix(lines[1], `{"short":"Xz","long":"https://go.dev","owner":"bob","deleted":false,"created_at":"`), lines[1])
+	}
+
+	dst, out := newTestApp(t, filepath.Join(dir, "dst.txt"), "json", exported.String())
+	if err := dst.run(ctx, []string{"import"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, `{"imported":2}`, out.String())
+
+	out.Reset()
+	if err := dst.run(ctx, []string{"export"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, exported.String(), out.String())
+}
+
+func TestNewApp(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver string
+		format string
+	}{
+		{name: "map storage", driver: "map", format: "table"},
+		{name: "unknown format", driver: "file", format: "yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{DBConfig: &repository.Config{DriverName: storage.Type(tt.driver)}}
+			_, err := newApp(cfg, nil, nil, tt.format)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestApp_InUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.txt")
+
+	// the server
+	server, err := repository.New(&repository.Config{DriverName: filestorage.FileStorageType, DataSourcePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := newTestApp(t, path, "table", "")
+	err = a.run(context.Background(), []string{"lookup", "zE"})
+	assert.True(t, errors.Is(err, wal.ErrInUse), err)
+
+	assert.NoError(t, server.Shutdown())
+	err = a.run(context.Background(), []string{"lookup", "zE"})
+	assert.False(t, errors.Is(err, wal.ErrInUse), err)
+}
+
+func TestApp_Migrate(t *testing.T) {
+	ctx := context.Background()
+	dsn := filepath.Join(t.TempDir(), "links.db")
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "status",
+			args: []string{"migrate", "status"},
+			want: "VENDOR   VERSION  DIRTY  LATEST  PENDING\nsqlite3  0        false  8       1,2,3,4,5,6,7,8\n",
+		},
+		{
+			name: "up",
+			args: []string{"migrate", "up"},
+			want: "VENDOR   VERSION  DIRTY  LATEST  PENDING\nsqlite3  8        false  8       \n",
+		},
+		{
+			name: "down dry-run",
+			args: []string{"migrate", "down", "-dry-run", "1"},
+			want: "-- 8_add_clicks_left.down.sql\nALTER TABLE links DROP COLUMN clicks_left;\n" +
+				"-- 7_add_link_password.down.sql\nALTER TABLE link_options DROP COLUMN password_hash;\n" +
+				"-- 6_add_link_options.down.sql\nDROP TABLE link_options;\n" +
+				"-- 5_add_audit_log.down.sql\nDROP TABLE audit_log;\n" +
+				"-- 4_add_idempotency_keys.down.sql\nDROP TABLE idempotency_keys;\n" +
+				"-- 3_add_created_at_column.down.sql\nALTER TABLE links DROP COLUMN created_at;\n" +
+				"-- 2_add_deleted_column.down.sql\nALTER TABLE links DROP COLUMN deleted;\n",
+		},
+		{
+			name: "down",
+			args: []string{"migrate", "down", "2"},
+			want: "VENDOR   VERSION  DIRTY  LATEST  PENDING\nsqlite3  2        false  8       3,4,5,6,7,8\n",
+		},
+		{
+			name:    "down to not applied version",
+			args:    []string{"migrate", "down", "3"},
+			wantErr: true,
+		},
+		{
+			name:    "down without version",
+			args:    []string{"migrate", "down"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{DBConfig: &repository.Config{DriverName: "sqlite3", DataSourceCred: dsn}}
+
+			out := &bytes.Buffer{}
+			a, err := newApp(cfg, nil, out, "table")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { a.close() })
+
+			err = a.run(ctx, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestApp_Rebalance(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	newShardedApp := func(names ...string) (*app, *bytes.Buffer) {
+		var shards []repository.Shard
+		for _, name := range names {
+			shards = append(shards, repository.Shard{Name: name, DSN: filepath.Join(dir, name+".txt")})
+		}
+
+		cfg := &config.Config{BaseURL: "http://localhost/", DBConfig: &repository.Config{
+			DriverName: filestorage.FileStorageType, Shards: shards}}
+
+		out := &bytes.Buffer{}
+		a, err := newApp(cfg, nil, out, "json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return a, out
+	}
+
+	a, _ := newShardedApp("a", "b")
+	for i := 0; i < 20; i++ {
+		err := a.run(ctx, []string{"create", fmt.Sprintf("-short=link%d", i), "https://ya.ru/" + strconv.Itoa(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.NoError(t, a.close())
+
+	a, out := newShardedApp("a", "b", "c")
+	t.Cleanup(func() { a.close() })
+
+	assert.NoError(t, a.run(ctx, []string{"rebalance", "-dry-run"}))
+
+	var moves []shard.Move
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &moves))
+	assert.NotEmpty(t, moves)
+	for _, m := range moves {
+		assert.Equal(t, "c", m.To)
+	}
+
+	out.Reset()
+	assert.NoError(t, a.run(ctx, []string{"rebalance"}))
+	assert.JSONEq(t, string(mustMarshal(t, moves)), out.String())
+
+	out.Reset()
+	assert.NoError(t, a.run(ctx, []string{"lookup", moves[0].Short}))
+	assert.Contains(t, out.String(), `"long": "https://ya.ru/`)
+
+	out.Reset()
+	assert.NoError(t, a.run(ctx, []string{"rebalance"}))
+	assert.JSONEq(t, "[]", out.String(), "the links are on their shards")
+
+	plain, _ := newTestApp(t, filepath.Join(dir, "plain.txt"), "table", "")
+	assert.Error(t, plain.run(ctx, []string{"rebalance"}), "the storage is not sharded")
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
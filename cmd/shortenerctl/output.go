@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"url-shortener/internal/storage"
+)
+
+// table the text form of the command result.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// print writes v as JSON or t as a table depending on the output format.
+func (a *app) print(v interface{}, t table) error {
+	if a.json {
+		enc := json.NewEncoder(a.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+
+	return w.Flush()
+}
+
+func linksTable(links ...storage.Link) table {
+	t := table{header: []string{"SHORT", "LONG", "OWNER", "DELETED", "CREATED"}}
+	for _, link := range links {
+		var created string
+		if !link.CreatedAt.IsZero() {
+			created = link.CreatedAt.Format(time.RFC3339)
+		}
+
+		t.rows = append(t.rows, []string{link.Short, link.Long, link.Owner, strconv.FormatBool(link.Deleted), created})
+	}
+
+	return t
+}
@@ -8,21 +8,32 @@ import (
 	"github.com/egorgasay/dockerdb"
 	"io"
 	"log"
-	"net"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/internal/access"
+	"url-shortener/internal/idempotency"
 	"url-shortener/internal/repository"
 	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/cache"
 	dbstorage "url-shortener/internal/storage/db"
 	filestorage "url-shortener/internal/storage/file"
 	mapstorage "url-shortener/internal/storage/map"
+	"url-shortener/internal/storage/wal"
+	"url-shortener/internal/webhook"
 )
 
 const (
-	defaultURL     = "http://127.0.0.1:8080/"
-	defaultHost    = "127.0.0.1:8080"
-	defaultPath    = "urlshortener.txt"
-	defaultStorage = dbstorage.DBStorageType
+	defaultURL      = "http://127.0.0.1:8080/"
+	defaultHost     = "127.0.0.1:8080"
+	defaultAdmin    = "127.0.0.1:8081"
+	defaultGateway  = "/gateway"
+	defaultPath     = "urlshortener.txt"
+	defaultDataDir  = "data"
+	defaultWebhooks = "webhooks.log"
+	defaultStorage  = dbstorage.DBStorageType
 )
 
 // Flag struct for parsing from env and cmd args.
@@ -37,17 +48,63 @@ type Flag struct {
 	Config            *string
 	HTTPS             *bool   `json:"enable_https,omitempty"`
 	TrustedSubNetwork *string `json:"trusted_subnet"`
+	TrustedProxies    *string `json:"trusted_proxies"`
 	GRPC              *string `json:"grpc"`
+	AdminHost         *string `json:"admin_address,omitempty"`
+	AdminToken        *string `json:"admin_token,omitempty"`
+	RateLimit         *int    `json:"rate_limit,omitempty"`
+	GatewayPrefix     *string `json:"gateway_prefix,omitempty"`
+	Migrate           *string `json:"migrate,omitempty"`
+	Fsync             *string `json:"fsync,omitempty"`
+	CompactInterval   *string `json:"compact_interval,omitempty"`
+	Snapshot          *string `json:"snapshot_path,omitempty"`
+	SnapshotInterval  *string `json:"snapshot_interval,omitempty"`
+	DataDir           *string `json:"data_dir,omitempty"`
+	Redis             *string `json:"redis,omitempty"`
+	RedisTTL          *string `json:"redis_ttl,omitempty"`
+	Replicas          *string `json:"replica_dsns,omitempty"`
+	ReadYourWrites    *string `json:"read_your_writes,omitempty"`
+	Shards            *string `json:"shards,omitempty"`
+	ShardCodes        *bool   `json:"shard_codes,omitempty"`
+	CacheSize         *int    `json:"cache_size,omitempty"`
+	CacheTTL          *string `json:"cache_ttl,omitempty"`
+	CacheNegativeTTL  *string `json:"cache_negative_ttl,omitempty"`
+	DBMaxOpen         *int    `json:"db_max_open_conns,omitempty"`
+	DBMaxIdle         *int    `json:"db_max_idle_conns,omitempty"`
+	DBConnLifetime    *string `json:"db_conn_max_lifetime,omitempty"`
+	DBConnIdleTime    *string `json:"db_conn_max_idle_time,omitempty"`
+	DBQueryTimeout    *string `json:"db_query_timeout,omitempty"`
+	IdempotencyTTL    *string `json:"idempotency_ttl,omitempty"`
+	Webhooks          *string `json:"webhooks_path,omitempty"`
+	WebhookAttempts   *int    `json:"webhook_attempts,omitempty"`
+	WebhookBackoff    *string `json:"webhook_backoff,omitempty"`
 }
 
 var f Flag
 
 // defaults for properly working the reflection.
 var defaults = map[string]string{
-	"Host":    defaultHost,
-	"BaseURL": defaultURL,
-	"Path":    defaultPath,
-	"Storage": string(defaultStorage),
+	"Host":             defaultHost,
+	"BaseURL":          defaultURL,
+	"Path":             defaultPath,
+	"Storage":          string(defaultStorage),
+	"AdminHost":        defaultAdmin,
+	"GatewayPrefix":    defaultGateway,
+	"Migrate":          repository.MigrateAuto,
+	"Fsync":            string(wal.SyncAlways),
+	"CompactInterval":  filestorage.DefaultCompactInterval.String(),
+	"SnapshotInterval": mapstorage.DefaultSnapshotInterval.String(),
+	"DataDir":          defaultDataDir,
+	"RedisTTL":         "0s",
+	"ReadYourWrites":   "0s",
+	"CacheTTL":         cache.DefaultTTL.String(),
+	"CacheNegativeTTL": cache.DefaultNegativeTTL.String(),
+	"DBConnLifetime":   "0s",
+	"DBConnIdleTime":   "0s",
+	"DBQueryTimeout":   dbstorage.DefaultQueryTimeout.String(),
+	"IdempotencyTTL":   idempotency.DefaultTTL.String(),
+	"Webhooks":         defaultWebhooks,
+	"WebhookBackoff":   webhook.DefaultBackoff.String(),
 }
 
 func init() {
@@ -60,19 +117,56 @@ func init() {
 	f.HTTPS = flag.Bool("s", false, "-s to enable a HTTPS connection")
 	f.Cfg = flag.String("c", "", "-c=path/to/conf.json")
 	f.Config = flag.String("config", "", "-config=path/to/conf.json")
-	f.TrustedSubNetwork = flag.String("t", "", "-t=trusted_subnet")
+	f.TrustedSubNetwork = flag.String("t", "", "-t=trusted_subnet[,trusted_subnet]")
+	f.TrustedProxies = flag.String("tp", "", "-tp=trusted_proxy_subnet[,trusted_proxy_subnet]")
 	f.GRPC = flag.String("grpc", "", "-grpc=host:port")
+	f.AdminHost = flag.String("admin", defaults["AdminHost"], "-admin=host:port")
+	f.AdminToken = flag.String("admin-token", "", "-admin-token=secret")
+	f.RateLimit = flag.Int("rl", 0, "-rl=requests_per_second_per_client")
+	f.GatewayPrefix = flag.String("gw", defaults["GatewayPrefix"], "-gw=/path/prefix")
+	f.Migrate = flag.String("migrate", defaults["Migrate"], "-migrate=auto|off|only")
+	f.Fsync = flag.String("fsync", defaults["Fsync"], "-fsync=always|interval|never")
+	f.CompactInterval = flag.String("compact", defaults["CompactInterval"], "-compact=10m")
+	f.Snapshot = flag.String("snapshot", "", "-snapshot=path/to/snapshot")
+	f.SnapshotInterval = flag.String("snapshot-interval", defaults["SnapshotInterval"], "-snapshot-interval=5m")
+	f.DataDir = flag.String("data-dir", defaults["DataDir"], "-data-dir=path/to/dir")
+	f.Redis = flag.String("redis", "", "-redis=redis://host:6379/0 or -redis=host:port[,host:port]")
+	f.RedisTTL = flag.String("redis-ttl", defaults["RedisTTL"], "-redis-ttl=10m")
+	f.Replicas = flag.String("replicas", "", "-replicas=connection_string[,connection_string]")
+	f.ReadYourWrites = flag.String("read-your-writes", defaults["ReadYourWrites"], "-read-your-writes=5s")
+	f.Shards = flag.String("shards", "", "-shards=name=dsn[,name=dsn]")
+	f.ShardCodes = flag.Bool("shard-codes", false, "-shard-codes to encode the shard in the generated short URLs")
+	f.CacheSize = flag.Int("cache-size", 0, "-cache-size=max_cached_links")
+	f.CacheTTL = flag.String("cache-ttl", defaults["CacheTTL"], "-cache-ttl=1m")
+	f.CacheNegativeTTL = flag.String("cache-negative-ttl", defaults["CacheNegativeTTL"], "-cache-negative-ttl=10s")
+	f.DBMaxOpen = flag.Int("db-max-open", 0, "-db-max-open=max_open_connections")
+	f.DBMaxIdle = flag.Int("db-max-idle", 0, "-db-max-idle=max_idle_connections")
+	f.DBConnLifetime = flag.String("db-conn-lifetime", defaults["DBConnLifetime"], "-db-conn-lifetime=30m")
+	f.DBConnIdleTime = flag.String("db-conn-idle-time", defaults["DBConnIdleTime"], "-db-conn-idle-time=5m")
+	f.DBQueryTimeout = flag.String("db-query-timeout", defaults["DBQueryTimeout"], "-db-query-timeout=5s")
+	f.IdempotencyTTL = flag.String("idempotency-ttl", defaults["IdempotencyTTL"], "-idempotency-ttl=24h")
+	f.Webhooks = flag.String("webhooks", defaults["Webhooks"], "-webhooks=path/to/outbox")
+	// zero leaves webhook.DefaultMaxAttempts to the dispatcher, the config file fills the zero ints only
+	f.WebhookAttempts = flag.Int("webhook-attempts", 0, "-webhook-attempts=8")
+	f.WebhookBackoff = flag.String("webhook-backoff", defaults["WebhookBackoff"], "-webhook-backoff=1s")
 }
 
 // Config contains all the settings for configuring the application.
 type Config struct {
-	Host              string
-	BaseURL           string
-	TrustedSubNetwork *net.IPNet
-	Key               []byte
-	DBConfig          *repository.Config
-	HTTPS             bool
-	GRPC              string
+	Host          string
+	AdminHost     string
+	BaseURL       string
+	Access        access.Config
+	Key           []byte
+	DBConfig      *repository.Config
+	HTTPS         bool
+	GRPC          string
+	RateLimit     int
+	GatewayPrefix string
+	// IdempotencyTTL how long the responses to the requests with an idempotency key are replayed.
+	IdempotencyTTL time.Duration
+	// Webhooks settings of the delivery of the link events.
+	Webhooks webhook.Config
 }
 
 // Modify modifies the config by the file provided.
@@ -112,9 +206,13 @@ func Modify(filename string) error {
 					elem.SetString(reflectionFCopy.Field(i).Elem().String())
 				}
 			case reflect.Bool:
-				if !elem.Bool() {
+				if !elem.Bool() && reflectionFCopy.Field(i).Elem().IsValid() {
 					elem.SetBool(reflectionFCopy.Field(i).Elem().Bool())
 				}
+			case reflect.Int:
+				if elem.Int() == 0 && reflectionFCopy.Field(i).Elem().IsValid() {
+					elem.SetInt(reflectionFCopy.Field(i).Elem().Int())
+				}
 			}
 
 		}
@@ -153,6 +251,10 @@ func New() *Config {
 		f.TrustedSubNetwork = &sub
 	}
 
+	if proxies, ok := os.LookupEnv("TRUSTED_PROXIES"); ok {
+		f.TrustedProxies = &proxies
+	}
+
 	if addr, ok := os.LookupEnv("SERVER_ADDRESS"); ok {
 		f.Host = &addr
 	}
@@ -161,10 +263,138 @@ func New() *Config {
 		f.DSN = &dsn
 	}
 
+	if addr, ok := os.LookupEnv("ADMIN_ADDRESS"); ok {
+		f.AdminHost = &addr
+	}
+
+	if token, ok := os.LookupEnv("ADMIN_TOKEN"); ok {
+		f.AdminToken = &token
+	}
+
+	if rl, ok := os.LookupEnv("RATE_LIMIT"); ok {
+		limit, err := strconv.Atoi(rl)
+		if err != nil {
+			log.Fatalf("invalid rate limit: %v", err)
+		}
+		f.RateLimit = &limit
+	}
+
 	if grpcHost, ok := os.LookupEnv("GRPC_HOST"); ok {
 		f.GRPC = &grpcHost
 	}
 
+	if mode, ok := os.LookupEnv("MIGRATE"); ok {
+		f.Migrate = &mode
+	}
+
+	if policy, ok := os.LookupEnv("FSYNC"); ok {
+		f.Fsync = &policy
+	}
+
+	if interval, ok := os.LookupEnv("COMPACT_INTERVAL"); ok {
+		f.CompactInterval = &interval
+	}
+
+	if path, ok := os.LookupEnv("SNAPSHOT_PATH"); ok {
+		f.Snapshot = &path
+	}
+
+	if interval, ok := os.LookupEnv("SNAPSHOT_INTERVAL"); ok {
+		f.SnapshotInterval = &interval
+	}
+
+	if dir, ok := os.LookupEnv("DATA_DIR"); ok {
+		f.DataDir = &dir
+	}
+
+	if addr, ok := os.LookupEnv("REDIS_ADDR"); ok {
+		f.Redis = &addr
+	}
+
+	if ttl, ok := os.LookupEnv("REDIS_TTL"); ok {
+		f.RedisTTL = &ttl
+	}
+
+	if dsns, ok := os.LookupEnv("REPLICA_DSNS"); ok {
+		f.Replicas = &dsns
+	}
+
+	if window, ok := os.LookupEnv("READ_YOUR_WRITES"); ok {
+		f.ReadYourWrites = &window
+	}
+
+	if shards, ok := os.LookupEnv("SHARDS"); ok {
+		f.Shards = &shards
+	}
+
+	if _, ok := os.LookupEnv("SHARD_CODES"); ok {
+		f.ShardCodes = &ok
+	}
+
+	if size, ok := os.LookupEnv("CACHE_SIZE"); ok {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			log.Fatalf("invalid cache size: %v", err)
+		}
+		f.CacheSize = &n
+	}
+
+	if ttl, ok := os.LookupEnv("CACHE_TTL"); ok {
+		f.CacheTTL = &ttl
+	}
+
+	if ttl, ok := os.LookupEnv("CACHE_NEGATIVE_TTL"); ok {
+		f.CacheNegativeTTL = &ttl
+	}
+
+	if conns, ok := os.LookupEnv("DB_MAX_OPEN_CONNS"); ok {
+		n, err := strconv.Atoi(conns)
+		if err != nil {
+			log.Fatalf("invalid max open connections: %v", err)
+		}
+		f.DBMaxOpen = &n
+	}
+
+	if conns, ok := os.LookupEnv("DB_MAX_IDLE_CONNS"); ok {
+		n, err := strconv.Atoi(conns)
+		if err != nil {
+			log.Fatalf("invalid max idle connections: %v", err)
+		}
+		f.DBMaxIdle = &n
+	}
+
+	if lifetime, ok := os.LookupEnv("DB_CONN_MAX_LIFETIME"); ok {
+		f.DBConnLifetime = &lifetime
+	}
+
+	if idle, ok := os.LookupEnv("DB_CONN_MAX_IDLE_TIME"); ok {
+		f.DBConnIdleTime = &idle
+	}
+
+	if timeout, ok := os.LookupEnv("DB_QUERY_TIMEOUT"); ok {
+		f.DBQueryTimeout = &timeout
+	}
+
+	if ttl, ok := os.LookupEnv("IDEMPOTENCY_TTL"); ok {
+		f.IdempotencyTTL = &ttl
+	}
+
+	if path, ok := os.LookupEnv("WEBHOOKS_PATH"); ok {
+		f.Webhooks = &path
+	}
+
+	if attempts, ok := os.LookupEnv("WEBHOOK_ATTEMPTS"); ok {
+		n, err := strconv.Atoi(attempts)
+		if err != nil {
+			log.Fatalf("invalid webhook attempts: %v", err)
+		}
+		f.WebhookAttempts = &n
+	}
+
+	if backoff, ok := os.LookupEnv("WEBHOOK_BACKOFF"); ok {
+		f.WebhookBackoff = &backoff
+	}
+
 	if _, ok := os.LookupEnv("ENABLE_HTTPS"); ok {
 		f.HTTPS = &ok
 	}
@@ -207,21 +437,147 @@ func New() *Config {
 		f.DSN = &ddb.ConnString
 	}
 
-	_, subnet, _ := net.ParseCIDR(*f.TrustedSubNetwork)
+	migrate, err := repository.ParseMigrate(*f.Migrate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sync, err := wal.ParseSyncPolicy(*f.Fsync)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	compact, err := time.ParseDuration(*f.CompactInterval)
+	if err != nil {
+		log.Fatalf("invalid compact interval: %v", err)
+	}
+
+	snapshotInterval, err := time.ParseDuration(*f.SnapshotInterval)
+	if err != nil {
+		log.Fatalf("invalid snapshot interval: %v", err)
+	}
+
+	redisTTL, err := time.ParseDuration(*f.RedisTTL)
+	if err != nil {
+		log.Fatalf("invalid redis ttl: %v", err)
+	}
+
+	readYourWrites, err := time.ParseDuration(*f.ReadYourWrites)
+	if err != nil {
+		log.Fatalf("invalid read-your-writes window: %v", err)
+	}
+
+	var replicas []string
+	if *f.Replicas != "" {
+		replicas = strings.Split(*f.Replicas, ",")
+	}
+
+	shards, err := repository.ParseShards(*f.Shards)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cacheTTL, err := time.ParseDuration(*f.CacheTTL)
+	if err != nil {
+		log.Fatalf("invalid cache ttl: %v", err)
+	}
+
+	cacheNegativeTTL, err := time.ParseDuration(*f.CacheNegativeTTL)
+	if err != nil {
+		log.Fatalf("invalid cache negative ttl: %v", err)
+	}
+
+	connLifetime, err := time.ParseDuration(*f.DBConnLifetime)
+	if err != nil {
+		log.Fatalf("invalid connection max lifetime: %v", err)
+	}
+
+	connIdleTime, err := time.ParseDuration(*f.DBConnIdleTime)
+	if err != nil {
+		log.Fatalf("invalid connection max idle time: %v", err)
+	}
+
+	queryTimeout, err := time.ParseDuration(*f.DBQueryTimeout)
+	if err != nil {
+		log.Fatalf("invalid query timeout: %v", err)
+	}
+
+	idempotencyTTL, err := time.ParseDuration(*f.IdempotencyTTL)
+	if err != nil {
+		log.Fatalf("invalid idempotency ttl: %v", err)
+	}
+
+	webhookBackoff, err := time.ParseDuration(*f.WebhookBackoff)
+	if err != nil {
+		log.Fatalf("invalid webhook backoff: %v", err)
+	}
+
+	subnets, err := access.ParseCIDRs(*f.TrustedSubNetwork)
+	if err != nil {
+		log.Fatalf("invalid trusted subnet: %v", err)
+	}
+
+	proxies, err := access.ParseCIDRs(*f.TrustedProxies)
+	if err != nil {
+		log.Fatalf("invalid trusted proxies: %v", err)
+	}
+
+	key := []byte("CHANGE ME")
 
 	var config = &Config{
-		Host:    *f.Host,
-		BaseURL: *f.BaseURL,
-		Key:     []byte("CHANGE ME"),
+		Host:      *f.Host,
+		AdminHost: *f.AdminHost,
+		BaseURL:   *f.BaseURL,
+		Key:       key,
 		DBConfig: &repository.Config{
-			DriverName:     storage.Type(*f.Storage),
-			DataSourcePath: *f.Path,
-			DataSourceCred: *f.DSN,
-			VDB:            ddb,
-			Name:           vdb,
+			DriverName:       storage.Type(*f.Storage),
+			DataSourcePath:   *f.Path,
+			DataSourceCred:   *f.DSN,
+			VDB:              ddb,
+			Name:             vdb,
+			Migrate:          migrate,
+			Sync:             sync,
+			CompactInterval:  compact,
+			Snapshot:         *f.Snapshot,
+			SnapshotInterval: snapshotInterval,
+			DataDir:          *f.DataDir,
+			Redis:            *f.Redis,
+			RedisTTL:         redisTTL,
+			Replicas:         replicas,
+			ReadYourWrites:   readYourWrites,
+			Shards:           shards,
+			ShardCodes:       *f.ShardCodes,
+			Cache: cache.Config{
+				Size:        *f.CacheSize,
+				TTL:         cacheTTL,
+				NegativeTTL: cacheNegativeTTL,
+			},
+			Pool: dbstorage.Pool{
+				MaxOpenConns:    *f.DBMaxOpen,
+				MaxIdleConns:    *f.DBMaxIdle,
+				ConnMaxLifetime: connLifetime,
+				ConnMaxIdleTime: connIdleTime,
+			},
+			QueryTimeout: queryTimeout,
+		},
+		HTTPS:          *f.HTTPS,
+		GRPC:           *f.GRPC,
+		RateLimit:      *f.RateLimit,
+		GatewayPrefix:  *f.GatewayPrefix,
+		IdempotencyTTL: idempotencyTTL,
+		Webhooks: webhook.Config{
+			Path:        *f.Webhooks,
+			Sync:        sync,
+			Key:         key,
+			BaseURL:     *f.BaseURL,
+			MaxAttempts: *f.WebhookAttempts,
+			Backoff:     webhookBackoff,
+		},
+		Access: access.Config{
+			TrustedSubnets: subnets,
+			TrustedProxies: proxies,
+			Token:          *f.AdminToken,
 		},
-		HTTPS:             *f.HTTPS,
-		TrustedSubNetwork: subnet,
 	}
 
 	return config
@@ -11,8 +11,9 @@ func TestNew(t *testing.T) {
 
 func TestModify(t *testing.T) {
 	type fl struct {
-		Host  string
-		HTTPS bool
+		Host            string
+		HTTPS           bool
+		WebhookAttempts int
 	}
 
 	tests := []struct {
@@ -33,8 +34,9 @@ func TestModify(t *testing.T) {
 			wantErr: false,
 			wantF:   true,
 			f: fl{
-				Host:  "localhost:8090",
-				HTTPS: true,
+				Host:            "localhost:8090",
+				HTTPS:           true,
+				WebhookAttempts: 3,
 			},
 		},
 	}
@@ -44,8 +46,9 @@ func TestModify(t *testing.T) {
 				t.Errorf("Modify() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
-			if tt.wantF && (*f.Host != tt.f.Host || *f.HTTPS != tt.f.HTTPS) {
-				t.Errorf("Modify() error = %v, %v", *f.Host != tt.f.Host, *f.HTTPS != tt.f.HTTPS)
+			if tt.wantF && (*f.Host != tt.f.Host || *f.HTTPS != tt.f.HTTPS || *f.WebhookAttempts != tt.f.WebhookAttempts) {
+				t.Errorf("Modify() error = %v, %v, %v", *f.Host != tt.f.Host, *f.HTTPS != tt.f.HTTPS,
+					*f.WebhookAttempts != tt.f.WebhookAttempts)
 			}
 		})
 	}
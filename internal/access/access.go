@@ -0,0 +1,238 @@
+package access
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Config describes who is allowed through the Guard.
+type Config struct {
+	// TrustedSubnets clients from these networks are allowed.
+	TrustedSubnets []*net.IPNet
+	// TrustedProxies X-Forwarded-For and X-Real-IP are honoured only from these networks.
+	TrustedProxies []*net.IPNet
+	// Token clients presenting it as a bearer token are allowed from any network.
+	// Empty token disables token auth.
+	Token string
+}
+
+// gatewayKey the metadata the in-process gateway proves itself with.
+const gatewayKey = "x-gateway-key"
+
+// Guard decides whether a client may reach internal endpoints.
+type Guard struct {
+	subnets []*net.IPNet
+	proxies []*net.IPNet
+	token   string
+	// gateway the secret of the in-process gateway, known only to this process.
+	gateway string
+}
+
+// New creates an instance of the Guard.
+func New(cfg Config) *Guard {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("can't generate the gateway secret: %s", err))
+	}
+
+	return &Guard{subnets: cfg.TrustedSubnets, proxies: cfg.TrustedProxies, token: cfg.Token,
+		gateway: hex.EncodeToString(secret)}
+}
+
+// ParseCIDRs parses a comma separated list of CIDRs.
+// An empty string gives an empty list.
+func ParseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		_, subnet, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse %q: %w", part, err)
+		}
+
+		nets = append(nets, subnet)
+	}
+
+	return nets, nil
+}
+
+// Allowed reports whether ip belongs to one of the trusted subnets.
+// Nothing is allowed when no subnets were configured.
+func (g *Guard) Allowed(ip net.IP) bool {
+	return ip != nil && contains(g.subnets, ip)
+}
+
+// Authorized reports whether the client is allowed either by its ip or by the bearer token
+// from the Authorization value.
+func (g *Guard) Authorized(ip net.IP, authorization string) bool {
+	if g.Allowed(ip) {
+		return true
+	}
+
+	if g.token == "" {
+		return false
+	}
+
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(g.token)) == 1
+}
+
+// ClientIP derives the client IP from the peer address and the forwarding headers.
+// Headers are taken into account only when the peer is a trusted proxy.
+func (g *Guard) ClientIP(remoteAddr string, xForwardedFor, xRealIP string) net.IP {
+	ip := hostIP(remoteAddr)
+	if ip == nil || !contains(g.proxies, ip) {
+		return ip
+	}
+
+	return g.forwarded(ip, xForwardedFor, xRealIP)
+}
+
+// forwarded derives the client IP from the forwarding headers sent by the trusted peer ip.
+func (g *Guard) forwarded(ip net.IP, xForwardedFor, xRealIP string) net.IP {
+	if xForwardedFor != "" {
+		// walk from the right: the last hop that is not our proxy is the client
+		hops := strings.Split(xForwardedFor, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				return ip
+			}
+
+			ip = hop
+			if !contains(g.proxies, hop) {
+				return hop
+			}
+		}
+
+		return ip
+	}
+
+	if realIP := net.ParseIP(strings.TrimSpace(xRealIP)); realIP != nil {
+		return realIP
+	}
+
+	return ip
+}
+
+// Handler returns gin middleware that aborts requests from unauthorized clients with 403.
+func (g *Guard) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := g.ClientIP(c.Request.RemoteAddr,
+			c.Request.Header.Get("X-Forwarded-For"), c.Request.Header.Get("X-Real-IP"))
+		if !g.Authorized(ip, c.Request.Header.Get("Authorization")) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// UnaryServerInterceptor returns an interceptor that checks the peer of the listed methods.
+// Methods are full gRPC method names, e.g. /api.Shortener/GetStats.
+func (g *Guard) UnaryServerInterceptor(methods ...string) grpc.UnaryServerInterceptor {
+	protected := make(map[string]struct{}, len(methods))
+	for _, m := range methods {
+		protected[m] = struct{}{}
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := protected[info.FullMethod]; !ok {
+			return handler(ctx, req)
+		}
+
+		var authorization string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get("authorization"); len(v) > 0 {
+				authorization = v[0]
+			}
+		}
+
+		if !g.Authorized(g.PeerIP(ctx), authorization) {
+			return nil, status.Errorf(codes.PermissionDenied, "Permission denied")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// PeerIP derives the client IP of the gRPC call like ClientIP.
+// A call of the in-process gateway, marked by GatewayMetadata, is trusted as a proxy whatever its address,
+// the gateway adds the HTTP peer to x-forwarded-for.
+func (g *Guard) PeerIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	xff := strings.Join(md.Get("x-forwarded-for"), ",")
+	var xri string
+	if v := md.Get("x-real-ip"); len(v) > 0 {
+		xri = v[0]
+	}
+
+	if ip := hostIP(p.Addr.String()); ip != nil && g.fromGateway(md) {
+		return g.forwarded(ip, xff, xri)
+	}
+
+	return g.ClientIP(p.Addr.String(), xff, xri)
+}
+
+// GatewayMetadata returns the metadata the in-process gateway adds to its calls, so that PeerIP
+// takes the client IP from the x-forwarded-for of the gateway instead of the loopback peer.
+func (g *Guard) GatewayMetadata() metadata.MD {
+	return metadata.Pairs(gatewayKey, g.gateway)
+}
+
+// fromGateway reports whether the call was made by the in-process gateway.
+func (g *Guard) fromGateway(md metadata.MD) bool {
+	for _, v := range md.Get(gatewayKey) {
+		if subtle.ConstantTimeCompare([]byte(v), []byte(g.gateway)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hostIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	return net.ParseIP(host)
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
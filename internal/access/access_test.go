@@ -0,0 +1,319 @@
+package access
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func mustParse(t *testing.T, s string) []*net.IPNet {
+	t.Helper()
+
+	nets, err := ParseCIDRs(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return nets
+}
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{name: "empty", input: "", want: 0},
+		{name: "one", input: "192.168.0.0/24", want: 1},
+		{name: "many", input: "192.168.0.0/24, 10.0.0.0/8,::1/128", want: 3},
+		{name: "bad", input: "192.168.0.0/24,localhost", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCIDRs(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCIDRs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Len(t, got, tt.want)
+		})
+	}
+}
+
+func TestGuard_ClientIP(t *testing.T) {
+	g := New(Config{TrustedProxies: mustParse(t, "10.0.0.0/8")})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		xri        string
+		want       string
+	}{
+		{
+			name:       "no proxy",
+			remoteAddr: "192.168.0.5:4321",
+			want:       "192.168.0.5",
+		},
+		{
+			name:       "spoofed header from untrusted peer",
+			remoteAddr: "8.8.8.8:4321",
+			xri:        "192.168.0.5",
+			xff:        "192.168.0.5",
+			want:       "8.8.8.8",
+		},
+		{
+			name:       "real ip from trusted proxy",
+			remoteAddr: "10.0.0.1:4321",
+			xri:        "192.168.0.5",
+			want:       "192.168.0.5",
+		},
+		{
+			name:       "forwarded for through two proxies",
+			remoteAddr: "10.0.0.1:4321",
+			xff:        "1.1.1.1, 192.168.0.5, 10.0.0.2",
+			want:       "192.168.0.5",
+		},
+		{
+			name:       "forwarded for with garbage",
+			remoteAddr: "10.0.0.1:4321",
+			xff:        "192.168.0.5, garbage",
+			want:       "10.0.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := g.ClientIP(tt.remoteAddr, tt.xff, tt.xri)
+			assert.Equal(t, tt.want, got.String())
+		})
+	}
+}
+
+func TestGuard_Handler(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		remoteAddr string
+		xri        string
+		want       int
+	}{
+		{
+			name:       "no subnets configured",
+			remoteAddr: "192.168.0.5:4321",
+			want:       http.StatusForbidden,
+		},
+		{
+			name:       "trusted",
+			cfg:        Config{TrustedSubnets: mustParse(t, "172.16.0.0/12,192.168.0.0/24")},
+			remoteAddr: "192.168.0.5:4321",
+			want:       http.StatusOK,
+		},
+		{
+			name:       "spoofed",
+			cfg:        Config{TrustedSubnets: mustParse(t, "192.168.0.0/24")},
+			remoteAddr: "8.8.8.8:4321",
+			xri:        "192.168.0.5",
+			want:       http.StatusForbidden,
+		},
+		{
+			name: "behind proxy",
+			cfg: Config{
+				TrustedSubnets: mustParse(t, "192.168.0.0/24"),
+				TrustedProxies: mustParse(t, "10.0.0.0/8"),
+			},
+			remoteAddr: "10.0.0.1:4321",
+			xri:        "192.168.0.5",
+			want:       http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/", New(tt.cfg).Handler(), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Real-IP", tt.xri)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestGuard_UnaryServerInterceptor(t *testing.T) {
+	g := New(Config{TrustedSubnets: mustParse(t, "127.0.0.0/8")})
+	interceptor := g.UnaryServerInterceptor("/api.Shortener/GetStats")
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	withPeer := func(ip string) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50051},
+		})
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		want   codes.Code
+	}{
+		{
+			name:   "trusted peer",
+			ctx:    withPeer("127.0.0.1"),
+			method: "/api.Shortener/GetStats",
+			want:   codes.OK,
+		},
+		{
+			name:   "untrusted peer",
+			ctx:    withPeer("8.8.8.8"),
+			method: "/api.Shortener/GetStats",
+			want:   codes.PermissionDenied,
+		},
+		{
+			name:   "metadata is ignored from untrusted peer",
+			ctx:    metadata.NewIncomingContext(withPeer("8.8.8.8"), metadata.Pairs("x-real-ip", "127.0.0.1")),
+			method: "/api.Shortener/GetStats",
+			want:   codes.PermissionDenied,
+		},
+		{
+			name:   "no peer",
+			ctx:    context.Background(),
+			method: "/api.Shortener/GetStats",
+			want:   codes.PermissionDenied,
+		},
+		{
+			name:   "not protected",
+			ctx:    withPeer("8.8.8.8"),
+			method: "/api.Shortener/Get",
+			want:   codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := interceptor(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			assert.Equal(t, tt.want, status.Code(err))
+		})
+	}
+}
+
+func TestGuard_PeerIP(t *testing.T) {
+	g := New(Config{TrustedProxies: mustParse(t, "10.0.0.0/8")})
+
+	withPeer := func(ip string, md metadata.MD) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50051},
+		})
+
+		return metadata.NewIncomingContext(ctx, md)
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{
+			name: "no metadata",
+			ctx:  withPeer("127.0.0.1", nil),
+			want: "127.0.0.1",
+		},
+		{
+			name: "forwarded for from untrusted peer",
+			ctx:  withPeer("127.0.0.1", metadata.Pairs("x-forwarded-for", "192.168.0.5")),
+			want: "127.0.0.1",
+		},
+		{
+			name: "forwarded for from trusted proxy",
+			ctx:  withPeer("10.0.0.1", metadata.Pairs("x-forwarded-for", "192.168.0.5")),
+			want: "192.168.0.5",
+		},
+		{
+			name: "gateway",
+			ctx: withPeer("127.0.0.1", metadata.Join(g.GatewayMetadata(),
+				metadata.Pairs("x-forwarded-for", "192.168.0.5"))),
+			want: "192.168.0.5",
+		},
+		{
+			name: "gateway behind trusted proxy",
+			ctx: withPeer("127.0.0.1", metadata.Join(g.GatewayMetadata(),
+				metadata.Pairs("x-forwarded-for", "1.1.1.1, 192.168.0.5, 10.0.0.2"))),
+			want: "192.168.0.5",
+		},
+		{
+			name: "wrong gateway secret",
+			ctx: withPeer("127.0.0.1", metadata.Pairs(gatewayKey, "guess",
+				"x-forwarded-for", "192.168.0.5")),
+			want: "127.0.0.1",
+		},
+		{
+			name: "gateway secret of another guard",
+			ctx: withPeer("127.0.0.1", metadata.Join(New(Config{}).GatewayMetadata(),
+				metadata.Pairs("x-forwarded-for", "192.168.0.5"))),
+			want: "127.0.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, g.PeerIP(tt.ctx).String())
+		})
+	}
+}
+
+func TestGuard_Authorized(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           Config
+		ip            string
+		authorization string
+		want          bool
+	}{
+		{
+			name:          "token disabled",
+			ip:            "8.8.8.8",
+			authorization: "Bearer ",
+			want:          false,
+		},
+		{
+			name:          "valid token",
+			cfg:           Config{Token: "secret"},
+			ip:            "8.8.8.8",
+			authorization: "Bearer secret",
+			want:          true,
+		},
+		{
+			name:          "token without scheme",
+			cfg:           Config{Token: "secret"},
+			ip:            "8.8.8.8",
+			authorization: "secret",
+			want:          false,
+		},
+		{
+			name: "trusted subnet without token",
+			cfg:  Config{TrustedSubnets: mustParse(t, "8.8.8.0/24"), Token: "secret"},
+			ip:   "8.8.8.8",
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := New(tt.cfg).Authorized(net.ParseIP(tt.ip), tt.authorization)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
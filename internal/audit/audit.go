@@ -0,0 +1,121 @@
+// Package audit records who changed which link, when and with what outcome.
+//
+// The usecase layer records an entry for every mutating operation. The actor is the cookie
+// of the user, or the one put in the context by the caller, the IP is put in the context
+// by Handler or UnaryServerInterceptor. The entries are kept by the configured storage
+// if it implements storage.IAudit, in memory otherwise.
+package audit
+
+import (
+	"context"
+	"log"
+	"time"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/auditlog"
+)
+
+// Operations of the entries.
+const (
+	LinkCreate  = "link.create"
+	LinkDelete  = "link.delete"
+	LinkRestore = "link.restore"
+	LinkUpdate  = "link.update"
+	LinkOptions = "link.options"
+
+	WebhookRegister = "webhook.register"
+	WebhookDelete   = "webhook.delete"
+)
+
+// ActorAdmin the actor of the operations made by the operators through the admin endpoints.
+const ActorAdmin = "admin"
+
+// Actor the client making the request.
+type Actor struct {
+	// ID the cookie of the user or the name of the operator tool.
+	ID string
+	IP string
+}
+
+type actorKey struct{}
+
+// WithActor returns the context carrying the actor.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor of the context, a zero one if there is none.
+func ActorFrom(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	return actor
+}
+
+// Detach returns a background context carrying the actor of ctx,
+// for the work that outlives the request.
+func Detach(ctx context.Context) context.Context {
+	return WithActor(context.Background(), ActorFrom(ctx))
+}
+
+// Log records the entries, safe for concurrent use.
+type Log struct {
+	backend storage.IAudit
+}
+
+// New returns the Log kept in st, or in memory if st can't keep it.
+func New(st storage.IStorage) *Log {
+	backend, ok := storage.As[storage.IAudit](st)
+	if !ok {
+		log.Println("audit: the storage can't keep the audit log, it is kept in memory")
+		backend = auditlog.NewMemory()
+	}
+
+	return NewWithBackend(backend)
+}
+
+// NewWithBackend returns the Log kept in backend.
+func NewWithBackend(backend storage.IAudit) *Log {
+	return &Log{backend: backend}
+}
+
+// Record saves the entries, the zero At, Actor and IP are taken from now and the actor of ctx.
+// A failure is logged, it does not fail the operation.
+func (l *Log) Record(ctx context.Context, entries ...storage.AuditEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	actor := ActorFrom(ctx)
+	now := time.Now().UTC()
+
+	for i := range entries {
+		e := &entries[i]
+		if e.At.IsZero() {
+			e.At = now
+		}
+		if e.Actor == "" {
+			e.Actor = actor.ID
+		}
+		if e.IP == "" {
+			e.IP = actor.IP
+		}
+	}
+
+	// the entries are recorded even if the request was canceled after the operation
+	if err := l.backend.AppendAudit(Detach(ctx), entries...); err != nil {
+		log.Printf("audit: can't record %d entries: %v", len(entries), err)
+	}
+}
+
+// Entries returns the entries selected by the filter in the order they were recorded.
+func (l *Log) Entries(ctx context.Context, filter storage.AuditFilter) ([]storage.AuditEntry, error) {
+	return l.backend.AuditEntries(ctx, filter)
+}
+
+// Entry returns the entry of the operation on the target with the outcome of err.
+func Entry(operation, target string, err error) storage.AuditEntry {
+	e := storage.AuditEntry{Operation: operation, Target: target, Outcome: storage.AuditSuccess}
+	if err != nil {
+		e.Outcome, e.Error = storage.AuditFailure, err.Error()
+	}
+
+	return e
+}
@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"context"
+	"url-shortener/internal/access"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+)
+
+// Handler returns gin middleware putting the IP of the client into the context of the request,
+// the forwarding headers are trusted as by g. A non-empty id is the actor of every request.
+func Handler(g *access.Guard, id string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := g.ClientIP(c.Request.RemoteAddr,
+			c.Request.Header.Get("X-Forwarded-For"), c.Request.Header.Get("X-Real-IP"))
+
+		actor := Actor{ID: id}
+		if ip != nil {
+			actor.IP = ip.String()
+		}
+
+		c.Request = c.Request.WithContext(WithActor(c.Request.Context(), actor))
+		c.Next()
+	}
+}
+
+// UnaryServerInterceptor returns an interceptor putting the IP of the client into the context of the call.
+func UnaryServerInterceptor(g *access.Guard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var actor Actor
+		if ip := g.PeerIP(ctx); ip != nil {
+			actor.IP = ip.String()
+		}
+
+		return handler(WithActor(ctx, actor), req)
+	}
+}
@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"url-shortener/internal/access"
+	"url-shortener/internal/idempotency"
+	shortener "url-shortener/pkg/api"
+)
+
+const (
+	tokenKey   = "token"
+	cookieName = "session"
+	cookieAge  = 3600
+)
+
+// New returns a JSON/HTTP gateway to the Shortener gRPC service listening on addr.
+// Routes are generated from the google.api.http rules of api/proto/shortener.proto.
+//
+// The token metadata is mapped to the Authorization header and the session cookie
+// in both directions, as the REST handlers do. The Idempotency-Key header is passed on
+// as the idempotency-key metadata. The calls carry the GatewayMetadata of guard,
+// so the service sees the IP of the HTTP client instead of the loopback one.
+func New(ctx context.Context, addr string, guard *access.Guard) (http.Handler, error) {
+	if guard == nil {
+		panic("nil pointer")
+	}
+
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions:   protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true},
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+		}),
+		runtime.WithMetadata(incomingMetadata),
+		runtime.WithMetadata(func(context.Context, *http.Request) metadata.MD { return guard.GatewayMetadata() }),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher),
+		runtime.WithForwardResponseOption(outgoingToken),
+	)
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+
+	err := shortener.RegisterShortenerHandlerFromEndpoint(ctx, mux, addr, opts)
+	if err != nil {
+		return nil, fmt.Errorf("can't register gateway: %w", err)
+	}
+
+	return mux, nil
+}
+
+// incomingMetadata takes the token from the Authorization header or the session cookie
+// and the idempotency key.
+func incomingMetadata(_ context.Context, r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	if token := r.Header.Get("Authorization"); token != "" {
+		md.Set(tokenKey, token)
+	} else if cookie, err := r.Cookie(cookieName); err == nil && cookie.Value != "" {
+		md.Set(tokenKey, cookie.Value)
+	}
+
+	if key := r.Header.Get(idempotency.Header); key != "" {
+		md.Set(idempotency.MetadataKey, key)
+	}
+
+	return md
+}
+
+// outgoingToken sets the token issued by the service as the Authorization header and the session cookie.
+func outgoingToken(ctx context.Context, w http.ResponseWriter, _ proto.Message) error {
+	md, ok := runtime.ServerMetadataFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	values := md.HeaderMD.Get(tokenKey)
+	if len(values) == 0 {
+		return nil
+	}
+
+	w.Header().Set("Authorization", values[0])
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    values[0],
+		Path:     "/",
+		MaxAge:   cookieAge,
+		HttpOnly: true,
+	})
+
+	return nil
+}
+
+// outgoingHeaderMatcher keeps the default Grpc-Metadata- prefix for everything but the token.
+func outgoingHeaderMatcher(key string) (string, bool) {
+	if key == tokenKey {
+		return "", false
+	}
+
+	return runtime.MetadataHeaderPrefix + key, true
+}
@@ -0,0 +1,208 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"url-shortener/config"
+	"url-shortener/internal/access"
+	grpchandler "url-shortener/internal/handler/grpc"
+	"url-shortener/internal/idempotency"
+	"url-shortener/internal/repository"
+	"url-shortener/internal/usecase"
+	shortener "url-shortener/pkg/api"
+)
+
+func newGateway(t *testing.T) http.Handler {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{Key: []byte("test-key"), DBConfig: &repository.Config{DriverName: "map"},
+		BaseURL: "http://localhost/"}
+	storage, err := repository.New(cfg.DBConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guard := access.New(access.Config{})
+	idem := idempotency.NewWithBackend(idempotency.NewMemory(), 0).WithGuard(guard)
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(idem.UnaryServerInterceptor(grpchandler.IdempotentMethods...)))
+	shortener.RegisterShortenerServer(grpcServer, grpchandler.NewHandler(&cfg, usecase.New(storage)).WithGuard(guard))
+
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	gw, err := New(ctx, lis.Addr().String(), guard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return gw
+}
+
+func TestGateway(t *testing.T) {
+	gw := newGateway(t)
+
+	// CreateApi issues a token
+	req := httptest.NewRequest("POST", "/v1/user/links", bytes.NewBufferString(`{"url":"http://ya.ru"}`))
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, req)
+
+	if !assert.Equal(t, http.StatusOK, w.Code, w.Body.String()) {
+		return
+	}
+
+	token := w.Header().Get("Authorization")
+	assert.NotEmpty(t, token)
+	assert.Empty(t, w.Header().Get("Grpc-Metadata-Token"))
+
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, cookieName, cookies[0].Name)
+		assert.Equal(t, token, cookies[0].Value)
+	}
+
+	var created shortener.CreateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "http://localhost/zE", created.Shortened)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		header   string
+		cookie   string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "get",
+			method:   "GET",
+			target:   "/v1/links/zE",
+			wantCode: http.StatusOK,
+			wantBody: `{"original_url":"http://ya.ru"}`,
+		},
+		{
+			name:     "get not found",
+			method:   "GET",
+			target:   "/v1/links/nope",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "user links by header",
+			method:   "GET",
+			target:   "/v1/user/links",
+			header:   token,
+			wantCode: http.StatusOK,
+			wantBody: `{"urls":[{"original_url":"http://ya.ru","short_url":"http://localhost/zE","deleted":false}]}`,
+		},
+		{
+			name:     "user links by cookie",
+			method:   "GET",
+			target:   "/v1/user/links",
+			cookie:   token,
+			wantCode: http.StatusOK,
+			wantBody: `{"urls":[{"original_url":"http://ya.ru","short_url":"http://localhost/zE","deleted":false}]}`,
+		},
+		{
+			name:     "user links without token",
+			method:   "GET",
+			target:   "/v1/user/links",
+			wantCode: http.StatusOK,
+			wantBody: `{"urls":[]}`,
+		},
+		{
+			name:     "ping",
+			method:   "GET",
+			target:   "/v1/ping",
+			wantCode: http.StatusOK,
+			wantBody: `{}`,
+		},
+		{
+			name:     "stats are not exposed",
+			method:   "GET",
+			target:   "/v1/internal/stats",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body))
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: cookieName, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+
+			gw.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGateway_Idempotency(t *testing.T) {
+	gw := newGateway(t)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/user/links", bytes.NewBufferString(body))
+		req.Header.Set(idempotency.Header, "key-1")
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+
+		return w
+	}
+
+	first := create(`{"url":"http://ya.ru"}`)
+	if !assert.Equal(t, http.StatusOK, first.Code, first.Body.String()) {
+		return
+	}
+	assert.NotEmpty(t, first.Header().Get("Authorization"))
+
+	second := create(`{"url":"http://ya.ru"}`)
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.JSONEq(t, first.Body.String(), second.Body.String())
+	assert.Empty(t, second.Header().Get("Authorization"), "the token issued by the first call is not replayed")
+	assert.Equal(t, "true", second.Header().Get("Grpc-Metadata-Idempotent-Replayed"))
+
+	assert.Equal(t, http.StatusBadRequest, create(`{"url":"http://go.dev"}`).Code)
+}
+
+func TestGateway_ClientIP(t *testing.T) {
+	gw := newGateway(t)
+
+	create := func(remoteAddr, body string) int {
+		req := httptest.NewRequest("POST", "/v1/user/links", bytes.NewBufferString(body))
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(idempotency.Header, "key-1")
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	// the clients without a token are told apart by their own IP, not by the one of the gateway
+	assert.Equal(t, http.StatusOK, create("192.0.2.1:1234", `{"url":"http://ya.ru"}`))
+	assert.Equal(t, http.StatusOK, create("192.0.2.2:1234", `{"url":"http://go.dev"}`))
+	assert.Equal(t, http.StatusBadRequest, create("192.0.2.1:1234", `{"url":"http://go.dev"}`))
+}
@@ -4,30 +4,56 @@ import (
 	"context"
 	"errors"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
-	"net"
+	"log"
 	"url-shortener/config"
+	"url-shortener/internal/access"
+	"url-shortener/internal/audit"
 	"url-shortener/internal/storage"
 	"url-shortener/internal/storage/db/service"
 	"url-shortener/internal/usecase"
 	shortener "url-shortener/pkg/api"
 )
 
+// AdminMethods full names of the methods that are only available for trusted clients.
+var AdminMethods = []string{
+	"/api.Shortener/GetStats",
+}
+
+// IdempotentMethods full names of the methods replaying their responses for the repeated idempotency keys.
+var IdempotentMethods = []string{
+	"/api.Shortener/Create",
+	"/api.Shortener/CreateApi",
+	"/api.Shortener/Batch",
+}
+
 // Handler struct that contains link to the logic layer and conf.
 // It has methods for processing requests.
 type Handler struct {
 	conf  *config.Config
 	logic usecase.UseCase
+	guard *access.Guard
 	shortener.UnimplementedShortenerServer
 }
 
-// New returns new Handler.
+// New returns new Handler. The client IPs are taken from the peer addresses until WithGuard is called.
 func NewHandler(conf *config.Config, logic usecase.UseCase) *Handler {
 	return &Handler{
 		conf:  conf,
 		logic: logic,
+		guard: access.New(access.Config{}),
+	}
+}
+
+// WithGuard derives the client IPs with g, the forwarding metadata of its trusted proxies is honoured.
+func (h *Handler) WithGuard(g *access.Guard) *Handler {
+	if g == nil {
+		panic("nil pointer")
 	}
+
+	h.guard = g
+
+	return h
 }
 
 // Ping checks is alive db or not.
@@ -37,8 +63,14 @@ func (h *Handler) Ping(ctx context.Context, req *shortener.PingRequest) (*shorte
 
 // Create creates shortened link.
 func (h *Handler) Create(ctx context.Context, req *shortener.CreateRequest) (*shortener.CreateResponse, error) {
-	charsForURL, err := h.logic.CreateLink(ctx, req.GetUrl(), "")
+	charsForURL, err := h.logic.CreateRestrictedLink(ctx, req.GetUrl(), "", restrictions(req))
 	if err != nil {
+		if errors.Is(err, usecase.ErrPasswordTooLong) || errors.Is(err, usecase.ErrInvalidMaxClicks) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, usecase.ErrRestrictedExists) {
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
 		if !errors.Is(err, service.ErrExists) {
 			return nil, err
 		}
@@ -66,8 +98,14 @@ func (h *Handler) CreateApi(ctx context.Context, req *shortener.CreateRequest) (
 		setToken(ctx, token)
 	}
 
-	charsForURL, err := h.logic.CreateLink(ctx, req.GetUrl(), token)
+	charsForURL, err := h.logic.CreateRestrictedLink(ctx, req.GetUrl(), token, restrictions(req))
 	if err != nil {
+		if errors.Is(err, usecase.ErrPasswordTooLong) || errors.Is(err, usecase.ErrInvalidMaxClicks) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, usecase.ErrRestrictedExists) {
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
 		if !errors.Is(err, service.ErrExists) {
 			return nil, err
 		}
@@ -88,12 +126,28 @@ func (h *Handler) CreateApi(ctx context.Context, req *shortener.CreateRequest) (
 	return &shortener.CreateResponse{Shortened: URL.String()}, nil
 }
 
-// Get gets original link.
+// Get gets original link, the password is required for the links protected by one.
 func (h *Handler) Get(ctx context.Context, req *shortener.GetRequest) (*shortener.GetResponse, error) {
-	URL, err := h.logic.GetLink(ctx, req.GetShortened())
+	var client string
+	if ip := h.guard.PeerIP(ctx); ip != nil {
+		client = ip.String()
+	}
+
+	URL, err := h.logic.OpenLink(ctx, req.GetShortened(), req.GetPassword(), client)
 	if err != nil {
-		if errors.Is(err, storage.ErrDeleted) {
-			return nil, status.Errorf(codes.Unavailable, "Link was deleted")
+		switch {
+		case errors.Is(err, usecase.ErrPasswordRequired):
+			return nil, status.Errorf(codes.Unauthenticated, "Link is protected by a password")
+		case errors.Is(err, usecase.ErrWrongPassword):
+			return nil, status.Errorf(codes.PermissionDenied, "Wrong password")
+		case errors.Is(err, usecase.ErrUnknownClient):
+			return nil, status.Errorf(codes.PermissionDenied, "The password can't be checked, the address is unknown")
+		case errors.Is(err, usecase.ErrTooManyAttempts):
+			return nil, status.Errorf(codes.ResourceExhausted, "Too many wrong passwords, try again later")
+		case errors.Is(err, storage.ErrDeleted):
+			return nil, goneError(codes.Unavailable, "Link was deleted", ReasonDeleted)
+		case errors.Is(err, storage.ErrExhausted):
+			return nil, goneError(codes.FailedPrecondition, "Link has no visits left", ReasonExhausted)
 		}
 		return nil, status.Errorf(codes.NotFound, "Link not found")
 	}
@@ -101,6 +155,11 @@ func (h *Handler) Get(ctx context.Context, req *shortener.GetRequest) (*shortene
 	return &shortener.GetResponse{OriginalUrl: URL}, nil
 }
 
+// restrictions of the link of the request.
+func restrictions(req *shortener.CreateRequest) usecase.Restrictions {
+	return usecase.Restrictions{Password: req.GetPassword(), MaxClicks: int(req.GetMaxClicks())}
+}
+
 // GetAll gets all original links by token.
 func (h *Handler) GetAll(ctx context.Context, req *shortener.GetAllByCookieRequest) (*shortener.GetAllByCookieResponse, error) {
 	token, authenticated := getOrCreateToken(ctx, h.conf.Key)
@@ -121,11 +180,13 @@ func (h *Handler) Delete(ctx context.Context, req *shortener.DeleteRequest) (*sh
 	token, _ := getOrCreateToken(ctx, h.conf.Key)
 
 	urls := req.GetShortenedUrls()
-	go func(token string, s []string) {
+	go func(ctx context.Context, token string, s []string) {
 		for _, URL := range s {
-			h.logic.MarkAsDeleted(URL, token)
+			if err := h.logic.MarkAsDeleted(ctx, URL, token); err != nil {
+				log.Println("can't delete", URL, err)
+			}
 		}
-	}(token, urls)
+	}(audit.Detach(ctx), token, urls)
 
 	return &shortener.DeleteResponse{}, nil
 }
@@ -137,9 +198,17 @@ func (h *Handler) Batch(ctx context.Context, req *shortener.BatchRequest) (*shor
 		setToken(ctx, token)
 	}
 
-	urls := req.GetUrls()
-	resp, err := h.logic.Batch(ctx, urls, token, h.conf.BaseURL)
+	mode, err := usecase.ParseBatchMode(req.GetMode())
 	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	urls := req.GetUrls()
+	resp, err := h.logic.Batch(ctx, urls, token, h.conf.BaseURL, usecase.BatchOptions{Mode: mode})
+	var batchErr *usecase.BatchError
+	if errors.As(err, &batchErr) {
+		return nil, status.Error(codes.InvalidArgument, batchErr.Error())
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -147,14 +216,8 @@ func (h *Handler) Batch(ctx context.Context, req *shortener.BatchRequest) (*shor
 }
 
 // GetStats returns stats about urls and users.
+// Access must be restricted by access.Guard, see AdminMethods.
 func (h *Handler) GetStats(ctx context.Context, req *shortener.GetStatsRequest) (*shortener.GetStatsResponse, error) {
-	p, ok := peer.FromContext(ctx)
-	if !ok {
-		return nil, status.Errorf(codes.PermissionDenied, "Permission denied")
-	} else if !h.conf.TrustedSubNetwork.Contains(net.ParseIP(p.Addr.String())) {
-		return nil, status.Errorf(codes.PermissionDenied, "Permission denied")
-	}
-
 	data, err := h.logic.GetStats(ctx)
 	if err != nil {
 		return nil, status.Errorf(codes.Unknown, "Error while getting stats")
@@ -2,10 +2,12 @@ package grpchandler
 
 import (
 	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"log"
 	"net"
@@ -212,6 +214,14 @@ func TestHandler_Get(t *testing.T) {
 		t.Fatal("Wrong error was returned (deleted url)")
 	}
 
+	details := status.Convert(err).Details()
+	if len(details) != 1 {
+		t.Fatalf("Wrong details were returned (deleted url): %v", details)
+	}
+	if info, ok := details[0].(*errdetails.ErrorInfo); !ok || info.GetReason() != ReasonDeleted {
+		t.Errorf("Wrong details were returned (deleted url): %v", details)
+	}
+
 	// NOT FOUND
 
 	// TEST
@@ -222,6 +232,87 @@ func TestHandler_Get(t *testing.T) {
 	}
 }
 
+func TestHandler_Get_Password(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{Key: []byte("test-key"), BaseURL: "http://localhost:785/"}
+
+	storage, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHandler(&cfg, usecase.New(storage))
+
+	_, err = h.Create(ctx, &shortener.CreateRequest{Url: "https://ya.ru", Password: string(make([]byte, 73))})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Create() with a long password error = %v, want InvalidArgument", err)
+	}
+
+	created, err := h.Create(ctx, &shortener.CreateRequest{Url: "https://ya.ru", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	short := created.Shortened[len(cfg.BaseURL):]
+
+	client := peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}})
+
+	tests := []struct {
+		name     string
+		password string
+		want     codes.Code
+	}{
+		{name: "without password", want: codes.Unauthenticated},
+		{name: "wrong password", password: "wrong", want: codes.PermissionDenied},
+		{name: "right password", password: "secret", want: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := h.Get(client, &shortener.GetRequest{Shortened: short, Password: tt.password})
+			if status.Code(err) != tt.want {
+				t.Fatalf("Get() error = %v, want %v", err, tt.want)
+			}
+
+			if err == nil && resp.OriginalUrl != "https://ya.ru" {
+				t.Errorf("Get() = %q, want https://ya.ru", resp.OriginalUrl)
+			}
+		})
+	}
+
+	// the wrong passwords of a call without a peer address can't be counted
+	if _, err = h.Get(ctx, &shortener.GetRequest{Shortened: short, Password: "secret"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Get() without a peer error = %v, want PermissionDenied", err)
+	}
+}
+
+func TestHandler_Get_MaxClicks(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{Key: []byte("test-key"), BaseURL: "http://localhost:785/"}
+
+	storage, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHandler(&cfg, usecase.New(storage))
+
+	_, err = h.Create(ctx, &shortener.CreateRequest{Url: "https://ya.ru", MaxClicks: -1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Create() with negative max clicks error = %v, want InvalidArgument", err)
+	}
+
+	created, err := h.Create(ctx, &shortener.CreateRequest{Url: "https://ya.ru", MaxClicks: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	short := created.Shortened[len(cfg.BaseURL):]
+
+	for _, want := range []codes.Code{codes.OK, codes.FailedPrecondition} {
+		if _, err = h.Get(ctx, &shortener.GetRequest{Shortened: short}); status.Code(err) != want {
+			t.Errorf("Get() error = %v, want %v", err, want)
+		}
+	}
+}
+
 func TestHandler_Delete(t *testing.T) {
 	cfg := config.Config{Key: []byte("test-key"), DBConfig: &repository.Config{DriverName: "map"}, Host: ":787",
 		BaseURL: "http://localhost:787/"}
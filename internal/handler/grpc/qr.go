@@ -0,0 +1,55 @@
+package grpchandler
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"url-shortener/internal/qrcode"
+	"url-shortener/internal/storage"
+	shortener "url-shortener/pkg/api"
+)
+
+// GetQRCode returns the QR code of the short URL of the link as PNG or SVG.
+func (h *Handler) GetQRCode(ctx context.Context, req *shortener.GetQRCodeRequest) (*shortener.GetQRCodeResponse, error) {
+	p := qrcode.Params{
+		Format:     req.GetFormat(),
+		Size:       int(req.GetSize()),
+		Level:      req.GetLevel(),
+		Foreground: req.GetForeground(),
+		Background: req.GetBackground(),
+	}
+	if req.Margin != nil {
+		margin := int(req.GetMargin())
+		p.Margin = &margin
+	}
+
+	opts, err := p.Options()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err = h.logic.GetLink(ctx, req.GetShortened()); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrDeleted):
+			return nil, goneError(codes.Unavailable, "Link was deleted", ReasonDeleted)
+		case errors.Is(err, storage.ErrExhausted):
+			return nil, goneError(codes.FailedPrecondition, "Link has no visits left", ReasonExhausted)
+		}
+		return nil, status.Errorf(codes.NotFound, "Link not found")
+	}
+
+	URL, err := CreateLink(req.GetShortened(), h.conf.BaseURL)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	image, err := qrcode.Encode(URL.String(), opts)
+	if errors.Is(err, qrcode.ErrInvalid) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &shortener.GetQRCodeResponse{Image: image, ContentType: opts.Format.ContentType()}, nil
+}
@@ -0,0 +1,64 @@
+package grpchandler
+
+import (
+	"bytes"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"image/png"
+	"testing"
+	"url-shortener/config"
+	"url-shortener/internal/repository"
+	"url-shortener/internal/usecase"
+	shortener "url-shortener/pkg/api"
+)
+
+func TestHandler_GetQRCode(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{Key: []byte("test-key"), BaseURL: "http://localhost:785/"}
+
+	storage, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage.AddLink(ctx, "https://ya.ru", "ya", "alice")
+	h := NewHandler(&cfg, usecase.New(storage))
+
+	resp, err := h.GetQRCode(ctx, &shortener.GetQRCodeRequest{Shortened: "ya", Size: 128})
+	if err != nil {
+		t.Fatalf("GetQRCode() error = %v", err)
+	}
+
+	if resp.ContentType != "image/png" {
+		t.Errorf("GetQRCode() content type = %q, want image/png", resp.ContentType)
+	}
+
+	img, err := png.Decode(bytes.NewReader(resp.Image))
+	if err != nil || img.Bounds().Dx() != 128 {
+		t.Errorf("GetQRCode() image = %v, %v, want 128 pixels wide", img, err)
+	}
+
+	margin := int32(0)
+	resp, err = h.GetQRCode(ctx, &shortener.GetQRCodeRequest{Shortened: "ya", Format: "svg", Margin: &margin})
+	if err != nil || resp.ContentType != "image/svg+xml" {
+		t.Errorf("GetQRCode() svg = %v, %v", resp, err)
+	}
+
+	tests := []struct {
+		name string
+		req  *shortener.GetQRCodeRequest
+		want codes.Code
+	}{
+		{name: "invalid level", req: &shortener.GetQRCodeRequest{Shortened: "ya", Level: "Z"}, want: codes.InvalidArgument},
+		{name: "invalid size", req: &shortener.GetQRCodeRequest{Shortened: "ya", Size: 10}, want: codes.InvalidArgument},
+		{name: "not found", req: &shortener.GetQRCodeRequest{Shortened: "nope"}, want: codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := h.GetQRCode(ctx, tt.req); status.Code(err) != tt.want {
+				t.Errorf("GetQRCode() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,21 @@
 package grpchandler
 
-import "net/url"
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/url"
+)
+
+// Reasons of the errdetails.ErrorInfo attached to the errors of the links that are no longer served,
+// clients check them instead of the messages.
+const (
+	ReasonDeleted   = "LINK_DELETED"
+	ReasonExhausted = "LINK_EXHAUSTED"
+)
+
+// errorDomain domain of the errdetails.ErrorInfo.
+const errorDomain = "url-shortener"
 
 // CreateLink accepts chars and baseURL for building url.URL.
 func CreateLink(chars, baseURL string) (*url.URL, error) {
@@ -13,3 +28,13 @@ func CreateLink(chars, baseURL string) (*url.URL, error) {
 
 	return URL, nil
 }
+
+// goneError returns the status of a link that is no longer served with the reason in the details.
+func goneError(code codes.Code, msg, reason string) error {
+	st, err := status.New(code, msg).WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: errorDomain})
+	if err != nil {
+		return status.Error(code, msg)
+	}
+
+	return st.Err()
+}
@@ -0,0 +1,101 @@
+package grpchandler
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+	"url-shortener/internal/usecase"
+	"url-shortener/internal/webhook"
+	shortener "url-shortener/pkg/api"
+)
+
+// RegisterWebhook registers the webhook of the user, requires the token.
+func (h *Handler) RegisterWebhook(ctx context.Context, req *shortener.RegisterWebhookRequest) (*shortener.Webhook, error) {
+	token, authenticated := getOrCreateToken(ctx, h.conf.Key)
+	if !authenticated {
+		return nil, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	hook, err := h.logic.RegisterWebhook(ctx, token, req.GetUrl(), req.GetEvents())
+	if err != nil {
+		return nil, webhookError(err)
+	}
+
+	return toWebhook(hook), nil
+}
+
+// ListWebhooks returns the webhooks of the user, requires the token.
+func (h *Handler) ListWebhooks(ctx context.Context, req *shortener.ListWebhooksRequest) (*shortener.ListWebhooksResponse, error) {
+	token, authenticated := getOrCreateToken(ctx, h.conf.Key)
+	if !authenticated {
+		return nil, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	hooks, err := h.logic.GetWebhooks(ctx, token)
+	if err != nil {
+		return nil, webhookError(err)
+	}
+
+	resp := &shortener.ListWebhooksResponse{Webhooks: make([]*shortener.Webhook, 0, len(hooks))}
+	for _, hook := range hooks {
+		resp.Webhooks = append(resp.Webhooks, toWebhook(hook))
+	}
+
+	return resp, nil
+}
+
+// DeleteWebhook removes the webhook of the user, requires the token.
+func (h *Handler) DeleteWebhook(ctx context.Context, req *shortener.DeleteWebhookRequest) (*shortener.DeleteWebhookResponse, error) {
+	token, authenticated := getOrCreateToken(ctx, h.conf.Key)
+	if !authenticated {
+		return nil, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	if err := h.logic.DeleteWebhook(ctx, token, req.GetId()); err != nil {
+		return nil, webhookError(err)
+	}
+
+	return &shortener.DeleteWebhookResponse{}, nil
+}
+
+// TestWebhook sends a ping event to the webhook of the user, requires the token.
+func (h *Handler) TestWebhook(ctx context.Context, req *shortener.TestWebhookRequest) (*shortener.TestWebhookResponse, error) {
+	token, authenticated := getOrCreateToken(ctx, h.conf.Key)
+	if !authenticated {
+		return nil, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	res, err := h.logic.TestWebhook(ctx, token, req.GetId())
+	if err != nil {
+		return nil, webhookError(err)
+	}
+
+	return &shortener.TestWebhookResponse{StatusCode: int32(res.StatusCode), Error: res.Error}, nil
+}
+
+func toWebhook(hook webhook.Webhook) *shortener.Webhook {
+	return &shortener.Webhook{
+		Id:        hook.ID,
+		Url:       hook.URL,
+		Events:    hook.Events,
+		Secret:    hook.Secret,
+		CreatedAt: hook.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func webhookError(err error) error {
+	switch {
+	case errors.Is(err, webhook.ErrInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, webhook.ErrTooMany):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, webhook.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, usecase.ErrWebhooksDisabled):
+		return status.Error(codes.Unimplemented, err.Error())
+	default:
+		return status.Errorf(codes.Internal, "can't manage webhooks")
+	}
+}
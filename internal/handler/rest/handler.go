@@ -1,16 +1,22 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"log"
-	"net"
 	"net/http"
+	"strconv"
+	"time"
 	"url-shortener/config"
+	"url-shortener/internal/access"
+	"url-shortener/internal/audit"
 	"url-shortener/internal/schema"
 	"url-shortener/internal/storage"
 	"url-shortener/internal/storage/db/service"
+	"url-shortener/internal/transfer"
 	"url-shortener/internal/usecase"
 	shortener "url-shortener/pkg/api"
 )
@@ -20,34 +26,42 @@ import (
 type Handler struct {
 	conf  *config.Config
 	logic usecase.UseCase
+	guard *access.Guard
 }
 
 // NewHandler creates an instance of the Handler.
+// The client IPs are taken from the peer addresses until WithGuard is called.
 func NewHandler(cfg *config.Config, logic usecase.UseCase) *Handler {
 	if cfg == nil {
 		panic("конфиг равен nil")
 	}
 
-	return &Handler{conf: cfg, logic: logic}
+	return &Handler{conf: cfg, logic: logic, guard: access.New(access.Config{})}
+}
+
+// WithGuard derives the client IPs with g, the forwarding headers of its trusted proxies are honoured.
+func (h *Handler) WithGuard(g *access.Guard) *Handler {
+	if g == nil {
+		panic("nil pointer")
+	}
+
+	h.guard = g
+
+	return h
 }
 
 // GetLinkHandler accepts short url through the characters in the url (after the slash),
-// returns a redirect to the URL that was shortened.
+// returns a redirect to the URL that was shortened. The preview page is shown instead
+// for /<id>+, ?preview=1 and the links set to always show it, the password form
+// for the protected links.
 func (h Handler) GetLinkHandler(c *gin.Context) {
-	longURL, err := h.logic.GetLink(c.Request.Context(), c.Param("id"))
-	if err != nil {
-		log.Println(err)
-		if errors.Is(err, storage.ErrDeleted) {
-			c.AbortWithStatus(http.StatusGone)
-			return
-		}
-
-		c.AbortWithStatus(http.StatusBadRequest)
+	id, preview := previewRequest(c)
+	if preview {
+		h.renderPreview(c, id)
 		return
 	}
 
-	c.Header("Location", longURL)
-	c.Status(http.StatusTemporaryRedirect)
+	h.followLink(c, id, "", c.Query("preview") == "0")
 }
 
 // GetAllLinksHandler returns all URLs that have been shortened by a specific user,
@@ -160,8 +174,19 @@ func (h Handler) APICreateLinkHandler(c *gin.Context) {
 	}
 
 	var isConflict bool
-	charsForURL, err := h.logic.CreateLink(c.Request.Context(), rj.URL, cookie)
+	restrictions := usecase.Restrictions{Password: rj.Password, MaxClicks: rj.MaxClicks}
+	charsForURL, err := h.logic.CreateRestrictedLink(c.Request.Context(), rj.URL, cookie, restrictions)
 	if err != nil {
+		if errors.Is(err, usecase.ErrPasswordTooLong) || errors.Is(err, usecase.ErrInvalidMaxClicks) {
+			c.Error(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, usecase.ErrRestrictedExists) {
+			c.Error(err)
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
 		if !errors.Is(err, service.ErrExists) {
 			c.Error(err)
 			c.AbortWithStatus(http.StatusInternalServerError)
@@ -211,6 +236,7 @@ func (h Handler) Ping(c *gin.Context) {
 
 // BatchHandler accepts a batch of URLs and saves them.
 // Returns correlation id and shortened urls in the response.
+// The mode query parameter is "atomic" (default) or "best-effort", see usecase.Batch.
 func (h Handler) BatchHandler(c *gin.Context) {
 	cookie, err := getCookies(c)
 	if err != nil || !checkCookies(cookie, h.conf.Key) {
@@ -225,8 +251,18 @@ func (h Handler) BatchHandler(c *gin.Context) {
 		return
 	}
 
-	data, err := h.logic.Batch(c.Request.Context(), batchURLs, cookie, h.conf.BaseURL)
+	mode, err := usecase.ParseBatchMode(c.Query("mode"))
 	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, err := h.logic.Batch(c.Request.Context(), batchURLs, cookie, h.conf.BaseURL, usecase.BatchOptions{Mode: mode})
+	var batchErr *usecase.BatchError
+	if errors.As(err, &batchErr) {
+		c.String(http.StatusBadRequest, batchErr.Error())
+		return
+	} else if err != nil {
 		log.Println(err)
 		c.Status(http.StatusInternalServerError)
 		return
@@ -249,31 +285,132 @@ func (h Handler) APIDeleteLinksHandler(c *gin.Context) {
 		return
 	}
 
-	go func(cookie string, s []string) {
+	go func(ctx context.Context, cookie string, s []string) {
 		for _, URL := range s {
-			h.logic.MarkAsDeleted(URL, cookie)
+			if err := h.logic.MarkAsDeleted(ctx, URL, cookie); err != nil {
+				log.Println("can't delete", URL, err)
+			}
 		}
-	}(cookie, s)
+	}(audit.Detach(c.Request.Context()), cookie, s)
 
 	c.Status(http.StatusAccepted)
 	c.Header("Content-Type", "application/json")
 }
 
 // GetStatsHandler returns statistic about shortened links.
+// Access must be restricted by access.Guard.
 func (h Handler) GetStatsHandler(c *gin.Context) {
 	c.Header("Content-Type", "application/json")
 
-	ip := c.Request.Header.Get("X-Real-IP")
-	if !h.conf.TrustedSubNetwork.Contains(net.ParseIP(ip)) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+	data, err := h.logic.GetStats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
 	}
 
-	data, err := h.logic.GetStats(c.Request.Context())
+	c.IndentedJSON(http.StatusOK, data)
+}
+
+// ExportHandler streams every link as JSON Lines or as CSV with ?format=csv.
+// Access must be restricted by access.Guard.
+func (h Handler) ExportHandler(c *gin.Context) {
+	f, err := transfer.ParseFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", f.ContentType())
+	c.Header("Content-Disposition", `attachment; filename="links.`+string(f)+`"`)
+	c.Status(http.StatusOK)
+
+	count, err := h.logic.Export(c.Request.Context(), c.Writer, f)
+	if err == nil {
+		return
+	}
+
+	log.Printf("export stopped after %d links: %v", count, err)
+	if c.Writer.Written() {
+		// the status is already sent, the client sees the truncated body
+		return
+	}
+
+	c.Writer.Header().Del("Content-Type")
+	c.Writer.Header().Del("Content-Disposition")
+
+	if errors.Is(err, storage.ErrNotSupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+}
+
+// ImportHandler saves links from the JSON Lines or the CSV (?format=csv) body.
+// The import stops on the first invalid link, links saved before it are kept.
+// Access must be restricted by access.Guard.
+func (h Handler) ImportHandler(c *gin.Context) {
+	f, err := transfer.ParseFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := h.logic.Import(c.Request.Context(), c.Request.Body, f)
+	switch {
+	case errors.Is(err, storage.ErrNotSupported):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "imported": count})
+	default:
+		c.JSON(http.StatusOK, gin.H{"imported": count})
+	}
+}
+
+// AuditHandler returns the entries of the audit log as JSON, oldest first.
+// The entries are selected by ?from= and ?to= in RFC 3339, ?actor=, and paged by ?after= (the last id seen)
+// and ?limit=. Access must be restricted by access.Guard.
+func (h Handler) AuditHandler(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, err := h.logic.AuditLog(c.Request.Context(), filter)
+	if err != nil {
+		log.Println("can't get the audit log", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
 	}
 
-	c.IndentedJSON(http.StatusOK, data)
+	c.JSON(http.StatusOK, entries)
+}
+
+func parseAuditFilter(c *gin.Context) (storage.AuditFilter, error) {
+	filter := storage.AuditFilter{Actor: c.Query("actor")}
+
+	var err error
+	if from := c.Query("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	if after := c.Query("after"); after != "" {
+		if filter.AfterID, err = strconv.ParseInt(after, 10, 64); err != nil || filter.AfterID < 0 {
+			return filter, fmt.Errorf("invalid after: %q", after)
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+			return filter, fmt.Errorf("invalid limit: %q", limit)
+		}
+	}
+
+	return filter, nil
 }
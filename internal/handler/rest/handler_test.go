@@ -3,14 +3,20 @@ package rest
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 	"url-shortener/config"
+	"url-shortener/internal/access"
+	"url-shortener/internal/audit"
 	"url-shortener/internal/repository"
+	"url-shortener/internal/storage"
 	"url-shortener/internal/usecase"
 )
 
@@ -52,7 +58,7 @@ func TestHandler_GetLinkHandler(t *testing.T) {
 			repo.AddLink(ctx, "http://zrnzruvv7qfdy.ru/hlc65i", "zE", "df")
 
 			conf := &config.Config{Host: "127.0.0.1", DBConfig: cfg}
-			handler := Handler{conf: conf, logic: logic}
+			handler := NewHandler(conf, logic)
 
 			req := httptest.NewRequest("GET", test.target,
 				nil)
@@ -69,6 +75,219 @@ func TestHandler_GetLinkHandler(t *testing.T) {
 	}
 }
 
+func TestHandler_GetLinkHandler_Preview(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logic := usecase.New(repo)
+	repo.AddLink(ctx, "https://go.dev/doc/", "go", "alice")
+	repo.AddLink(ctx, "https://ya.ru", "ya", "alice")
+	repo.AddLink(ctx, "https://vk.com", "vk", "alice")
+	repo.MarkAsDeleted(ctx, "vk", "alice")
+
+	err = logic.SetLinkOptions(ctx, "ya", "alice", storage.LinkOptions{Title: "<Yandex>", Preview: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler(&config.Config{}, logic)
+	router := gin.New()
+	router.GET("/:id", handler.GetLinkHandler)
+
+	tests := []struct {
+		name         string
+		target       string
+		wantCode     int
+		wantLocation string
+		wantBody     []string
+	}{
+		{name: "redirect", target: "/go", wantCode: http.StatusTemporaryRedirect, wantLocation: "https://go.dev/doc/"},
+		{name: "suffix", target: "/go+", wantCode: http.StatusOK,
+			wantBody: []string{"go.dev", "https://go.dev/doc/", `href="go?preview=0"`}},
+		{name: "query", target: "/go?preview=1", wantCode: http.StatusOK, wantBody: []string{"go.dev"}},
+		{name: "forced", target: "/ya", wantCode: http.StatusOK,
+			wantBody: []string{"&lt;Yandex&gt;", "ya.ru", `href="ya?preview=0"`}},
+		{name: "continue", target: "/ya?preview=0", wantCode: http.StatusTemporaryRedirect, wantLocation: "https://ya.ru"},
+		{name: "missing", target: "/nope+", wantCode: http.StatusNotFound},
+		{name: "deleted", target: "/vk+", wantCode: http.StatusGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantLocation, w.Header().Get("Location"))
+			for _, s := range tt.wantBody {
+				assert.Contains(t, w.Body.String(), s)
+			}
+		})
+	}
+}
+
+func TestHandler_GetLinkHandler_MaxClicks(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logic := usecase.New(repo)
+	short, err := logic.CreateRestrictedLink(ctx, "https://ya.ru", "alice", usecase.Restrictions{MaxClicks: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler(&config.Config{}, logic)
+	router := gin.New()
+	router.GET("/:id", handler.GetLinkHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/"+short+"+", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "1 more time")
+	assert.NotContains(t, w.Body.String(), "ya.ru")
+
+	for _, want := range []int{http.StatusTemporaryRedirect, http.StatusGone} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/"+short, nil))
+		assert.Equal(t, want, w.Code)
+	}
+}
+
+func TestHandler_GetLinkHandler_Password(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logic := usecase.New(repo)
+	short, err := logic.CreateRestrictedLink(ctx, "https://ya.ru", "alice", usecase.Restrictions{Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler(&config.Config{}, logic)
+	router := gin.New()
+	router.GET("/:id", handler.GetLinkHandler)
+	router.POST("/:id", handler.UnlockLinkHandler)
+
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		password     string
+		wantCode     int
+		wantLocation string
+		wantBody     []string
+	}{
+		{name: "form", method: "GET", target: "/" + short, wantCode: http.StatusOK,
+			wantBody: []string{`action="` + short + `"`, `name="password"`}},
+		{name: "preview", method: "GET", target: "/" + short + "+", wantCode: http.StatusOK,
+			wantBody: []string{`action="` + short + `"`}},
+		{name: "wrong", method: "POST", target: "/" + short, password: "wrong", wantCode: http.StatusForbidden,
+			wantBody: []string{"Wrong password."}},
+		{name: "right", method: "POST", target: "/" + short, password: "secret", wantCode: http.StatusSeeOther,
+			wantLocation: "https://ya.ru"},
+		{name: "missing", method: "POST", target: "/nope", password: "secret", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"password": {tt.password}}
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantLocation, w.Header().Get("Location"))
+			for _, s := range tt.wantBody {
+				assert.Contains(t, w.Body.String(), s)
+			}
+			assert.NotContains(t, w.Body.String(), "ya.ru")
+		})
+	}
+
+	for i := 1; i < usecase.MaxPasswordAttempts; i++ {
+		req := httptest.NewRequest("POST", "/"+short, strings.NewReader("password=wrong"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest("POST", "/"+short, strings.NewReader("password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
+
+func TestHandler_QRCodeHandler(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.AddLink(ctx, "https://ya.ru", "ya", "alice")
+	repo.AddLink(ctx, "https://vk.com", "vk", "alice")
+	repo.MarkAsDeleted(ctx, "vk", "alice")
+
+	handler := NewHandler(&config.Config{BaseURL: "http://localhost/"}, usecase.New(repo))
+	router := gin.New()
+	router.GET("/:id/qr", handler.QRCodeHandler)
+
+	tests := []struct {
+		name            string
+		target          string
+		wantCode        int
+		wantContentType string
+	}{
+		{name: "png", target: "/ya/qr", wantCode: http.StatusOK, wantContentType: "image/png"},
+		{name: "svg", target: "/ya/qr?format=svg&size=512&level=H&margin=0&fg=336699&bg=fff",
+			wantCode: http.StatusOK, wantContentType: "image/svg+xml"},
+		{name: "invalid size", target: "/ya/qr?size=big", wantCode: http.StatusBadRequest},
+		{name: "invalid colour", target: "/ya/qr?fg=blue", wantCode: http.StatusBadRequest},
+		{name: "missing", target: "/nope/qr", wantCode: http.StatusNotFound},
+		{name: "deleted", target: "/vk/qr", wantCode: http.StatusGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, tt.wantContentType, w.Header().Get("Content-Type"))
+			assert.NotEmpty(t, w.Body.Bytes())
+			assert.Equal(t, "public, max-age=86400", w.Header().Get("Cache-Control"))
+
+			// the cached code is not sent again
+			req = httptest.NewRequest("GET", tt.target, nil)
+			req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+			w = httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusNotModified, w.Code)
+			assert.Empty(t, w.Body.Bytes())
+		})
+	}
+}
+
 func TestHandler_CreateLinkHandler(t *testing.T) {
 	tests := []struct {
 		name                 string
@@ -107,7 +326,7 @@ func TestHandler_CreateLinkHandler(t *testing.T) {
 
 	logic := usecase.New(repo)
 	conf := &config.Config{Host: "127.0.0.1", DBConfig: cfg}
-	handler := Handler{conf: conf, logic: logic}
+	handler := NewHandler(conf, logic)
 
 	// определяем хендлер
 	router := gin.Default()
@@ -165,7 +384,7 @@ func TestHandler_APICreateLinkHandler(t *testing.T) {
 			logic := usecase.New(repo)
 
 			conf := &config.Config{Host: "127.0.0.1", DBConfig: cfg}
-			handler := Handler{conf: conf, logic: logic}
+			handler := NewHandler(conf, logic)
 
 			req := httptest.NewRequest("POST", "/api/shorten",
 				bytes.NewBufferString(test.inputBody))
@@ -205,7 +424,7 @@ func TestHandler_Ping(t *testing.T) {
 	logic := usecase.New(repo)
 
 	conf := &config.Config{Host: "127.0.0.1", DBConfig: cfg}
-	handler := Handler{conf: conf, logic: logic}
+	handler := NewHandler(conf, logic)
 
 	router := gin.Default()
 	router.Use(handler.Ping)
@@ -226,15 +445,17 @@ func TestHandler_Ping(t *testing.T) {
 func TestHandler_BatchHandler(t *testing.T) {
 	tests := []struct {
 		name                 string
+		query                string
 		inputBody            string
 		expectedStatusCode   int
 		expectedResponseBody string
 	}{
 		{
-			name:                 "Ok",
-			inputBody:            `[{"correlation_id": "1", "original_url": "vk.com/gasayminajj"}]`,
-			expectedStatusCode:   201,
-			expectedResponseBody: "[\n    {\n        \"correlation_id\": \"1\",\n        \"short_url\": \"1\"\n    }\n]",
+			name:               "Ok",
+			inputBody:          `[{"correlation_id": "1", "original_url": "vk.com/gasayminajj"}]`,
+			expectedStatusCode: 201,
+			expectedResponseBody: "[\n    {\n        \"correlation_id\": \"1\",\n        \"short_url\": \"1\",\n" +
+				"        \"status\": \"created\"\n    }\n]",
 		},
 		{
 			name:                 "Bad JSON",
@@ -242,6 +463,28 @@ func TestHandler_BatchHandler(t *testing.T) {
 			expectedStatusCode:   400,
 			expectedResponseBody: "",
 		},
+		{
+			name:                 "Atomic invalid",
+			inputBody:            `[{"correlation_id": "2", "original_url": "vk.com/2"}, {"correlation_id": "3"}]`,
+			expectedStatusCode:   400,
+			expectedResponseBody: "[1].original_url: required",
+		},
+		{
+			name:               "Best effort",
+			query:              "?mode=best-effort",
+			inputBody:          `[{"correlation_id": "1", "original_url": "vk.com/gasayminajj"}, {"correlation_id": "3"}]`,
+			expectedStatusCode: 201,
+			expectedResponseBody: "[\n    {\n        \"correlation_id\": \"1\",\n        \"short_url\": \"1\",\n" +
+				"        \"status\": \"existing\"\n    },\n    {\n        \"correlation_id\": \"3\",\n" +
+				"        \"status\": \"invalid\",\n        \"error\": \"original_url: required\"\n    }\n]",
+		},
+		{
+			name:                 "Unknown mode",
+			query:                "?mode=some",
+			inputBody:            `[{"correlation_id": "4", "original_url": "vk.com/4"}]`,
+			expectedStatusCode:   400,
+			expectedResponseBody: `unknown batch mode "some", use atomic or best-effort`,
+		},
 	}
 
 	cfg := &repository.Config{
@@ -256,14 +499,14 @@ func TestHandler_BatchHandler(t *testing.T) {
 	logic := usecase.New(repo)
 
 	conf := &config.Config{Host: "127.0.0.1", DBConfig: cfg}
-	handler := Handler{conf: conf, logic: logic}
+	handler := NewHandler(conf, logic)
 
 	router := gin.Default()
 	router.Use(handler.BatchHandler)
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest("POST", "/api/shorten/batch",
+			req := httptest.NewRequest("POST", "/api/shorten/batch"+tt.query,
 				bytes.NewBufferString(tt.inputBody))
 			w := httptest.NewRecorder()
 
@@ -288,7 +531,7 @@ func TestHandler_APIDeleteLinksHandler(t *testing.T) {
 	logic := usecase.New(repo)
 
 	conf := &config.Config{Host: "127.0.0.1", DBConfig: cfg}
-	handler := Handler{conf: conf, logic: logic}
+	handler := NewHandler(conf, logic)
 
 	inputBody := `[ "zE" ]`
 
@@ -319,3 +562,138 @@ func TestHandler_APIDeleteLinksHandler(t *testing.T) {
 	router.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusGone, w.Code)
 }
+
+func TestHandler_ExportImportHandler(t *testing.T) {
+	newRouter := func() *gin.Engine {
+		repo, err := repository.New(&repository.Config{DriverName: "map"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		handler := NewHandler(&config.Config{}, usecase.New(repo))
+
+		router := gin.New()
+		router.GET("/api/internal/export", handler.ExportHandler)
+		router.POST("/api/internal/import", handler.ImportHandler)
+
+		return router
+	}
+
+	body := "short,long,owner,deleted,created_at,title,preview,password_hash,clicks_left\n" +
+		"zE,https://ya.ru,alice,true,2023-01-02T03:04:05Z,,false,,\n"
+
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "import csv",
+			method:       "POST",
+			target:       "/api/internal/import?format=csv",
+			body:         body,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"imported":1}`,
+		},
+		{
+			name:         "export csv",
+			method:       "GET",
+			target:       "/api/internal/export?format=csv",
+			expectedCode: http.StatusOK,
+			expectedBody: body,
+		},
+		{
+			name:         "export jsonl",
+			method:       "GET",
+			target:       "/api/internal/export",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"short":"zE","long":"https://ya.ru","owner":"alice","deleted":true,"created_at":"2023-01-02T03:04:05Z"}` + "\n",
+		},
+		{
+			name:         "import existing",
+			method:       "POST",
+			target:       "/api/internal/import?format=jsonl",
+			body:         `{"short":"zE","long":"https://ya.ru"}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":"can't import zE: the shortened URL already exists","imported":0}`,
+		},
+		{
+			name:         "unknown format",
+			method:       "GET",
+			target:       "/api/internal/export?format=xml",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":"unknown format, use jsonl or csv"}`,
+		},
+	}
+
+	router := newRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestHandler_AuditHandler(t *testing.T) {
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler(&config.Config{}, usecase.New(repo))
+
+	router := gin.New()
+	router.Use(audit.Handler(access.New(access.Config{}), audit.ActorAdmin))
+	router.POST("/api/internal/import", handler.ImportHandler)
+	router.GET("/api/internal/audit", handler.AuditHandler)
+
+	req := httptest.NewRequest("POST", "/api/internal/import",
+		bytes.NewBufferString(`{"short":"zE","long":"https://ya.ru","owner":"alice"}`+"\n"))
+	req.RemoteAddr = "10.0.0.1:4321"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	tests := []struct {
+		name         string
+		target       string
+		expectedCode int
+		expectedLen  int
+	}{
+		{name: "all", target: "/api/internal/audit", expectedCode: http.StatusOK, expectedLen: 1},
+		{name: "actor", target: "/api/internal/audit?actor=admin", expectedCode: http.StatusOK, expectedLen: 1},
+		{name: "other actor", target: "/api/internal/audit?actor=alice", expectedCode: http.StatusOK},
+		{name: "future", target: "/api/internal/audit?from=2100-01-01T00:00:00Z", expectedCode: http.StatusOK},
+		{name: "past", target: "/api/internal/audit?to=2000-01-01T00:00:00Z", expectedCode: http.StatusOK},
+		{name: "after", target: "/api/internal/audit?after=1&limit=10", expectedCode: http.StatusOK},
+		{name: "invalid from", target: "/api/internal/audit?from=yesterday", expectedCode: http.StatusBadRequest},
+		{name: "invalid limit", target: "/api/internal/audit?limit=0", expectedCode: http.StatusBadRequest},
+		{name: "invalid after", target: "/api/internal/audit?after=-1", expectedCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+
+			var entries []storage.AuditEntry
+			if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries)) && assert.Len(t, entries, tt.expectedLen) &&
+				tt.expectedLen > 0 {
+				assert.Equal(t, storage.AuditEntry{ID: 1, At: entries[0].At, Actor: audit.ActorAdmin, IP: "10.0.0.1",
+					Operation: audit.LinkCreate, Target: "zE", NewValue: "https://ya.ru", Outcome: storage.AuditSuccess}, entries[0])
+			}
+		})
+	}
+}
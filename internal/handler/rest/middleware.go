@@ -0,0 +1,133 @@
+package rest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"url-shortener/internal/access"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+	maxRequestIDLen = 128
+)
+
+// RequestID returns middleware that assigns an id to every request.
+// The id provided by the client in X-Request-ID is kept.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Request.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLen {
+			id = newRequestID()
+		}
+
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// RateLimit returns middleware that allows rps requests per second for every client ip
+// and answers 429 to the rest. Zero or negative rps disables limiting.
+func RateLimit(g *access.Guard, rps int) gin.HandlerFunc {
+	if rps <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	l := &limiter{rate: float64(rps), burst: float64(rps), clients: make(map[string]*bucket)}
+
+	return func(c *gin.Context) {
+		ip := g.ClientIP(c.Request.RemoteAddr,
+			c.Request.Header.Get("X-Forwarded-For"), c.Request.Header.Get("X-Real-IP"))
+
+		if !l.allow(ip.String(), time.Now()) {
+			c.Header("Retry-After", "1")
+			abortWithError(c, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// clientIP returns the IP of the client derived by g, empty if it is unknown.
+func clientIP(g *access.Guard, c *gin.Context) string {
+	ip := g.ClientIP(c.Request.RemoteAddr,
+		c.Request.Header.Get("X-Forwarded-For"), c.Request.Header.Get("X-Real-IP"))
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
+
+// maxClients the limiter forgets idle clients when this number is reached.
+const maxClients = 10000
+
+// limiter token bucket per client.
+type limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	clients map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *limiter) allow(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.clients[client]
+	if !ok {
+		if len(l.clients) >= maxClients {
+			l.evict(now)
+		}
+
+		b = &bucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// evict removes the clients that have a full bucket again.
+func (l *limiter) evict(now time.Time) {
+	for client, b := range l.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.clients, client)
+		}
+	}
+}
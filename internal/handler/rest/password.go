@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/usecase"
+
+	"github.com/gin-gonic/gin"
+)
+
+var passwordTemplate = template.Must(template.ParseFS(templates, "templates/password.html"))
+
+// passwordPage the data of the password template.
+type passwordPage struct {
+	// Action the short URL the form is posted to.
+	Action string
+	Error  string
+}
+
+// UnlockLinkHandler accepts the password of a protected link posted by the form of GetLinkHandler,
+// returns a redirect to the URL that was shortened if the password is right.
+func (h Handler) UnlockLinkHandler(c *gin.Context) {
+	h.followLink(c, c.Param("id"), c.PostForm("password"), true)
+}
+
+// followLink redirects to the URL that was shortened, the password form is answered for the protected links
+// and the preview page for the links set to always show it unless skipPreview.
+func (h Handler) followLink(c *gin.Context, id, password string, skipPreview bool) {
+	longURL, err := h.logic.FollowLink(c.Request.Context(), id, password, clientIP(h.guard, c), skipPreview)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrPreviewForced):
+			h.renderPreview(c, id)
+		case errors.Is(err, usecase.ErrPasswordRequired):
+			renderPassword(c, id, http.StatusOK, "")
+		case errors.Is(err, usecase.ErrWrongPassword):
+			renderPassword(c, id, http.StatusForbidden, "Wrong password.")
+		case errors.Is(err, usecase.ErrTooManyAttempts):
+			c.Header("Retry-After", strconv.Itoa(int(usecase.PasswordAttemptsWindow.Seconds())))
+			renderPassword(c, id, http.StatusTooManyRequests, "Too many wrong passwords, try again later.")
+		case errors.Is(err, usecase.ErrUnknownClient):
+			renderPassword(c, id, http.StatusForbidden, "The password can't be checked, your address is unknown.")
+		case errors.Is(err, storage.ErrDeleted), errors.Is(err, storage.ErrExhausted):
+			log.Println(err)
+			c.AbortWithStatus(http.StatusGone)
+		default:
+			log.Println(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+		}
+		return
+	}
+
+	c.Header("Location", longURL)
+	if c.Request.Method == http.MethodPost {
+		c.Status(http.StatusSeeOther)
+		return
+	}
+	c.Status(http.StatusTemporaryRedirect)
+}
+
+// renderPassword answers the password form of the protected link instead of the redirect.
+func renderPassword(c *gin.Context, id string, status int, message string) {
+	page := passwordPage{Action: (&url.URL{Path: id}).String(), Error: message}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	if err := passwordTemplate.Execute(c.Writer, page); err != nil {
+		log.Println("can't render password form", err)
+	}
+}
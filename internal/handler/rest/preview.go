@@ -0,0 +1,69 @@
+package rest
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/usecase"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates
+var templates embed.FS
+
+var previewTemplate = template.Must(template.ParseFS(templates, "templates/preview.html"))
+
+// previewSuffix the suffix of the short URL asking for the preview page, like /zE+.
+const previewSuffix = "+"
+
+// previewPage the data of the preview template.
+type previewPage struct {
+	usecase.Preview
+	// Continue the link following the short URL without the preview.
+	Continue string
+}
+
+// previewRequest returns the short URL of the request and whether the preview page is asked for
+// by the suffix or ?preview=1.
+func previewRequest(c *gin.Context) (string, bool) {
+	id := c.Param("id")
+	if strings.HasSuffix(id, previewSuffix) {
+		return strings.TrimSuffix(id, previewSuffix), true
+	}
+
+	return id, c.Query("preview") == "1"
+}
+
+// renderPreview answers the preview page of the link instead of the redirect.
+func (h Handler) renderPreview(c *gin.Context, id string) {
+	p, err := h.logic.GetPreview(c.Request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrPasswordRequired):
+			renderPassword(c, id, http.StatusOK, "")
+		case errors.Is(err, storage.ErrDeleted), errors.Is(err, storage.ErrExhausted):
+			c.AbortWithStatus(http.StatusGone)
+		case errors.Is(err, storage.ErrNotFound):
+			c.AbortWithStatus(http.StatusNotFound)
+		default:
+			log.Println(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+		}
+		return
+	}
+
+	page := previewPage{Preview: p, Continue: (&url.URL{Path: id, RawQuery: "preview=0"}).String()}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	if err = previewTemplate.Execute(c.Writer, page); err != nil {
+		log.Println("can't render preview", err)
+	}
+}
@@ -0,0 +1,98 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"url-shortener/internal/qrcode"
+	"url-shortener/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// qrMaxAge how long the clients and the proxies may cache a QR code, the code of a short URL never changes.
+const qrMaxAge = "public, max-age=86400"
+
+// QRCodeHandler answers the QR code of the short URL of the link.
+// The image is set by ?format=png|svg, ?size= in pixels, ?level=L|M|Q|H, ?margin= in modules
+// and the ?fg= and ?bg= colours as hex RRGGBB.
+func (h Handler) QRCodeHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	opts, err := parseQRParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err = h.logic.GetLink(c.Request.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrDeleted), errors.Is(err, storage.ErrExhausted):
+			c.AbortWithStatus(http.StatusGone)
+		case errors.Is(err, storage.ErrNotFound):
+			c.AbortWithStatus(http.StatusNotFound)
+		default:
+			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	URL, err := CreateLink(id, h.conf.BaseURL)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	image, err := qrcode.Encode(URL.String(), opts)
+	if errors.Is(err, qrcode.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		log.Println("can't encode QR code", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(image)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	c.Header("Cache-Control", qrMaxAge)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, opts.Format.ContentType(), image)
+}
+
+func parseQRParams(c *gin.Context) (qrcode.Options, error) {
+	p := qrcode.Params{
+		Format:     c.Query("format"),
+		Level:      c.Query("level"),
+		Foreground: c.Query("fg"),
+		Background: c.Query("bg"),
+	}
+
+	if size := c.Query("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return qrcode.Options{}, errors.New("invalid size: " + size)
+		}
+		p.Size = n
+	}
+
+	if margin := c.Query("margin"); margin != "" {
+		n, err := strconv.Atoi(margin)
+		if err != nil {
+			return qrcode.Options{}, errors.New("invalid margin: " + margin)
+		}
+		p.Margin = &n
+	}
+
+	return p.Options()
+}
@@ -0,0 +1,497 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"url-shortener/config"
+	"url-shortener/internal/access"
+	"url-shortener/internal/audit"
+	"url-shortener/internal/schema"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/service"
+	"url-shortener/internal/usecase"
+	"url-shortener/internal/webhook"
+	shortener "url-shortener/pkg/api"
+)
+
+const (
+	maxURLLen    = 2048
+	maxBatchSize = 1000
+	maxBodySize  = 1 << 20
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// errorCodes machine-readable codes of the error envelope.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusConflict:            "conflict",
+	http.StatusGone:                "gone",
+	http.StatusUnprocessableEntity: "validation_failed",
+	http.StatusTooManyRequests:     "rate_limited",
+	http.StatusInternalServerError: "internal",
+	http.StatusNotImplemented:      "not_implemented",
+}
+
+// HandlerV2 serves the /api/v2 routes.
+// Every error is answered with schema.ErrorResponse.
+type HandlerV2 struct {
+	conf    *config.Config
+	logic   usecase.UseCase
+	guard   *access.Guard
+	limiter gin.HandlerFunc
+}
+
+// NewHandlerV2 creates an instance of the HandlerV2.
+func NewHandlerV2(cfg *config.Config, logic usecase.UseCase, g *access.Guard) *HandlerV2 {
+	if cfg == nil || g == nil {
+		panic("nil pointer")
+	}
+
+	return &HandlerV2{conf: cfg, logic: logic, guard: g, limiter: RateLimit(g, cfg.RateLimit)}
+}
+
+// RateLimit limits requests per client, see config.Config RateLimit.
+func (h *HandlerV2) RateLimit(c *gin.Context) {
+	h.limiter(c)
+}
+
+// OpenAPI serves the OpenAPI 3 document of the v2 API.
+func (h *HandlerV2) OpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPI)
+}
+
+// CreateLink accepts {"url": "..."} and returns the shortened link.
+// Answers 409 with the Location of the existing link if it was already shortened.
+func (h *HandlerV2) CreateLink(c *gin.Context) {
+	var rj schema.RequestJSON
+	if !h.bind(c, &rj) {
+		return
+	}
+
+	if err := validateURL(rj.URL); err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, "url: "+err.Error())
+		return
+	}
+
+	if len(rj.Password) > usecase.MaxPasswordLength {
+		abortWithError(c, http.StatusUnprocessableEntity, "password: "+usecase.ErrPasswordTooLong.Error())
+		return
+	}
+
+	if rj.MaxClicks < 0 || rj.MaxClicks > math.MaxInt32 {
+		abortWithError(c, http.StatusUnprocessableEntity, "max_clicks: "+usecase.ErrInvalidMaxClicks.Error())
+		return
+	}
+
+	cookie := h.session(c)
+
+	restrictions := usecase.Restrictions{Password: rj.Password, MaxClicks: rj.MaxClicks}
+	chars, err := h.logic.CreateRestrictedLink(c.Request.Context(), rj.URL, cookie, restrictions)
+	if errors.Is(err, usecase.ErrRestrictedExists) {
+		abortWithError(c, http.StatusConflict, err.Error())
+		return
+	} else if err != nil && !errors.Is(err, service.ErrExists) {
+		c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, "can't create link")
+		return
+	}
+
+	URL, urlErr := CreateLink(chars, h.conf.BaseURL)
+	if urlErr != nil {
+		c.Error(urlErr)
+		abortWithError(c, http.StatusInternalServerError, "can't build link")
+		return
+	}
+
+	c.Header("Location", URL.String())
+	if err != nil {
+		abortWithError(c, http.StatusConflict, "link already exists")
+		return
+	}
+
+	c.JSON(http.StatusCreated, schema.URL{LongURL: rj.URL, ShortURL: URL.String()})
+}
+
+// Batch accepts a list of {"correlation_id", "original_url"} and saves them.
+// The mode query parameter is "atomic" (default) or "best-effort", see usecase.Batch.
+func (h *HandlerV2) Batch(c *gin.Context) {
+	var batchURLs []*shortener.LongAndShortURL
+	if !h.bind(c, &batchURLs) {
+		return
+	}
+
+	if len(batchURLs) == 0 || len(batchURLs) > maxBatchSize {
+		abortWithError(c, http.StatusUnprocessableEntity,
+			fmt.Sprintf("batch must contain from 1 to %d urls", maxBatchSize))
+		return
+	}
+
+	mode, err := usecase.ParseBatchMode(c.Query("mode"))
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	cookie := h.session(c)
+
+	data, err := h.logic.Batch(c.Request.Context(), batchURLs, cookie, h.conf.BaseURL,
+		usecase.BatchOptions{Mode: mode, Validate: validateURL})
+	var batchErr *usecase.BatchError
+	if errors.As(err, &batchErr) {
+		abortWithError(c, http.StatusUnprocessableEntity, batchErr.Error())
+		return
+	} else if err != nil {
+		c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, "can't create links")
+		return
+	}
+
+	c.JSON(http.StatusCreated, data)
+}
+
+// passwordHeader the header carrying the password of a protected link.
+const passwordHeader = "X-Link-Password"
+
+// GetLink returns the original link by the short one without redirecting.
+// The password of a protected link is taken from the X-Link-Password header.
+func (h *HandlerV2) GetLink(c *gin.Context) {
+	id := c.Param("id")
+
+	longURL, err := h.logic.OpenLink(c.Request.Context(), id, c.GetHeader(passwordHeader), clientIP(h.guard, c))
+	switch {
+	case errors.Is(err, usecase.ErrPasswordRequired):
+		abortWithError(c, http.StatusUnauthorized, err.Error())
+		return
+	case errors.Is(err, usecase.ErrWrongPassword), errors.Is(err, usecase.ErrUnknownClient):
+		abortWithError(c, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, usecase.ErrTooManyAttempts):
+		c.Header("Retry-After", strconv.Itoa(int(usecase.PasswordAttemptsWindow.Seconds())))
+		abortWithError(c, http.StatusTooManyRequests, err.Error())
+		return
+	case err != nil:
+		h.abortWithStorageError(c, err)
+		return
+	}
+
+	URL, err := CreateLink(id, h.conf.BaseURL)
+	if err != nil {
+		c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, "can't build link")
+		return
+	}
+
+	c.JSON(http.StatusOK, schema.URL{LongURL: longURL, ShortURL: URL.String()})
+}
+
+// GetUserLinks returns all links of the user. Requires a valid session.
+func (h *HandlerV2) GetUserLinks(c *gin.Context) {
+	cookie, ok := h.authenticated(c)
+	if !ok {
+		return
+	}
+
+	links, err := h.logic.GetAllLinksByCookie(c.Request.Context(), cookie, h.conf.BaseURL)
+	if err != nil {
+		c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, "can't get links")
+		return
+	}
+
+	if links == nil {
+		links = make([]*shortener.UserURL, 0)
+	}
+
+	c.JSON(http.StatusOK, links)
+}
+
+// DeleteUserLinks accepts a list of short ids and marks them as deleted.
+// Requires a valid session, every id must belong to the user.
+func (h *HandlerV2) DeleteUserLinks(c *gin.Context) {
+	cookie, ok := h.authenticated(c)
+	if !ok {
+		return
+	}
+
+	var ids []string
+	if !h.bind(c, &ids) {
+		return
+	}
+
+	if len(ids) == 0 || len(ids) > maxBatchSize {
+		abortWithError(c, http.StatusUnprocessableEntity,
+			fmt.Sprintf("list must contain from 1 to %d ids", maxBatchSize))
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	links, err := h.logic.GetAllLinksByCookie(ctx, cookie, "")
+	if err != nil {
+		c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, "can't get links")
+		return
+	}
+
+	owned := make(map[string]struct{}, len(links))
+	for _, link := range links {
+		owned[link.ShortUrl] = struct{}{}
+	}
+
+	for _, id := range ids {
+		if _, ok := owned[id]; ok {
+			continue
+		}
+
+		if _, err := h.logic.GetLink(ctx, id); errors.Is(err, storage.ErrNotFound) {
+			abortWithError(c, http.StatusNotFound, id+": link not found")
+			return
+		}
+
+		abortWithError(c, http.StatusForbidden, id+": link belongs to another user")
+		return
+	}
+
+	go func(ctx context.Context, cookie string, s []string) {
+		for _, URL := range s {
+			if err := h.logic.MarkAsDeleted(ctx, URL, cookie); err != nil {
+				log.Println("can't delete", URL, err)
+			}
+		}
+	}(audit.Detach(c.Request.Context()), cookie, ids)
+
+	c.Status(http.StatusAccepted)
+}
+
+// SetLinkOptions accepts {"title": "...", "preview": true} and replaces the options of the link.
+// Requires a valid session, the link must belong to the user.
+func (h *HandlerV2) SetLinkOptions(c *gin.Context) {
+	cookie, ok := h.authenticated(c)
+	if !ok {
+		return
+	}
+
+	var req schema.LinkOptions
+	if !h.bind(c, &req) {
+		return
+	}
+
+	id := c.Param("id")
+	err := h.logic.SetLinkOptions(c.Request.Context(), id, cookie,
+		storage.LinkOptions{Title: req.Title, Preview: req.Preview})
+	switch {
+	case errors.Is(err, usecase.ErrTitleTooLong):
+		abortWithError(c, http.StatusUnprocessableEntity, "title: "+err.Error())
+		return
+	case errors.Is(err, usecase.ErrNotOwner):
+		abortWithError(c, http.StatusForbidden, id+": link belongs to another user")
+		return
+	case err != nil:
+		h.abortWithStorageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+// RegisterWebhook accepts {"url": "...", "events": [...]} and registers the webhook of the user.
+// Requires a valid session, the response contains the secret the payloads are signed with.
+func (h *HandlerV2) RegisterWebhook(c *gin.Context) {
+	cookie, ok := h.authenticated(c)
+	if !ok {
+		return
+	}
+
+	var req schema.WebhookRequest
+	if !h.bind(c, &req) {
+		return
+	}
+
+	hook, err := h.logic.RegisterWebhook(c.Request.Context(), cookie, req.URL, req.Events)
+	if err != nil {
+		abortWithWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, hook)
+}
+
+// GetWebhooks returns the webhooks of the user. Requires a valid session.
+func (h *HandlerV2) GetWebhooks(c *gin.Context) {
+	cookie, ok := h.authenticated(c)
+	if !ok {
+		return
+	}
+
+	hooks, err := h.logic.GetWebhooks(c.Request.Context(), cookie)
+	if err != nil {
+		abortWithWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hooks)
+}
+
+// DeleteWebhook removes the webhook of the user. Requires a valid session.
+func (h *HandlerV2) DeleteWebhook(c *gin.Context) {
+	cookie, ok := h.authenticated(c)
+	if !ok {
+		return
+	}
+
+	if err := h.logic.DeleteWebhook(c.Request.Context(), cookie, c.Param("id")); err != nil {
+		abortWithWebhookError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// TestWebhook sends a ping event to the webhook of the user and returns the outcome.
+// Requires a valid session.
+func (h *HandlerV2) TestWebhook(c *gin.Context) {
+	cookie, ok := h.authenticated(c)
+	if !ok {
+		return
+	}
+
+	res, err := h.logic.TestWebhook(c.Request.Context(), cookie, c.Param("id"))
+	if err != nil {
+		abortWithWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// bind decodes the JSON body into v, answers 400 if it is malformed.
+func (h *HandlerV2) bind(c *gin.Context, v interface{}) bool {
+	body := io.Reader(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+
+	if strings.Contains(c.Request.Header.Get("Content-Encoding"), "gzip") {
+		data, err := DecompressGzip(body)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, "malformed gzip body")
+			return false
+		}
+		body = bytes.NewReader(data)
+	}
+
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		abortWithError(c, http.StatusBadRequest, "malformed JSON: "+err.Error())
+		return false
+	}
+
+	if dec.More() {
+		abortWithError(c, http.StatusBadRequest, "malformed JSON: unexpected data after the value")
+		return false
+	}
+
+	return true
+}
+
+// session returns the user's cookie, a new one is issued if it is absent or invalid.
+func (h *HandlerV2) session(c *gin.Context) string {
+	cookie, err := getCookies(c)
+	if err != nil || !checkCookies(cookie, h.conf.Key) {
+		cookie = setCookies(c, h.conf.Key)
+	}
+
+	return cookie
+}
+
+// authenticated returns the user's cookie, answers 401 if it is absent or invalid.
+func (h *HandlerV2) authenticated(c *gin.Context) (string, bool) {
+	cookie, err := getCookies(c)
+	if err != nil || !checkCookies(cookie, h.conf.Key) {
+		abortWithError(c, http.StatusUnauthorized, "valid session is required")
+		return "", false
+	}
+
+	return cookie, true
+}
+
+func (h *HandlerV2) abortWithStorageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrDeleted):
+		abortWithError(c, http.StatusGone, "link was deleted")
+	case errors.Is(err, storage.ErrExhausted):
+		abortWithError(c, http.StatusGone, "link has no visits left")
+	case errors.Is(err, storage.ErrNotFound):
+		abortWithError(c, http.StatusNotFound, "link not found")
+	default:
+		c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, "can't get link")
+	}
+}
+
+func abortWithWebhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, webhook.ErrInvalid), errors.Is(err, webhook.ErrTooMany):
+		abortWithError(c, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, webhook.ErrNotFound):
+		abortWithError(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, usecase.ErrWebhooksDisabled):
+		abortWithError(c, http.StatusNotImplemented, err.Error())
+	default:
+		c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, "can't manage webhooks")
+	}
+}
+
+func abortWithError(c *gin.Context, status int, message string) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+
+	c.AbortWithStatusJSON(status, schema.ErrorResponse{
+		Code:      code,
+		Message:   message,
+		RequestID: requestID(c),
+	})
+}
+
+func validateURL(raw string) error {
+	switch {
+	case raw == "":
+		return errors.New("required")
+	case len(raw) > maxURLLen:
+		return fmt.Errorf("must not be longer than %d characters", maxURLLen)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("must be a valid URL")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("must be an absolute http or https URL")
+	}
+
+	if u.Host == "" {
+		return errors.New("must contain a host")
+	}
+
+	return nil
+}
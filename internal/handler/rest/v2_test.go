@@ -0,0 +1,510 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"url-shortener/config"
+	"url-shortener/internal/access"
+	"url-shortener/internal/repository"
+	"url-shortener/internal/schema"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/usecase"
+	"url-shortener/internal/webhook"
+)
+
+const testCookie = "2daa0f44d32c33a74cfbfd96fd58134649862dd008bd8cba3c331314e81fb551-31363832313834363939393633363234313030"
+
+func newV2Router(t *testing.T, rateLimit int) (*gin.Engine, storage.IStorage) {
+	t.Helper()
+
+	cfg := &repository.Config{DriverName: "map"}
+	repo, err := repository.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{Key: []byte("CHANGE ME"), BaseURL: "http://localhost/", DBConfig: cfg, RateLimit: rateLimit}
+	h := NewHandlerV2(conf, usecase.New(repo), access.New(access.Config{}))
+
+	router := gin.New()
+	v2 := router.Group("/api/v2", RequestID(), h.RateLimit)
+	v2.GET("/openapi.json", h.OpenAPI)
+	v2.POST("/links", h.CreateLink)
+	v2.POST("/links/batch", h.Batch)
+	v2.GET("/links/:id", h.GetLink)
+	v2.GET("/user/links", h.GetUserLinks)
+	v2.DELETE("/user/links", h.DeleteUserLinks)
+	v2.PUT("/user/links/:id/options", h.SetLinkOptions)
+
+	return router, repo
+}
+
+func TestHandlerV2(t *testing.T) {
+	router, repo := newV2Router(t, 0)
+
+	ctx := context.Background()
+	repo.AddLink(ctx, "https://ya.ru", "ya", testCookie)
+	repo.AddLink(ctx, "https://vk.com", "vk", "someone-else")
+	repo.AddLink(ctx, "https://deleted.com", "del", testCookie)
+	repo.MarkAsDeleted(ctx, "del", testCookie)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		cookie   string
+		wantCode int
+		wantErr  string
+	}{
+		{
+			name:     "create",
+			method:   "POST",
+			target:   "/api/v2/links",
+			body:     `{"url":"https://example.com/path"}`,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "create malformed JSON",
+			method:   "POST",
+			target:   "/api/v2/links",
+			body:     `q`,
+			wantCode: http.StatusBadRequest,
+			wantErr:  "bad_request",
+		},
+		{
+			name:     "create unknown field",
+			method:   "POST",
+			target:   "/api/v2/links",
+			body:     `{"link":"https://example.com"}`,
+			wantCode: http.StatusBadRequest,
+			wantErr:  "bad_request",
+		},
+		{
+			name:     "create invalid url",
+			method:   "POST",
+			target:   "/api/v2/links",
+			body:     `{"url":"vk.com/gasayminajj"}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantErr:  "validation_failed",
+		},
+		{
+			name:     "create long password",
+			method:   "POST",
+			target:   "/api/v2/links",
+			body:     `{"url":"https://example.com/secret","password":"` + strings.Repeat("p", usecase.MaxPasswordLength+1) + `"}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantErr:  "validation_failed",
+		},
+		{
+			name:     "batch",
+			method:   "POST",
+			target:   "/api/v2/links/batch",
+			body:     `[{"correlation_id":"a1","original_url":"https://a.com"}]`,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "batch empty",
+			method:   "POST",
+			target:   "/api/v2/links/batch",
+			body:     `[]`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantErr:  "validation_failed",
+		},
+		{
+			name:     "batch duplicate correlation id",
+			method:   "POST",
+			target:   "/api/v2/links/batch",
+			body:     `[{"correlation_id":"b","original_url":"https://a.com"},{"correlation_id":"b","original_url":"https://b.com"}]`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantErr:  "validation_failed",
+		},
+		{
+			name:     "batch best effort",
+			method:   "POST",
+			target:   "/api/v2/links/batch?mode=best-effort",
+			body:     `[{"correlation_id":"c","original_url":"https://c.com"},{"correlation_id":"d","original_url":"ftp://d.com"}]`,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "batch unknown mode",
+			method:   "POST",
+			target:   "/api/v2/links/batch?mode=some",
+			body:     `[{"correlation_id":"e","original_url":"https://e.com"}]`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantErr:  "validation_failed",
+		},
+		{
+			name:     "get",
+			method:   "GET",
+			target:   "/api/v2/links/ya",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "get not found",
+			method:   "GET",
+			target:   "/api/v2/links/nope",
+			wantCode: http.StatusNotFound,
+			wantErr:  "not_found",
+		},
+		{
+			name:     "get deleted",
+			method:   "GET",
+			target:   "/api/v2/links/del",
+			wantCode: http.StatusGone,
+			wantErr:  "gone",
+		},
+		{
+			name:     "user links without session",
+			method:   "GET",
+			target:   "/api/v2/user/links",
+			wantCode: http.StatusUnauthorized,
+			wantErr:  "unauthorized",
+		},
+		{
+			name:     "user links",
+			method:   "GET",
+			target:   "/api/v2/user/links",
+			cookie:   testCookie,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "options",
+			method:   "PUT",
+			target:   "/api/v2/user/links/ya/options",
+			body:     `{"title":"Yandex","preview":true}`,
+			cookie:   testCookie,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "options without session",
+			method:   "PUT",
+			target:   "/api/v2/user/links/ya/options",
+			body:     `{"title":"Yandex"}`,
+			wantCode: http.StatusUnauthorized,
+			wantErr:  "unauthorized",
+		},
+		{
+			name:     "options of foreign link",
+			method:   "PUT",
+			target:   "/api/v2/user/links/vk/options",
+			body:     `{"preview":true}`,
+			cookie:   testCookie,
+			wantCode: http.StatusForbidden,
+			wantErr:  "forbidden",
+		},
+		{
+			name:     "options of missing link",
+			method:   "PUT",
+			target:   "/api/v2/user/links/nope/options",
+			body:     `{"preview":true}`,
+			cookie:   testCookie,
+			wantCode: http.StatusNotFound,
+			wantErr:  "not_found",
+		},
+		{
+			name:     "options of deleted link",
+			method:   "PUT",
+			target:   "/api/v2/user/links/del/options",
+			body:     `{"preview":true}`,
+			cookie:   testCookie,
+			wantCode: http.StatusGone,
+			wantErr:  "gone",
+		},
+		{
+			name:     "options long title",
+			method:   "PUT",
+			target:   "/api/v2/user/links/ya/options",
+			body:     `{"title":"` + strings.Repeat("a", usecase.MaxTitleLength+1) + `"}`,
+			cookie:   testCookie,
+			wantCode: http.StatusUnprocessableEntity,
+			wantErr:  "validation_failed",
+		},
+		{
+			name:     "delete foreign link",
+			method:   "DELETE",
+			target:   "/api/v2/user/links",
+			body:     `["vk"]`,
+			cookie:   testCookie,
+			wantCode: http.StatusForbidden,
+			wantErr:  "forbidden",
+		},
+		{
+			name:     "delete missing link",
+			method:   "DELETE",
+			target:   "/api/v2/user/links",
+			body:     `["nope"]`,
+			cookie:   testCookie,
+			wantCode: http.StatusNotFound,
+			wantErr:  "not_found",
+		},
+		{
+			name:     "delete",
+			method:   "DELETE",
+			target:   "/api/v2/user/links",
+			body:     `["ya"]`,
+			cookie:   testCookie,
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "openapi",
+			method:   "GET",
+			target:   "/api/v2/openapi.json",
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body))
+			if tt.cookie != "" {
+				req.Header.Set("Authorization", tt.cookie)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+
+			if tt.wantErr == "" {
+				return
+			}
+
+			var resp schema.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("error envelope expected, got %q", w.Body.String())
+			}
+			assert.Equal(t, tt.wantErr, resp.Code)
+			assert.NotEmpty(t, resp.Message)
+			assert.Equal(t, w.Header().Get("X-Request-ID"), resp.RequestID)
+		})
+	}
+}
+
+func TestHandlerV2_CreateLink(t *testing.T) {
+	router, _ := newV2Router(t, 0)
+
+	req := httptest.NewRequest("POST", "/api/v2/links", bytes.NewBufferString(`{"url":"https://ya.ru"}`))
+	req.Header.Set("Authorization", testCookie)
+	req.Header.Set("X-Request-ID", "test-id")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var link schema.URL
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "https://ya.ru", link.LongURL)
+	assert.Equal(t, link.ShortURL, w.Header().Get("Location"))
+	assert.Equal(t, "test-id", w.Header().Get("X-Request-ID"))
+}
+
+func TestHandlerV2_ProtectedLink(t *testing.T) {
+	router, _ := newV2Router(t, 0)
+
+	req := httptest.NewRequest("POST", "/api/v2/links", bytes.NewBufferString(`{"url":"https://ya.ru","password":"secret"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if !assert.Equal(t, http.StatusCreated, w.Code) {
+		return
+	}
+	id := strings.TrimPrefix(w.Header().Get("Location"), "http://localhost/")
+
+	tests := []struct {
+		name     string
+		password string
+		wantCode int
+		wantErr  string
+	}{
+		{name: "without password", wantCode: http.StatusUnauthorized, wantErr: "unauthorized"},
+		{name: "wrong password", password: "wrong", wantCode: http.StatusForbidden, wantErr: "forbidden"},
+		{name: "right password", password: "secret", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v2/links/"+id, nil)
+			if tt.password != "" {
+				req.Header.Set("X-Link-Password", tt.password)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+
+			if tt.wantErr == "" {
+				assert.Contains(t, w.Body.String(), "https://ya.ru")
+				return
+			}
+
+			var resp schema.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("error envelope expected, got %q", w.Body.String())
+			}
+			assert.Equal(t, tt.wantErr, resp.Code)
+		})
+	}
+}
+
+func TestHandlerV2_MaxClicks(t *testing.T) {
+	router, _ := newV2Router(t, 0)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", testCookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := do("POST", "/api/v2/links", `{"url":"https://ya.ru","max_clicks":-1}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = do("POST", "/api/v2/links", `{"url":"https://ya.ru","max_clicks":1}`)
+	if !assert.Equal(t, http.StatusCreated, w.Code) {
+		return
+	}
+	id := strings.TrimPrefix(w.Header().Get("Location"), "http://localhost/")
+
+	w = do("GET", "/api/v2/user/links", "")
+	assert.JSONEq(t, `[{"short_url":"http://localhost/`+id+`","original_url":"https://ya.ru","clicks_left":1}]`, w.Body.String())
+
+	w = do("GET", "/api/v2/links/"+id, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do("GET", "/api/v2/links/"+id, "")
+	assert.Equal(t, http.StatusGone, w.Code)
+
+	var resp schema.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error envelope expected, got %q", w.Body.String())
+	}
+	assert.Equal(t, "gone", resp.Code)
+
+	w = do("GET", "/api/v2/user/links", "")
+	assert.Contains(t, w.Body.String(), `"clicks_left":0`)
+}
+
+func TestHandlerV2_RateLimit(t *testing.T) {
+	router, _ := newV2Router(t, 2)
+
+	codes := make([]int, 0, 3)
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/api/v2/openapi.json", nil)
+		req.RemoteAddr = "192.168.0.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+
+	req := httptest.NewRequest("GET", "/api/v2/openapi.json", nil)
+	req.RemoteAddr = "192.168.0.2:1234"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandlerV2_Webhooks(t *testing.T) {
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hooks, err := webhook.New(webhook.Config{Key: []byte("CHANGE ME"), AllowPrivate: true}, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hooks.Close()
+
+	pings := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pings <- r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	conf := &config.Config{Key: []byte("CHANGE ME"), BaseURL: "http://localhost/"}
+	h := NewHandlerV2(conf, usecase.New(repo).WithWebhooks(hooks), access.New(access.Config{}))
+
+	router := gin.New()
+	v2 := router.Group("/api/v2", RequestID())
+	v2.POST("/user/webhooks", h.RegisterWebhook)
+	v2.GET("/user/webhooks", h.GetWebhooks)
+	v2.DELETE("/user/webhooks/:id", h.DeleteWebhook)
+	v2.POST("/user/webhooks/:id/test", h.TestWebhook)
+
+	do := func(method, target, body, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if cookie != "" {
+			req.Header.Set("Authorization", cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := do("POST", "/api/v2/user/webhooks", `{"url":"`+srv.URL+`","events":["link.created"]}`, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = do("POST", "/api/v2/user/webhooks", `{"url":"`+srv.URL+`","events":["link.renamed"]}`, testCookie)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = do("POST", "/api/v2/user/webhooks", `{"url":"`+srv.URL+`","events":["link.created"]}`, testCookie)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var hook webhook.Webhook
+	if err = json.Unmarshal(w.Body.Bytes(), &hook); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, srv.URL, hook.URL)
+	assert.Equal(t, webhook.Secret(conf.Key, hook.ID), hook.Secret)
+
+	w = do("GET", "/api/v2/user/webhooks", "", testCookie)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var list []webhook.Webhook
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list, 1)
+
+	w = do("POST", "/api/v2/user/webhooks/"+hook.ID+"/test", "", testCookie)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status_code":200}`, w.Body.String())
+	assert.Equal(t, webhook.EventPing, (<-pings).Get(webhook.HeaderEvent))
+
+	w = do("DELETE", "/api/v2/user/webhooks/"+hook.ID, "", NewCookie(conf.Key))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do("DELETE", "/api/v2/user/webhooks/"+hook.ID, "", testCookie)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = do("POST", "/api/v2/user/webhooks/"+hook.ID+"/test", "", testCookie)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandlerV2_WebhooksDisabled(t *testing.T) {
+	router, _ := newV2Router(t, 0)
+	router.GET("/api/v2/user/webhooks", NewHandlerV2(&config.Config{Key: []byte("CHANGE ME")}, usecase.New(nil),
+		access.New(access.Config{})).GetWebhooks)
+
+	req := httptest.NewRequest("GET", "/api/v2/user/webhooks", nil)
+	req.Header.Set("Authorization", testCookie)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	var resp schema.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "not_implemented", resp.Code)
+}
@@ -0,0 +1,197 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// MetadataKey the metadata of the key.
+const MetadataKey = "idempotency-key"
+
+// savedCodes the errors saved as the response, the others may be retried with the same key.
+var savedCodes = map[codes.Code]bool{
+	codes.OK:              true,
+	codes.InvalidArgument: true,
+	codes.AlreadyExists:   true,
+}
+
+// grpcResponse a saved response of the gRPC handlers.
+type grpcResponse struct {
+	Header  metadata.MD `json:"header,omitempty"`
+	Code    codes.Code  `json:"code"`
+	Message string      `json:"message,omitempty"`
+	// Type the full name of the response message.
+	Type string `json:"type,omitempty"`
+	Body []byte `json:"body,omitempty"`
+}
+
+// stream copies the header metadata sent by the handler.
+type stream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *stream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return s.ServerTransportStream.SetHeader(md)
+}
+
+func (s *stream) SendHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return s.ServerTransportStream.SendHeader(md)
+}
+
+// UnaryServerInterceptor returns an interceptor that replays the responses of the listed methods
+// called with the idempotency-key metadata. The payload is the request message, the client is
+// identified by the token metadata or the IP derived by the guard of the Store. The token sent
+// to the client in the header is not saved.
+//
+// A key reused with another payload gets InvalidArgument, a key of a call still in progress
+// gets Aborted. Methods are full gRPC method names, e.g. /api.Shortener/Create.
+func (s *Store) UnaryServerInterceptor(methods ...string) grpc.UnaryServerInterceptor {
+	idempotent := make(map[string]struct{}, len(methods))
+	for _, m := range methods {
+		idempotent[m] = struct{}{}
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := idempotent[info.FullMethod]; !ok {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		keys := md.Get(MetadataKey)
+		msg, ok := req.(proto.Message)
+		if len(keys) == 0 || !ok {
+			return handler(ctx, req)
+		}
+
+		payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Internal error")
+		}
+
+		r, replay, err := s.Begin(ctx, s.grpcScope(ctx, md, info.FullMethod), keys[0], payload)
+		switch {
+		case errors.Is(err, ErrInvalidKey):
+			return nil, status.Errorf(codes.InvalidArgument, "%s must be 1 to %d characters long", MetadataKey, MaxKeyLength)
+		case errors.Is(err, ErrMismatch):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, ErrInProgress):
+			return nil, status.Error(codes.Aborted, err.Error())
+		case err != nil:
+			log.Printf("idempotency: %v", err)
+			return nil, status.Errorf(codes.Internal, "Internal error")
+		}
+
+		if replay != nil {
+			return replayGRPC(ctx, replay)
+		}
+
+		saved := false
+		defer func() {
+			if !saved {
+				if err := r.Release(context.Background()); err != nil {
+					log.Printf("idempotency: can't release the key: %v", err)
+				}
+			}
+		}()
+
+		var st *stream
+		if ts := grpc.ServerTransportStreamFromContext(ctx); ts != nil {
+			st = &stream{ServerTransportStream: ts}
+			ctx = grpc.NewContextWithServerTransportStream(ctx, st)
+		}
+
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+		if !savedCodes[code] {
+			return resp, err
+		}
+
+		saving := grpcResponse{Code: code, Message: status.Convert(err).Message()}
+		if st != nil {
+			saving.Header = st.header.Copy()
+			delete(saving.Header, "token")
+		}
+
+		if m, ok := resp.(proto.Message); ok && err == nil {
+			saving.Type = string(proto.MessageName(m))
+			if saving.Body, err = proto.Marshal(m); err != nil {
+				log.Printf("idempotency: can't encode the response: %v", err)
+				return resp, nil
+			}
+		}
+
+		data, jsonErr := json.Marshal(saving)
+		if jsonErr != nil {
+			log.Printf("idempotency: can't encode the response: %v", jsonErr)
+			return resp, err
+		}
+
+		if saveErr := r.Complete(context.Background(), data); saveErr != nil {
+			log.Printf("idempotency: can't save the response: %v", saveErr)
+			return resp, err
+		}
+		saved = true
+
+		return resp, err
+	}
+}
+
+// replayGRPC sends the saved header and returns the saved response.
+func replayGRPC(ctx context.Context, replay []byte) (interface{}, error) {
+	var saved grpcResponse
+	if err := json.Unmarshal(replay, &saved); err != nil {
+		log.Printf("idempotency: can't decode the saved response: %v", err)
+		return nil, status.Errorf(codes.Internal, "Internal error")
+	}
+
+	if grpc.ServerTransportStreamFromContext(ctx) != nil {
+		header := metadata.Join(saved.Header, metadata.Pairs(ReplayedHeader, "true"))
+		if err := grpc.SendHeader(ctx, header); err != nil {
+			log.Printf("idempotency: can't send the header: %v", err)
+		}
+	}
+
+	if saved.Code != codes.OK {
+		return nil, status.Error(saved.Code, saved.Message)
+	}
+
+	typ, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(saved.Type))
+	if err != nil {
+		log.Printf("idempotency: unknown response type %q: %v", saved.Type, err)
+		return nil, status.Errorf(codes.Internal, "Internal error")
+	}
+
+	resp := typ.New().Interface()
+	if err = proto.Unmarshal(saved.Body, resp); err != nil {
+		log.Printf("idempotency: can't decode the saved response: %v", err)
+		return nil, status.Errorf(codes.Internal, "Internal error")
+	}
+
+	return resp, nil
+}
+
+// grpcScope identifies the method and the client of the call.
+func (s *Store) grpcScope(ctx context.Context, md metadata.MD, method string) string {
+	var client string
+	if tokens := md.Get("token"); len(tokens) > 0 {
+		client = tokens[0]
+	} else if ip := s.guard.PeerIP(ctx); ip != nil {
+		client = "ip " + ip.String()
+	}
+
+	return "grpc\x00" + method + "\x00" + client
+}
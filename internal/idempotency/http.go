@@ -0,0 +1,171 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header the request header of the key.
+const Header = "Idempotency-Key"
+
+// ReplayedHeader is set to true on the replayed responses.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// replayedHeaders the headers of a response saved with its body. The credentials issued to the client,
+// Authorization and Set-Cookie, are never saved: the key of a client without them is scoped by its IP,
+// which another client may share.
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+// httpResponse a saved response of the REST handlers.
+type httpResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// recorder copies the body written to the response.
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Handler returns gin middleware that replays the responses of the requests with the Idempotency-Key
+// header. The payload is the method, the path, the query and the body, the client is identified by
+// the Authorization header, the session cookie or the IP derived by the guard of the Store.
+//
+// A key reused with another payload gets 422, a key of a request still in progress gets 409.
+// Responses with 5xx, 408 and 429 are not saved, the request may be retried with the same key.
+func (s *Store) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		payload := bytes.Join([][]byte{[]byte(c.Request.Method), []byte(c.Request.URL.Path),
+			[]byte(c.Request.URL.RawQuery), body}, []byte{0})
+
+		req, replay, err := s.Begin(c.Request.Context(), s.httpScope(c), key, payload)
+		switch {
+		case errors.Is(err, ErrInvalidKey):
+			c.String(http.StatusBadRequest, "%s must be 1 to %d characters long", Header, MaxKeyLength)
+			c.Abort()
+			return
+		case errors.Is(err, ErrMismatch):
+			c.String(http.StatusUnprocessableEntity, err.Error())
+			c.Abort()
+			return
+		case errors.Is(err, ErrInProgress):
+			c.String(http.StatusConflict, err.Error())
+			c.Abort()
+			return
+		case err != nil:
+			log.Printf("idempotency: %v", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		if replay != nil {
+			var resp httpResponse
+			if err = json.Unmarshal(replay, &resp); err != nil {
+				log.Printf("idempotency: can't decode the saved response: %v", err)
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+
+			for name, values := range resp.Header {
+				for _, v := range values {
+					c.Writer.Header().Add(name, v)
+				}
+			}
+			c.Header(ReplayedHeader, "true")
+			c.Status(resp.Status)
+			c.Writer.Write(resp.Body)
+			c.Abort()
+
+			return
+		}
+
+		w := &recorder{ResponseWriter: c.Writer}
+		c.Writer = w
+
+		saved := false
+		defer func() {
+			// the client may be gone, the key must not stay reserved anyway
+			if !saved {
+				if err := req.Release(context.Background()); err != nil {
+					log.Printf("idempotency: can't release the key: %v", err)
+				}
+			}
+		}()
+
+		c.Next()
+
+		status := w.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusRequestTimeout ||
+			status == http.StatusTooManyRequests {
+			return
+		}
+
+		resp := httpResponse{Status: status, Header: make(http.Header), Body: w.body.Bytes()}
+		for _, name := range replayedHeaders {
+			if values := w.Header().Values(name); len(values) > 0 {
+				resp.Header[name] = values
+			}
+		}
+
+		data, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("idempotency: can't encode the response: %v", err)
+			return
+		}
+
+		if err = req.Complete(context.Background(), data); err != nil {
+			log.Printf("idempotency: can't save the response: %v", err)
+			return
+		}
+		saved = true
+	}
+}
+
+// httpScope identifies the endpoint and the client of the request.
+func (s *Store) httpScope(c *gin.Context) string {
+	client := c.GetHeader("Authorization")
+	if client == "" {
+		if cookie, err := c.Cookie("session"); err == nil {
+			client = cookie
+		}
+	}
+
+	if client == "" {
+		client = "ip " + s.guard.ClientIP(c.Request.RemoteAddr,
+			c.Request.Header.Get("X-Forwarded-For"), c.Request.Header.Get("X-Real-IP")).String()
+	}
+
+	return "rest\x00" + c.Request.Method + " " + c.FullPath() + "\x00" + client
+}
@@ -0,0 +1,190 @@
+// Package idempotency replays the responses of the requests repeated with the same idempotency key.
+//
+// A key is scoped by the endpoint and the client, a key reused with another payload is rejected.
+// The keys are kept by the configured storage if it implements storage.IIdempotency,
+// in memory otherwise.
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+	"url-shortener/internal/access"
+	"url-shortener/internal/storage"
+)
+
+// DefaultTTL the default retention of the keys.
+const DefaultTTL = 24 * time.Hour
+
+// MaxKeyLength the longest key accepted.
+const MaxKeyLength = 255
+
+var (
+	// ErrInvalidKey occurs when the key is empty or too long.
+	ErrInvalidKey = errors.New("invalid idempotency key")
+	// ErrMismatch occurs when the key was used with another payload.
+	ErrMismatch = errors.New("the idempotency key was used with another payload")
+	// ErrInProgress occurs when the request with the key has not completed yet.
+	ErrInProgress = errors.New("a request with the idempotency key is in progress")
+)
+
+// Store reserves the keys and keeps the responses of their requests.
+type Store struct {
+	backend storage.IIdempotency
+	ttl     time.Duration
+	// guard derives the IP of the clients without credentials.
+	guard *access.Guard
+}
+
+// New returns the Store keeping the keys for ttl in st, or in memory if st can't keep them.
+func New(st storage.IStorage, ttl time.Duration) *Store {
+	backend, ok := storage.As[storage.IIdempotency](st)
+	if !ok {
+		backend = NewMemory()
+	}
+
+	return NewWithBackend(backend, ttl)
+}
+
+// NewWithBackend returns the Store keeping the keys for ttl in backend.
+func NewWithBackend(backend storage.IIdempotency, ttl time.Duration) *Store {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Store{backend: backend, ttl: ttl, guard: access.New(access.Config{})}
+}
+
+// WithGuard returns the Store telling the clients without credentials apart by the IP derived by g,
+// the trusted proxies of g included. The IP of the connection is used otherwise.
+func (s *Store) WithGuard(g *access.Guard) *Store {
+	if g == nil {
+		panic("nil pointer")
+	}
+
+	s.guard = g
+
+	return s
+}
+
+// Request a request holding its key until it is completed or released.
+type Request struct {
+	store *Store
+	key   string
+}
+
+// Begin reserves the key of the client scope for the request with the payload.
+// If the key was used by a completed request with the same payload, its response
+// is returned to be replayed instead.
+func (s *Store) Begin(ctx context.Context, scope, key string, payload []byte) (*Request, []byte, error) {
+	if key == "" || len(key) > MaxKeyLength {
+		return nil, nil, ErrInvalidKey
+	}
+
+	rec := storage.IdempotencyRecord{
+		Key:         hash([]byte(scope + "\x00" + key)),
+		Fingerprint: hash(payload),
+		ExpiresAt:   time.Now().Add(s.ttl),
+	}
+
+	found, reserved, err := s.backend.ReserveKey(ctx, rec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if reserved {
+		return &Request{store: s, key: rec.Key}, nil, nil
+	}
+
+	if found.Fingerprint != rec.Fingerprint {
+		return nil, nil, ErrMismatch
+	}
+
+	if found.Response == nil {
+		return nil, nil, ErrInProgress
+	}
+
+	return nil, found.Response, nil
+}
+
+// Complete saves the response to be replayed.
+func (r *Request) Complete(ctx context.Context, response []byte) error {
+	return r.store.backend.CompleteKey(ctx, r.key, response)
+}
+
+// Release frees the key, so the request can be retried.
+func (r *Request) Release(ctx context.Context) error {
+	return r.store.backend.ReleaseKey(ctx, r.key)
+}
+
+func hash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// sweepInterval how often Memory looks for the expired records.
+const sweepInterval = time.Minute
+
+// Memory keeps the keys in memory for the storages that can't keep them, like the map storage.
+type Memory struct {
+	mu        sync.Mutex
+	records   map[string]storage.IdempotencyRecord
+	lastSweep time.Time
+}
+
+var _ storage.IIdempotency = (*Memory)(nil)
+
+// NewMemory creates an instance of the Memory.
+func NewMemory() *Memory {
+	return &Memory{records: make(map[string]storage.IdempotencyRecord)}
+}
+
+// ReserveKey saves the record unless the key is taken by a record that has not expired.
+func (m *Memory) ReserveKey(_ context.Context, rec storage.IdempotencyRecord) (storage.IdempotencyRecord, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		for key, r := range m.records {
+			if !now.Before(r.ExpiresAt) {
+				delete(m.records, key)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	if found, ok := m.records[rec.Key]; ok && now.Before(found.ExpiresAt) {
+		return found, false, nil
+	}
+
+	m.records[rec.Key] = rec
+
+	return rec, true, nil
+}
+
+// CompleteKey saves the response of the request with the key.
+func (m *Memory) CompleteKey(_ context.Context, key string, response []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rec, ok := m.records[key]; ok {
+		rec.Response = response
+		m.records[key] = rec
+	}
+
+	return nil
+}
+
+// ReleaseKey removes the key.
+func (m *Memory) ReleaseKey(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+
+	return nil
+}
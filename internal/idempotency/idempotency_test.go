@@ -0,0 +1,291 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"url-shortener/internal/access"
+	"url-shortener/internal/storage"
+	shortener "url-shortener/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestStore_Begin(t *testing.T) {
+	ctx := context.Background()
+	s := NewWithBackend(NewMemory(), time.Hour)
+
+	req, replay, err := s.Begin(ctx, "alice", "k", []byte("payload"))
+	if !assert.NoError(t, err) || !assert.NotNil(t, req) {
+		return
+	}
+	assert.Nil(t, replay)
+
+	_, _, err = s.Begin(ctx, "alice", "k", []byte("payload"))
+	assert.True(t, errors.Is(err, ErrInProgress), err)
+
+	_, _, err = s.Begin(ctx, "alice", "k", []byte("other"))
+	assert.True(t, errors.Is(err, ErrMismatch), err)
+
+	assert.NoError(t, req.Complete(ctx, []byte("response")))
+
+	_, replay, err = s.Begin(ctx, "alice", "k", []byte("payload"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("response"), replay)
+
+	// the key of another client is another key
+	other, _, err := s.Begin(ctx, "bob", "k", []byte("other"))
+	assert.NoError(t, err)
+	assert.NoError(t, other.Release(ctx))
+
+	other, _, err = s.Begin(ctx, "bob", "k", []byte("payload"))
+	assert.NoError(t, err, "released keys can be reused")
+	assert.NotNil(t, other)
+
+	_, _, err = s.Begin(ctx, "alice", "", nil)
+	assert.True(t, errors.Is(err, ErrInvalidKey), err)
+
+	_, _, err = s.Begin(ctx, "alice", strings.Repeat("k", MaxKeyLength+1), nil)
+	assert.True(t, errors.Is(err, ErrInvalidKey), err)
+}
+
+func TestMemory_Expiry(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	_, reserved, err := m.ReserveKey(ctx, storage.IdempotencyRecord{Key: "k", Fingerprint: "a",
+		ExpiresAt: time.Now().Add(-time.Second)})
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+
+	found, reserved, err := m.ReserveKey(ctx, storage.IdempotencyRecord{Key: "k", Fingerprint: "b",
+		ExpiresAt: time.Now().Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.True(t, reserved, "the expired key is taken over")
+	assert.Equal(t, "b", found.Fingerprint)
+}
+
+func TestStore_Handler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var calls int
+	router := gin.New()
+	router.POST("/links", NewWithBackend(NewMemory(), time.Hour).Handler(), func(c *gin.Context) {
+		calls++
+
+		switch c.Query("fail") {
+		case "panic":
+			panic("boom")
+		case "":
+			c.Header("Authorization", "token")
+			c.String(http.StatusCreated, "created %d", calls)
+		default:
+			c.Status(http.StatusInternalServerError)
+		}
+	})
+
+	do := func(target, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(Header, key)
+		}
+		w := httptest.NewRecorder()
+
+		func() {
+			defer func() { recover() }()
+			router.ServeHTTP(w, req)
+		}()
+
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		key      string
+		body     string
+		want     int
+		wantBody string
+		replayed bool
+	}{
+		{name: "first", target: "/links", key: "a", body: "x", want: http.StatusCreated, wantBody: "created 1"},
+		{name: "replayed", target: "/links", key: "a", body: "x", want: http.StatusCreated, wantBody: "created 1", replayed: true},
+		{name: "other body", target: "/links", key: "a", body: "y", want: http.StatusUnprocessableEntity},
+		{name: "other query", target: "/links?fail=", key: "a", body: "x", want: http.StatusUnprocessableEntity},
+		{name: "no key", target: "/links", body: "x", want: http.StatusCreated, wantBody: "created 2"},
+		{name: "server error", target: "/links?fail=1", key: "b", body: "x", want: http.StatusInternalServerError},
+		{name: "server error is not saved", target: "/links?fail=1", key: "b", body: "x", want: http.StatusInternalServerError},
+		{name: "panic", target: "/links?fail=panic", key: "c", body: "x", want: http.StatusOK},
+		{name: "panic is not saved", target: "/links", key: "c", body: "x", want: http.StatusCreated, wantBody: "created 6"},
+		{name: "long key", target: "/links", key: strings.Repeat("k", MaxKeyLength+1), want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.target, tt.key, tt.body)
+			assert.Equal(t, tt.want, w.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+			}
+			if tt.replayed {
+				assert.Equal(t, "true", w.Header().Get(ReplayedHeader))
+				assert.Empty(t, w.Header().Get("Authorization"))
+			} else if tt.wantBody != "" {
+				assert.Equal(t, "token", w.Header().Get("Authorization"))
+			}
+		})
+	}
+	assert.Equal(t, 6, calls)
+}
+
+func TestStore_Handler_ClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		name  string
+		guard *access.Guard
+		want  int
+	}{
+		{name: "spoofed", want: http.StatusUnprocessableEntity},
+		{name: "trusted proxy", guard: access.New(access.Config{TrustedProxies: []*net.IPNet{proxies}}), want: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewWithBackend(NewMemory(), time.Hour)
+			if tt.guard != nil {
+				s.WithGuard(tt.guard)
+			}
+
+			router := gin.New()
+			router.POST("/links", s.Handler(), func(c *gin.Context) {
+				c.Status(http.StatusCreated)
+			})
+
+			do := func(xff, body string) int {
+				req := httptest.NewRequest("POST", "/links", strings.NewReader(body))
+				req.RemoteAddr = "10.0.0.1:1234"
+				req.Header.Set(Header, "a")
+				req.Header.Set("X-Forwarded-For", xff)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+
+				return w.Code
+			}
+
+			assert.Equal(t, http.StatusCreated, do("1.1.1.1", "x"))
+			assert.Equal(t, tt.want, do("2.2.2.2", "y"))
+		})
+	}
+}
+
+func TestStore_Handler_InProgress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := NewWithBackend(NewMemory(), time.Hour)
+	entered, release := make(chan struct{}), make(chan struct{})
+
+	router := gin.New()
+	router.POST("/links", s.Handler(), func(c *gin.Context) {
+		close(entered)
+		<-release
+		c.Status(http.StatusCreated)
+	})
+
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/links", nil)
+		req.Header.Set(Header, "a")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	first := make(chan int)
+	go func() { first <- do().Code }()
+
+	<-entered
+	assert.Equal(t, http.StatusConflict, do().Code)
+	close(release)
+	assert.Equal(t, http.StatusCreated, <-first)
+}
+
+func TestStore_UnaryServerInterceptor(t *testing.T) {
+	interceptor := NewWithBackend(NewMemory(), time.Hour).UnaryServerInterceptor("/api.Shortener/Create")
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Shortener/Create"}
+
+	var calls int
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+
+		switch req.(*shortener.CreateRequest).GetUrl() {
+		case "exists":
+			return nil, status.Errorf(codes.AlreadyExists, "exists %d", calls)
+		case "down":
+			return nil, status.Errorf(codes.Unavailable, "down")
+		}
+
+		return &shortener.CreateResponse{Shortened: "short"}, nil
+	}
+
+	call := func(method, key, token, url string) (interface{}, error) {
+		md := metadata.Pairs("token", token)
+		if key != "" {
+			md.Set(MetadataKey, key)
+		}
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+
+		return interceptor(ctx, &shortener.CreateRequest{Url: url}, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+
+	want := &shortener.CreateResponse{Shortened: "short"}
+
+	tests := []struct {
+		name     string
+		method   string
+		key      string
+		token    string
+		url      string
+		wantCode codes.Code
+		wantMsg  string
+	}{
+		{name: "first", key: "a", token: "alice", url: "ya.ru"},
+		{name: "replayed", key: "a", token: "alice", url: "ya.ru"},
+		{name: "other payload", key: "a", token: "alice", url: "go.dev", wantCode: codes.InvalidArgument},
+		{name: "other client", key: "a", token: "bob", url: "go.dev"},
+		{name: "error", key: "b", token: "alice", url: "exists", wantCode: codes.AlreadyExists, wantMsg: "exists 3"},
+		{name: "error replayed", key: "b", token: "alice", url: "exists", wantCode: codes.AlreadyExists, wantMsg: "exists 3"},
+		{name: "unavailable", key: "c", token: "alice", url: "down", wantCode: codes.Unavailable},
+		{name: "unavailable is not saved", key: "c", token: "alice", url: "ya.ru"},
+		{name: "no key", token: "alice", url: "ya.ru"},
+		{name: "other method", method: "/api.Shortener/GetAll", key: "a", token: "alice", url: "go.dev"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = info.FullMethod
+			}
+
+			got, err := call(method, tt.key, tt.token, tt.url)
+			assert.Equal(t, tt.wantCode, status.Code(err), err)
+			if tt.wantMsg != "" {
+				assert.Equal(t, tt.wantMsg, status.Convert(err).Message())
+			}
+			if err == nil {
+				assert.True(t, proto.Equal(want, got.(proto.Message)), got)
+			}
+		})
+	}
+	assert.Equal(t, 7, calls)
+}
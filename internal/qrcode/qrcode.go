@@ -0,0 +1,260 @@
+// Package qrcode renders the QR codes of the short links as PNG or SVG images.
+//
+// The codes are encoded by rsc.io/qr, a pure Go encoder, the images are drawn here
+// so the size, the margin and the colours can be chosen.
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// Format the image format of a code.
+type Format string
+
+// Formats.
+const (
+	PNG Format = "png"
+	SVG Format = "svg"
+)
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == SVG {
+		return "image/svg+xml"
+	}
+
+	return "image/png"
+}
+
+// Bounds of the options.
+const (
+	DefaultSize   = 256
+	MinSize       = 64
+	MaxSize       = 2048
+	DefaultMargin = 4
+	MaxMargin     = 16
+)
+
+// ErrInvalid occurs when the options are out of their bounds or malformed.
+var ErrInvalid = errors.New("invalid QR code options")
+
+// Options of the image of a code.
+type Options struct {
+	Format Format
+	// Size the width and the height of the image in pixels.
+	Size int
+	// Level the error correction level.
+	Level qr.Level
+	// Margin the quiet zone around the code in modules.
+	Margin int
+	// Foreground the colour of the dark modules, Background of the light ones and the margin.
+	Foreground, Background color.RGBA
+}
+
+// DefaultOptions returns the options of a 256 pixels black on white PNG with the M level.
+func DefaultOptions() Options {
+	return Options{
+		Format:     PNG,
+		Size:       DefaultSize,
+		Level:      qr.M,
+		Margin:     DefaultMargin,
+		Foreground: color.RGBA{A: 0xff},
+		Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+}
+
+// Validate checks the options are within their bounds.
+func (o Options) Validate() error {
+	switch {
+	case o.Format != PNG && o.Format != SVG:
+		return fmt.Errorf("%w: format must be png or svg", ErrInvalid)
+	case o.Size < MinSize || o.Size > MaxSize:
+		return fmt.Errorf("%w: size must be from %d to %d", ErrInvalid, MinSize, MaxSize)
+	case o.Level < qr.L || o.Level > qr.H:
+		return fmt.Errorf("%w: level must be L, M, Q or H", ErrInvalid)
+	case o.Margin < 0 || o.Margin > MaxMargin:
+		return fmt.Errorf("%w: margin must be from 0 to %d", ErrInvalid, MaxMargin)
+	}
+
+	return nil
+}
+
+// Params the options as they come in a request, the zero ones take the defaults.
+type Params struct {
+	Format string
+	Size   int
+	Level  string
+	// Margin is the default one if nil, a zero margin is allowed.
+	Margin                 *int
+	Foreground, Background string
+}
+
+// Options returns the validated options of the params.
+func (p Params) Options() (Options, error) {
+	o := DefaultOptions()
+
+	var err error
+	if o.Format, err = ParseFormat(p.Format); err != nil {
+		return Options{}, err
+	}
+	if o.Level, err = ParseLevel(p.Level); err != nil {
+		return Options{}, err
+	}
+	if p.Foreground != "" {
+		if o.Foreground, err = ParseColor(p.Foreground); err != nil {
+			return Options{}, err
+		}
+	}
+	if p.Background != "" {
+		if o.Background, err = ParseColor(p.Background); err != nil {
+			return Options{}, err
+		}
+	}
+	if p.Size != 0 {
+		o.Size = p.Size
+	}
+	if p.Margin != nil {
+		o.Margin = *p.Margin
+	}
+
+	return o, o.Validate()
+}
+
+// ParseFormat returns the format of the name, PNG for the empty one.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case "":
+		return PNG, nil
+	case PNG, SVG:
+		return f, nil
+	}
+
+	return "", fmt.Errorf("%w: format must be png or svg", ErrInvalid)
+}
+
+// ParseLevel returns the error correction level of the name, M for the empty one.
+func ParseLevel(name string) (qr.Level, error) {
+	switch strings.ToUpper(name) {
+	case "L":
+		return qr.L, nil
+	case "", "M":
+		return qr.M, nil
+	case "Q":
+		return qr.Q, nil
+	case "H":
+		return qr.H, nil
+	}
+
+	return 0, fmt.Errorf("%w: level must be L, M, Q or H", ErrInvalid)
+}
+
+// ParseColor returns the colour of the hex "RRGGBB" or "RGB", with or without "#".
+func ParseColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("%w: colour %q must be hex RRGGBB", ErrInvalid, s)
+	}
+
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// Encode returns the image of the code of the text.
+func Encode(text string, o Options) ([]byte, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	code, err := qr.Encode(text, o.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := code.Size + 2*o.Margin
+	if modules > o.Size {
+		return nil, fmt.Errorf("%w: size must be at least %d for this link", ErrInvalid, modules)
+	}
+
+	if o.Format == SVG {
+		return encodeSVG(code, o), nil
+	}
+
+	return encodePNG(code, o)
+}
+
+// encodePNG draws every module as a square of whole pixels, the pixels left over widen the margin.
+func encodePNG(code *qr.Code, o Options) ([]byte, error) {
+	scale := o.Size / (code.Size + 2*o.Margin)
+	offset := (o.Size - scale*code.Size) / 2
+
+	img := image.NewPaletted(image.Rect(0, 0, o.Size, o.Size), color.Palette{o.Background, o.Foreground})
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.Black(x, y) {
+				continue
+			}
+
+			for py := offset + y*scale; py < offset+(y+1)*scale; py++ {
+				row := img.Pix[py*img.Stride:]
+				for px := offset + x*scale; px < offset+(x+1)*scale; px++ {
+					row[px] = 1
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeSVG draws the runs of the dark modules of every row as one path, the view box is in modules.
+func encodeSVG(code *qr.Code, o Options) []byte {
+	modules := code.Size + 2*o.Margin
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		o.Size, o.Size, modules, modules)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="%s"/><path fill="%s" d="`,
+		modules, modules, hexColor(o.Background), hexColor(o.Foreground))
+
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; {
+			if !code.Black(x, y) {
+				x++
+				continue
+			}
+
+			run := 1
+			for code.Black(x+run, y) {
+				run++
+			}
+
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", x+o.Margin, y+o.Margin, run, run)
+			x += run
+		}
+	}
+	b.WriteString(`"/></svg>`)
+
+	return b.Bytes()
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
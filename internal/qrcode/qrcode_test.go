@@ -0,0 +1,133 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"rsc.io/qr"
+)
+
+func TestEncode_PNG(t *testing.T) {
+	const text = "http://localhost:8080/zE"
+
+	o := DefaultOptions()
+	o.Size = 300
+	o.Foreground = color.RGBA{R: 0x11, G: 0x22, B: 0x33, A: 0xff}
+
+	data, err := Encode(text, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := img.Bounds(); b.Dx() != o.Size || b.Dy() != o.Size {
+		t.Fatalf("image is %v, want %dx%d", b, o.Size, o.Size)
+	}
+
+	code, err := qr.Encode(text, o.Level)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scale := o.Size / (code.Size + 2*o.Margin)
+	offset := (o.Size - scale*code.Size) / 2
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			want := o.Background
+			if code.Black(x, y) {
+				want = o.Foreground
+			}
+
+			// the centre of the module
+			got := color.RGBAModel.Convert(img.At(offset+x*scale+scale/2, offset+y*scale+scale/2))
+			if got != want {
+				t.Fatalf("module %d,%d = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	if got := color.RGBAModel.Convert(img.At(0, 0)); got != o.Background {
+		t.Errorf("margin = %v, want %v", got, o.Background)
+	}
+}
+
+func TestEncode_SVG(t *testing.T) {
+	o := DefaultOptions()
+	o.Format, o.Margin, o.Background = SVG, 0, color.RGBA{R: 0xff, A: 0xff}
+
+	data, err := Encode("http://localhost:8080/zE", o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svg := string(data)
+	for _, want := range []string{`<svg xmlns="http://www.w3.org/2000/svg" width="256" height="256"`,
+		`fill="#ff0000"`, `<path fill="#000000" d="M0 0h7v1h-7z`, `</svg>`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG %q does not contain %q", svg, want)
+		}
+	}
+}
+
+func TestEncode_TooSmall(t *testing.T) {
+	o := DefaultOptions()
+	o.Size = MinSize
+
+	if _, err := Encode(strings.Repeat("a", 500), o); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Encode() of a long text error = %v, want %v", err, ErrInvalid)
+	}
+}
+
+func TestParams_Options(t *testing.T) {
+	zero := 0
+
+	tests := []struct {
+		name    string
+		params  Params
+		want    func(o *Options)
+		wantErr bool
+	}{
+		{name: "defaults", want: func(o *Options) {}},
+		{
+			name:   "every option",
+			params: Params{Format: "SVG", Size: 512, Level: "h", Margin: &zero, Foreground: "#fff", Background: "123456"},
+			want: func(o *Options) {
+				o.Format, o.Size, o.Level, o.Margin = SVG, 512, qr.H, 0
+				o.Foreground = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+				o.Background = color.RGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff}
+			},
+		},
+		{name: "unknown format", params: Params{Format: "gif"}, wantErr: true},
+		{name: "small size", params: Params{Size: MinSize - 1}, wantErr: true},
+		{name: "large size", params: Params{Size: MaxSize + 1}, wantErr: true},
+		{name: "unknown level", params: Params{Level: "X"}, wantErr: true},
+		{name: "negative margin", params: Params{Margin: func() *int { m := -1; return &m }()}, wantErr: true},
+		{name: "short colour", params: Params{Foreground: "#ff"}, wantErr: true},
+		{name: "not hex colour", params: Params{Background: "red"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.params.Options()
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalid) {
+					t.Errorf("Options() error = %v, want %v", err, ErrInvalid)
+				}
+				return
+			}
+
+			want := DefaultOptions()
+			tt.want(&want)
+			if err != nil || got != want {
+				t.Errorf("Options() = %+v, %v, want %+v", got, err, want)
+			}
+		})
+	}
+}
@@ -3,16 +3,40 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"embed"
 	"errors"
 	"fmt"
 	"github.com/egorgasay/dockerdb"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/sqlite3"
-	"log"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"strings"
+	"time"
 	"url-shortener/internal/storage"
+	boltStorage "url-shortener/internal/storage/bolt"
+	"url-shortener/internal/storage/cache"
 	dbStorage "url-shortener/internal/storage/db"
+	"url-shortener/internal/storage/db/replica"
 	filestorage "url-shortener/internal/storage/file"
 	mapStorage "url-shortener/internal/storage/map"
+	redisStorage "url-shortener/internal/storage/redis"
+	"url-shortener/internal/storage/shard"
+	"url-shortener/internal/storage/wal"
+)
+
+// helperMigrations the schema of the sqlite3 database keeping the docker databases.
+//
+//go:embed migrations/*.sql
+var helperMigrations embed.FS
+
+// Migrate modes.
+const (
+	// MigrateAuto applies pending migrations when the storage is built.
+	MigrateAuto = "auto"
+	// MigrateOff leaves the schema as is.
+	MigrateOff = "off"
+	// MigrateOnly applies pending migrations, the caller exits after that.
+	MigrateOnly = "only"
 )
 
 // Config struct containing information about the database.
@@ -22,21 +46,133 @@ type Config struct {
 	DataSourcePath string
 	VDB            *dockerdb.VDB
 	Name           string
+	// Migrate one of MigrateAuto, MigrateOff and MigrateOnly, MigrateAuto is used if empty.
+	Migrate string
+	// Sync fsync policy of the file storage and the map storage log.
+	Sync wal.SyncPolicy
+	// CompactInterval period of the file storage compaction, the default one is used if zero.
+	CompactInterval time.Duration
+	// Snapshot path of the map storage snapshot, the map storage is not persistent if empty.
+	Snapshot string
+	// SnapshotInterval period of the map storage snapshots, the default one is used if zero.
+	SnapshotInterval time.Duration
+	// DataDir directory of the embedded key-value storage.
+	DataDir string
+	// Redis address of the redis storage, for other storages it enables the Redis cache.
+	Redis string
+	// RedisTTL ttl of the links in the redis storage or of the cached ones.
+	RedisTTL time.Duration
+	// Replicas connection strings of the read replicas of the SQL storages.
+	Replicas []string
+	// ReadYourWrites window during which the written links and their owners are read from the primary.
+	ReadYourWrites time.Duration
+	// Shards of the storage, each one is built as the storage with its DSN. No sharding if empty.
+	Shards []Shard
+	// ShardCodes encodes the shard of a new link in its short URL.
+	ShardCodes bool
+	// Cache in-process cache of the long links, disabled if its size is zero.
+	Cache cache.Config
+	// Pool settings of the connection pools of the SQL storages.
+	Pool dbStorage.Pool
+	// QueryTimeout limit of every query of the SQL storages, no limit if zero.
+	QueryTimeout time.Duration
+}
+
+// Shard a storage of the sharded storage.
+type Shard struct {
+	// Name places the shard on the consistent hashing ring, it must not change.
+	Name string
+	// DSN connection string, path or address of the shard depending on the storage type.
+	DSN string
+}
+
+// ParseShards parses the comma separated list of name=dsn pairs.
+func ParseShards(list string) ([]Shard, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	var shards []Shard
+	for _, pair := range strings.Split(list, ",") {
+		name, dsn, ok := strings.Cut(pair, "=")
+		if !ok || name == "" || dsn == "" {
+			return nil, fmt.Errorf("invalid shard %q, use name=dsn", pair)
+		}
+
+		shards = append(shards, Shard{Name: name, DSN: dsn})
+	}
+
+	return shards, nil
+}
+
+// ParseMigrate validates the migrate mode.
+func ParseMigrate(mode string) (string, error) {
+	switch mode {
+	case "":
+		return MigrateAuto, nil
+	case MigrateAuto, MigrateOff, MigrateOnly:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown migrate mode %q, use auto, off or only", mode)
 }
 
 // New build storage.IStorage on Config.
+// If Redis is set for a storage other than redis, Redis caches the long links of the storage.
+// The in-process cache, if enabled, is put in front of everything.
 func New(cfg *Config) (storage.IStorage, error) {
 	if cfg == nil {
 		panic("конфигурация задана некорректно")
 	}
 
+	var st storage.IStorage
+	var err error
+
+	if len(cfg.Shards) > 0 {
+		st, err = newShardedStorage(cfg)
+	} else {
+		st, err = newStorage(cfg, string(cfg.DriverName))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Replicas) > 0 {
+		st, err = withReplicas(st, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Redis != "" && cfg.DriverName != redisStorage.RedisStorageType {
+		client, err := redisStorage.NewClient(cfg.Redis)
+		if err != nil {
+			st.Shutdown()
+			return nil, err
+		}
+
+		st = redisStorage.NewCache(st, client, cfg.RedisTTL)
+	}
+
+	if cfg.Cache.Size > 0 {
+		st = cache.New(st, cfg.Cache)
+	}
+
+	return st, nil
+}
+
+// newStorage builds the storage, name identifies its connection pool in the pool stats.
+func newStorage(cfg *Config, name string) (storage.IStorage, error) {
+	opts := dbOptions(cfg, name)
+	opts.Migrate = cfg.Migrate != MigrateOff
+
 	switch cfg.DriverName {
-	case "sqlite3", "test":
+	case "sqlite3":
 		db, err := sql.Open("sqlite3", cfg.DataSourceCred)
 		if err != nil {
 			return nil, err
 		}
-		return dbStorage.NewRealStorage(db, cfg.DriverName), nil
+		return dbStorage.NewRealStorage(db, cfg.DriverName, opts)
 	case "mysql", "postgres":
 		var db *sql.DB
 		var err error
@@ -46,11 +182,11 @@ func New(cfg *Config) (storage.IStorage, error) {
 			if err != nil {
 				return nil, err
 			}
-			return dbStorage.NewRealStorage(db, cfg.DriverName), nil
+			return dbStorage.NewRealStorage(db, cfg.DriverName, opts)
 		}
 
 		cfg.DataSourcePath = "dockerDBs"
-		sqlitedb, err := upSqlite(cfg, "file://internal/repository/migrations")
+		sqlitedb, err := upSqlite(cfg)
 		if err != nil {
 			return nil, err
 		}
@@ -83,17 +219,121 @@ func New(cfg *Config) (storage.IStorage, error) {
 
 		sqlitedb.Close()
 
-		return dbStorage.NewRealStorage(cfg.VDB.DB, cfg.DriverName), nil
+		return dbStorage.NewRealStorage(cfg.VDB.DB, cfg.DriverName, opts)
+	case redisStorage.RedisStorageType:
+		client, err := redisStorage.NewClient(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		return redisStorage.NewRedisStorage(client, cfg.RedisTTL), nil
+	case boltStorage.BoltStorageType:
+		return boltStorage.NewBoltStorage(cfg.DataDir)
 	case "file":
-		filename := cfg.DataSourcePath
-		return filestorage.NewFileStorage(filename)
+		return filestorage.NewFileStorage(filestorage.Config{
+			Path:            cfg.DataSourcePath,
+			Sync:            cfg.Sync,
+			CompactInterval: cfg.CompactInterval,
+		})
 	default:
+		if cfg.Snapshot != "" {
+			return mapStorage.NewPersistentMapStorage(mapStorage.Config{
+				Path:     cfg.Snapshot,
+				Interval: cfg.SnapshotInterval,
+				Sync:     cfg.Sync,
+			})
+		}
+
 		db := mapStorage.NewMapStorage()
 		return db, nil
 	}
 }
 
-func upSqlite(cfg *Config, path string) (*sql.DB, error) {
+// newShardedStorage builds a storage of the configured type for every shard.
+func newShardedStorage(cfg *Config) (storage.IStorage, error) {
+	if len(cfg.Replicas) > 0 {
+		return nil, errors.New("read replicas are not supported with shards")
+	}
+
+	shards := make([]shard.Shard, 0, len(cfg.Shards))
+	shutdown := func() {
+		for _, sh := range shards {
+			sh.Shutdown()
+		}
+	}
+
+	for _, sh := range cfg.Shards {
+		shardCfg := *cfg
+		shardCfg.Shards, shardCfg.VDB = nil, nil
+
+		switch cfg.DriverName {
+		case "file":
+			shardCfg.DataSourcePath = sh.DSN
+		case boltStorage.BoltStorageType:
+			shardCfg.DataDir = sh.DSN
+		case redisStorage.RedisStorageType:
+			shardCfg.Redis = sh.DSN
+		case mapStorage.MapStorageType:
+			shardCfg.Snapshot = sh.DSN
+		default:
+			shardCfg.DataSourceCred = sh.DSN
+		}
+
+		st, err := newStorage(&shardCfg, "shard "+sh.Name)
+		if err != nil {
+			shutdown()
+			return nil, fmt.Errorf("shard %s: %w", sh.Name, err)
+		}
+
+		shards = append(shards, shard.Shard{Name: sh.Name, IStorage: st})
+	}
+
+	router, err := shard.New(shards, cfg.ShardCodes)
+	if err != nil {
+		shutdown()
+		return nil, err
+	}
+
+	if err = router.Verify(context.Background()); err != nil {
+		shutdown()
+		return nil, err
+	}
+
+	return router, nil
+}
+
+// withReplicas routes the reads of the SQL storage to the replicas, they are never migrated.
+func withReplicas(primary storage.IStorage, cfg *Config) (storage.IStorage, error) {
+	switch cfg.DriverName {
+	case "sqlite3", "mysql", "postgres":
+	default:
+		primary.Shutdown()
+		return nil, fmt.Errorf("read replicas are not supported by the %s storage", cfg.DriverName)
+	}
+
+	replicas := make([]replica.Replica, len(cfg.Replicas))
+	for i, dsn := range cfg.Replicas {
+		dsn, name := dsn, fmt.Sprintf("#%d", i+1)
+		replicas[i] = replica.Replica{
+			Name: name,
+			Open: func() (storage.IStorage, error) {
+				db, err := sql.Open(string(cfg.DriverName), dsn)
+				if err != nil {
+					return nil, err
+				}
+
+				return dbStorage.NewRealStorage(db, cfg.DriverName, dbOptions(cfg, "replica "+name))
+			},
+		}
+	}
+
+	return replica.New(primary, replicas, replica.Config{Window: cfg.ReadYourWrites}), nil
+}
+
+func dbOptions(cfg *Config, name string) dbStorage.Options {
+	return dbStorage.Options{Name: name, Pool: cfg.Pool, QueryTimeout: cfg.QueryTimeout}
+}
+
+func upSqlite(cfg *Config) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", cfg.DataSourcePath)
 	if err != nil {
 		return nil, err
@@ -101,23 +341,22 @@ func upSqlite(cfg *Config, path string) (*sql.DB, error) {
 
 	driver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
 	if err != nil {
-		log.Fatal(err)
-		return nil, err
+		return nil, fmt.Errorf("can't create migration driver: %w", err)
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		path,
-		"url-shortener", driver)
+	src, err := iofs.New(helperMigrations, "migrations")
 	if err != nil {
-		log.Fatal(err)
-		return nil, err
+		return nil, fmt.Errorf("can't read migrations: %w", err)
 	}
 
-	err = m.Up()
+	m, err := migrate.NewWithInstance("iofs", src, "url-shortener", driver)
 	if err != nil {
-		if err.Error() != "no change" {
-			log.Fatal(err)
-		}
+		return nil, fmt.Errorf("can't create migrations: %w", err)
+	}
+
+	err = m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return nil, fmt.Errorf("can't migrate: %w", err)
 	}
 
 	return db, nil
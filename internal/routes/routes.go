@@ -1,31 +1,90 @@
 package routes
 
 import (
+	"expvar"
 	"github.com/gin-gonic/gin"
+	"net/http"
 	"net/http/pprof"
+	"url-shortener/internal/access"
+	"url-shortener/internal/audit"
 	handlers "url-shortener/internal/handler/rest"
+	"url-shortener/internal/idempotency"
 )
 
 // PublicRoutes routes for unregistered users.
-func PublicRoutes(r *gin.RouterGroup, h *handlers.Handler) {
-	if r == nil || h == nil {
+// The create routes replay their responses for the repeated Idempotency-Key headers.
+func PublicRoutes(r *gin.RouterGroup, h *handlers.Handler, idem *idempotency.Store) {
+	if r == nil || h == nil || idem == nil {
 		panic("nil pointer")
 	}
 
 	r.GET("/:id", h.GetLinkHandler)
+	r.GET("/:id/qr", h.QRCodeHandler)
 	r.GET("/api/user/urls", h.GetAllLinksHandler)
 	r.GET("/ping", h.Ping)
-	r.GET("/api/internal/stats", h.GetStatsHandler)
 
-	r.Any("/debug/pprof/", gin.WrapF(pprof.Index))
-	r.Any("/debug/pprof/cmdline", gin.WrapF(pprof.Cmdline))
-	r.Any("/debug/pprof/profile", gin.WrapF(pprof.Profile))
-	r.Any("/debug/pprof/symbol", gin.WrapF(pprof.Symbol))
-	r.Any("/debug/pprof/trace", gin.WrapF(pprof.Trace))
-
-	r.POST("/api/shorten/batch", h.BatchHandler)
-	r.POST("/", h.CreateLinkHandler)
-	r.POST("/api/shorten", h.APICreateLinkHandler)
+	r.POST("/api/shorten/batch", idem.Handler(), h.BatchHandler)
+	r.POST("/", idem.Handler(), h.CreateLinkHandler)
+	r.POST("/:id", h.UnlockLinkHandler)
+	r.POST("/api/shorten", idem.Handler(), h.APICreateLinkHandler)
 
 	r.DELETE("/api/user/urls", h.APIDeleteLinksHandler)
 }
+
+// V2Routes versioned API routes, v1 stays in PublicRoutes for compatibility.
+// The create routes replay their responses for the repeated Idempotency-Key headers.
+func V2Routes(r *gin.RouterGroup, h *handlers.HandlerV2, idem *idempotency.Store) {
+	if r == nil || h == nil || idem == nil {
+		panic("nil pointer")
+	}
+
+	v2 := r.Group("/api/v2", handlers.RequestID(), h.RateLimit)
+
+	v2.GET("/openapi.json", h.OpenAPI)
+
+	v2.POST("/links", idem.Handler(), h.CreateLink)
+	v2.POST("/links/batch", idem.Handler(), h.Batch)
+	v2.GET("/links/:id", h.GetLink)
+
+	v2.GET("/user/links", h.GetUserLinks)
+	v2.DELETE("/user/links", h.DeleteUserLinks)
+	v2.PUT("/user/links/:id/options", h.SetLinkOptions)
+
+	v2.POST("/user/webhooks", h.RegisterWebhook)
+	v2.GET("/user/webhooks", h.GetWebhooks)
+	v2.DELETE("/user/webhooks/:id", h.DeleteWebhook)
+	v2.POST("/user/webhooks/:id/test", h.TestWebhook)
+}
+
+// GatewayRoutes mounts the JSON gateway to the gRPC service under prefix.
+func GatewayRoutes(r *gin.RouterGroup, prefix string, gw http.Handler) {
+	if r == nil || gw == nil {
+		panic("nil pointer")
+	}
+
+	r.Any(prefix+"/*path", gin.WrapH(http.StripPrefix(prefix, gw)))
+}
+
+// AdminRoutes routes for operators, they must be served by a separate listener.
+// Every route is behind the access.Guard.
+func AdminRoutes(r *gin.RouterGroup, h *handlers.Handler, g *access.Guard) {
+	if r == nil || h == nil || g == nil {
+		panic("nil pointer")
+	}
+
+	admin := r.Group("/", g.Handler(), audit.Handler(g, audit.ActorAdmin))
+
+	admin.GET("/ping", h.Ping)
+	admin.GET("/api/internal/stats", h.GetStatsHandler)
+	admin.GET("/api/internal/export", h.ExportHandler)
+	admin.POST("/api/internal/import", h.ImportHandler)
+	admin.GET("/api/internal/audit", h.AuditHandler)
+	admin.GET("/metrics", gin.WrapH(expvar.Handler()))
+
+	admin.Any("/debug/pprof/", gin.WrapF(pprof.Index))
+	admin.Any("/debug/pprof/cmdline", gin.WrapF(pprof.Cmdline))
+	admin.Any("/debug/pprof/profile", gin.WrapF(pprof.Profile))
+	admin.Any("/debug/pprof/symbol", gin.WrapF(pprof.Symbol))
+	admin.Any("/debug/pprof/trace", gin.WrapF(pprof.Trace))
+	admin.Any("/debug/pprof/:profile", gin.WrapF(pprof.Index))
+}
@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"url-shortener/config"
+	"url-shortener/internal/access"
+	handlers "url-shortener/internal/handler/rest"
+	"url-shortener/internal/idempotency"
+	"url-shortener/internal/repository"
+	"url-shortener/internal/usecase"
+)
+
+func newHandler(t *testing.T) *handlers.Handler {
+	t.Helper()
+
+	cfg := &repository.Config{DriverName: "map"}
+	repo, err := repository.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{Key: []byte("test-key"), BaseURL: "http://localhost/", DBConfig: cfg}
+	return handlers.NewHandler(conf, usecase.New(repo))
+}
+
+func TestPublicRoutes_NoAdminEndpoints(t *testing.T) {
+	router := gin.New()
+	PublicRoutes(router.Group("/"), newHandler(t), idempotency.NewWithBackend(idempotency.NewMemory(), 0))
+
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{name: "pprof index", target: "/debug/pprof/"},
+		{name: "pprof profile", target: "/debug/pprof/profile"},
+		{name: "pprof heap", target: "/debug/pprof/heap"},
+		{name: "stats", target: "/api/internal/stats"},
+		{name: "pprof trace", target: "/debug/pprof/trace"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.RemoteAddr = "127.0.0.1:4321"
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		})
+	}
+}
+
+func TestPublicRoutes_Idempotency(t *testing.T) {
+	router := gin.New()
+	PublicRoutes(router.Group("/"), newHandler(t), idempotency.NewWithBackend(idempotency.NewMemory(), 0))
+
+	post := func(target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		req.Header.Set(idempotency.Header, "key-1")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("/api/shorten", `{"url":"https://ya.ru"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	second := post("/api/shorten", `{"url":"https://ya.ru"}`)
+	assert.Equal(t, first.Code, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.NotEmpty(t, first.Header().Get("Authorization"))
+	assert.Empty(t, second.Header().Get("Authorization"), "the issued token is not replayed")
+	assert.Equal(t, "true", second.Header().Get(idempotency.ReplayedHeader))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, post("/api/shorten", `{"url":"https://go.dev"}`).Code)
+
+	// keys are scoped by the endpoint
+	assert.Equal(t, http.StatusCreated, post("/", "https://go.dev").Code)
+}
+
+func TestAdminRoutes(t *testing.T) {
+	subnets, err := access.ParseCIDRs("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	AdminRoutes(router.Group("/"), newHandler(t),
+		access.New(access.Config{TrustedSubnets: subnets, Token: "secret"}))
+
+	tests := []struct {
+		name          string
+		target        string
+		remoteAddr    string
+		authorization string
+		want          int
+	}{
+		{
+			name:       "pprof from trusted subnet",
+			target:     "/debug/pprof/",
+			remoteAddr: "127.0.0.1:4321",
+			want:       http.StatusOK,
+		},
+		{
+			name:       "pprof from outside",
+			target:     "/debug/pprof/",
+			remoteAddr: "8.8.8.8:4321",
+			want:       http.StatusForbidden,
+		},
+		{
+			name:          "named profile with token",
+			target:        "/debug/pprof/heap",
+			remoteAddr:    "8.8.8.8:4321",
+			authorization: "Bearer secret",
+			want:          http.StatusOK,
+		},
+		{
+			name:          "stats with wrong token",
+			target:        "/api/internal/stats",
+			remoteAddr:    "8.8.8.8:4321",
+			authorization: "Bearer guess",
+			want:          http.StatusForbidden,
+		},
+		{
+			name:          "stats with token",
+			target:        "/api/internal/stats",
+			remoteAddr:    "8.8.8.8:4321",
+			authorization: "Bearer secret",
+			want:          http.StatusOK,
+		},
+		{
+			name:       "metrics",
+			target:     "/metrics",
+			remoteAddr: "127.0.0.1:4321",
+			want:       http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("Authorization", tt.authorization)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package schema
 
-// RequestJSON describes Request with URL in it.
+// RequestJSON describes Request with URL in it, the link is protected by Password if it's set.
 type RequestJSON struct {
-	URL string `json:"url"`
+	URL       string `json:"url"`
+	Password  string `json:"password,omitempty"`
+	MaxClicks int    `json:"max_clicks,omitempty"`
 }
 
 // ResponseJSON describes Response with URL in it.
@@ -34,3 +36,22 @@ type StatsResponse struct {
 	URLs  int `json:"urls"`
 	Users int `json:"users"`
 }
+
+// ErrorResponse describes the error envelope returned by the v2 API.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// WebhookRequest describes Request that registers a webhook.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// LinkOptions describes the settings of a link made by its owner.
+type LinkOptions struct {
+	Title   string `json:"title"`
+	Preview bool   `json:"preview"`
+}
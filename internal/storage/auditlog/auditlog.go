@@ -0,0 +1,122 @@
+// Package auditlog implements storage.IAudit for the storages keeping their data in memory.
+//
+// The entries are served from memory. A persistent Log appends them to a write-ahead log
+// that is never compacted, so an entry can't be changed or removed once it is written.
+package auditlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/wal"
+)
+
+var _ storage.IAudit = (*Log)(nil)
+
+// Log the audit log, safe for concurrent use.
+type Log struct {
+	mu      sync.RWMutex
+	entries []storage.AuditEntry
+	// file is nil if the log is kept in memory only.
+	file *wal.Log
+}
+
+// NewMemory returns a Log kept in memory only.
+func NewMemory() *Log {
+	return &Log{}
+}
+
+// Open restores the Log from the file at path, creating it if needed.
+func Open(path string, policy wal.SyncPolicy) (*Log, error) {
+	if policy == "" {
+		policy = wal.SyncAlways
+	}
+
+	l := &Log{}
+
+	file, err := wal.Open(path, policy, func(data []byte) error {
+		var e storage.AuditEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+
+		l.entries = append(l.entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't open the audit log: %w", err)
+	}
+	l.file = file
+
+	return l, nil
+}
+
+// AppendAudit saves the entries by one write.
+func (l *Log) AppendAudit(ctx context.Context, entries ...storage.AuditEntry) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var last int64
+	if len(l.entries) > 0 {
+		last = l.entries[len(l.entries)-1].ID
+	}
+
+	records := make([]any, len(entries))
+	added := make([]storage.AuditEntry, len(entries))
+	for i, e := range entries {
+		e.ID = last + int64(i) + 1
+		e.At = e.At.UTC()
+		added[i], records[i] = e, e
+	}
+
+	if l.file != nil && len(records) > 0 {
+		if err := l.file.Append(records...); err != nil {
+			return fmt.Errorf("can't write the audit log: %w", err)
+		}
+	}
+	l.entries = append(l.entries, added...)
+
+	return nil
+}
+
+// AuditEntries returns the entries selected by the filter.
+func (l *Log) AuditEntries(ctx context.Context, filter storage.AuditFilter) ([]storage.AuditEntry, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// the ids are ascending, the entries up to AfterID are skipped at once
+	start := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].ID > filter.AfterID })
+
+	found := make([]storage.AuditEntry, 0)
+	for _, e := range l.entries[start:] {
+		if filter.Limit > 0 && len(found) == filter.Limit {
+			break
+		}
+
+		if filter.Match(e) {
+			found = append(found, e)
+		}
+	}
+
+	return found, nil
+}
+
+// Close closes the file of a persistent Log.
+func (l *Log) Close() error {
+	if l.file == nil {
+		return nil
+	}
+
+	return l.file.Close()
+}
@@ -0,0 +1,72 @@
+package auditlog
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+	"url-shortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit")
+	at := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	l, err := Open(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, l.AppendAudit(ctx,
+		storage.AuditEntry{At: at, Actor: "alice", Operation: "link.create", Target: "a", Outcome: storage.AuditSuccess},
+		storage.AuditEntry{At: at.Add(time.Minute), Actor: "bob", Operation: "link.create", Target: "b", Outcome: storage.AuditSuccess},
+	))
+	assert.NoError(t, l.AppendAudit(ctx,
+		storage.AuditEntry{At: at.Add(2 * time.Minute), Actor: "alice", Operation: "link.delete", Target: "a", Outcome: storage.AuditSuccess},
+	))
+	assert.NoError(t, l.Close())
+
+	// the entries are restored from the file
+	l, err = Open(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		name   string
+		filter storage.AuditFilter
+		want   []int64
+	}{
+		{name: "all", want: []int64{1, 2, 3}},
+		{name: "actor", filter: storage.AuditFilter{Actor: "alice"}, want: []int64{1, 3}},
+		{name: "from", filter: storage.AuditFilter{From: at.Add(time.Minute)}, want: []int64{2, 3}},
+		{name: "to", filter: storage.AuditFilter{To: at.Add(time.Minute)}, want: []int64{1}},
+		{name: "after", filter: storage.AuditFilter{AfterID: 1}, want: []int64{2, 3}},
+		{name: "limit", filter: storage.AuditFilter{Actor: "alice", Limit: 1}, want: []int64{1}},
+		{name: "none", filter: storage.AuditFilter{Actor: "carol"}, want: []int64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := l.AuditEntries(ctx, tt.filter)
+			assert.NoError(t, err)
+
+			ids := make([]int64, len(entries))
+			for i, e := range entries {
+				ids[i] = e.ID
+			}
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+
+	// the ids go on after the restored ones
+	assert.NoError(t, l.AppendAudit(ctx, storage.AuditEntry{At: at, Actor: "bob"}))
+	entries, err := l.AuditEntries(ctx, storage.AuditFilter{AfterID: 3})
+	if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+		assert.Equal(t, int64(4), entries[0].ID)
+		assert.Equal(t, at, entries[0].At)
+	}
+}
@@ -0,0 +1,86 @@
+package boltstorage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"url-shortener/internal/storage"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// AppendAudit saves the entries in one transaction.
+func (b *BoltStorage) AppendAudit(ctx context.Context, entries ...storage.AuditEntry) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		audit := tx.Bucket(bucketAudit)
+
+		for _, e := range entries {
+			id, err := audit.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			e.ID, e.At = int64(id), e.At.UTC()
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+
+			if err = audit.Put(itob(id), data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving audit entries: %w", err)
+	}
+
+	return nil
+}
+
+// AuditEntries returns the entries selected by the filter, the scan starts after AfterID.
+func (b *BoltStorage) AuditEntries(ctx context.Context, filter storage.AuditFilter) ([]storage.AuditEntry, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	entries := make([]storage.AuditEntry, 0)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketAudit).Cursor()
+
+		for k, data := c.Seek(itob(uint64(filter.AfterID) + 1)); k != nil; k, data = c.Next() {
+			if filter.Limit > 0 && len(entries) == filter.Limit {
+				return nil
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			var e storage.AuditEntry
+			if err := json.Unmarshal(data, &e); err != nil {
+				return fmt.Errorf("audit entry %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+
+			if filter.Match(e) {
+				entries = append(entries, e)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting audit entries: %w", err)
+	}
+
+	return entries, nil
+}
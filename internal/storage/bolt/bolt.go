@@ -0,0 +1,557 @@
+// Package boltstorage implements storage.IStorage on top of bbolt, an embedded pure Go key-value store.
+//
+// The data is kept in buckets of one file:
+//
+//	links        short -> JSON of the link
+//	ids          id -> short, the order the links were created in
+//	owners       owner -> (id -> short)
+//	longs        long -> short, the first link of the long URL
+//	idempotency  key -> JSON of the idempotency record
+//	expiries     expiry + key -> nothing, the idempotency keys in the order they expire
+//	audit        id -> JSON of the audit entry, the sequence of the bucket is the id counter
+//	options      short -> JSON of the options of the link
+//	clicks       short -> visits left of the limited link, big endian
+//
+// The sequence of the links bucket is the id counter.
+package boltstorage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/service"
+	shortener "url-shortener/pkg/api"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	_ storage.IStorage     = (*BoltStorage)(nil)
+	_ storage.IAdmin       = (*BoltStorage)(nil)
+	_ storage.IIdempotency = (*BoltStorage)(nil)
+	_ storage.IAudit       = (*BoltStorage)(nil)
+	_ storage.ILinkOptions = (*BoltStorage)(nil)
+	_ storage.IClickLimit  = (*BoltStorage)(nil)
+)
+
+// BoltStorageType type for the bbolt storage.
+const BoltStorageType storage.Type = "bolt"
+
+// FileName name of the database file in the data directory.
+const FileName = "links.db"
+
+var (
+	bucketLinks  = []byte("links")
+	bucketIDs    = []byte("ids")
+	bucketOwners = []byte("owners")
+	bucketLongs  = []byte("longs")
+
+	bucketIdempotency = []byte("idempotency")
+	bucketExpiries    = []byte("expiries")
+
+	bucketAudit   = []byte("audit")
+	bucketOptions = []byte("options")
+	bucketClicks  = []byte("clicks")
+)
+
+// BoltStorage struct with the bbolt database.
+// It has methods for working with URLs.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// value of the links bucket.
+type value struct {
+	ID      uint64 `json:"id"`
+	Long    string `json:"long"`
+	Owner   string `json:"owner"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Created int64  `json:"created,omitempty"`
+}
+
+// NewBoltStorage opens the database in dir, the directory is created if needed.
+func NewBoltStorage(dir string) (storage.IStorage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("can't create %s: %w", dir, err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, FileName), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("can't open the database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketLinks, bucketIDs, bucketOwners, bucketLongs, bucketIdempotency, bucketExpiries, bucketAudit,
+			bucketOptions, bucketClicks} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("can't create buckets: %w", err)
+	}
+
+	return &BoltStorage{db: db}, nil
+}
+
+// AddLink adds a link to the repository.
+// If the long URL was already shortened, the existing short URL is returned with service.ErrExists.
+func (b *BoltStorage) AddLink(ctx context.Context, longURL, shortURL, cookie string) (string, error) {
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if existing := tx.Bucket(bucketLongs).Get([]byte(longURL)); longURL != "" && existing != nil {
+			shortURL = string(existing)
+			return service.ErrExists
+		}
+
+		if tx.Bucket(bucketLinks).Get([]byte(shortURL)) != nil {
+			return service.ErrExists
+		}
+
+		return put(tx, storage.Link{Short: shortURL, Long: longURL, Owner: cookie,
+			CreatedAt: time.Now().UTC().Truncate(time.Second)})
+	})
+	if errors.Is(err, service.ErrExists) {
+		return shortURL, err
+	} else if err != nil {
+		return "", fmt.Errorf("error adding link: %w", err)
+	}
+
+	return shortURL, nil
+}
+
+// AddLinks adds the links with their options and visits left in one transaction.
+// A link exists if its long URL or its short URL is taken, by a stored link or an earlier one of the batch.
+func (b *BoltStorage) AddLinks(ctx context.Context, links []storage.Link) ([]storage.BatchResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	results := make([]storage.BatchResult, len(links))
+	created := time.Now().UTC().Truncate(time.Second)
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for i, link := range links {
+			results[i] = storage.BatchResult{Short: link.Short}
+
+			if existing := tx.Bucket(bucketLongs).Get([]byte(link.Long)); link.Long != "" && existing != nil {
+				results[i] = storage.BatchResult{Short: string(existing), Exists: true}
+				continue
+			}
+
+			if tx.Bucket(bucketLinks).Get([]byte(link.Short)) != nil {
+				results[i].Exists = true
+				continue
+			}
+
+			link.Deleted, link.CreatedAt = false, created
+			if err := putWithOptions(tx, link); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error adding links: %w", err)
+	}
+
+	return results, nil
+}
+
+// FindMaxID returns the last id given to a link.
+func (b *BoltStorage) FindMaxID(ctx context.Context) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	var id uint64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		id = tx.Bucket(bucketLinks).Sequence()
+		return nil
+	})
+
+	return int(id), err
+}
+
+// GetLongLink gets a long link from the repository.
+func (b *BoltStorage) GetLongLink(ctx context.Context, shortURL string) (string, error) {
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	var long string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v, err := get(tx, []byte(shortURL))
+		if err != nil {
+			return err
+		}
+
+		if v.Deleted {
+			return storage.ErrDeleted
+		}
+
+		if left, ok := clicksLeft(tx, []byte(shortURL)); ok && left == 0 {
+			return storage.ErrExhausted
+		}
+
+		long = v.Long
+		return nil
+	})
+
+	return long, err
+}
+
+// GetAllLinksByCookie gets all links ([]schema.URL) by cookie in the order they were created.
+func (b *BoltStorage) GetAllLinksByCookie(ctx context.Context, cookie, baseURL string) ([]*shortener.UserURL, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var links = make([]*shortener.UserURL, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		owned := tx.Bucket(bucketOwners).Bucket([]byte(cookie))
+		if cookie == "" || owned == nil {
+			return nil
+		}
+
+		return owned.ForEach(func(_, short []byte) error {
+			v, err := get(tx, short)
+			if err != nil {
+				return err
+			}
+
+			link := &shortener.UserURL{OriginalUrl: v.Long, ShortUrl: baseURL + string(short), Deleted: v.Deleted}
+			if left, ok := clicksLeft(tx, short); ok {
+				link.ClicksLeft = proto.Int32(int32(left))
+			}
+			links = append(links, link)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting links by cookie: %w", err)
+	}
+
+	return links, nil
+}
+
+// Ping checks that the database is open.
+func (b *BoltStorage) Ping(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return b.db.View(func(*bolt.Tx) error { return nil })
+}
+
+// MarkAsDeleted finds a URL of the owner and marks it as deleted.
+func (b *BoltStorage) MarkAsDeleted(ctx context.Context, shortURL, cookie string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return b.update(shortURL, func(v *value) error {
+		if v.Owner != cookie {
+			return storage.ErrNotFound
+		}
+
+		v.Deleted = true
+
+		return nil
+	})
+}
+
+// Shutdown closes the database.
+func (b *BoltStorage) Shutdown() error {
+	return b.db.Close()
+}
+
+// URLsCount gets count of URLs in the repository.
+func (b *BoltStorage) URLsCount(ctx context.Context) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	var count int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(bucketLinks).Stats().KeyN
+		return nil
+	})
+
+	return count, err
+}
+
+// UsersCount gets count of users in the repository.
+func (b *BoltStorage) UsersCount(ctx context.Context) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	var count int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// every owner is a nested bucket
+		count = tx.Bucket(bucketOwners).Stats().BucketN - 1
+		return nil
+	})
+
+	return count, err
+}
+
+// GetLink gets the record including deleted ones.
+func (b *BoltStorage) GetLink(ctx context.Context, shortURL string) (storage.Link, error) {
+	if ctx.Err() != nil {
+		return storage.Link{}, ctx.Err()
+	}
+
+	var link storage.Link
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v, err := get(tx, []byte(shortURL))
+		if err != nil {
+			return err
+		}
+
+		link, err = withOptions(tx, v.link(shortURL))
+		return err
+	})
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	return link, nil
+}
+
+// DisableLink marks the link as deleted regardless of the owner.
+func (b *BoltStorage) DisableLink(ctx context.Context, shortURL string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return b.update(shortURL, func(v *value) error {
+		v.Deleted = true
+		return nil
+	})
+}
+
+// PurgeLink removes the record, its index entries, options and visits left in one transaction.
+func (b *BoltStorage) PurgeLink(ctx context.Context, shortURL string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		short := []byte(shortURL)
+
+		v, err := get(tx, short)
+		if err != nil {
+			return err
+		}
+
+		if err = tx.Bucket(bucketLinks).Delete(short); err != nil {
+			return err
+		}
+
+		if err = putOptions(tx, short, storage.LinkOptions{}); err != nil {
+			return err
+		}
+
+		if err = putClicks(tx, short, storage.Unlimited); err != nil {
+			return err
+		}
+
+		id := itob(v.ID)
+		if err = tx.Bucket(bucketIDs).Delete(id); err != nil {
+			return err
+		}
+
+		owners := tx.Bucket(bucketOwners)
+		if owned := owners.Bucket([]byte(v.Owner)); v.Owner != "" && owned != nil {
+			if err = owned.Delete(id); err != nil {
+				return err
+			}
+
+			if k, _ := owned.Cursor().First(); k == nil {
+				if err = owners.DeleteBucket([]byte(v.Owner)); err != nil {
+					return err
+				}
+			}
+		}
+
+		longs := tx.Bucket(bucketLongs)
+		if v.Long != "" && string(longs.Get([]byte(v.Long))) == shortURL {
+			return longs.Delete([]byte(v.Long))
+		}
+
+		return nil
+	})
+}
+
+// Links calls fn for every record in the order they were created.
+func (b *BoltStorage) Links(ctx context.Context, fn func(storage.Link) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketIDs).ForEach(func(_, short []byte) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			v, err := get(tx, short)
+			if err != nil {
+				return err
+			}
+
+			link, err := withOptions(tx, v.link(string(short)))
+			if err != nil {
+				return err
+			}
+
+			return fn(link)
+		})
+	})
+}
+
+// ImportLink saves the record with its options and visits left in one transaction.
+func (b *BoltStorage) ImportLink(ctx context.Context, link storage.Link) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketLinks).Get([]byte(link.Short)) != nil {
+			return service.ErrExists
+		}
+
+		return putWithOptions(tx, link)
+	})
+}
+
+// update changes the value of the link by fn and saves it.
+func (b *BoltStorage) update(shortURL string, fn func(v *value) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		v, err := get(tx, []byte(shortURL))
+		if err != nil {
+			return err
+		}
+
+		if err = fn(&v); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(bucketLinks).Put([]byte(shortURL), data)
+	})
+}
+
+// putWithOptions saves a new link by put with its options and visits left.
+func putWithOptions(tx *bolt.Tx, link storage.Link) error {
+	if err := put(tx, link); err != nil {
+		return err
+	}
+
+	if err := putOptions(tx, []byte(link.Short), link.Options); err != nil {
+		return err
+	}
+
+	return putClicks(tx, []byte(link.Short), link.MaxClicks())
+}
+
+// put saves a new link with the next id and indexes it.
+func put(tx *bolt.Tx, link storage.Link) error {
+	links := tx.Bucket(bucketLinks)
+
+	id, err := links.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	v := value{ID: id, Long: link.Long, Owner: link.Owner, Deleted: link.Deleted}
+	if !link.CreatedAt.IsZero() {
+		v.Created = link.CreatedAt.Unix()
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	short := []byte(link.Short)
+	if err = links.Put(short, data); err != nil {
+		return err
+	}
+
+	if err = tx.Bucket(bucketIDs).Put(itob(id), short); err != nil {
+		return err
+	}
+
+	// bbolt keys can't be empty, links without an owner are not indexed
+	if link.Owner != "" {
+		owned, err := tx.Bucket(bucketOwners).CreateBucketIfNotExists([]byte(link.Owner))
+		if err != nil {
+			return err
+		}
+
+		if err = owned.Put(itob(id), short); err != nil {
+			return err
+		}
+	}
+
+	longs := tx.Bucket(bucketLongs)
+	if link.Long != "" && longs.Get([]byte(link.Long)) == nil {
+		return longs.Put([]byte(link.Long), short)
+	}
+
+	return nil
+}
+
+func get(tx *bolt.Tx, short []byte) (value, error) {
+	data := tx.Bucket(bucketLinks).Get(short)
+	if data == nil {
+		return value{}, storage.ErrNotFound
+	}
+
+	var v value
+	if err := json.Unmarshal(data, &v); err != nil {
+		return value{}, fmt.Errorf("can't decode %s: %w", short, err)
+	}
+
+	return v, nil
+}
+
+func (v value) link(short string) storage.Link {
+	link := storage.Link{Short: short, Long: v.Long, Owner: v.Owner, Deleted: v.Deleted}
+	if v.Created != 0 {
+		link.CreatedAt = time.Unix(v.Created, 0).UTC()
+	}
+
+	return link
+}
+
+// itob encodes the id big endian, so the keys are ordered by id.
+func itob(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+
+	return b
+}
@@ -0,0 +1,310 @@
+package boltstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/service"
+	"url-shortener/internal/storage/storagetest"
+	shortener "url-shortener/pkg/api"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStorage(t *testing.T, dir string) *BoltStorage {
+	t.Helper()
+
+	st, err := NewBoltStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return st.(*BoltStorage)
+}
+
+func TestBoltStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st := newTestStorage(t, dir)
+
+	for _, link := range []storage.Link{
+		{Short: "a", Long: "https://a.ru", Owner: "alice"},
+		{Short: "b", Long: "https://b.ru", Owner: "bob"},
+		{Short: "c", Long: "https://c.ru", Owner: "alice"},
+	} {
+		got, err := st.AddLink(ctx, link.Long, link.Short, link.Owner)
+		assert.NoError(t, err)
+		assert.Equal(t, link.Short, got)
+	}
+
+	tests := []struct {
+		name    string
+		long    string
+		short   string
+		want    string
+		wantErr error
+	}{
+		{name: "long URL dedupe", long: "https://a.ru", short: "x", want: "a", wantErr: service.ErrExists},
+		{name: "short URL taken", long: "https://x.ru", short: "b", want: "b", wantErr: service.ErrExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := st.AddLink(ctx, tt.long, tt.short, "carol")
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.NoError(t, st.MarkAsDeleted(ctx, "a", "alice"))
+	assert.True(t, errors.Is(st.MarkAsDeleted(ctx, "b", "alice"), storage.ErrNotFound))
+
+	_, err := st.GetLongLink(ctx, "a")
+	assert.True(t, errors.Is(err, storage.ErrDeleted), err)
+
+	assert.NoError(t, st.PurgeLink(ctx, "b"))
+	assert.True(t, errors.Is(st.PurgeLink(ctx, "b"), storage.ErrNotFound))
+	assert.NoError(t, st.Shutdown())
+
+	// the data is kept after reopening
+	st = newTestStorage(t, dir)
+	defer st.Shutdown()
+
+	long, err := st.GetLongLink(ctx, "c")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://c.ru", long)
+
+	links, err := st.GetAllLinksByCookie(ctx, "alice", "/")
+	assert.NoError(t, err)
+	assert.Equal(t, []*shortener.UserURL{
+		{OriginalUrl: "https://a.ru", ShortUrl: "/a", Deleted: true},
+		{OriginalUrl: "https://c.ru", ShortUrl: "/c"},
+	}, links)
+
+	id, err := st.FindMaxID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, id)
+
+	urls, err := st.URLsCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, urls)
+
+	users, err := st.UsersCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, users)
+
+	// the purged long URL can be shortened again
+	got, err := st.AddLink(ctx, "https://b.ru", "d", "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, "d", got)
+
+	assert.NoError(t, st.Ping(ctx))
+}
+
+func TestBoltStorage_Admin(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t, t.TempDir())
+	defer st.Shutdown()
+
+	want := []storage.Link{
+		{Short: "zE", Long: "https://ya.ru", Owner: "alice", CreatedAt: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{Short: "Xz", Long: "https://go.dev", Owner: "bob", Deleted: true},
+		{Short: "legacy", Long: "https://example.com"},
+	}
+	for _, link := range want {
+		assert.NoError(t, st.ImportLink(ctx, link))
+	}
+	assert.True(t, errors.Is(st.ImportLink(ctx, want[0]), service.ErrExists))
+
+	var got []storage.Link
+	err := st.Links(ctx, func(link storage.Link) error {
+		got = append(got, link)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	assert.NoError(t, st.DisableLink(ctx, "zE"))
+
+	link, err := st.GetLink(ctx, "zE")
+	assert.NoError(t, err)
+	assert.True(t, link.Deleted)
+
+	assert.NoError(t, st.PurgeLink(ctx, "legacy"))
+	_, err = st.GetLink(ctx, "legacy")
+	assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+}
+
+func TestBoltStorage_AddLinks(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t, t.TempDir())
+	defer st.Shutdown()
+
+	if _, err := st.AddLink(ctx, "https://a.ru", "a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := st.AddLinks(ctx, []storage.Link{
+		{Short: "x", Long: "https://a.ru", Owner: "bob"},
+		{Short: "b", Long: "https://b.ru", Owner: "bob"},
+		{Short: "y", Long: "https://b.ru", Owner: "bob"},
+		{Short: "a", Long: "https://c.ru", Owner: "bob"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.BatchResult{
+		{Short: "a", Exists: true},
+		{Short: "b"},
+		{Short: "b", Exists: true},
+		{Short: "a", Exists: true},
+	}, results)
+
+	links, err := st.GetAllLinksByCookie(ctx, "bob", "/")
+	assert.NoError(t, err)
+	assert.Equal(t, []*shortener.UserURL{{OriginalUrl: "https://b.ru", ShortUrl: "/b"}}, links)
+}
+
+func TestBoltStorage_Idempotency(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st := newTestStorage(t, dir)
+
+	rec := storage.IdempotencyRecord{Key: "k", Fingerprint: "a", ExpiresAt: time.Now().Add(time.Hour).Truncate(time.Millisecond)}
+	_, reserved, err := st.ReserveKey(ctx, rec)
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+
+	found, reserved, err := st.ReserveKey(ctx, storage.IdempotencyRecord{Key: "k", Fingerprint: "b", ExpiresAt: rec.ExpiresAt})
+	assert.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, rec, found)
+
+	assert.NoError(t, st.CompleteKey(ctx, "k", []byte("response")))
+	assert.NoError(t, st.Shutdown())
+
+	st = newTestStorage(t, dir)
+	defer st.Shutdown()
+
+	found, _, err = st.ReserveKey(ctx, rec)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("response"), found.Response)
+
+	assert.NoError(t, st.ReleaseKey(ctx, "k"))
+	_, reserved, err = st.ReserveKey(ctx, rec)
+	assert.NoError(t, err)
+	assert.True(t, reserved, "released keys can be reserved")
+
+	// expired records are swept
+	_, _, err = st.ReserveKey(ctx, storage.IdempotencyRecord{Key: "old", ExpiresAt: time.Now().Add(-time.Second)})
+	assert.NoError(t, err)
+	_, reserved, err = st.ReserveKey(ctx, storage.IdempotencyRecord{Key: "old", ExpiresAt: rec.ExpiresAt})
+	assert.NoError(t, err)
+	assert.True(t, reserved, "expired keys can be reserved")
+}
+
+func TestBoltStorage_Audit(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st := newTestStorage(t, dir)
+	at := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	assert.NoError(t, st.AppendAudit(ctx,
+		storage.AuditEntry{At: at, Actor: "alice", Operation: "link.create", Target: "a", Outcome: storage.AuditSuccess},
+		storage.AuditEntry{At: at.Add(time.Minute), Actor: "bob", Operation: "link.create", Target: "b", Outcome: storage.AuditSuccess},
+		storage.AuditEntry{At: at.Add(2 * time.Minute), Actor: "alice", Operation: "link.delete", Target: "a", Outcome: storage.AuditSuccess},
+	))
+	assert.NoError(t, st.Shutdown())
+
+	st = newTestStorage(t, dir)
+	defer st.Shutdown()
+
+	entries, err := st.AuditEntries(ctx, storage.AuditFilter{Actor: "alice"})
+	if assert.NoError(t, err) && assert.Len(t, entries, 2) {
+		assert.Equal(t, storage.AuditEntry{ID: 3, At: at.Add(2 * time.Minute), Actor: "alice",
+			Operation: "link.delete", Target: "a", Outcome: storage.AuditSuccess}, entries[1])
+	}
+
+	entries, err = st.AuditEntries(ctx, storage.AuditFilter{AfterID: 1, To: at.Add(2 * time.Minute)})
+	if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+		assert.Equal(t, "b", entries[0].Target)
+	}
+
+	entries, err = st.AuditEntries(ctx, storage.AuditFilter{Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestBoltStorage_LinkOptions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st := newTestStorage(t, dir)
+
+	opts := storage.LinkOptions{Title: "Yandex", Preview: true, PasswordHash: "$2a$10$hash"}
+	assert.NoError(t, st.SetLinkOptions(ctx, "a", opts))
+	assert.NoError(t, st.SetLinkOptions(ctx, "b", storage.LinkOptions{Title: "b"}))
+	assert.NoError(t, st.SetLinkOptions(ctx, "b", storage.LinkOptions{}))
+	assert.NoError(t, st.Shutdown())
+
+	st = newTestStorage(t, dir)
+	defer st.Shutdown()
+
+	found, err := st.GetLinkOptions(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, opts, found)
+
+	found, err = st.GetLinkOptions(ctx, "b")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.LinkOptions{}, found)
+}
+
+func TestBoltStorage_Clicks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st := newTestStorage(t, dir)
+
+	_, err := st.AddLink(ctx, "https://ya.ru", "a", "alice")
+	assert.NoError(t, err)
+	_, err = st.AddLink(ctx, "https://go.dev", "b", "alice")
+	assert.NoError(t, err)
+
+	left, err := st.UseClick(ctx, "b")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Unlimited, left)
+
+	assert.NoError(t, st.SetMaxClicks(ctx, "a", 2))
+	left, err = st.UseClick(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, left)
+	assert.NoError(t, st.Shutdown())
+
+	st = newTestStorage(t, dir)
+	defer st.Shutdown()
+
+	links, err := st.GetAllLinksByCookie(ctx, "alice", "")
+	if assert.NoError(t, err) && assert.Len(t, links, 2) {
+		assert.Equal(t, int32(1), links[0].GetClicksLeft())
+		assert.Nil(t, links[1].ClicksLeft)
+	}
+
+	left, err = st.UseClick(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, left)
+
+	_, err = st.UseClick(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrExhausted)
+
+	_, err = st.GetLongLink(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrExhausted)
+
+	assert.NoError(t, st.SetMaxClicks(ctx, "a", storage.Unlimited))
+	long, err := st.GetLongLink(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", long)
+}
+
+func TestBoltStorage_Conformance(t *testing.T) {
+	st := newTestStorage(t, t.TempDir())
+	defer st.Shutdown()
+
+	storagetest.Run(t, st)
+}
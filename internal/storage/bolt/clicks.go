@@ -0,0 +1,72 @@
+package boltstorage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"url-shortener/internal/storage"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SetMaxClicks limits the visits of the link to n, storage.Unlimited removes the limit.
+func (b *BoltStorage) SetMaxClicks(ctx context.Context, shortURL string, n int) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return putClicks(tx, []byte(shortURL), n)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving click limit: %w", err)
+	}
+
+	return nil
+}
+
+// UseClick takes a visit of the link in a read-write transaction, bbolt runs them one at a time.
+func (b *BoltStorage) UseClick(ctx context.Context, shortURL string) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	left := storage.Unlimited
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		n, ok := clicksLeft(tx, []byte(shortURL))
+		switch {
+		case !ok:
+			return nil
+		case n == 0:
+			return storage.ErrExhausted
+		}
+
+		left = n - 1
+		return tx.Bucket(bucketClicks).Put([]byte(shortURL), itob(uint64(left)))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return left, nil
+}
+
+// clicksLeft returns the visits left of the link, false for a link without a limit.
+func clicksLeft(tx *bolt.Tx, short []byte) (int, bool) {
+	data := tx.Bucket(bucketClicks).Get(short)
+	if len(data) != 8 {
+		return 0, false
+	}
+
+	return int(binary.BigEndian.Uint64(data)), true
+}
+
+// putClicks limits the visits of the link to n, storage.Unlimited removes the limit.
+func putClicks(tx *bolt.Tx, short []byte, n int) error {
+	clicks := tx.Bucket(bucketClicks)
+	if n < 0 {
+		return clicks.Delete(short)
+	}
+
+	return clicks.Put(short, itob(uint64(n)))
+}
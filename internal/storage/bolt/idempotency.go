@@ -0,0 +1,156 @@
+package boltstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"url-shortener/internal/storage"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// record value of the idempotency bucket.
+type record struct {
+	Fingerprint string `json:"fingerprint"`
+	Response    []byte `json:"response,omitempty"`
+	Expires     int64  `json:"expires"`
+}
+
+// ReserveKey saves the record unless the key is taken by a record that has not expired.
+// The expired records are removed on the way.
+func (b *BoltStorage) ReserveKey(ctx context.Context, rec storage.IdempotencyRecord) (storage.IdempotencyRecord, bool, error) {
+	if ctx.Err() != nil {
+		return storage.IdempotencyRecord{}, false, ctx.Err()
+	}
+
+	var (
+		found storage.IdempotencyRecord
+		taken bool
+	)
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := sweep(tx, time.Now()); err != nil {
+			return err
+		}
+
+		if data := tx.Bucket(bucketIdempotency).Get([]byte(rec.Key)); data != nil {
+			var v record
+			if err := json.Unmarshal(data, &v); err != nil {
+				return err
+			}
+
+			found = storage.IdempotencyRecord{Key: rec.Key, Fingerprint: v.Fingerprint,
+				Response: v.Response, ExpiresAt: time.UnixMilli(v.Expires)}
+			taken = true
+
+			return nil
+		}
+
+		return putRecord(tx, rec.Key, record{Fingerprint: rec.Fingerprint, Expires: rec.ExpiresAt.UnixMilli()})
+	})
+	if err != nil {
+		return storage.IdempotencyRecord{}, false, fmt.Errorf("error reserving key: %w", err)
+	}
+
+	if taken {
+		return found, false, nil
+	}
+
+	return rec, true, nil
+}
+
+// CompleteKey saves the response of the request with the key.
+func (b *BoltStorage) CompleteKey(ctx context.Context, key string, response []byte) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketIdempotency).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		var v record
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		v.Response = response
+
+		return putRecord(tx, key, v)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving key: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseKey removes the key.
+func (b *BoltStorage) ReleaseKey(ctx context.Context, key string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketIdempotency).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		var v record
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(bucketExpiries).Delete(expiry(v.Expires, key)); err != nil {
+			return err
+		}
+
+		return tx.Bucket(bucketIdempotency).Delete([]byte(key))
+	})
+	if err != nil {
+		return fmt.Errorf("error removing key: %w", err)
+	}
+
+	return nil
+}
+
+func putRecord(tx *bolt.Tx, key string, v record) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Bucket(bucketExpiries).Put(expiry(v.Expires, key), nil); err != nil {
+		return err
+	}
+
+	return tx.Bucket(bucketIdempotency).Put([]byte(key), data)
+}
+
+// sweep removes the records expired by now.
+func sweep(tx *bolt.Tx, now time.Time) error {
+	until := itob(uint64(now.UnixMilli()))
+	expiries, records := tx.Bucket(bucketExpiries), tx.Bucket(bucketIdempotency)
+
+	c := expiries.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k[:8], until) <= 0; k, _ = c.First() {
+		if err := records.Delete(k[8:]); err != nil {
+			return err
+		}
+
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expiry key of the expiries bucket.
+func expiry(expires int64, key string) []byte {
+	return append(itob(uint64(expires)), key...)
+}
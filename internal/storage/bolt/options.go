@@ -0,0 +1,89 @@
+package boltstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"url-shortener/internal/storage"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// GetLinkOptions returns the options of the link, the zero ones if they were not set.
+func (b *BoltStorage) GetLinkOptions(ctx context.Context, shortURL string) (storage.LinkOptions, error) {
+	if ctx.Err() != nil {
+		return storage.LinkOptions{}, ctx.Err()
+	}
+
+	var opts storage.LinkOptions
+
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		opts, err = getOptions(tx, []byte(shortURL))
+		return err
+	})
+	if err != nil {
+		return storage.LinkOptions{}, fmt.Errorf("error getting link options: %w", err)
+	}
+
+	return opts, nil
+}
+
+// SetLinkOptions replaces the options of the link, the zero ones are removed.
+func (b *BoltStorage) SetLinkOptions(ctx context.Context, shortURL string, opts storage.LinkOptions) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return putOptions(tx, []byte(shortURL), opts)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving link options: %w", err)
+	}
+
+	return nil
+}
+
+// getOptions returns the options of the link, the zero ones if they were not set.
+func getOptions(tx *bolt.Tx, short []byte) (storage.LinkOptions, error) {
+	var opts storage.LinkOptions
+
+	data := tx.Bucket(bucketOptions).Get(short)
+	if data == nil {
+		return opts, nil
+	}
+
+	err := json.Unmarshal(data, &opts)
+
+	return opts, err
+}
+
+// putOptions replaces the options of the link, the zero ones are removed.
+func putOptions(tx *bolt.Tx, short []byte, opts storage.LinkOptions) error {
+	options := tx.Bucket(bucketOptions)
+	if opts == (storage.LinkOptions{}) {
+		return options.Delete(short)
+	}
+
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+
+	return options.Put(short, data)
+}
+
+// withOptions adds the options and the visits left of the link.
+func withOptions(tx *bolt.Tx, link storage.Link) (storage.Link, error) {
+	opts, err := getOptions(tx, []byte(link.Short))
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	link.Options = opts
+	if left, ok := clicksLeft(tx, []byte(link.Short)); ok {
+		link.ClicksLeft = &left
+	}
+
+	return link, nil
+}
@@ -0,0 +1,390 @@
+// Package cache implements an in-process read-through LRU cache of the long links in front of any storage.
+// The options of a link and whether its visits are limited are cached along with its long link.
+//
+// Hits and misses of every cache are counted in the "link_cache" expvar map served by /metrics.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+	"url-shortener/internal/storage"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	_ storage.IStorage     = (*Cache)(nil)
+	_ storage.IAdmin       = (*Cache)(nil)
+	_ storage.IWrapper     = (*Cache)(nil)
+	_ storage.IClickLimit  = (*Cache)(nil)
+	_ storage.ILinkOptions = (*Cache)(nil)
+	_ storage.ITargets     = (*Cache)(nil)
+)
+
+// Counters of the "link_cache" expvar map.
+const (
+	CounterHits          = "hits"
+	CounterNegativeHits  = "negative_hits"
+	CounterMisses        = "misses"
+	CounterEvictions     = "evictions"
+	CounterInvalidations = "invalidations"
+)
+
+var stats = expvar.NewMap("link_cache")
+
+// Defaults of the configuration.
+const (
+	DefaultTTL         = time.Minute
+	DefaultNegativeTTL = 10 * time.Second
+	DefaultLoadTimeout = 10 * time.Second
+)
+
+// Config of the cache.
+type Config struct {
+	// Size the maximum number of cached links, the cache is disabled if zero.
+	Size int
+	// TTL of the cached links, DefaultTTL is used if zero.
+	TTL time.Duration
+	// NegativeTTL of the links that were not found, zero disables caching of misses.
+	NegativeTTL time.Duration
+	// LoadTimeout of a load shared by the concurrent misses, DefaultLoadTimeout is used if zero.
+	LoadTimeout time.Duration
+}
+
+// Cache read-through LRU cache of GetLongLink and GetTarget.
+// The other methods are passed to the storage, the ones changing links drop the cached value.
+type Cache struct {
+	storage.IStorage
+	cfg Config
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	// gen is incremented on every invalidation, a load started before it is not cached.
+	gen uint64
+
+	group singleflight.Group
+	now   func() time.Time
+}
+
+type entry struct {
+	short   string
+	target  storage.Target
+	err     error
+	expires time.Time
+}
+
+// New wraps next with the cache.
+func New(next storage.IStorage, cfg Config) *Cache {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+
+	if cfg.LoadTimeout <= 0 {
+		cfg.LoadTimeout = DefaultLoadTimeout
+	}
+
+	return &Cache{
+		IStorage: next,
+		cfg:      cfg,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element, cfg.Size),
+		now:      time.Now,
+	}
+}
+
+// GetLongLink gets a long link from the cache or from the storage on a miss.
+// Concurrent misses of one link share a single call of the storage.
+func (c *Cache) GetLongLink(ctx context.Context, shortURL string) (string, error) {
+	t, err := c.GetTarget(ctx, shortURL)
+
+	return t.Long, err
+}
+
+// GetTarget gets the target of a link from the cache or from the storage on a miss.
+// Concurrent misses of one link share a single call of the storage.
+func (c *Cache) GetTarget(ctx context.Context, shortURL string) (storage.Target, error) {
+	if ctx.Err() != nil {
+		return storage.Target{}, ctx.Err()
+	}
+
+	if e, ok := c.get(shortURL); ok {
+		if errors.Is(e.err, storage.ErrNotFound) {
+			stats.Add(CounterNegativeHits, 1)
+		} else {
+			stats.Add(CounterHits, 1)
+		}
+
+		return e.target, e.err
+	}
+
+	stats.Add(CounterMisses, 1)
+
+	// the load is shared, so it must not be cancelled with the caller that started it
+	ch := c.group.DoChan(shortURL, func() (any, error) {
+		c.mu.Lock()
+		gen := c.gen
+		c.mu.Unlock()
+
+		loadCtx, cancel := context.WithTimeout(detached{ctx}, c.cfg.LoadTimeout)
+		defer cancel()
+
+		t, err := storage.GetTarget(loadCtx, c.IStorage, shortURL)
+		c.add(gen, shortURL, t, err)
+
+		return t, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return storage.Target{}, ctx.Err()
+	case res := <-ch:
+		t, _ := res.Val.(storage.Target)
+
+		return t, res.Err
+	}
+}
+
+// detached keeps the values of the context without its deadline and cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// AddLink adds the link to the storage and drops a cached miss of it.
+func (c *Cache) AddLink(ctx context.Context, longURL, shortURL, cookie string) (string, error) {
+	short, err := c.IStorage.AddLink(ctx, longURL, shortURL, cookie)
+	c.forget(shortURL)
+
+	return short, err
+}
+
+// AddLinks adds the links to the storage and drops cached misses of them.
+func (c *Cache) AddLinks(ctx context.Context, links []storage.Link) ([]storage.BatchResult, error) {
+	results, err := c.IStorage.AddLinks(ctx, links)
+	for _, link := range links {
+		c.forget(link.Short)
+	}
+
+	return results, err
+}
+
+// MarkAsDeleted marks the link as deleted in the storage and drops it from the cache.
+func (c *Cache) MarkAsDeleted(ctx context.Context, shortURL, cookie string) error {
+	err := c.IStorage.MarkAsDeleted(ctx, shortURL, cookie)
+	c.forget(shortURL)
+
+	return err
+}
+
+// Unwrap returns the cached storage.
+func (c *Cache) Unwrap() storage.IStorage {
+	return c.IStorage
+}
+
+// GetLink gets the record from the storage.
+func (c *Cache) GetLink(ctx context.Context, shortURL string) (storage.Link, error) {
+	admin, err := c.admin()
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	return admin.GetLink(ctx, shortURL)
+}
+
+// DisableLink disables the link in the storage and drops it from the cache.
+func (c *Cache) DisableLink(ctx context.Context, shortURL string) error {
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	err = admin.DisableLink(ctx, shortURL)
+	c.forget(shortURL)
+
+	return err
+}
+
+// PurgeLink removes the link from the storage and the cache.
+func (c *Cache) PurgeLink(ctx context.Context, shortURL string) error {
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	err = admin.PurgeLink(ctx, shortURL)
+	c.forget(shortURL)
+
+	return err
+}
+
+// Links calls fn for every record of the storage.
+func (c *Cache) Links(ctx context.Context, fn func(storage.Link) error) error {
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	return admin.Links(ctx, fn)
+}
+
+// ImportLink saves the record to the storage and drops a cached value of it.
+func (c *Cache) ImportLink(ctx context.Context, link storage.Link) error {
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	err = admin.ImportLink(ctx, link)
+	c.forget(link.Short)
+
+	return err
+}
+
+// SetMaxClicks limits the visits of the link in the storage and drops it from the cache.
+func (c *Cache) SetMaxClicks(ctx context.Context, shortURL string, n int) error {
+	clicks, ok := storage.As[storage.IClickLimit](c.IStorage)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+
+	err := clicks.SetMaxClicks(ctx, shortURL, n)
+	c.forget(shortURL)
+
+	return err
+}
+
+// UseClick takes a visit of the link in the storage, the link is dropped from the cache
+// when no visit is left. The visits are not limited if the storage can't limit them.
+func (c *Cache) UseClick(ctx context.Context, shortURL string) (int, error) {
+	clicks, ok := storage.As[storage.IClickLimit](c.IStorage)
+	if !ok {
+		return storage.Unlimited, nil
+	}
+
+	left, err := clicks.UseClick(ctx, shortURL)
+	if err == nil && left == 0 || errors.Is(err, storage.ErrExhausted) {
+		c.forget(shortURL)
+	}
+
+	return left, err
+}
+
+// GetLinkOptions gets the options of the link from the storage.
+func (c *Cache) GetLinkOptions(ctx context.Context, shortURL string) (storage.LinkOptions, error) {
+	options, ok := storage.As[storage.ILinkOptions](c.IStorage)
+	if !ok {
+		return storage.LinkOptions{}, storage.ErrNotSupported
+	}
+
+	return options.GetLinkOptions(ctx, shortURL)
+}
+
+// SetLinkOptions replaces the options of the link in the storage and drops it from the cache.
+func (c *Cache) SetLinkOptions(ctx context.Context, shortURL string, opts storage.LinkOptions) error {
+	options, ok := storage.As[storage.ILinkOptions](c.IStorage)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+
+	err := options.SetLinkOptions(ctx, shortURL, opts)
+	c.forget(shortURL)
+
+	return err
+}
+
+// Len returns the number of cached links.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+func (c *Cache) admin() (storage.IAdmin, error) {
+	admin, ok := storage.As[storage.IAdmin](c.IStorage)
+	if !ok {
+		return nil, storage.ErrNotSupported
+	}
+
+	return admin, nil
+}
+
+func (c *Cache) get(short string) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[short]
+	if !ok {
+		return entry{}, false
+	}
+
+	e := el.Value.(*entry)
+	if !c.now().Before(e.expires) {
+		c.remove(el)
+		return entry{}, false
+	}
+
+	c.ll.MoveToFront(el)
+
+	return *e, true
+}
+
+// add caches the result of the storage unless the link was invalidated since gen.
+func (c *Cache) add(gen uint64, short string, t storage.Target, err error) {
+	ttl := c.cfg.TTL
+	switch {
+	case err == nil, errors.Is(err, storage.ErrDeleted):
+	case errors.Is(err, storage.ErrNotFound) && c.cfg.NegativeTTL > 0:
+		ttl = c.cfg.NegativeTTL
+	default:
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	e := &entry{short: short, target: t, err: err, expires: c.now().Add(ttl)}
+	if el, ok := c.items[short]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[short] = c.ll.PushFront(e)
+
+	for c.ll.Len() > c.cfg.Size {
+		c.remove(c.ll.Back())
+		stats.Add(CounterEvictions, 1)
+	}
+}
+
+func (c *Cache) forget(short string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if el, ok := c.items[short]; ok {
+		c.remove(el)
+	}
+
+	// callers after this one don't wait for a load started before the change
+	c.group.Forget(short)
+	stats.Add(CounterInvalidations, 1)
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry).short)
+}
@@ -0,0 +1,312 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"url-shortener/internal/storage"
+	mapstorage "url-shortener/internal/storage/map"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type adminStorage interface {
+	storage.IStorage
+	storage.IAdmin
+}
+
+// counting counts the calls of GetLink the links are loaded by. If release is set, the calls read
+// the link and wait for it to be closed before returning, the ones cancelled meanwhile fail.
+type counting struct {
+	adminStorage
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (c *counting) GetLink(ctx context.Context, shortURL string) (storage.Link, error) {
+	link, err := c.adminStorage.GetLink(ctx, shortURL)
+	c.calls.Add(1)
+	if c.release != nil {
+		<-c.release
+		if ctx.Err() != nil {
+			return storage.Link{}, ctx.Err()
+		}
+	}
+
+	return link, err
+}
+
+// Unwrap lets the capabilities of the map storage hidden by adminStorage be found.
+func (c *counting) Unwrap() storage.IStorage {
+	return c.adminStorage
+}
+
+func newTestCache(t *testing.T, cfg Config) (*Cache, *counting, *time.Time) {
+	t.Helper()
+
+	next := &counting{adminStorage: mapstorage.NewMapStorage().(adminStorage)}
+	t.Cleanup(func() { next.Shutdown() })
+
+	c := New(next, cfg)
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	return c, next, &now
+}
+
+func counter(name string) int64 {
+	v, ok := stats.Get(name).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+
+	return v.Value()
+}
+
+func TestCache_GetLongLink(t *testing.T) {
+	ctx := context.Background()
+	c, next, now := newTestCache(t, Config{Size: 2, TTL: time.Minute, NegativeTTL: time.Second})
+
+	for _, short := range []string{"a", "b", "c"} {
+		if _, err := c.AddLink(ctx, "https://"+short+".ru", short, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hits, misses := counter(CounterHits), counter(CounterMisses)
+
+	for i := 0; i < 3; i++ {
+		long, err := c.GetLongLink(ctx, "a")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://a.ru", long)
+	}
+	assert.EqualValues(t, 1, next.calls.Load())
+	assert.Equal(t, hits+2, counter(CounterHits))
+	assert.Equal(t, misses+1, counter(CounterMisses))
+
+	// misses are cached for NegativeTTL
+	for i := 0; i < 2; i++ {
+		_, err := c.GetLongLink(ctx, "nope")
+		assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+	}
+	assert.EqualValues(t, 2, next.calls.Load())
+
+	*now = now.Add(2 * time.Second)
+	_, err := c.GetLongLink(ctx, "nope")
+	assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+	assert.EqualValues(t, 3, next.calls.Load(), "the miss is expired")
+
+	// "a" is the least recently used one
+	for _, short := range []string{"b", "c"} {
+		_, err = c.GetLongLink(ctx, short)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, c.Len())
+
+	calls := next.calls.Load()
+	_, err = c.GetLongLink(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, calls+1, next.calls.Load(), "the link is evicted")
+
+	*now = now.Add(time.Minute)
+	_, err = c.GetLongLink(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, calls+2, next.calls.Load(), "the link is expired")
+}
+
+func TestCache_Invalidation(t *testing.T) {
+	ctx := context.Background()
+	c, next, _ := newTestCache(t, Config{Size: 10, TTL: time.Minute, NegativeTTL: time.Minute})
+
+	_, err := c.GetLongLink(ctx, "a")
+	assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+
+	_, err = c.AddLink(ctx, "https://a.ru", "a", "alice")
+	assert.NoError(t, err)
+
+	long, err := c.GetLongLink(ctx, "a")
+	assert.NoError(t, err, "AddLink drops the cached miss")
+	assert.Equal(t, "https://a.ru", long)
+
+	assert.NoError(t, c.MarkAsDeleted(ctx, "a", "alice"))
+	_, err = c.GetLongLink(ctx, "a")
+	assert.True(t, errors.Is(err, storage.ErrDeleted), err)
+
+	assert.NoError(t, c.ImportLink(ctx, storage.Link{Short: "b", Long: "https://b.ru", Owner: "bob"}))
+	_, err = c.GetLongLink(ctx, "b")
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.DisableLink(ctx, "b"))
+	_, err = c.GetLongLink(ctx, "b")
+	assert.True(t, errors.Is(err, storage.ErrDeleted), err)
+
+	assert.NoError(t, c.PurgeLink(ctx, "b"))
+	_, err = c.GetLongLink(ctx, "b")
+	assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+
+	// the change is seen even if the storage call of it fails
+	_, err = c.GetLongLink(ctx, "a")
+	assert.True(t, errors.Is(err, storage.ErrDeleted), err)
+	calls := next.calls.Load()
+	assert.Error(t, c.MarkAsDeleted(ctx, "a", "bob"))
+	_, _ = c.GetLongLink(ctx, "a")
+	assert.Equal(t, calls+1, next.calls.Load())
+}
+
+func TestCache_ClickLimit(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := newTestCache(t, Config{Size: 10, TTL: time.Minute})
+
+	_, err := c.AddLink(ctx, "https://a.ru", "a", "alice")
+	assert.NoError(t, err)
+	_, err = c.GetLongLink(ctx, "a")
+	assert.NoError(t, err)
+
+	// the storage limiting the visits is found behind the wrapper
+	assert.NoError(t, c.SetMaxClicks(ctx, "a", 1))
+
+	left, err := c.UseClick(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, left)
+
+	_, err = c.GetLongLink(ctx, "a")
+	assert.True(t, errors.Is(err, storage.ErrExhausted), err)
+}
+
+func TestCache_GetTarget(t *testing.T) {
+	ctx := context.Background()
+	c, next, _ := newTestCache(t, Config{Size: 10, TTL: time.Minute})
+
+	_, err := c.AddLinks(ctx, []storage.Link{{Short: "a", Long: "https://a.ru", Owner: "alice"}})
+	assert.NoError(t, err)
+	assert.NoError(t, c.SetLinkOptions(ctx, "a", storage.LinkOptions{Title: "A", Preview: true}))
+
+	// the options are cached along with the long link
+	for i := 0; i < 2; i++ {
+		target, err := c.GetTarget(ctx, "a")
+		assert.NoError(t, err)
+		assert.Equal(t, storage.Target{Long: "https://a.ru", Options: storage.LinkOptions{Title: "A", Preview: true}}, target)
+	}
+	long, err := c.GetLongLink(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://a.ru", long)
+	assert.EqualValues(t, 1, next.calls.Load())
+
+	assert.NoError(t, c.SetLinkOptions(ctx, "a", storage.LinkOptions{Title: "B"}))
+	target, err := c.GetTarget(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.LinkOptions{Title: "B"}, target.Options, "SetLinkOptions drops the cached target")
+	assert.False(t, target.Limited)
+
+	assert.NoError(t, c.SetMaxClicks(ctx, "a", 2))
+	target, err = c.GetTarget(ctx, "a")
+	assert.NoError(t, err)
+	assert.True(t, target.Limited, "SetMaxClicks drops the cached target")
+	assert.EqualValues(t, 3, next.calls.Load())
+}
+
+func TestCache_Coalescing(t *testing.T) {
+	ctx := context.Background()
+	c, next, _ := newTestCache(t, Config{Size: 10, TTL: time.Minute})
+
+	if _, err := c.AddLink(ctx, "https://a.ru", "a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	next.release = make(chan struct{})
+	misses := counter(CounterMisses)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			long, err := c.GetLongLink(ctx, "a")
+			assert.NoError(t, err)
+			assert.Equal(t, "https://a.ru", long)
+		}()
+	}
+
+	assert.Eventually(t, func() bool { return counter(CounterMisses) == misses+10 }, time.Second, time.Millisecond)
+	// let the callers join the call in progress
+	time.Sleep(10 * time.Millisecond)
+	close(next.release)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, next.calls.Load())
+}
+
+func TestCache_CoalescingCancel(t *testing.T) {
+	c, next, _ := newTestCache(t, Config{Size: 10, TTL: time.Minute})
+
+	if _, err := c.AddLink(context.Background(), "https://a.ru", "a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	next.release = make(chan struct{})
+	misses := counter(CounterMisses)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := c.GetLongLink(ctx, "a")
+		first <- err
+	}()
+
+	assert.Eventually(t, func() bool { return next.calls.Load() > 0 }, time.Second, time.Millisecond)
+
+	second := make(chan string)
+	go func() {
+		long, err := c.GetLongLink(context.Background(), "a")
+		assert.NoError(t, err)
+		second <- long
+	}()
+
+	assert.Eventually(t, func() bool { return counter(CounterMisses) == misses+2 }, time.Second, time.Millisecond)
+	// let the second caller join the load in progress
+	time.Sleep(10 * time.Millisecond)
+
+	// the caller that started the load gives up, the load goes on for the others
+	cancel()
+	assert.True(t, errors.Is(<-first, context.Canceled))
+
+	close(next.release)
+	assert.Equal(t, "https://a.ru", <-second)
+	assert.EqualValues(t, 1, next.calls.Load())
+}
+
+func TestCache_Stale(t *testing.T) {
+	ctx := context.Background()
+	c, next, _ := newTestCache(t, Config{Size: 10, TTL: time.Minute})
+
+	if _, err := c.AddLink(ctx, "https://a.ru", "a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	next.release = make(chan struct{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		long, err := c.GetLongLink(ctx, "a")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://a.ru", long)
+	}()
+
+	assert.Eventually(t, func() bool { return next.calls.Load() > 0 }, time.Second, time.Millisecond)
+
+	// the link is deleted after it was read, but before the read is cached
+	assert.NoError(t, c.MarkAsDeleted(ctx, "a", "alice"))
+	close(next.release)
+	<-done
+
+	_, err := c.GetLongLink(ctx, "a")
+	assert.True(t, errors.Is(err, storage.ErrDeleted), "the value read before the change is not cached")
+	assert.EqualValues(t, 2, next.calls.Load())
+}
@@ -0,0 +1,157 @@
+// Package clicklimit keeps the visits left of the limited links for the storages keeping their data in memory.
+//
+// The counters are served from memory. A persistent Store appends every change to a write-ahead log,
+// the log is rewritten with the current counters when it is opened.
+package clicklimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/wal"
+)
+
+// Store the visits left of the limited links, safe for concurrent use.
+type Store struct {
+	mu   sync.Mutex
+	left map[string]int
+	// file is nil if the store is kept in memory only.
+	file *wal.Log
+}
+
+// record of the log, the counter of a link replaces the earlier ones. storage.Unlimited removes it.
+type record struct {
+	Short string `json:"short"`
+	Left  int    `json:"left"`
+}
+
+// NewMemory returns a Store kept in memory only.
+func NewMemory() *Store {
+	return &Store{left: make(map[string]int)}
+}
+
+// Open restores the Store from the file at path, creating it if needed.
+func Open(path string, policy wal.SyncPolicy) (*Store, error) {
+	if policy == "" {
+		policy = wal.SyncAlways
+	}
+
+	s := NewMemory()
+	records := 0
+
+	file, err := wal.Open(path, policy, func(data []byte) error {
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+
+		records++
+		s.set(r.Short, r.Left)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't open the click limits: %w", err)
+	}
+	s.file = file
+
+	if records > len(s.left) {
+		err = file.Rewrite(func(write func(v any) error) error {
+			for short, left := range s.left {
+				if err := write(record{Short: short, Left: left}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("can't compact the click limits: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// SetMaxClicks limits the visits of the link to n, storage.Unlimited removes the limit.
+func (s *Store) SetMaxClicks(ctx context.Context, shortURL string, n int) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if n < 0 {
+		n = storage.Unlimited
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(shortURL, n)
+}
+
+// UseClick takes a visit of the link and returns the visits left, storage.ErrExhausted if none was left.
+func (s *Store) UseClick(ctx context.Context, shortURL string) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	left, ok := s.left[shortURL]
+	switch {
+	case !ok:
+		return storage.Unlimited, nil
+	case left == 0:
+		return 0, storage.ErrExhausted
+	}
+
+	if err := s.write(shortURL, left-1); err != nil {
+		return 0, err
+	}
+
+	return left - 1, nil
+}
+
+// Left returns the visits left of the link, false for a link without a limit.
+func (s *Store) Left(shortURL string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	left, ok := s.left[shortURL]
+
+	return left, ok
+}
+
+// write logs the counter of the link and keeps it.
+func (s *Store) write(shortURL string, left int) error {
+	if s.file != nil {
+		if err := s.file.Append(record{Short: shortURL, Left: left}); err != nil {
+			return fmt.Errorf("can't write the click limit: %w", err)
+		}
+	}
+	s.set(shortURL, left)
+
+	return nil
+}
+
+// set keeps only the limited links.
+func (s *Store) set(shortURL string, left int) {
+	if left < 0 {
+		delete(s.left, shortURL)
+		return
+	}
+
+	s.left[shortURL] = left
+}
+
+// Close closes the file of a persistent Store.
+func (s *Store) Close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	return s.file.Close()
+}
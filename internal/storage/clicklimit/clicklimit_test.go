@@ -0,0 +1,89 @@
+package clicklimit
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"url-shortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "clicks")
+
+	s, err := Open(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	left, err := s.UseClick(ctx, "free")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Unlimited, left)
+
+	assert.NoError(t, s.SetMaxClicks(ctx, "once", 1))
+	assert.NoError(t, s.SetMaxClicks(ctx, "twice", 2))
+	assert.NoError(t, s.SetMaxClicks(ctx, "lifted", 1))
+	assert.NoError(t, s.SetMaxClicks(ctx, "lifted", storage.Unlimited))
+
+	left, err = s.UseClick(ctx, "once")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, left)
+
+	_, err = s.UseClick(ctx, "once")
+	assert.ErrorIs(t, err, storage.ErrExhausted)
+
+	left, err = s.UseClick(ctx, "twice")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, left)
+	assert.NoError(t, s.Close())
+
+	s, err = Open(path, "")
+	if assert.NoError(t, err) {
+		left, ok := s.Left("once")
+		assert.True(t, ok)
+		assert.Equal(t, 0, left)
+
+		left, ok = s.Left("twice")
+		assert.True(t, ok)
+		assert.Equal(t, 1, left)
+
+		_, ok = s.Left("lifted")
+		assert.False(t, ok)
+
+		// the log was compacted
+		assert.Len(t, s.left, 2)
+		assert.NoError(t, s.Close())
+	}
+}
+
+func TestStore_UseClick_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	const limit = 10
+	assert.NoError(t, s.SetMaxClicks(ctx, "a", limit))
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		used int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := s.UseClick(ctx, "a"); err == nil {
+				mu.Lock()
+				used++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, limit, used)
+}
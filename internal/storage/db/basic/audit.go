@@ -0,0 +1,104 @@
+package basic
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/queries"
+)
+
+var _ storage.IAudit = (*DB)(nil)
+
+// AppendAudit inserts the entries in one transaction.
+func (db *DB) AppendAudit(ctx context.Context, entries ...storage.AuditEntry) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	insert, err := db.stmts.Get(queries.InsertAuditEntry)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error saving audit entries: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt := tx.StmtContext(ctx, insert)
+	for _, e := range entries {
+		_, err = stmt.ExecContext(ctx, e.At.UnixMilli(), e.Actor, e.IP, e.Operation, e.Target,
+			e.OldValue, e.NewValue, e.Outcome, e.Error)
+		if err != nil {
+			return fmt.Errorf("error saving audit entries: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error saving audit entries: %w", err)
+	}
+
+	return nil
+}
+
+// AuditEntries returns the entries selected by the filter.
+func (db *DB) AuditEntries(ctx context.Context, filter storage.AuditFilter) ([]storage.AuditEntry, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := db.stmts.Get(queries.AuditEntries)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	var from, to, limit int64 = 0, math.MaxInt64, math.MaxInt32
+	if !filter.From.IsZero() {
+		from = filter.From.UnixMilli()
+	}
+	if !filter.To.IsZero() {
+		to = filter.To.UnixMilli()
+	}
+	if filter.Limit > 0 {
+		limit = int64(filter.Limit)
+	}
+
+	rows, err := stmt.QueryContext(ctx, filter.AfterID, from, to, filter.Actor, filter.Actor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error getting audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]storage.AuditEntry, 0)
+	for rows.Next() {
+		var (
+			e  storage.AuditEntry
+			at int64
+		)
+
+		err = rows.Scan(&e.ID, &at, &e.Actor, &e.IP, &e.Operation, &e.Target,
+			&e.OldValue, &e.NewValue, &e.Outcome, &e.Error)
+		if err != nil {
+			return nil, fmt.Errorf("error getting audit entries: %w", err)
+		}
+		e.At = time.UnixMilli(at).UTC()
+
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error getting audit entries: %w", err)
+	}
+
+	return entries, nil
+}
@@ -3,16 +3,57 @@ package basic
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 	shortener "url-shortener/pkg/api"
 
 	"url-shortener/internal/storage"
 	"url-shortener/internal/storage/db/queries"
+
+	"google.golang.org/protobuf/proto"
 )
 
+// Options of the database storage.
+type Options struct {
+	// Name of the connection pool in the "db_pools" expvar map, the pool is not published if empty.
+	Name string
+	// QueryTimeout limits every query except reading all links, zero leaves it to the context of the caller.
+	QueryTimeout time.Duration
+}
+
 // DB is a basic implementation of the storage.Repository interface.
 type DB struct {
 	*sql.DB
+	stmts  *queries.Statements
+	vendor string
+	opts   Options
+}
+
+// New prepares the queries of the vendor, the tables must already exist.
+func New(db *sql.DB, vendor string, opts Options) (DB, error) {
+	stmts, err := queries.Prepare(db, vendor)
+	if err != nil {
+		return DB{}, fmt.Errorf("failed to prepare queries: %w", err)
+	}
+
+	publish(opts.Name, db)
+
+	return DB{DB: db, stmts: stmts, vendor: vendor, opts: opts}, nil
+}
+
+// WithTimeout limits ctx by the query timeout.
+func (db *DB) WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.opts.QueryTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, db.opts.QueryTimeout)
+}
+
+// Stmt returns the prepared statement of the query.
+func (db *DB) Stmt(name queries.Name) (*sql.Stmt, error) {
+	return db.stmts.Get(name)
 }
 
 // Ping checks connection with the repository.
@@ -21,6 +62,9 @@ func (db *DB) Ping(ctx context.Context) error {
 		return ctx.Err()
 	}
 
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
 	if err := db.PingContext(ctx); err != nil {
 		return fmt.Errorf("error pinging db: %w", err)
 	}
@@ -28,8 +72,14 @@ func (db *DB) Ping(ctx context.Context) error {
 	return nil
 }
 
-// Shutdown closes the database connection.
+// Shutdown closes the prepared statements and the database connection.
 func (db *DB) Shutdown() error {
+	unpublish(db.opts.Name, db.DB)
+
+	if err := db.stmts.Close(); err != nil {
+		return err
+	}
+
 	if err := db.Close(); err != nil {
 		return fmt.Errorf("error closing db: %w", err)
 	}
@@ -38,13 +88,20 @@ func (db *DB) Shutdown() error {
 }
 
 // MarkAsDeleted finds a URL and marks it as deleted.
-func (db *DB) MarkAsDeleted(shortURL, cookie string) error {
-	stmt, err := queries.GetPreparedStatement(queries.MarkAsDeleted)
+func (db *DB) MarkAsDeleted(ctx context.Context, shortURL, cookie string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := db.stmts.Get(queries.MarkAsDeleted)
 	if err != nil {
 		return fmt.Errorf("error preparing statement: %w", err)
 	}
 
-	_, err = stmt.Exec(sql.Named("short", shortURL).Value,
+	_, err = stmt.ExecContext(ctx, sql.Named("short", shortURL).Value,
 		sql.Named("cookie", cookie).Value,
 	)
 
@@ -61,14 +118,17 @@ func (db *DB) FindMaxID(ctx context.Context) (int, error) {
 		return 0, ctx.Err()
 	}
 
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
 	var id int
 
-	stmt, err := queries.GetPreparedStatement(queries.FindMaxURL)
+	stmt, err := db.stmts.Get(queries.FindMaxURL)
 	if err != nil {
 		return 0, fmt.Errorf("error preparing statement: %w", err)
 	}
 
-	if stmt.QueryRowContext(ctx).Scan(&id) != nil {
+	if err = stmt.QueryRowContext(ctx).Scan(&id); err != nil {
 		return 0, fmt.Errorf("error finding max id: %w", err)
 	}
 
@@ -81,32 +141,43 @@ func (db *DB) GetAllLinksByCookie(ctx context.Context, cookie, baseURL string) (
 		return nil, ctx.Err()
 	}
 
-	stmt, err := queries.GetPreparedStatement(queries.GetAllLinksByCookie)
-	if err != nil {
-		return nil, nil
-	}
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
 
-	stm, err := stmt.QueryContext(ctx, sql.Named("cookie", cookie).Value)
+	stmt, err := db.stmts.Get(queries.GetAllLinksByCookie)
 	if err != nil {
-		return nil, fmt.Errorf("error getting links by cookie: %w", err)
+		return nil, fmt.Errorf("error preparing statement: %w", err)
 	}
 
-	err = stm.Err()
+	stm, err := stmt.QueryContext(ctx, sql.Named("cookie", cookie).Value)
 	if err != nil {
 		return nil, fmt.Errorf("error getting links by cookie: %w", err)
 	}
+	defer stm.Close()
 
 	var links = make([]*shortener.UserURL, 0)
 
 	for stm.Next() {
 		short, long := "", ""
+		var (
+			deleted    sql.NullBool
+			clicksLeft sql.NullInt64
+		)
 
-		err = stm.Scan(&short, &long)
+		err = stm.Scan(&short, &long, &deleted, &clicksLeft)
 		if err != nil {
 			return nil, fmt.Errorf("error getting links by cookie: %w", err)
 		}
 
-		links = append(links, &shortener.UserURL{OriginalUrl: long, ShortUrl: baseURL + short})
+		link := &shortener.UserURL{OriginalUrl: long, ShortUrl: baseURL + short, Deleted: deleted.Bool}
+		if clicksLeft.Valid {
+			link.ClicksLeft = proto.Int32(int32(clicksLeft.Int64))
+		}
+		links = append(links, link)
+	}
+
+	if err = stm.Err(); err != nil {
+		return nil, fmt.Errorf("error getting links by cookie: %w", err)
 	}
 
 	return links, nil
@@ -118,13 +189,22 @@ func (db *DB) GetLongLink(ctx context.Context, shortURL string) (longURL string,
 		return "", ctx.Err()
 	}
 
-	stmt, err := queries.GetPreparedStatement(queries.GetLongLink)
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := db.stmts.Get(queries.GetLongLink)
 	if err != nil {
 		return "", fmt.Errorf("error preparing statement: %w", err)
 	}
 
-	var isDeleted = sql.NullBool{}
-	if err = stmt.QueryRowContext(ctx, sql.Named("short", shortURL).Value).Scan(&longURL, &isDeleted); err != nil {
+	var (
+		isDeleted  sql.NullBool
+		clicksLeft sql.NullInt64
+	)
+	err = stmt.QueryRowContext(ctx, sql.Named("short", shortURL).Value).Scan(&longURL, &isDeleted, &clicksLeft)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("error getting long link: %w", storage.ErrNotFound)
+	} else if err != nil {
 		return "", fmt.Errorf("error getting long link: %w", err)
 	}
 
@@ -132,6 +212,10 @@ func (db *DB) GetLongLink(ctx context.Context, shortURL string) (longURL string,
 		return "", fmt.Errorf("error getting long link: %w", storage.ErrDeleted)
 	}
 
+	if clicksLeft.Valid && clicksLeft.Int64 <= 0 {
+		return "", fmt.Errorf("error getting long link: %w", storage.ErrExhausted)
+	}
+
 	return longURL, nil
 }
 
@@ -141,12 +225,15 @@ func (db *DB) AddLink(ctx context.Context, longURL, shortURL, cookie string) (st
 		return "", ctx.Err()
 	}
 
-	stmt, err := queries.GetPreparedStatement(queries.InsertURL)
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := db.stmts.Get(queries.InsertURL)
 	if err != nil {
 		return "", fmt.Errorf("error preparing statement: %w", err)
 	}
 
-	_, err = stmt.Exec(
+	_, err = stmt.ExecContext(ctx,
 		sql.Named("long", longURL).Value,
 		sql.Named("short", shortURL).Value,
 		sql.Named("cookie", cookie).Value,
@@ -159,20 +246,160 @@ func (db *DB) AddLink(ctx context.Context, longURL, shortURL, cookie string) (st
 	return shortURL, nil
 }
 
+// insertChunk the number of links inserted by one query, it keeps the placeholders under the vendor limits.
+const insertChunk = 300
+
+// AddLinks adds the links in one transaction by multi-row inserts, the options and the visits left
+// of the links are saved in it too. A link exists if its long URL or its short URL is taken, by a stored link or an earlier one of the batch.
+func (db *DB) AddLinks(ctx context.Context, links []storage.Link) ([]storage.BatchResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	getShort, err := db.stmts.Get(queries.GetShortLink)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	getLong, err := db.stmts.Get(queries.GetLongLink)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error adding links: %w", err)
+	}
+	defer tx.Rollback()
+
+	getShort, getLong = tx.StmtContext(ctx, getShort), tx.StmtContext(ctx, getLong)
+
+	results := make([]storage.BatchResult, len(links))
+	fresh := make([]storage.Link, 0, len(links))
+	longs := make(map[string]string, len(links))
+	shorts := make(map[string]bool, len(links))
+
+	for i, link := range links {
+		results[i].Short = link.Short
+
+		if short, ok := longs[link.Long]; ok {
+			results[i] = storage.BatchResult{Short: short, Exists: true}
+			continue
+		}
+
+		if shorts[link.Short] {
+			results[i].Exists = true
+			continue
+		}
+
+		var short string
+		err = getShort.QueryRowContext(ctx, sql.Named("long", link.Long).Value).Scan(&short)
+		if err == nil {
+			results[i] = storage.BatchResult{Short: short, Exists: true}
+			continue
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("error adding links: %w", err)
+		}
+
+		var (
+			long    string
+			deleted sql.NullBool
+			clicks  sql.NullInt64
+		)
+		err = getLong.QueryRowContext(ctx, sql.Named("short", link.Short).Value).Scan(&long, &deleted, &clicks)
+		if err == nil {
+			results[i].Exists = true
+			continue
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("error adding links: %w", err)
+		}
+
+		longs[link.Long], shorts[link.Short] = link.Short, true
+		fresh = append(fresh, link)
+	}
+
+	for start := 0; start < len(fresh); start += insertChunk {
+		chunk := fresh[start:]
+		if len(chunk) > insertChunk {
+			chunk = chunk[:insertChunk]
+		}
+
+		query, err := queries.InsertURLs(db.vendor, len(chunk))
+		if err != nil {
+			return nil, err
+		}
+
+		args := make([]any, 0, 3*len(chunk))
+		for _, link := range chunk {
+			args = append(args, link.Long, link.Short, link.Owner)
+		}
+
+		if _, err = tx.ExecContext(ctx, string(query), args...); err != nil {
+			return nil, fmt.Errorf("error adding links: %w", err)
+		}
+	}
+
+	if err = db.restrict(ctx, tx, fresh); err != nil {
+		return nil, fmt.Errorf("error adding links: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error adding links: %w", err)
+	}
+
+	return results, nil
+}
+
+// restrict saves the options and the visits left of the links added by tx.
+func (db *DB) restrict(ctx context.Context, tx *sql.Tx, links []storage.Link) error {
+	options, err := db.stmts.Get(queries.SetLinkOptions)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	clicks, err := db.stmts.Get(queries.SetMaxClicks)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	for _, link := range links {
+		if opts := link.Options; opts != (storage.LinkOptions{}) {
+			_, err = tx.StmtContext(ctx, options).ExecContext(ctx, link.Short, opts.Title, opts.Preview, opts.PasswordHash)
+			if err != nil {
+				return err
+			}
+		}
+
+		if link.ClicksLeft != nil {
+			if _, err = tx.StmtContext(ctx, clicks).ExecContext(ctx, *link.ClicksLeft, link.Short); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // URLsCount gets count of URLs in the repository.
 func (db *DB) URLsCount(ctx context.Context) (int, error) {
 	if ctx.Err() != nil {
 		return 0, ctx.Err()
 	}
 
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
 	var count int
 
-	stmt, err := queries.GetPreparedStatement(queries.CountURLs)
+	stmt, err := db.stmts.Get(queries.CountURLs)
 	if err != nil {
 		return 0, fmt.Errorf("error preparing statement: %w", err)
 	}
 
-	if err = stmt.QueryRow(ctx).Scan(&count); err != nil {
+	if err = stmt.QueryRowContext(ctx).Scan(&count); err != nil {
 		return 0, fmt.Errorf("error counting URLs: %w", err)
 	}
 
@@ -185,9 +412,12 @@ func (db *DB) UsersCount(ctx context.Context) (int, error) {
 		return 0, ctx.Err()
 	}
 
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
 	var count int
 
-	stmt, err := queries.GetPreparedStatement(queries.CountUsers)
+	stmt, err := db.stmts.Get(queries.CountUsers)
 	if err != nil {
 		return 0, fmt.Errorf("error preparing statement: %w", err)
 	}
@@ -198,3 +428,247 @@ func (db *DB) UsersCount(ctx context.Context) (int, error) {
 
 	return count, nil
 }
+
+// GetLink gets the record including deleted ones with its options and visits left.
+func (db *DB) GetLink(ctx context.Context, shortURL string) (storage.Link, error) {
+	if ctx.Err() != nil {
+		return storage.Link{}, ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := db.stmts.Get(queries.GetLink)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	link, err := scanLink(stmt.QueryRowContext(ctx, sql.Named("short", shortURL).Value))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Link{}, fmt.Errorf("error getting link: %w", storage.ErrNotFound)
+	} else if err != nil {
+		return storage.Link{}, fmt.Errorf("error getting link: %w", err)
+	}
+
+	return link, nil
+}
+
+// DisableLink marks the link as deleted regardless of the owner.
+func (db *DB) DisableLink(ctx context.Context, shortURL string) error {
+	err := db.execOne(ctx, queries.DisableLink, shortURL)
+	if errors.Is(err, storage.ErrNotFound) {
+		// MySQL does not count rows that were already deleted
+		_, err = db.GetLink(ctx, shortURL)
+	}
+
+	return err
+}
+
+// PurgeLink removes the record and its options in one transaction.
+func (db *DB) PurgeLink(ctx context.Context, shortURL string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	options, err := db.stmts.Get(queries.PurgeLinkOptions)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	purge, err := db.stmts.Get(queries.PurgeLink)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error purging link: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.StmtContext(ctx, options).ExecContext(ctx, shortURL); err != nil {
+		return fmt.Errorf("error purging link: %w", err)
+	}
+
+	res, err := tx.StmtContext(ctx, purge).ExecContext(ctx, sql.Named("short", shortURL).Value)
+	if err != nil {
+		return fmt.Errorf("error purging link: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error purging link: %w", err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("error purging link: %w", storage.ErrNotFound)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error purging link: %w", err)
+	}
+
+	return nil
+}
+
+// Links calls fn for every record, rows are read one by one.
+// The query timeout does not apply, reading every link takes as long as it takes.
+func (db *DB) Links(ctx context.Context, fn func(storage.Link) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	stmt, err := db.stmts.Get(queries.AllLinks)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting links: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return fmt.Errorf("error getting links: %w", err)
+		}
+
+		if err = fn(link); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error getting links: %w", err)
+	}
+
+	return nil
+}
+
+// ImportLink saves the record with its options and visits left in one transaction.
+func (db *DB) ImportLink(ctx context.Context, link storage.Link) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := db.stmts.Get(queries.ImportLink)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	var left sql.NullInt64
+	if link.ClicksLeft != nil {
+		left = sql.NullInt64{Int64: int64(*link.ClicksLeft), Valid: true}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error importing link: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.StmtContext(ctx, stmt).ExecContext(ctx,
+		sql.Named("long", link.Long).Value,
+		sql.Named("short", link.Short).Value,
+		sql.Named("cookie", link.Owner).Value,
+		sql.Named("deleted", link.Deleted).Value,
+		sql.Named("created", unixTime(link.CreatedAt)).Value,
+		sql.Named("clicks", left).Value,
+	)
+	if err != nil {
+		return fmt.Errorf("error importing link: %w", err)
+	}
+
+	// the visits left are inserted with the link
+	if err = db.restrict(ctx, tx, []storage.Link{{Short: link.Short, Options: link.Options}}); err != nil {
+		return fmt.Errorf("error importing link: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error importing link: %w", err)
+	}
+
+	return nil
+}
+
+// execOne executes the query by the short URL, storage.ErrNotFound is returned if no rows were affected.
+func (db *DB) execOne(ctx context.Context, name queries.Name, shortURL string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := db.stmts.Get(name)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	res, err := stmt.ExecContext(ctx, sql.Named("short", shortURL).Value)
+	if err != nil {
+		return fmt.Errorf("error updating link: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error updating link: %w", err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("error updating link: %w", storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanLink(row scanner) (storage.Link, error) {
+	var (
+		link    storage.Link
+		owner   sql.NullString
+		deleted sql.NullBool
+		created sql.NullInt64
+		left    sql.NullInt64
+		// the options are NULL for the links without them
+		title, hash sql.NullString
+		preview     sql.NullBool
+	)
+
+	err := row.Scan(&link.Short, &link.Long, &owner, &deleted, &created, &left, &title, &preview, &hash)
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	link.Owner, link.Deleted = owner.String, deleted.Bool
+	if created.Valid {
+		link.CreatedAt = time.Unix(created.Int64, 0).UTC()
+	}
+	if left.Valid {
+		n := int(left.Int64)
+		link.ClicksLeft = &n
+	}
+	link.Options = storage.LinkOptions{Title: title.String, Preview: preview.Bool, PasswordHash: hash.String}
+
+	return link, nil
+}
+
+// unixTime stores the time with seconds precision, the zero time is stored as NULL.
+func unixTime(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
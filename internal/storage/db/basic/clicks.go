@@ -0,0 +1,97 @@
+package basic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/queries"
+)
+
+var _ storage.IClickLimit = (*DB)(nil)
+
+// SetMaxClicks limits the visits of the link to n, storage.Unlimited removes the limit.
+func (db *DB) SetMaxClicks(ctx context.Context, shortURL string, n int) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := db.stmts.Get(queries.SetMaxClicks)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	var left sql.NullInt64
+	if n >= 0 {
+		left = sql.NullInt64{Int64: int64(n), Valid: true}
+	}
+
+	if _, err = stmt.ExecContext(ctx, left, shortURL); err != nil {
+		return fmt.Errorf("error saving click limit: %w", err)
+	}
+
+	return nil
+}
+
+// UseClick takes a visit of the link, the counter is decremented by a single conditional update
+// so the concurrent visits can't take more than the limit.
+func (db *DB) UseClick(ctx context.Context, shortURL string) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	use, err := db.stmts.Get(queries.UseClick)
+	if err != nil {
+		return 0, fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	get, err := db.stmts.Get(queries.GetClicksLeft)
+	if err != nil {
+		return 0, fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error using click: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.StmtContext(ctx, use).ExecContext(ctx, shortURL)
+	if err != nil {
+		return 0, fmt.Errorf("error using click: %w", err)
+	}
+
+	used, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error using click: %w", err)
+	}
+
+	var left sql.NullInt64
+	err = tx.StmtContext(ctx, get).QueryRowContext(ctx, shortURL).Scan(&left)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("error using click: %w", storage.ErrNotFound)
+	} else if err != nil {
+		return 0, fmt.Errorf("error using click: %w", err)
+	}
+
+	switch {
+	case !left.Valid:
+		return storage.Unlimited, nil
+	case used == 0:
+		return 0, storage.ErrExhausted
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error using click: %w", err)
+	}
+
+	return int(left.Int64), nil
+}
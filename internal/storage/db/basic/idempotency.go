@@ -0,0 +1,121 @@
+package basic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/queries"
+)
+
+var _ storage.IIdempotency = (*DB)(nil)
+
+// ReserveKey saves the record unless the key is taken by a record that has not expired.
+// The expired records are removed on the way.
+func (db *DB) ReserveKey(ctx context.Context, rec storage.IdempotencyRecord) (storage.IdempotencyRecord, bool, error) {
+	if ctx.Err() != nil {
+		return storage.IdempotencyRecord{}, false, ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	expired, err := db.stmts.Get(queries.DeleteExpiredKeys)
+	if err != nil {
+		return storage.IdempotencyRecord{}, false, fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	insert, err := db.stmts.Get(queries.InsertIdempotencyKey)
+	if err != nil {
+		return storage.IdempotencyRecord{}, false, fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.IdempotencyRecord{}, false, fmt.Errorf("error reserving key: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.StmtContext(ctx, expired).ExecContext(ctx, time.Now().UnixMilli()); err != nil {
+		return storage.IdempotencyRecord{}, false, fmt.Errorf("error removing expired keys: %w", err)
+	}
+
+	found, err := db.getKey(ctx, tx, rec.Key)
+	if err == nil {
+		return found, false, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return storage.IdempotencyRecord{}, false, fmt.Errorf("error reserving key: %w", err)
+	}
+
+	_, err = tx.StmtContext(ctx, insert).ExecContext(ctx, rec.Key, rec.Fingerprint, rec.ExpiresAt.UnixMilli())
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	if err != nil {
+		// a concurrent request may have taken the key after the check
+		tx.Rollback()
+		if found, getErr := db.getKey(ctx, nil, rec.Key); getErr == nil {
+			return found, false, nil
+		}
+
+		return storage.IdempotencyRecord{}, false, fmt.Errorf("error reserving key: %w", err)
+	}
+
+	return rec, true, nil
+}
+
+// CompleteKey saves the response of the request with the key.
+func (db *DB) CompleteKey(ctx context.Context, key string, response []byte) error {
+	return db.execKey(ctx, queries.CompleteIdempotencyKey, response, key)
+}
+
+// ReleaseKey removes the key.
+func (db *DB) ReleaseKey(ctx context.Context, key string) error {
+	return db.execKey(ctx, queries.DeleteIdempotencyKey, key)
+}
+
+// getKey reads the record of the key within tx if it is not nil.
+func (db *DB) getKey(ctx context.Context, tx *sql.Tx, key string) (storage.IdempotencyRecord, error) {
+	stmt, err := db.stmts.Get(queries.GetIdempotencyKey)
+	if err != nil {
+		return storage.IdempotencyRecord{}, fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	if tx != nil {
+		stmt = tx.StmtContext(ctx, stmt)
+	}
+
+	rec := storage.IdempotencyRecord{Key: key}
+
+	var expiresAt int64
+	if err = stmt.QueryRowContext(ctx, key).Scan(&rec.Fingerprint, &rec.Response, &expiresAt); err != nil {
+		return storage.IdempotencyRecord{}, err
+	}
+	rec.ExpiresAt = time.UnixMilli(expiresAt)
+
+	return rec, nil
+}
+
+func (db *DB) execKey(ctx context.Context, name queries.Name, args ...any) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := db.stmts.Get(name)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	if _, err = stmt.ExecContext(ctx, args...); err != nil {
+		return fmt.Errorf("error saving key: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package basic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/queries"
+)
+
+var _ storage.ILinkOptions = (*DB)(nil)
+
+// GetLinkOptions returns the options of the link, the zero ones if they were not set.
+func (db *DB) GetLinkOptions(ctx context.Context, shortURL string) (storage.LinkOptions, error) {
+	if ctx.Err() != nil {
+		return storage.LinkOptions{}, ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := db.stmts.Get(queries.GetLinkOptions)
+	if err != nil {
+		return storage.LinkOptions{}, fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	var opts storage.LinkOptions
+	err = stmt.QueryRowContext(ctx, shortURL).Scan(&opts.Title, &opts.Preview, &opts.PasswordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.LinkOptions{}, nil
+	} else if err != nil {
+		return storage.LinkOptions{}, fmt.Errorf("error getting link options: %w", err)
+	}
+
+	return opts, nil
+}
+
+// SetLinkOptions replaces the options of the link.
+func (db *DB) SetLinkOptions(ctx context.Context, shortURL string, opts storage.LinkOptions) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := db.stmts.Get(queries.SetLinkOptions)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+
+	if _, err = stmt.ExecContext(ctx, shortURL, opts.Title, opts.Preview, opts.PasswordHash); err != nil {
+		return fmt.Errorf("error saving link options: %w", err)
+	}
+
+	return nil
+}
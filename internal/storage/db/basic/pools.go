@@ -0,0 +1,76 @@
+package basic
+
+import (
+	"database/sql"
+	"expvar"
+	"sync"
+)
+
+// PoolStat the state of a connection pool.
+type PoolStat struct {
+	MaxOpen int `json:"max_open"`
+	Open    int `json:"open"`
+	InUse   int `json:"in_use"`
+	Idle    int `json:"idle"`
+	// WaitCount the number of connections waited for.
+	WaitCount int64 `json:"wait_count"`
+	// WaitMillis the total time spent waiting for connections.
+	WaitMillis        int64 `json:"wait_ms"`
+	MaxIdleClosed     int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64 `json:"max_lifetime_closed"`
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = make(map[string]*sql.DB)
+)
+
+func init() {
+	expvar.Publish("db_pools", expvar.Func(func() any { return PoolStats() }))
+}
+
+// PoolStats returns the state of the connection pools of the open storages by name.
+func PoolStats() map[string]PoolStat {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	stats := make(map[string]PoolStat, len(pools))
+	for name, db := range pools {
+		s := db.Stats()
+		stats[name] = PoolStat{
+			MaxOpen:           s.MaxOpenConnections,
+			Open:              s.OpenConnections,
+			InUse:             s.InUse,
+			Idle:              s.Idle,
+			WaitCount:         s.WaitCount,
+			WaitMillis:        s.WaitDuration.Milliseconds(),
+			MaxIdleClosed:     s.MaxIdleClosed,
+			MaxIdleTimeClosed: s.MaxIdleTimeClosed,
+			MaxLifetimeClosed: s.MaxLifetimeClosed,
+		}
+	}
+
+	return stats
+}
+
+func publish(name string, db *sql.DB) {
+	if name == "" {
+		return
+	}
+
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	pools[name] = db
+}
+
+// unpublish removes the pool unless the name was taken by another one since.
+func unpublish(name string, db *sql.DB) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	if pools[name] == db {
+		delete(pools, name)
+	}
+}
@@ -2,11 +2,13 @@ package dbstorage
 
 import (
 	"database/sql"
-	"log"
+	"fmt"
+	"time"
 	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/basic"
+	"url-shortener/internal/storage/db/migration"
 	"url-shortener/internal/storage/db/mysql"
 	"url-shortener/internal/storage/db/postgres"
-	"url-shortener/internal/storage/db/queries"
 	"url-shortener/internal/storage/db/service"
 	"url-shortener/internal/storage/db/sqlite3"
 )
@@ -14,31 +16,86 @@ import (
 // DBStorageType postgres type.
 const DBStorageType storage.Type = "postgres"
 
+// DefaultQueryTimeout the default limit of a query.
+const DefaultQueryTimeout = 5 * time.Second
+
+// Pool settings of the connection pool, zero values keep the database/sql defaults.
+type Pool struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// Apply sets the non-zero settings on db.
+func (p Pool) Apply(db *sql.DB) {
+	if p.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(p.MaxOpenConns)
+	}
+
+	if p.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+
+	if p.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	}
+
+	if p.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+	}
+}
+
+// Options of the database storage.
+type Options struct {
+	// Name of the connection pool in the pool stats.
+	Name string
+	// Migrate applies pending migrations.
+	Migrate bool
+	Pool    Pool
+	// QueryTimeout limits every query, zero leaves it to the context of the caller.
+	QueryTimeout time.Duration
+}
+
 // NewRealStorage constructor for storage.IStorage with db implementation.
-func NewRealStorage(db *sql.DB, vendor storage.Type) service.IRealStorage {
-	var irs service.IRealStorage
+// The storage owns db, it is closed if the storage can't be made.
+func NewRealStorage(db *sql.DB, vendor storage.Type, opts Options) (st service.IRealStorage, err error) {
+	defer func() {
+		if err != nil {
+			db.Close()
+		}
+	}()
+
+	var newStorage func(db *sql.DB, opts basic.Options) (service.IRealStorage, error)
 
 	switch vendor {
 	case "postgres":
-		irs = postgres.New(db, "file://migrations/postgres")
+		newStorage = postgres.New
 	case "mysql":
-		irs = mysql.New(db, "file://migrations/mysql")
+		newStorage = mysql.New
 	case "sqlite3":
-		irs = sqlite3.New(db, "file://migrations/sqlite3")
-	case "test":
-		irs = sqlite3.New(db, "file://../../migrations/sqlite3")
+		newStorage = sqlite3.New
+	default:
+		return nil, fmt.Errorf("unknown database vendor %q", vendor)
 	}
 
-	var err error
-	if vendor != "test" {
-		err = queries.Prepare(db, string(vendor))
-	} else {
-		err = queries.Prepare(db, "sqlite3")
-	}
+	opts.Pool.Apply(db)
+
+	if opts.Migrate {
+		m, err := migration.New(db, vendor)
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.Up()
+		if closeErr := m.Close(); err == nil {
+			err = closeErr
+		}
 
-	if err != nil {
-		log.Fatal("failed to prepare queries: ", err)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return irs
+	return newStorage(db, basic.Options{Name: opts.Name, QueryTimeout: opts.QueryTimeout})
 }
@@ -0,0 +1,63 @@
+package dbstorage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+	"url-shortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool_Apply(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "pool.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	Pool{}.Apply(db)
+	assert.Zero(t, db.Stats().MaxOpenConnections, "zero values keep the defaults")
+
+	Pool{MaxOpenConns: 4, MaxIdleConns: 2, ConnMaxLifetime: time.Minute}.Apply(db)
+	assert.Equal(t, 4, db.Stats().MaxOpenConnections)
+}
+
+func TestNewRealStorage(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := NewRealStorage(db, "sqlite3", Options{Migrate: true, Pool: Pool{MaxOpenConns: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Shutdown()
+
+	assert.Equal(t, 1, db.Stats().MaxOpenConnections)
+}
+
+func TestNewRealStorage_Closed(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		vendor storage.Type
+	}{
+		{name: "unknown vendor", path: filepath.Join(t.TempDir(), "storage.db"), vendor: "oracle"},
+		{name: "migration", path: filepath.Join(t.TempDir(), "missing", "storage.db"), vendor: "sqlite3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sql.Open("sqlite3", tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = NewRealStorage(db, tt.vendor, Options{Migrate: true})
+			assert.Error(t, err)
+			assert.EqualError(t, db.Ping(), "sql: database is closed")
+		})
+	}
+}
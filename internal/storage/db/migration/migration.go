@@ -0,0 +1,279 @@
+// Package migration applies the embedded migrations of the migrations package.
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"io"
+	"io/fs"
+	"url-shortener/internal/storage"
+	"url-shortener/migrations"
+)
+
+// Migrator applies the migrations of a vendor to a database.
+type Migrator struct {
+	vendor storage.Type
+	m      *migrate.Migrate
+	// conn the connection of db the migrations run on, nil for sqlite3 using db itself.
+	conn *sql.Conn
+	// src reads the migrations for Status and the dry-run plans.
+	src source.Driver
+}
+
+// Step a migration to apply.
+type Step struct {
+	Version uint   `json:"version"`
+	Name    string `json:"name"`
+	Up      bool   `json:"up"`
+	SQL     string `json:"sql"`
+}
+
+// Status of the database migrations.
+type Status struct {
+	Vendor string `json:"vendor"`
+	// Version is 0 if no migrations were applied.
+	Version uint   `json:"version"`
+	Dirty   bool   `json:"dirty"`
+	Latest  uint   `json:"latest"`
+	Pending []uint `json:"pending"`
+}
+
+// ErrUnknownVersion occurs when there is no migration with the version.
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// New returns the Migrator of the vendor for db. The Migrator takes a connection of db,
+// Close releases it. db stays owned by the caller.
+func New(db *sql.DB, vendor storage.Type) (mg *Migrator, err error) {
+	var (
+		driver database.Driver
+		conn   *sql.Conn
+	)
+
+	// The drivers made by WithInstance close db with them, so they get a connection of it.
+	switch vendor {
+	case "postgres", "mysql":
+		if conn, err = db.Conn(context.Background()); err != nil {
+			return nil, fmt.Errorf("can't connect to the database: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				conn.Close()
+			}
+		}()
+	}
+
+	switch vendor {
+	case "postgres":
+		driver, err = postgres.WithConnection(context.Background(), conn, &postgres.Config{})
+	case "mysql":
+		driver, err = mysql.WithConnection(context.Background(), conn, &mysql.Config{})
+	case "sqlite3":
+		driver, err = sqlite3.WithInstance(db, &sqlite3.Config{})
+	default:
+		return nil, fmt.Errorf("%s has no migrations", vendor)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("can't create migration driver: %w", err)
+	}
+
+	src, err := iofs.New(migrations.FS, string(vendor))
+	if err != nil {
+		return nil, fmt.Errorf("can't read migrations: %w", err)
+	}
+
+	// migrate.Migrate owns its source, so it gets its own copy
+	own, err := iofs.New(migrations.FS, string(vendor))
+	if err != nil {
+		return nil, fmt.Errorf("can't read migrations: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", own, string(vendor), driver)
+	if err != nil {
+		return nil, fmt.Errorf("can't create migrations: %w", err)
+	}
+
+	return &Migrator{vendor: vendor, m: m, src: src, conn: conn}, nil
+}
+
+// Close releases the connection of the Migrator, db is left open.
+func (mg *Migrator) Close() error {
+	if mg.conn == nil {
+		return nil
+	}
+
+	if err := mg.conn.Close(); err != nil {
+		return fmt.Errorf("can't close migration connection: %w", err)
+	}
+
+	return nil
+}
+
+// Status returns the current version and the versions that are not applied yet.
+func (mg *Migrator) Status() (Status, error) {
+	st := Status{Vendor: string(mg.vendor), Pending: make([]uint, 0)}
+
+	var err error
+	st.Version, st.Dirty, err = mg.version()
+	if err != nil {
+		return st, err
+	}
+
+	steps, err := mg.PlanUp()
+	if err != nil {
+		return st, err
+	}
+
+	st.Latest = st.Version
+	for _, step := range steps {
+		st.Pending = append(st.Pending, step.Version)
+		st.Latest = step.Version
+	}
+
+	return st, nil
+}
+
+// Up applies all pending migrations.
+func (mg *Migrator) Up() error {
+	err := mg.m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("can't migrate up: %w", err)
+	}
+
+	return nil
+}
+
+// DownTo rolls back the migrations applied after version, 0 rolls back all of them.
+func (mg *Migrator) DownTo(version uint) error {
+	if _, err := mg.PlanDownTo(version); err != nil {
+		return err
+	}
+
+	var err error
+	if version == 0 {
+		err = mg.m.Down()
+	} else {
+		err = mg.m.Migrate(version)
+	}
+
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("can't migrate down to %d: %w", version, err)
+	}
+
+	return nil
+}
+
+// PlanUp returns the migrations Up would apply, nothing is changed.
+func (mg *Migrator) PlanUp() ([]Step, error) {
+	current, _, err := mg.version()
+	if err != nil {
+		return nil, err
+	}
+
+	var next uint
+	if current == 0 {
+		next, err = mg.src.First()
+	} else {
+		next, err = mg.src.Next(current)
+	}
+
+	var steps []Step
+	for ; err == nil; next, err = mg.src.Next(next) {
+		step, readErr := mg.read(next, true)
+		if readErr != nil {
+			return nil, readErr
+		}
+		steps = append(steps, step)
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("can't read migrations: %w", err)
+	}
+
+	return steps, nil
+}
+
+// PlanDownTo returns the migrations DownTo would roll back, nothing is changed.
+func (mg *Migrator) PlanDownTo(version uint) ([]Step, error) {
+	current, _, err := mg.version()
+	if err != nil {
+		return nil, err
+	}
+
+	if version > current {
+		return nil, fmt.Errorf("version %d is not applied, the current one is %d", version, current)
+	}
+
+	if version != 0 {
+		r, _, err := mg.src.ReadUp(version)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+		r.Close()
+	}
+
+	var steps []Step
+	for current > version {
+		step, err := mg.read(current, false)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+
+		current, err = mg.src.Prev(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("can't read migrations: %w", err)
+		}
+	}
+
+	return steps, nil
+}
+
+// version returns 0 if no migrations were applied.
+func (mg *Migrator) version() (uint, bool, error) {
+	version, dirty, err := mg.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, fmt.Errorf("can't get version: %w", err)
+	}
+
+	return version, dirty, nil
+}
+
+func (mg *Migrator) read(version uint, up bool) (Step, error) {
+	var (
+		r    io.ReadCloser
+		name string
+		err  error
+	)
+
+	if up {
+		r, name, err = mg.src.ReadUp(version)
+	} else {
+		r, name, err = mg.src.ReadDown(version)
+	}
+
+	if err != nil {
+		return Step{}, fmt.Errorf("can't read migration %d: %w", version, err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Step{}, fmt.Errorf("can't read migration %d: %w", version, err)
+	}
+
+	return Step{Version: version, Name: name, Up: up, SQL: string(data)}, nil
+}
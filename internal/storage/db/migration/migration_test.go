@@ -0,0 +1,98 @@
+package migration
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func versions(steps []Step) []uint {
+	v := make([]uint, len(steps))
+	for i, step := range steps {
+		v[i] = step.Version
+	}
+
+	return v
+}
+
+func TestMigrator(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m, err := New(db, "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := m.Status()
+	if assert.NoError(t, err) {
+		assert.Equal(t, Status{Vendor: "sqlite3", Latest: 8, Pending: []uint{1, 2, 3, 4, 5, 6, 7, 8}}, st)
+	}
+
+	steps, err := m.PlanUp()
+	if assert.NoError(t, err) && assert.Len(t, steps, 8) {
+		assert.Equal(t, "init_db", steps[0].Name)
+		assert.True(t, steps[0].Up)
+		assert.Contains(t, steps[0].SQL, "CREATE TABLE links")
+	}
+
+	// the dry-run changes nothing
+	st, err = m.Status()
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(0), st.Version)
+	}
+
+	assert.NoError(t, m.Up())
+	assert.NoError(t, m.Up(), "Up without pending migrations")
+
+	st, err = m.Status()
+	if assert.NoError(t, err) {
+		assert.Equal(t, Status{Vendor: "sqlite3", Version: 8, Latest: 8, Pending: []uint{}}, st)
+	}
+
+	steps, err = m.PlanDownTo(1)
+	if assert.NoError(t, err) && assert.Equal(t, []uint{8, 7, 6, 5, 4, 3, 2}, versions(steps)) {
+		assert.False(t, steps[0].Up)
+		assert.Contains(t, steps[0].SQL, "DROP COLUMN clicks_left")
+		assert.Contains(t, steps[1].SQL, "DROP COLUMN password_hash")
+		assert.Contains(t, steps[2].SQL, "DROP TABLE link_options")
+		assert.Contains(t, steps[3].SQL, "DROP TABLE audit_log")
+		assert.Contains(t, steps[4].SQL, "DROP TABLE idempotency_keys")
+		assert.Contains(t, steps[5].SQL, "DROP COLUMN created_at")
+	}
+
+	_, err = m.PlanDownTo(9)
+	assert.Error(t, err, "version is not applied")
+
+	if assert.NoError(t, m.DownTo(1)) {
+		st, err = m.Status()
+		if assert.NoError(t, err) {
+			assert.Equal(t, Status{Vendor: "sqlite3", Version: 1, Latest: 8, Pending: []uint{2, 3, 4, 5, 6, 7, 8}}, st)
+		}
+	}
+
+	if assert.NoError(t, m.DownTo(0)) {
+		_, err = db.Exec("SELECT 1 FROM links")
+		assert.Error(t, err, "links must be dropped")
+	}
+
+	assert.NoError(t, m.Close())
+	assert.NoError(t, db.Ping(), "db stays open")
+}
+
+func TestNew_UnknownVendor(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = New(db, "oracle")
+	assert.Error(t, err)
+}
@@ -3,10 +3,6 @@ package mysql
 import (
 	"context"
 	"database/sql"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/mysql"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"log"
 	"url-shortener/internal/storage"
 	"url-shortener/internal/storage/db/basic"
 	"url-shortener/internal/storage/db/queries"
@@ -17,6 +13,7 @@ import (
 
 var (
 	_ storage.IStorage = (*MySQL)(nil)
+	_ storage.IAdmin   = (*MySQL)(nil)
 )
 
 // MySQL struct with *sql.DB instance.
@@ -26,36 +23,24 @@ type MySQL struct {
 }
 
 // New MySQL struct constructor.
-func New(db *sql.DB, path string) service.IRealStorage {
-	driver, err := mysql.WithInstance(db, &mysql.Config{})
+// Migrations are applied by the caller, see the migration package.
+func New(db *sql.DB, opts basic.Options) (service.IRealStorage, error) {
+	b, err := basic.New(db, "mysql", opts)
 	if err != nil {
-		log.Fatal(err)
-		return nil
+		return nil, err
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		path,
-		"mysql", driver)
-	if err != nil {
-		log.Fatal(err)
-		return nil
-	}
-
-	err = m.Up()
-	if err != nil {
-		if err.Error() != "no change" {
-			log.Fatal(err)
-		}
-	}
-
-	return &MySQL{DB: basic.DB{DB: db}}
+	return &MySQL{DB: b}, nil
 }
 
 // FindMaxID gets len of the repository.
 func (m *MySQL) FindMaxID(ctx context.Context) (int, error) {
+	ctx, cancel := m.WithTimeout(ctx)
+	defer cancel()
+
 	var id sql.NullInt32
 
-	stmt, err := queries.GetPreparedStatement(queries.FindMaxURL)
+	stmt, err := m.Stmt(queries.FindMaxURL)
 	if err != nil {
 		return 0, nil
 	}
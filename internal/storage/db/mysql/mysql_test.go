@@ -7,14 +7,13 @@ import (
 	"os"
 	"reflect"
 	"testing"
-	prep "url-shortener/internal/storage/db/queries"
+	"url-shortener/internal/storage/db/basic"
+	"url-shortener/internal/storage/db/migration"
 	shortener "url-shortener/pkg/api"
 )
 
 var TestDB *MySQL
 
-const pathToMigrations = "file://../../../../migrations/mysql"
-
 func TestMain(m *testing.M) {
 	// Write code here to run before tests
 	ctx := context.TODO()
@@ -43,15 +42,13 @@ func TestMain(m *testing.M) {
 		os.Exit(0)
 	}
 
-	TestDB = New(vdb.DB, pathToMigrations).(*MySQL)
-
 	queries := []string{
 		"SET foreign_key_checks = 0;",
 		"TRUNCATE links;",
 		"SET foreign_key_checks = 1;",
 	}
 
-	tx, err := TestDB.DB.Begin()
+	tx, err := vdb.DB.Begin()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -68,14 +65,30 @@ func TestMain(m *testing.M) {
 		log.Fatal(err)
 	}
 
-	TestDB = New(vdb.DB, pathToMigrations).(*MySQL)
-	// Run tests
+	migrator, err := migration.New(vdb.DB, "mysql")
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	err = prep.Prepare(TestDB.DB.DB, "mysql")
+	if err = migrator.Up(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = migrator.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if inUse := vdb.DB.Stats().InUse; inUse != 0 {
+		log.Fatalf("%d connections are kept after the migrations", inUse)
+	}
+
+	irs, err := New(vdb.DB, basic.Options{})
 	if err != nil {
 		log.Fatal(err)
 	}
+	TestDB = irs.(*MySQL)
 
+	// Run tests
 	os.Exit(m.Run())
 }
 
@@ -242,7 +255,7 @@ func Test_MarkAsDeleted(t *testing.T) {
 	ctx := context.Background()
 	ShortURL := "qwe"
 	cookie := "qwsa"
-	TestDB.MarkAsDeleted(ShortURL, cookie)
+	TestDB.MarkAsDeleted(ctx, ShortURL, cookie)
 
 	_, err := TestDB.GetLongLink(ctx, ShortURL)
 	if err == nil {
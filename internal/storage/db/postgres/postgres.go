@@ -4,9 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jackc/pgerrcode"
 	"github.com/lib/pq"
 	"log"
@@ -19,6 +16,7 @@ import (
 
 var (
 	_ storage.IStorage = (*Postgres)(nil)
+	_ storage.IAdmin   = (*Postgres)(nil)
 )
 
 // Postgres struct with *sql.DB instance.
@@ -28,44 +26,36 @@ type Postgres struct {
 }
 
 // New Postgres struct constructor.
-func New(db *sql.DB, path string) service.IRealStorage {
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
+// Migrations are applied by the caller, see the migration package.
+func New(db *sql.DB, opts basic.Options) (service.IRealStorage, error) {
+	b, err := basic.New(db, "postgres", opts)
 	if err != nil {
-		log.Fatal(err)
-		return nil
+		return nil, err
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		path,
-		"postgres", driver)
-	if err != nil {
-		log.Fatal(err)
-		return nil
-	}
-
-	err = m.Up()
-	if err != nil {
-		if err.Error() != "no change" {
-			log.Fatal(err)
-		}
-	}
-
-	return &Postgres{DB: basic.DB{DB: db}}
+	return &Postgres{DB: b}, nil
 }
 
 // AddLink adds a link to the repository.
 func (p *Postgres) AddLink(ctx context.Context, longURL, shortURL, cookie string) (string, error) {
-	stmt, err := queries.GetPreparedStatement(queries.InsertURL)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	queryCtx, cancel := p.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := p.Stmt(queries.InsertURL)
 	if err != nil {
 		return "", err
 	}
 
-	GetShortLinkSTMT, err := queries.GetPreparedStatement(queries.GetShortLink)
+	GetShortLinkSTMT, err := p.Stmt(queries.GetShortLink)
 	if err != nil {
 		return "", err
 	}
 
-	_, err = stmt.Exec(
+	_, err = stmt.ExecContext(queryCtx,
 		sql.Named("long", longURL).Value,
 		sql.Named("short", shortURL).Value,
 		sql.Named("cookie", cookie).Value,
@@ -85,7 +75,7 @@ func (p *Postgres) AddLink(ctx context.Context, longURL, shortURL, cookie string
 		return "", fmt.Errorf("UniqueViolation error: %s", err)
 	}
 
-	row := GetShortLinkSTMT.QueryRow(sql.Named("long", longURL).Value)
+	row := GetShortLinkSTMT.QueryRowContext(queryCtx, sql.Named("long", longURL).Value)
 	if row.Err() != nil {
 		return "", err
 	}
@@ -7,14 +7,13 @@ import (
 	"os"
 	"reflect"
 	"testing"
-	prep "url-shortener/internal/storage/db/queries"
+	"url-shortener/internal/storage/db/basic"
+	"url-shortener/internal/storage/db/migration"
 	shortener "url-shortener/pkg/api"
 )
 
 var TestDB *Postgres
 
-const pathToMigrations = "file://../../../../migrations/postgres"
-
 func TestMain(m *testing.M) {
 	// Write code here to run before tests
 	ctx := context.TODO()
@@ -43,8 +42,6 @@ func TestMain(m *testing.M) {
 		os.Exit(0)
 	}
 
-	TestDB = New(vdb.DB, pathToMigrations).(*Postgres)
-
 	queries := []string{
 		"DROP SCHEMA public CASCADE;",
 		"CREATE SCHEMA public;",
@@ -52,7 +49,7 @@ func TestMain(m *testing.M) {
 		"COMMENT ON SCHEMA public IS 'standard public schema';",
 	}
 
-	tx, err := TestDB.DB.Begin()
+	tx, err := vdb.DB.Begin()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -69,14 +66,30 @@ func TestMain(m *testing.M) {
 		log.Fatal(err)
 	}
 
-	TestDB = New(vdb.DB, pathToMigrations).(*Postgres)
-	// Run tests
+	migrator, err := migration.New(vdb.DB, "postgres")
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	err = prep.Prepare(TestDB.DB.DB, "postgres")
+	if err = migrator.Up(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = migrator.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if inUse := vdb.DB.Stats().InUse; inUse != 0 {
+		log.Fatalf("%d connections are kept after the migrations", inUse)
+	}
+
+	irs, err := New(vdb.DB, basic.Options{})
 	if err != nil {
 		log.Fatal(err)
 	}
+	TestDB = irs.(*Postgres)
 
+	// Run tests
 	os.Exit(m.Run())
 }
 
@@ -254,7 +267,7 @@ func TestPostgres_MarkAsDeleted(t *testing.T) {
 	ctx := context.Background()
 	ShortURL := "qwe"
 	cookie := "qwsa"
-	TestDB.MarkAsDeleted(ShortURL, cookie)
+	TestDB.MarkAsDeleted(ctx, ShortURL, cookie)
 
 	_, err := TestDB.GetLongLink(ctx, ShortURL)
 	if err == nil {
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // Query text of query.
@@ -14,7 +15,7 @@ type Name int
 
 // Query names.
 const (
-	InsertURL = iota
+	InsertURL Name = iota
 	GetLongLink
 	FindMaxURL
 	GetAllLinksByCookie
@@ -22,49 +23,204 @@ const (
 	GetShortLink
 	CountURLs
 	CountUsers
+	GetLink
+	DisableLink
+	PurgeLink
+	AllLinks
+	ImportLink
+	GetIdempotencyKey
+	InsertIdempotencyKey
+	CompleteIdempotencyKey
+	DeleteIdempotencyKey
+	DeleteExpiredKeys
+	InsertAuditEntry
+	AuditEntries
+	GetLinkOptions
+	SetLinkOptions
+	SetMaxClicks
+	UseClick
+	GetClicksLeft
+	PurgeLinkOptions
+
+	// count of the query names, every vendor defines all of them.
+	count
 )
 
 var queriesSqlite3 = map[Name]Query{
-	InsertURL:           "INSERT INTO links (long, short, cookie) VALUES (?, ?, ?)",
-	GetLongLink:         "SELECT long, deleted FROM links WHERE short = ?",
+	InsertURL:           "INSERT INTO links (long, short, cookie, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
+	GetLongLink:         "SELECT long, deleted, clicks_left FROM links WHERE short = ?",
 	FindMaxURL:          "SELECT MAX(id) FROM links",
-	GetAllLinksByCookie: "SELECT short, long FROM links WHERE cookie = ?",
+	GetAllLinksByCookie: "SELECT short, long, deleted, clicks_left FROM links WHERE cookie = ?",
 	MarkAsDeleted:       "UPDATE links SET deleted = 1 WHERE short = ? AND cookie = ?",
+	GetShortLink:        "SELECT short FROM links WHERE long = ?",
 	CountURLs:           "SELECT COUNT(*) FROM links",
 	CountUsers:          "SELECT COUNT(DISTINCT cookie) FROM links",
+	DisableLink:         "UPDATE links SET deleted = 1 WHERE short = ?",
+	PurgeLink:           "DELETE FROM links WHERE short = ?",
+
+	GetLink: "SELECT l.short, l.long, l.cookie, l.deleted, CAST(strftime('%s', l.created_at) AS INTEGER), l.clicks_left, " +
+		"o.title, o.preview, o.password_hash FROM links l LEFT JOIN link_options o ON o.short_url = l.short WHERE l.short = ?",
+	AllLinks: "SELECT l.short, l.long, l.cookie, l.deleted, CAST(strftime('%s', l.created_at) AS INTEGER), l.clicks_left, " +
+		"o.title, o.preview, o.password_hash FROM links l LEFT JOIN link_options o ON o.short_url = l.short " +
+		"WHERE l.id > 0 ORDER BY l.id",
+	ImportLink: "INSERT INTO links (long, short, cookie, deleted, created_at, clicks_left) " +
+		"VALUES (?, ?, ?, ?, datetime(?, 'unixepoch'), ?)",
+
+	GetIdempotencyKey:      "SELECT fingerprint, response, expires_at FROM idempotency_keys WHERE key_hash = ?",
+	InsertIdempotencyKey:   "INSERT INTO idempotency_keys (key_hash, fingerprint, expires_at) VALUES (?, ?, ?)",
+	CompleteIdempotencyKey: "UPDATE idempotency_keys SET response = ? WHERE key_hash = ?",
+	DeleteIdempotencyKey:   "DELETE FROM idempotency_keys WHERE key_hash = ?",
+	DeleteExpiredKeys:      "DELETE FROM idempotency_keys WHERE expires_at <= ?",
+
+	InsertAuditEntry: "INSERT INTO audit_log (occurred_at, actor, ip, operation, target, old_value, new_value, outcome, error_message) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	AuditEntries: "SELECT id, occurred_at, actor, ip, operation, target, old_value, new_value, outcome, error_message " +
+		"FROM audit_log WHERE id > ? AND occurred_at >= ? AND occurred_at < ? AND (? = '' OR actor = ?) ORDER BY id LIMIT ?",
+
+	GetLinkOptions: "SELECT title, preview, password_hash FROM link_options WHERE short_url = ?",
+	SetLinkOptions: "INSERT INTO link_options (short_url, title, preview, password_hash) VALUES (?, ?, ?, ?) " +
+		"ON CONFLICT (short_url) DO UPDATE SET title = excluded.title, preview = excluded.preview, " +
+		"password_hash = excluded.password_hash",
+	PurgeLinkOptions: "DELETE FROM link_options WHERE short_url = ?",
+
+	SetMaxClicks:  "UPDATE links SET clicks_left = ? WHERE short = ?",
+	UseClick:      "UPDATE links SET clicks_left = clicks_left - 1 WHERE short = ? AND clicks_left > 0",
+	GetClicksLeft: "SELECT clicks_left FROM links WHERE short = ?",
 }
 
 var queriesPostgres = map[Name]Query{
 	InsertURL:           "INSERT INTO links (long, short, cookie, deleted) VALUES ($1, $2, $3, false)",
-	GetLongLink:         `SELECT long, deleted FROM links WHERE short = $1`,
+	GetLongLink:         `SELECT long, deleted, clicks_left FROM links WHERE short = $1`,
 	FindMaxURL:          `SELECT MAX(id) FROM links`,
-	GetAllLinksByCookie: `SELECT short, long FROM links WHERE cookie = $1`,
+	GetAllLinksByCookie: `SELECT short, long, deleted, clicks_left FROM links WHERE cookie = $1`,
 	MarkAsDeleted:       `UPDATE links SET deleted = true WHERE short = $1 and cookie = $2`,
 	GetShortLink:        "SELECT short FROM links WHERE long = $1",
 	CountURLs:           "SELECT COUNT(*) FROM links",
 	CountUsers:          "SELECT COUNT(DISTINCT cookie) FROM links",
+	DisableLink:         "UPDATE links SET deleted = true WHERE short = $1",
+	PurgeLink:           "DELETE FROM links WHERE short = $1",
+
+	GetLink: "SELECT l.short, l.long, l.cookie, l.deleted, CAST(EXTRACT(EPOCH FROM l.created_at) AS BIGINT), l.clicks_left, " +
+		"o.title, o.preview, o.password_hash FROM links l LEFT JOIN link_options o ON o.short_url = l.short WHERE l.short = $1",
+	AllLinks: "SELECT l.short, l.long, l.cookie, l.deleted, CAST(EXTRACT(EPOCH FROM l.created_at) AS BIGINT), l.clicks_left, " +
+		"o.title, o.preview, o.password_hash FROM links l LEFT JOIN link_options o ON o.short_url = l.short " +
+		"WHERE l.id > 0 ORDER BY l.id",
+	ImportLink: "INSERT INTO links (long, short, cookie, deleted, created_at, clicks_left) " +
+		"VALUES ($1, $2, $3, $4, to_timestamp($5), $6)",
+
+	GetIdempotencyKey:      "SELECT fingerprint, response, expires_at FROM idempotency_keys WHERE key_hash = $1",
+	InsertIdempotencyKey:   "INSERT INTO idempotency_keys (key_hash, fingerprint, expires_at) VALUES ($1, $2, $3)",
+	CompleteIdempotencyKey: "UPDATE idempotency_keys SET response = $1 WHERE key_hash = $2",
+	DeleteIdempotencyKey:   "DELETE FROM idempotency_keys WHERE key_hash = $1",
+	DeleteExpiredKeys:      "DELETE FROM idempotency_keys WHERE expires_at <= $1",
+
+	InsertAuditEntry: "INSERT INTO audit_log (occurred_at, actor, ip, operation, target, old_value, new_value, outcome, error_message) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+	AuditEntries: "SELECT id, occurred_at, actor, ip, operation, target, old_value, new_value, outcome, error_message " +
+		"FROM audit_log WHERE id > $1 AND occurred_at >= $2 AND occurred_at < $3 AND ($4::text = '' OR actor = $5) ORDER BY id LIMIT $6",
+
+	GetLinkOptions: "SELECT title, preview, password_hash FROM link_options WHERE short_url = $1",
+	SetLinkOptions: "INSERT INTO link_options (short_url, title, preview, password_hash) VALUES ($1, $2, $3, $4) " +
+		"ON CONFLICT (short_url) DO UPDATE SET title = EXCLUDED.title, preview = EXCLUDED.preview, " +
+		"password_hash = EXCLUDED.password_hash",
+	PurgeLinkOptions: "DELETE FROM link_options WHERE short_url = $1",
+
+	SetMaxClicks:  "UPDATE links SET clicks_left = $1 WHERE short = $2",
+	UseClick:      "UPDATE links SET clicks_left = clicks_left - 1 WHERE short = $1 AND clicks_left > 0",
+	GetClicksLeft: "SELECT clicks_left FROM links WHERE short = $1",
 }
 
 var queriesMySQL = map[Name]Query{
 	InsertURL:           "INSERT INTO links (`longURL`, `shortURL`, `cookie`) VALUES (?, ?, ?)",
-	GetLongLink:         "SELECT `longURL`, `deleted` FROM links WHERE `shortURL` = ?",
+	GetLongLink:         "SELECT `longURL`, `deleted`, `clicks_left` FROM links WHERE `shortURL` = ?",
 	FindMaxURL:          "SELECT MAX(`id`) FROM links",
-	GetAllLinksByCookie: "SELECT `shortURL`, `longURL` FROM links WHERE `cookie` = ?",
+	GetAllLinksByCookie: "SELECT `shortURL`, `longURL`, `deleted`, `clicks_left` FROM links WHERE `cookie` = ?",
 	MarkAsDeleted:       "UPDATE links SET `deleted` = 1 WHERE `shortURL` = ? AND `cookie` = ?",
+	GetShortLink:        "SELECT `shortURL` FROM links WHERE `longURL` = ?",
 	CountURLs:           "SELECT COUNT(*) FROM links",
 	CountUsers:          "SELECT COUNT(DISTINCT cookie) FROM links",
+	DisableLink:         "UPDATE links SET `deleted` = 1 WHERE `shortURL` = ?",
+	PurgeLink:           "DELETE FROM links WHERE `shortURL` = ?",
+
+	GetLink: "SELECT l.`shortURL`, l.`longURL`, l.`cookie`, l.`deleted`, UNIX_TIMESTAMP(l.`created_at`), l.`clicks_left`, " +
+		"o.`title`, o.`preview`, o.`password_hash` FROM links l LEFT JOIN link_options o ON o.`short_url` = l.`shortURL` " +
+		"WHERE l.`shortURL` = ?",
+	AllLinks: "SELECT l.`shortURL`, l.`longURL`, l.`cookie`, l.`deleted`, UNIX_TIMESTAMP(l.`created_at`), l.`clicks_left`, " +
+		"o.`title`, o.`preview`, o.`password_hash` FROM links l LEFT JOIN link_options o ON o.`short_url` = l.`shortURL` " +
+		"WHERE l.`id` > 0 ORDER BY l.`id`",
+	ImportLink: "INSERT INTO links (`longURL`, `shortURL`, `cookie`, `deleted`, `created_at`, `clicks_left`) " +
+		"VALUES (?, ?, ?, ?, FROM_UNIXTIME(?), ?)",
+
+	GetIdempotencyKey:      "SELECT `fingerprint`, `response`, `expires_at` FROM idempotency_keys WHERE `key_hash` = ?",
+	InsertIdempotencyKey:   "INSERT INTO idempotency_keys (`key_hash`, `fingerprint`, `expires_at`) VALUES (?, ?, ?)",
+	CompleteIdempotencyKey: "UPDATE idempotency_keys SET `response` = ? WHERE `key_hash` = ?",
+	DeleteIdempotencyKey:   "DELETE FROM idempotency_keys WHERE `key_hash` = ?",
+	DeleteExpiredKeys:      "DELETE FROM idempotency_keys WHERE `expires_at` <= ?",
+
+	InsertAuditEntry: "INSERT INTO audit_log (`occurred_at`, `actor`, `ip`, `operation`, `target`, `old_value`, `new_value`, " +
+		"`outcome`, `error_message`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	AuditEntries: "SELECT `id`, `occurred_at`, `actor`, `ip`, `operation`, `target`, `old_value`, `new_value`, `outcome`, " +
+		"`error_message` FROM audit_log WHERE `id` > ? AND `occurred_at` >= ? AND `occurred_at` < ? AND (? = '' OR `actor` = ?) " +
+		"ORDER BY `id` LIMIT ?",
+
+	GetLinkOptions: "SELECT `title`, `preview`, `password_hash` FROM link_options WHERE `short_url` = ?",
+	SetLinkOptions: "INSERT INTO link_options (`short_url`, `title`, `preview`, `password_hash`) VALUES (?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE `title` = VALUES(`title`), `preview` = VALUES(`preview`), " +
+		"`password_hash` = VALUES(`password_hash`)",
+	PurgeLinkOptions: "DELETE FROM link_options WHERE `short_url` = ?",
+
+	SetMaxClicks:  "UPDATE links SET `clicks_left` = ? WHERE `shortURL` = ?",
+	UseClick:      "UPDATE links SET `clicks_left` = `clicks_left` - 1 WHERE `shortURL` = ? AND `clicks_left` > 0",
+	GetClicksLeft: "SELECT `clicks_left` FROM links WHERE `shortURL` = ?",
+}
+
+// insertRows the multi-row insert of every vendor: the head and the row repeated for every link,
+// the row is a format of the numbers of its placeholders if numbered.
+var insertRows = map[string]struct {
+	head, row string
+	numbered  bool
+}{
+	"sqlite3":  {head: "INSERT INTO links (long, short, cookie, created_at) VALUES ", row: "(?, ?, ?, CURRENT_TIMESTAMP)"},
+	"postgres": {head: "INSERT INTO links (long, short, cookie, deleted) VALUES ", row: "($%d, $%d, $%d, false)", numbered: true},
+	"mysql":    {head: "INSERT INTO links (`longURL`, `shortURL`, `cookie`) VALUES ", row: "(?, ?, ?)"},
+}
+
+// InsertURLs returns the query inserting n links at once.
+// Its arguments are the long URL, the short URL and the cookie of every link.
+func InsertURLs(vendor string, n int) (Query, error) {
+	insert, ok := insertRows[vendor]
+	if !ok {
+		return "", fmt.Errorf("no queries for %q", vendor)
+	}
+
+	var b strings.Builder
+	b.WriteString(insert.head)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+
+		if insert.numbered {
+			fmt.Fprintf(&b, insert.row, 3*i+1, 3*i+2, 3*i+3)
+		} else {
+			b.WriteString(insert.row)
+		}
+	}
+
+	return Query(b.String()), nil
 }
 
 // ErrNotFound occurs when query was not found.
 var ErrNotFound = errors.New("the query was not found")
 
-// ErrNilStatement occurs query statement is nil.
-var ErrNilStatement = errors.New("query statement is nil")
-
-var statements = make(map[Name]*sql.Stmt, 10)
+// Statements prepared statements of one database.
+type Statements struct {
+	statements map[Name]*sql.Stmt
+}
 
-// Prepare prepares all queries for db instance.
-func Prepare(DB *sql.DB, vendor string) error {
+// Prepare prepares all queries of the vendor for db.
+// Every query name must be defined for the vendor.
+func Prepare(db *sql.DB, vendor string) (*Statements, error) {
 	var queries map[Name]Query
 	switch vendor {
 	case "sqlite3":
@@ -73,40 +229,47 @@ func Prepare(DB *sql.DB, vendor string) error {
 		queries = queriesPostgres
 	case "mysql":
 		queries = queriesMySQL
+	default:
+		return nil, fmt.Errorf("no queries for %q", vendor)
+	}
+
+	for n := Name(0); n < count; n++ {
+		if _, ok := queries[n]; !ok {
+			return nil, fmt.Errorf("%s: %w: %d", vendor, ErrNotFound, n)
+		}
 	}
 
+	s := &Statements{statements: make(map[Name]*sql.Stmt, len(queries))}
 	for n, q := range queries {
-		prep, err := DB.Prepare(string(q))
+		prep, err := db.Prepare(string(q))
 		if err != nil {
-			return err
+			s.Close()
+			return nil, fmt.Errorf("error preparing query %d: %w", n, err)
 		}
-		statements[n] = prep
+		s.statements[n] = prep
 	}
-	return nil
+
+	return s, nil
 }
 
-// GetPreparedStatement returns *sql.Stmt by name of query.
-func GetPreparedStatement(name int) (*sql.Stmt, error) {
-	stmt, ok := statements[Name(name)]
+// Get returns *sql.Stmt by name of query.
+func (s *Statements) Get(name Name) (*sql.Stmt, error) {
+	stmt, ok := s.statements[name]
 	if !ok {
 		return nil, ErrNotFound
 	}
 
-	if stmt == nil {
-		return nil, ErrNilStatement
-	}
-
 	return stmt, nil
 }
 
 // Close closes all prepared statements.
-func Close() error {
-	for _, stmt := range statements {
-		err := stmt.Close()
-		if err != nil {
-			return fmt.Errorf("error closing statement: %w", err)
+func (s *Statements) Close() error {
+	var err error
+	for _, stmt := range s.statements {
+		if closeErr := stmt.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("error closing statement: %w", closeErr)
 		}
 	}
 
-	return nil
+	return err
 }
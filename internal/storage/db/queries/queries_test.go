@@ -0,0 +1,46 @@
+package queries
+
+import "testing"
+
+func TestVendorsDefineAllQueries(t *testing.T) {
+	for vendor, queries := range map[string]map[Name]Query{
+		"sqlite3":  queriesSqlite3,
+		"postgres": queriesPostgres,
+		"mysql":    queriesMySQL,
+	} {
+		for n := Name(0); n < count; n++ {
+			if queries[n] == "" {
+				t.Errorf("%s does not define query %d", vendor, n)
+			}
+		}
+	}
+}
+
+func TestPrepare_UnknownVendor(t *testing.T) {
+	if _, err := Prepare(nil, "oracle"); err == nil {
+		t.Error("Prepare() expected an error for an unknown vendor")
+	}
+}
+
+func TestInsertURLs(t *testing.T) {
+	tests := []struct {
+		vendor string
+		want   Query
+	}{
+		{vendor: "sqlite3", want: "INSERT INTO links (long, short, cookie, created_at) VALUES " +
+			"(?, ?, ?, CURRENT_TIMESTAMP), (?, ?, ?, CURRENT_TIMESTAMP)"},
+		{vendor: "postgres", want: "INSERT INTO links (long, short, cookie, deleted) VALUES " +
+			"($1, $2, $3, false), ($4, $5, $6, false)"},
+		{vendor: "mysql", want: "INSERT INTO links (`longURL`, `shortURL`, `cookie`) VALUES (?, ?, ?), (?, ?, ?)"},
+	}
+	for _, tt := range tests {
+		got, err := InsertURLs(tt.vendor, 2)
+		if err != nil || got != tt.want {
+			t.Errorf("InsertURLs(%s) = %q, %v, want %q", tt.vendor, got, err, tt.want)
+		}
+	}
+
+	if _, err := InsertURLs("oracle", 1); err == nil {
+		t.Error("InsertURLs() expected an error for an unknown vendor")
+	}
+}
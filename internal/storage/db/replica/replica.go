@@ -0,0 +1,524 @@
+// Package replica routes the reads of a database storage to its read replicas.
+//
+// Writes, the admin methods and FindMaxID go to the primary. GetLongLink, GetAllLinksByCookie
+// and the counters go to the healthy replicas in turn, and to the primary when none is healthy
+// or the replica fails. Replicas are pinged periodically, a failed one is skipped until it answers again.
+package replica
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+	"url-shortener/internal/storage"
+	shortener "url-shortener/pkg/api"
+)
+
+var (
+	_ storage.IStorage     = (*Storage)(nil)
+	_ storage.IAdmin       = (*Storage)(nil)
+	_ storage.IWrapper     = (*Storage)(nil)
+	_ storage.IClickLimit  = (*Storage)(nil)
+	_ storage.ITargets     = (*Storage)(nil)
+	_ storage.ILinkOptions = (*Storage)(nil)
+)
+
+// Defaults of the configuration.
+const (
+	DefaultHealthInterval = 5 * time.Second
+	DefaultHealthTimeout  = time.Second
+)
+
+// Replica a read replica, Open is called until it succeeds so a replica can be down at start.
+type Replica struct {
+	// Name of the replica in the logs.
+	Name string
+	Open func() (storage.IStorage, error)
+}
+
+// Config of the routing.
+type Config struct {
+	// Window during which the links and the users written to the primary are read from it,
+	// so they are seen despite the replication lag. Zero disables it.
+	Window time.Duration
+	// HealthInterval period of the replica pings, DefaultHealthInterval is used if zero.
+	HealthInterval time.Duration
+	// HealthTimeout of one ping, DefaultHealthTimeout is used if zero.
+	HealthTimeout time.Duration
+}
+
+// Storage the primary storage with its read replicas.
+type Storage struct {
+	storage.IStorage
+	cfg      Config
+	replicas []*replica
+	next     atomic.Uint32
+
+	mu sync.Mutex
+	// writes the short URLs and the owners with the time of their last write.
+	writes map[string]time.Time
+	now    func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type replica struct {
+	Replica
+
+	mu      sync.Mutex
+	st      storage.IStorage
+	healthy atomic.Bool
+}
+
+// New routes the reads of primary to the replicas and starts their health checks.
+// The replicas are opened and checked before New returns.
+func New(primary storage.IStorage, replicas []Replica, cfg Config) *Storage {
+	if cfg.HealthInterval <= 0 {
+		cfg.HealthInterval = DefaultHealthInterval
+	}
+
+	if cfg.HealthTimeout <= 0 {
+		cfg.HealthTimeout = DefaultHealthTimeout
+	}
+
+	s := &Storage{
+		IStorage: primary,
+		cfg:      cfg,
+		writes:   make(map[string]time.Time),
+		now:      time.Now,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for _, r := range replicas {
+		s.replicas = append(s.replicas, &replica{Replica: r})
+	}
+
+	s.check()
+	go s.checker()
+
+	return s
+}
+
+// Healthy returns the names of the replicas in use.
+func (s *Storage) Healthy() []string {
+	var names []string
+	for _, r := range s.replicas {
+		if r.healthy.Load() {
+			names = append(names, r.Name)
+		}
+	}
+
+	return names
+}
+
+// AddLink adds the link to the primary, the link and its owner are read from it during the window.
+func (s *Storage) AddLink(ctx context.Context, longURL, shortURL, cookie string) (string, error) {
+	short, err := s.IStorage.AddLink(ctx, longURL, shortURL, cookie)
+	if err == nil {
+		s.wrote(short, cookie)
+	}
+
+	return short, err
+}
+
+// AddLinks adds the links to the primary, the new links and their owners are read from it during the window.
+func (s *Storage) AddLinks(ctx context.Context, links []storage.Link) ([]storage.BatchResult, error) {
+	results, err := s.IStorage.AddLinks(ctx, links)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, 2*len(links))
+	for i, link := range links {
+		if !results[i].Exists {
+			keys = append(keys, link.Short, link.Owner)
+		}
+	}
+	s.wrote(keys...)
+
+	return results, nil
+}
+
+// MarkAsDeleted marks the link as deleted on the primary, the link and its owner are read from it during the window.
+func (s *Storage) MarkAsDeleted(ctx context.Context, shortURL, cookie string) error {
+	err := s.IStorage.MarkAsDeleted(ctx, shortURL, cookie)
+	if err == nil {
+		s.wrote(shortURL, cookie)
+	}
+
+	return err
+}
+
+// GetLongLink gets a long link from a replica.
+func (s *Storage) GetLongLink(ctx context.Context, shortURL string) (string, error) {
+	var long string
+
+	err := s.read(ctx, shortURL, func(st storage.IStorage) (err error) {
+		long, err = st.GetLongLink(ctx, shortURL)
+		return err
+	})
+
+	return long, err
+}
+
+// GetTarget gets the target of a link from a replica.
+func (s *Storage) GetTarget(ctx context.Context, shortURL string) (storage.Target, error) {
+	var t storage.Target
+
+	err := s.read(ctx, shortURL, func(st storage.IStorage) (err error) {
+		t, err = storage.GetTarget(ctx, st, shortURL)
+		return err
+	})
+
+	return t, err
+}
+
+// GetAllLinksByCookie gets all links of the owner from a replica.
+func (s *Storage) GetAllLinksByCookie(ctx context.Context, cookie, baseURL string) ([]*shortener.UserURL, error) {
+	var links []*shortener.UserURL
+
+	err := s.read(ctx, cookie, func(st storage.IStorage) (err error) {
+		links, err = st.GetAllLinksByCookie(ctx, cookie, baseURL)
+		return err
+	})
+
+	return links, err
+}
+
+// URLsCount gets count of URLs from a replica.
+func (s *Storage) URLsCount(ctx context.Context) (int, error) {
+	var count int
+
+	err := s.read(ctx, "", func(st storage.IStorage) (err error) {
+		count, err = st.URLsCount(ctx)
+		return err
+	})
+
+	return count, err
+}
+
+// UsersCount gets count of users from a replica.
+func (s *Storage) UsersCount(ctx context.Context) (int, error) {
+	var count int
+
+	err := s.read(ctx, "", func(st storage.IStorage) (err error) {
+		count, err = st.UsersCount(ctx)
+		return err
+	})
+
+	return count, err
+}
+
+// Shutdown stops the health checks and shuts the replicas and the primary down.
+func (s *Storage) Shutdown() error {
+	close(s.stop)
+	<-s.done
+
+	for _, r := range s.replicas {
+		r.mu.Lock()
+		if r.st != nil {
+			if err := r.st.Shutdown(); err != nil {
+				log.Printf("replica %s: %v", r.Name, err)
+			}
+		}
+		r.mu.Unlock()
+	}
+
+	return s.IStorage.Shutdown()
+}
+
+// Unwrap returns the primary.
+func (s *Storage) Unwrap() storage.IStorage {
+	return s.IStorage
+}
+
+// GetLink gets the record from the primary.
+func (s *Storage) GetLink(ctx context.Context, shortURL string) (storage.Link, error) {
+	admin, err := s.admin()
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	return admin.GetLink(ctx, shortURL)
+}
+
+// DisableLink disables the link on the primary.
+func (s *Storage) DisableLink(ctx context.Context, shortURL string) error {
+	admin, err := s.admin()
+	if err != nil {
+		return err
+	}
+
+	if err = admin.DisableLink(ctx, shortURL); err != nil {
+		return err
+	}
+
+	s.wrote(shortURL)
+
+	return nil
+}
+
+// PurgeLink removes the link from the primary.
+func (s *Storage) PurgeLink(ctx context.Context, shortURL string) error {
+	admin, err := s.admin()
+	if err != nil {
+		return err
+	}
+
+	if err = admin.PurgeLink(ctx, shortURL); err != nil {
+		return err
+	}
+
+	s.wrote(shortURL)
+
+	return nil
+}
+
+// Links calls fn for every record of the primary.
+func (s *Storage) Links(ctx context.Context, fn func(storage.Link) error) error {
+	admin, err := s.admin()
+	if err != nil {
+		return err
+	}
+
+	return admin.Links(ctx, fn)
+}
+
+// ImportLink saves the record to the primary.
+func (s *Storage) ImportLink(ctx context.Context, link storage.Link) error {
+	admin, err := s.admin()
+	if err != nil {
+		return err
+	}
+
+	if err = admin.ImportLink(ctx, link); err != nil {
+		return err
+	}
+
+	s.wrote(link.Short, link.Owner)
+
+	return nil
+}
+
+// SetMaxClicks limits the visits of the link on the primary.
+func (s *Storage) SetMaxClicks(ctx context.Context, shortURL string, n int) error {
+	clicks, ok := storage.As[storage.IClickLimit](s.IStorage)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+
+	if err := clicks.SetMaxClicks(ctx, shortURL, n); err != nil {
+		return err
+	}
+
+	s.wrote(shortURL)
+
+	return nil
+}
+
+// UseClick takes a visit of the link on the primary, the link is read from the primary
+// during the window so a replica behind can't serve the visit that was the last one.
+func (s *Storage) UseClick(ctx context.Context, shortURL string) (int, error) {
+	clicks, ok := storage.As[storage.IClickLimit](s.IStorage)
+	if !ok {
+		return storage.Unlimited, nil
+	}
+
+	left, err := clicks.UseClick(ctx, shortURL)
+	if err != nil {
+		return 0, err
+	}
+
+	if left != storage.Unlimited {
+		s.wrote(shortURL)
+	}
+
+	return left, nil
+}
+
+// GetLinkOptions gets the options of the link from the primary.
+func (s *Storage) GetLinkOptions(ctx context.Context, shortURL string) (storage.LinkOptions, error) {
+	options, ok := storage.As[storage.ILinkOptions](s.IStorage)
+	if !ok {
+		return storage.LinkOptions{}, storage.ErrNotSupported
+	}
+
+	return options.GetLinkOptions(ctx, shortURL)
+}
+
+// SetLinkOptions replaces the options of the link on the primary.
+func (s *Storage) SetLinkOptions(ctx context.Context, shortURL string, opts storage.LinkOptions) error {
+	options, ok := storage.As[storage.ILinkOptions](s.IStorage)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+
+	if err := options.SetLinkOptions(ctx, shortURL, opts); err != nil {
+		return err
+	}
+
+	s.wrote(shortURL)
+
+	return nil
+}
+
+func (s *Storage) admin() (storage.IAdmin, error) {
+	admin, ok := storage.As[storage.IAdmin](s.IStorage)
+	if !ok {
+		return nil, storage.ErrNotSupported
+	}
+
+	return admin, nil
+}
+
+// read calls fn with a healthy replica and with the primary if there is none, the replica fails
+// or key was written during the window. A failed replica is skipped until the next health check.
+func (s *Storage) read(ctx context.Context, key string, fn func(storage.IStorage) error) error {
+	if key != "" && s.recent(key) {
+		return fn(s.IStorage)
+	}
+
+	r := s.pick()
+	if r == nil {
+		return fn(s.IStorage)
+	}
+
+	err := fn(r.storage())
+	if err == nil || errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrDeleted) ||
+		errors.Is(err, storage.ErrExhausted) || ctx.Err() != nil {
+		return err
+	}
+
+	log.Printf("replica %s failed, reading from the primary: %v", r.Name, err)
+	r.healthy.Store(false)
+
+	return fn(s.IStorage)
+}
+
+// pick returns the next healthy replica or nil.
+func (s *Storage) pick() *replica {
+	n := uint32(len(s.replicas))
+	if n == 0 {
+		return nil
+	}
+
+	start := s.next.Add(1)
+	for i := uint32(0); i < n; i++ {
+		if r := s.replicas[(start+i)%n]; r.healthy.Load() {
+			return r
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) wrote(keys ...string) {
+	if s.cfg.Window <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, key := range keys {
+		if key != "" {
+			s.writes[key] = now
+		}
+	}
+}
+
+func (s *Storage) recent(key string) bool {
+	if s.cfg.Window <= 0 {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at, ok := s.writes[key]
+
+	return ok && s.now().Sub(at) < s.cfg.Window
+}
+
+// forgetWritten drops the writes older than the window.
+func (s *Storage) forgetWritten() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for key, at := range s.writes {
+		if now.Sub(at) >= s.cfg.Window {
+			delete(s.writes, key)
+		}
+	}
+}
+
+func (s *Storage) checker() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.cfg.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.check()
+			s.forgetWritten()
+		}
+	}
+}
+
+// check opens the replicas that are not opened yet and pings them.
+func (s *Storage) check() {
+	var wg sync.WaitGroup
+	for _, r := range s.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+
+			err := r.check(s.cfg.HealthTimeout)
+			if healthy := err == nil; healthy != r.healthy.Swap(healthy) {
+				if healthy {
+					log.Printf("replica %s is up", r.Name)
+				} else {
+					log.Printf("replica %s is down: %v", r.Name, err)
+				}
+			}
+		}(r)
+	}
+
+	wg.Wait()
+}
+
+func (r *replica) check(timeout time.Duration) error {
+	st := r.storage()
+	if st == nil {
+		var err error
+		if st, err = r.Open(); err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		r.st = st
+		r.mu.Unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return st.Ping(ctx)
+}
+
+func (r *replica) storage() storage.IStorage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.st
+}
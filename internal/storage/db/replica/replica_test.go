@@ -0,0 +1,153 @@
+package replica
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"url-shortener/internal/storage"
+	mapstorage "url-shortener/internal/storage/map"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errDown = errors.New("connection refused")
+
+// flaky fails every call while it is down.
+type flaky struct {
+	storage.IStorage
+	down bool
+}
+
+func (f *flaky) Ping(ctx context.Context) error {
+	if f.down {
+		return errDown
+	}
+
+	return f.IStorage.Ping(ctx)
+}
+
+func (f *flaky) GetLongLink(ctx context.Context, shortURL string) (string, error) {
+	if f.down {
+		return "", errDown
+	}
+
+	return f.IStorage.GetLongLink(ctx, shortURL)
+}
+
+func newStorage(t *testing.T, links ...string) storage.IStorage {
+	t.Helper()
+
+	st := mapstorage.NewMapStorage()
+	for _, short := range links {
+		if _, err := st.AddLink(context.Background(), "https://"+short+".ru", short, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return st
+}
+
+func opened(st storage.IStorage) Replica {
+	return Replica{Name: "replica", Open: func() (storage.IStorage, error) { return st, nil }}
+}
+
+func TestStorage_Routing(t *testing.T) {
+	ctx := context.Background()
+	primary := newStorage(t, "p")
+
+	s := New(primary, []Replica{opened(newStorage(t, "r1")), opened(newStorage(t, "r2"))},
+		Config{HealthInterval: time.Hour})
+	defer s.Shutdown()
+
+	// the replicas are used in turn
+	var found int
+	for i := 0; i < 4; i++ {
+		if _, err := s.GetLongLink(ctx, "r1"); err == nil {
+			found++
+		}
+	}
+	assert.Equal(t, 2, found)
+
+	_, err := s.GetLongLink(ctx, "p")
+	assert.True(t, errors.Is(err, storage.ErrNotFound), "reads don't go to the primary")
+
+	short, err := s.AddLink(ctx, "https://new.ru", "new", "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", short)
+
+	long, err := primary.GetLongLink(ctx, "new")
+	assert.NoError(t, err, "writes go to the primary")
+	assert.Equal(t, "https://new.ru", long)
+
+	links, err := s.GetAllLinksByCookie(ctx, "bob", "/")
+	assert.NoError(t, err)
+	assert.Empty(t, links, "no read-your-writes window")
+
+	count, err := s.URLsCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestStorage_ReadYourWrites(t *testing.T) {
+	ctx := context.Background()
+
+	s := New(newStorage(t), []Replica{opened(newStorage(t))},
+		Config{Window: time.Minute, HealthInterval: time.Hour})
+	defer s.Shutdown()
+
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	if _, err := s.AddLink(ctx, "https://new.ru", "new", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	long, err := s.GetLongLink(ctx, "new")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://new.ru", long)
+
+	links, err := s.GetAllLinksByCookie(ctx, "bob", "/")
+	assert.NoError(t, err)
+	assert.Len(t, links, 1)
+
+	now = now.Add(time.Minute)
+	s.forgetWritten()
+	assert.Empty(t, s.writes)
+
+	_, err = s.GetLongLink(ctx, "new")
+	assert.True(t, errors.Is(err, storage.ErrNotFound), "the replica is read after the window")
+}
+
+func TestStorage_Failover(t *testing.T) {
+	ctx := context.Background()
+	replica := &flaky{IStorage: newStorage(t, "a")}
+	opens := 0
+
+	s := New(newStorage(t, "a", "p"), []Replica{
+		{Name: "flaky", Open: func() (storage.IStorage, error) { return replica, nil }},
+		{Name: "broken", Open: func() (storage.IStorage, error) {
+			opens++
+			return nil, errDown
+		}},
+	}, Config{HealthInterval: time.Hour})
+	defer s.Shutdown()
+
+	assert.Equal(t, []string{"flaky"}, s.Healthy())
+
+	replica.down = true
+
+	long, err := s.GetLongLink(ctx, "a")
+	assert.NoError(t, err, "the primary is read when the replica fails")
+	assert.Equal(t, "https://a.ru", long)
+	assert.Empty(t, s.Healthy())
+
+	long, err = s.GetLongLink(ctx, "p")
+	assert.NoError(t, err, "no healthy replica is left")
+	assert.Equal(t, "https://p.ru", long)
+
+	replica.down = false
+	s.check()
+	assert.Equal(t, []string{"flaky"}, s.Healthy())
+	assert.Equal(t, 2, opens, "the broken replica is opened again")
+}
@@ -3,17 +3,19 @@ package service
 import (
 	"context"
 	"errors"
+	"url-shortener/internal/storage"
 	shortener "url-shortener/pkg/api"
 )
 
 // IRealStorage interface for the database storage.
 type IRealStorage interface {
 	AddLink(ctx context.Context, longURL, shortURL, cookie string) (string, error)
+	AddLinks(ctx context.Context, links []storage.Link) ([]storage.BatchResult, error)
 	FindMaxID(ctx context.Context) (int, error)
 	GetLongLink(ctx context.Context, shortURL string) (longURL string, err error)
 	GetAllLinksByCookie(ctx context.Context, cookie, baseURL string) ([]*shortener.UserURL, error)
 	Ping(ctx context.Context) error
-	MarkAsDeleted(shortURL, cookie string) error
+	MarkAsDeleted(ctx context.Context, shortURL, cookie string) error
 	Shutdown() error
 	URLsCount(ctx context.Context) (int, error)
 	UsersCount(ctx context.Context) (int, error)
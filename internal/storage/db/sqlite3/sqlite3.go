@@ -2,10 +2,6 @@ package sqlite3
 
 import (
 	"database/sql"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/sqlite"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"log"
 	"url-shortener/internal/storage"
 	"url-shortener/internal/storage/db/basic"
 	"url-shortener/internal/storage/db/service"
@@ -15,6 +11,7 @@ import (
 
 var (
 	_ storage.IStorage = (*Sqlite3)(nil)
+	_ storage.IAdmin   = (*Sqlite3)(nil)
 )
 
 // Sqlite3 struct with *sql.DB instance.
@@ -24,27 +21,12 @@ type Sqlite3 struct {
 }
 
 // New Sqlite3 struct constructor.
-func New(db *sql.DB, path string) service.IRealStorage {
-	driver, err := sqlite.WithInstance(db, &sqlite.Config{})
+// Migrations are applied by the caller, see the migration package.
+func New(db *sql.DB, opts basic.Options) (service.IRealStorage, error) {
+	b, err := basic.New(db, "sqlite3", opts)
 	if err != nil {
-		log.Fatal(err)
-		return nil
+		return nil, err
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		path,
-		"sqlite", driver)
-	if err != nil {
-		log.Fatal(err)
-		return nil
-	}
-
-	err = m.Up()
-	if err != nil {
-		if err.Error() != "no change" {
-			log.Fatal(err)
-		}
-	}
-
-	return &Sqlite3{DB: basic.DB{DB: db}}
+	return &Sqlite3{DB: b}, nil
 }
@@ -3,18 +3,23 @@ package sqlite3
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
-	prep "url-shortener/internal/storage/db/queries"
+	"time"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/basic"
+	"url-shortener/internal/storage/db/migration"
+	"url-shortener/internal/storage/storagetest"
 	shortener "url-shortener/pkg/api"
 )
 
 var TestDB *Sqlite3
 
-const pathToMigrations = "file://../../../../migrations/sqlite3"
-
 func TestMain(m *testing.M) {
 	// Write code here to run before tests
 
@@ -23,12 +28,22 @@ func TestMain(m *testing.M) {
 		log.Fatal(err)
 	}
 
-	TestDB = New(db, pathToMigrations).(*Sqlite3)
+	migrator, err := migration.New(db, "sqlite3")
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	err = prep.Prepare(db, "sqlite3")
+	if err = migrator.Up(); err != nil {
+		log.Fatal(err)
+	}
+	migrator.Close()
+
+	irs, err := New(db, basic.Options{})
 	if err != nil {
 		log.Fatal(err)
 	}
+	TestDB = irs.(*Sqlite3)
+
 	// Run tests
 	c := m.Run()
 	db.Close()
@@ -206,10 +221,10 @@ func Test_GetLongLink(t *testing.T) {
 }
 
 func Test_MarkAsDeleted(t *testing.T) {
+	ctx := context.Background()
 	ShortURL := "qwe"
 	cookie := "qwsa"
-	TestDB.MarkAsDeleted(ShortURL, cookie)
-	ctx := context.Background()
+	TestDB.MarkAsDeleted(ctx, ShortURL, cookie)
 
 	_, err := TestDB.GetLongLink(ctx, ShortURL)
 	if err == nil {
@@ -224,3 +239,355 @@ func Test_Ping(t *testing.T) {
 		t.Errorf("Ping() error = %v", err)
 	}
 }
+
+func Test_Admin(t *testing.T) {
+	ctx := context.Background()
+	link := storage.Link{Short: "adm", Long: "https://admin.example", Owner: "operator",
+		CreatedAt: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	if err := TestDB.ImportLink(ctx, link); err != nil {
+		t.Fatalf("ImportLink() error = %v", err)
+	}
+
+	got, err := TestDB.GetLink(ctx, link.Short)
+	if err != nil || got != link {
+		t.Errorf("GetLink() got = %v, %v, want %v", got, err, link)
+	}
+
+	if err = TestDB.DisableLink(ctx, link.Short); err != nil {
+		t.Errorf("DisableLink() error = %v", err)
+	}
+
+	if _, err = TestDB.GetLongLink(ctx, link.Short); !errors.Is(err, storage.ErrDeleted) {
+		t.Errorf("GetLongLink() error = %v, want %v", err, storage.ErrDeleted)
+	}
+
+	var found bool
+	err = TestDB.Links(ctx, func(l storage.Link) error {
+		if l.Short == "0" {
+			t.Error("Links() returned the sentinel row")
+		}
+		found = found || l.Short == link.Short && l.Deleted
+		return nil
+	})
+	if err != nil || !found {
+		t.Errorf("Links() error = %v, found = %v", err, found)
+	}
+
+	if err = TestDB.PurgeLink(ctx, link.Short); err != nil {
+		t.Errorf("PurgeLink() error = %v", err)
+	}
+
+	if _, err = TestDB.GetLink(ctx, link.Short); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetLink() error = %v, want %v", err, storage.ErrNotFound)
+	}
+
+	if err = TestDB.PurgeLink(ctx, link.Short); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("PurgeLink() error = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func Test_SideBySide(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	open := func(name string) *Sqlite3 {
+		db, err := sql.Open("sqlite3", filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		migrator, err := migration.New(db, "sqlite3")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = migrator.Up(); err != nil {
+			t.Fatal(err)
+		}
+		migrator.Close()
+
+		irs, err := New(db, basic.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return irs.(*Sqlite3)
+	}
+
+	first, second := open("first.db"), open("second.db")
+
+	if _, err := first.AddLink(ctx, "https://first.example", "a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := second.AddLink(ctx, "https://second.example", "a", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		db   *Sqlite3
+		want string
+	}{
+		{db: first, want: "https://first.example"},
+		{db: second, want: "https://second.example"},
+	} {
+		if got, err := tt.db.GetLongLink(ctx, "a"); err != nil || got != tt.want {
+			t.Errorf("GetLongLink() got = %v, %v, want %v", got, err, tt.want)
+		}
+	}
+
+	if err := first.Shutdown(); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	// the statements of the second storage are not closed with the first one
+	if got, err := second.GetLongLink(ctx, "a"); err != nil || got != "https://second.example" {
+		t.Errorf("GetLongLink() after Shutdown of another storage got = %v, %v", got, err)
+	}
+
+	if err := second.Shutdown(); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+}
+
+func openTestDB(t *testing.T, opts basic.Options) *Sqlite3 {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "links.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := migration.New(db, "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	migrator.Close()
+
+	irs, err := New(db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return irs.(*Sqlite3)
+}
+
+func Test_Options(t *testing.T) {
+	ctx := context.Background()
+	irs := openTestDB(t, basic.Options{Name: "options", QueryTimeout: time.Nanosecond})
+
+	if _, ok := basic.PoolStats()["options"]; !ok {
+		t.Errorf("PoolStats() has no %q pool", "options")
+	}
+
+	if _, err := irs.GetLongLink(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetLongLink() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err := irs.Shutdown(); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	if _, ok := basic.PoolStats()["options"]; ok {
+		t.Errorf("PoolStats() has the %q pool after Shutdown()", "options")
+	}
+}
+
+func Test_AddLinks(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t, basic.Options{})
+	defer db.Shutdown()
+
+	if _, err := db.AddLink(ctx, "https://a.ru", "a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	links := []storage.Link{
+		{Short: "x", Long: "https://a.ru", Owner: "bob"},
+		{Short: "a", Long: "https://other.ru", Owner: "bob"},
+		{Short: "b", Long: "https://b.ru", Owner: "bob"},
+		{Short: "y", Long: "https://b.ru", Owner: "bob"},
+	}
+	for i := 0; i < 400; i++ {
+		short := fmt.Sprintf("c%d", i)
+		links = append(links, storage.Link{Short: short, Long: "https://" + short + ".ru", Owner: "bob"})
+	}
+
+	results, err := db.AddLinks(ctx, links)
+	if err != nil {
+		t.Fatalf("AddLinks() error = %v", err)
+	}
+
+	want := []storage.BatchResult{{Short: "a", Exists: true}, {Short: "a", Exists: true}, {Short: "b"}, {Short: "b", Exists: true}}
+	if !reflect.DeepEqual(results[:4], want) {
+		t.Errorf("AddLinks() got = %v, want %v", results[:4], want)
+	}
+
+	if count, err := db.URLsCount(ctx); err != nil || count != 403 {
+		t.Errorf("URLsCount() got = %v, %v, want %v", count, err, 403)
+	}
+
+	if long, err := db.GetLongLink(ctx, "c399"); err != nil || long != "https://c399.ru" {
+		t.Errorf("GetLongLink() got = %v, %v", long, err)
+	}
+}
+
+func Test_Idempotency(t *testing.T) {
+	ctx := context.Background()
+	st := openTestDB(t, basic.Options{})
+	defer st.Shutdown()
+
+	rec := storage.IdempotencyRecord{Key: "k", Fingerprint: "a", ExpiresAt: time.Now().Add(time.Hour).Truncate(time.Millisecond)}
+	if _, reserved, err := st.ReserveKey(ctx, rec); err != nil || !reserved {
+		t.Fatalf("ReserveKey() = %v, %v, want reserved", reserved, err)
+	}
+
+	found, reserved, err := st.ReserveKey(ctx, storage.IdempotencyRecord{Key: "k", Fingerprint: "b", ExpiresAt: rec.ExpiresAt})
+	if err != nil || reserved || !reflect.DeepEqual(found, rec) {
+		t.Errorf("ReserveKey() = %v, %v, %v, want %v", found, reserved, err, rec)
+	}
+
+	if err = st.CompleteKey(ctx, "k", []byte("response")); err != nil {
+		t.Fatal(err)
+	}
+
+	found, _, err = st.ReserveKey(ctx, rec)
+	if err != nil || string(found.Response) != "response" {
+		t.Errorf("ReserveKey() response = %q, %v, want %q", found.Response, err, "response")
+	}
+
+	if err = st.ReleaseKey(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, reserved, err = st.ReserveKey(ctx, rec); err != nil || !reserved {
+		t.Errorf("ReserveKey() of a released key = %v, %v, want reserved", reserved, err)
+	}
+
+	old := storage.IdempotencyRecord{Key: "old", ExpiresAt: time.Now().Add(-time.Second)}
+	if _, _, err = st.ReserveKey(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+
+	old.ExpiresAt = rec.ExpiresAt
+	if _, reserved, err = st.ReserveKey(ctx, old); err != nil || !reserved {
+		t.Errorf("ReserveKey() of an expired key = %v, %v, want reserved", reserved, err)
+	}
+}
+
+func Test_Audit(t *testing.T) {
+	ctx := context.Background()
+	st := openTestDB(t, basic.Options{})
+	defer st.Shutdown()
+	at := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	err := st.AppendAudit(ctx,
+		storage.AuditEntry{At: at, Actor: "alice", IP: "10.0.0.1", Operation: "link.create", Target: "a",
+			NewValue: "https://a.ru", Outcome: storage.AuditSuccess},
+		storage.AuditEntry{At: at.Add(time.Minute), Actor: "bob", Operation: "link.create", Target: "b",
+			Outcome: storage.AuditFailure, Error: "exists"},
+		storage.AuditEntry{At: at.Add(2 * time.Minute), Actor: "alice", Operation: "link.delete", Target: "a",
+			OldValue: "https://a.ru", Outcome: storage.AuditSuccess},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := storage.AuditEntry{ID: 1, At: at, Actor: "alice", IP: "10.0.0.1", Operation: "link.create", Target: "a",
+		NewValue: "https://a.ru", Outcome: storage.AuditSuccess}
+	if entries, err := st.AuditEntries(ctx, storage.AuditFilter{Actor: "alice"}); err != nil ||
+		len(entries) != 2 || !reflect.DeepEqual(entries[0], want) {
+		t.Errorf("AuditEntries() by actor = %v, %v, want first %v", entries, err, want)
+	}
+
+	if entries, err := st.AuditEntries(ctx, storage.AuditFilter{From: at.Add(time.Minute), To: at.Add(2 * time.Minute)}); err != nil ||
+		len(entries) != 1 || entries[0].Error != "exists" {
+		t.Errorf("AuditEntries() by time = %v, %v, want the entry of bob", entries, err)
+	}
+
+	if entries, err := st.AuditEntries(ctx, storage.AuditFilter{AfterID: 1, Limit: 1}); err != nil ||
+		len(entries) != 1 || entries[0].ID != 2 {
+		t.Errorf("AuditEntries() after 1 = %v, %v, want the entry 2", entries, err)
+	}
+}
+
+func Test_LinkOptions(t *testing.T) {
+	ctx := context.Background()
+	st := openTestDB(t, basic.Options{})
+	defer st.Shutdown()
+
+	if opts, err := st.GetLinkOptions(ctx, "a"); err != nil || opts != (storage.LinkOptions{}) {
+		t.Errorf("GetLinkOptions() of a link without options = %v, %v", opts, err)
+	}
+
+	for _, want := range []storage.LinkOptions{{Title: "Yandex"}, {Title: "Яндекс", Preview: true}, {PasswordHash: "$2a$10$hash"}} {
+		if err := st.SetLinkOptions(ctx, "a", want); err != nil {
+			t.Fatal(err)
+		}
+
+		if opts, err := st.GetLinkOptions(ctx, "a"); err != nil || opts != want {
+			t.Errorf("GetLinkOptions() = %v, %v, want %v", opts, err, want)
+		}
+	}
+}
+
+func Test_Clicks(t *testing.T) {
+	ctx := context.Background()
+	st := openTestDB(t, basic.Options{})
+	defer st.Shutdown()
+
+	for _, short := range []string{"once", "free"} {
+		if _, err := st.AddLink(ctx, "https://"+short+".ru", short, "clicks"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if left, err := st.UseClick(ctx, "free"); err != nil || left != storage.Unlimited {
+		t.Errorf("UseClick() of an unlimited link = %v, %v", left, err)
+	}
+
+	if _, err := st.UseClick(ctx, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("UseClick() of a missing link error = %v, want %v", err, storage.ErrNotFound)
+	}
+
+	if err := st.SetMaxClicks(ctx, "once", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	links, err := st.GetAllLinksByCookie(ctx, "clicks", "")
+	if err != nil || len(links) != 2 || links[0].ClicksLeft == nil || *links[0].ClicksLeft != 1 || links[1].ClicksLeft != nil {
+		t.Errorf("GetAllLinksByCookie() = %v, %v", links, err)
+	}
+
+	if left, err := st.UseClick(ctx, "once"); err != nil || left != 0 {
+		t.Errorf("UseClick() = %v, %v, want 0", left, err)
+	}
+
+	if _, err = st.UseClick(ctx, "once"); !errors.Is(err, storage.ErrExhausted) {
+		t.Errorf("UseClick() of a used link error = %v, want %v", err, storage.ErrExhausted)
+	}
+
+	if _, err = st.GetLongLink(ctx, "once"); !errors.Is(err, storage.ErrExhausted) {
+		t.Errorf("GetLongLink() of a used link error = %v, want %v", err, storage.ErrExhausted)
+	}
+
+	if err = st.SetMaxClicks(ctx, "once", storage.Unlimited); err != nil {
+		t.Fatal(err)
+	}
+
+	if long, err := st.GetLongLink(ctx, "once"); err != nil || long != "https://once.ru" {
+		t.Errorf("GetLongLink() of an unlimited link = %v, %v", long, err)
+	}
+}
+
+func Test_Conformance(t *testing.T) {
+	st := openTestDB(t, basic.Options{})
+	defer st.Shutdown()
+
+	storagetest.Run(t, st)
+}
@@ -0,0 +1,16 @@
+package filestorage
+
+import (
+	"context"
+	"url-shortener/internal/storage"
+)
+
+// AppendAudit saves the entries to the audit log.
+func (fs *FileStorage) AppendAudit(ctx context.Context, entries ...storage.AuditEntry) error {
+	return fs.audit.AppendAudit(ctx, entries...)
+}
+
+// AuditEntries returns the entries of the audit log selected by the filter.
+func (fs *FileStorage) AuditEntries(ctx context.Context, filter storage.AuditFilter) ([]storage.AuditEntry, error) {
+	return fs.audit.AuditEntries(ctx, filter)
+}
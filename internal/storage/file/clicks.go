@@ -0,0 +1,13 @@
+package filestorage
+
+import "context"
+
+// SetMaxClicks limits the visits of the link.
+func (fs *FileStorage) SetMaxClicks(ctx context.Context, shortURL string, n int) error {
+	return fs.clicks.SetMaxClicks(ctx, shortURL, n)
+}
+
+// UseClick takes a visit of the link.
+func (fs *FileStorage) UseClick(ctx context.Context, shortURL string) (int, error) {
+	return fs.clicks.UseClick(ctx, shortURL)
+}
@@ -2,77 +2,162 @@ package filestorage
 
 import (
 	"bufio"
-	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"log"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/auditlog"
+	"url-shortener/internal/storage/clicklimit"
+	"url-shortener/internal/storage/db/service"
+	"url-shortener/internal/storage/idempotencylog"
+	"url-shortener/internal/storage/linkoptions"
+	"url-shortener/internal/storage/wal"
 	shortener "url-shortener/pkg/api"
+
+	"google.golang.org/protobuf/proto"
 )
 
 var (
-	_ storage.IStorage = (*FileStorage)(nil)
+	_ storage.IStorage     = (*FileStorage)(nil)
+	_ storage.IAdmin       = (*FileStorage)(nil)
+	_ storage.IAudit       = (*FileStorage)(nil)
+	_ storage.ILinkOptions = (*FileStorage)(nil)
+	_ storage.IClickLimit  = (*FileStorage)(nil)
+	_ storage.IIdempotency = (*FileStorage)(nil)
 )
 
-// FileStorage struct with Mu for concurrent uses, File instance and Path variable.
-// It has methods for working with URLs.
+// FileStorage keeps the links in an append-only log and serves them from an in-memory index.
+// The log is replayed on start and compacted periodically.
 type FileStorage struct {
 	Path string
-	File *os.File
-	Mu   sync.Mutex
+
+	mu    sync.RWMutex
+	log   *wal.Log
+	links map[string]*entry
+	// owners short URLs by owner.
+	owners map[string]map[string]struct{}
+	// seq the last id given to a link, ids are never reused.
+	seq int
+	// stale the number of records superseded since the last compaction.
+	stale   int
+	audit   *auditlog.Log
+	options *linkoptions.Store
+	clicks  *clicklimit.Store
+	keys    *idempotencylog.Store
+
+	stop chan struct{}
+	done chan struct{}
 }
 
 // FileStorageType type for file storage.
 const FileStorageType storage.Type = "file"
 
+// DefaultCompactInterval the period of the compaction if Config has none.
+const DefaultCompactInterval = 10 * time.Minute
+
+// Config of the file storage.
+type Config struct {
+	// Path of the log, the audit log is kept in Path + ".audit", the options of the links in Path + ".options",
+	// the visits left of the limited links in Path + ".clicks" and the idempotency keys in Path + ".idempotency".
+	Path string
+	// Sync fsync policy of the log, wal.SyncAlways is used if empty.
+	Sync wal.SyncPolicy
+	// CompactInterval the period of the compaction, a negative one disables it.
+	CompactInterval time.Duration
+}
+
+// entry a link with the order it was created in.
+type entry struct {
+	id   int
+	link storage.Link
+}
+
+// Operations of the records.
+const (
+	opPut   = "put"
+	opPurge = "purge"
+	// opSeq keeps the last id when the links having it were compacted away.
+	opSeq = "seq"
+)
+
+// record of the log.
+type record struct {
+	Op      string `json:"op"`
+	ID      int    `json:"id,omitempty"`
+	Short   string `json:"short,omitempty"`
+	Long    string `json:"long,omitempty"`
+	Owner   string `json:"owner,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Created int64  `json:"created,omitempty"`
+}
+
 // NewFileStorage FileStorage struct constructor.
-func NewFileStorage(path string) (storage.IStorage, error) {
-	fs := &FileStorage{Path: path}
-	err := fs.Open()
-	if err != nil {
+// Files in the old "flag - short - long - cookie" format are converted.
+func NewFileStorage(cfg Config) (storage.IStorage, error) {
+	if cfg.Sync == "" {
+		cfg.Sync = wal.SyncAlways
+	}
+
+	if cfg.CompactInterval == 0 {
+		cfg.CompactInterval = DefaultCompactInterval
+	}
+
+	fs := &FileStorage{
+		Path:   cfg.Path,
+		links:  make(map[string]*entry),
+		owners: make(map[string]map[string]struct{}),
+	}
+
+	if err := convertLegacy(cfg.Path); err != nil {
 		return nil, err
 	}
 
-	err = fs.Close()
+	l, err := wal.Open(cfg.Path, cfg.Sync, fs.replay)
 	if err != nil {
 		return nil, err
 	}
+	fs.log = l
 
-	return fs, nil
-}
+	if fs.audit, err = auditlog.Open(cfg.Path+".audit", cfg.Sync); err != nil {
+		l.Close()
+		return nil, err
+	}
 
-// Open opens the file.
-func (fs *FileStorage) Open() error {
-	fs.Mu.Lock()
-	file, err := os.OpenFile(fs.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
-	if err != nil {
-		return fmt.Errorf("open file error: %w", err)
+	if fs.options, err = linkoptions.Open(cfg.Path+".options", cfg.Sync); err != nil {
+		fs.audit.Close()
+		l.Close()
+		return nil, err
 	}
 
-	fs.File = file
-	return nil
-}
+	if fs.clicks, err = clicklimit.Open(cfg.Path+".clicks", cfg.Sync); err != nil {
+		fs.options.Close()
+		fs.audit.Close()
+		l.Close()
+		return nil, err
+	}
 
-// OpenForWriteAt opens the file for write at.
-func (fs *FileStorage) OpenForWriteAt() error {
-	fs.Mu.Lock()
-	file, err := os.OpenFile(fs.Path, os.O_RDWR|os.O_CREATE, 0777)
-	if err != nil {
-		return err
+	if fs.keys, err = idempotencylog.Open(cfg.Path+".idempotency", cfg.Sync); err != nil {
+		fs.clicks.Close()
+		fs.options.Close()
+		fs.audit.Close()
+		l.Close()
+		return nil, err
 	}
 
-	fs.File = file
-	return nil
-}
+	if cfg.CompactInterval > 0 {
+		fs.stop, fs.done = make(chan struct{}), make(chan struct{})
+		go fs.compactor(cfg.CompactInterval)
+	}
 
-// Close closes the file.
-func (fs *FileStorage) Close() error {
-	fs.Mu.Unlock()
-	return fs.File.Close()
+	return fs, nil
 }
 
 // AddLink adds a link to the file.
@@ -81,57 +166,85 @@ func (fs *FileStorage) AddLink(ctx context.Context, longURL, shortURL, cookie st
 		return "", ctx.Err()
 	}
 
-	err := fs.Open()
-	if err != nil {
-		return "", err
-	}
-
-	defer fs.Close()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 
-	writer := bufio.NewWriter(fs.File)
+	if _, ok := fs.links[shortURL]; ok {
+		return shortURL, service.ErrExists
+	}
 
-	_, err = writer.Write([]byte("1" + " - " + shortURL + " - " + longURL + " - " + cookie + "\n"))
-	if err != nil {
+	// drops the options left by an interrupted purge
+	if err := fs.saveOptions(ctx, storage.Link{Short: shortURL}); err != nil {
 		return "", err
 	}
 
-	err = writer.Flush()
-	if err != nil {
+	link := storage.Link{Short: shortURL, Long: longURL, Owner: cookie, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	if err := fs.put(&entry{id: fs.seq + 1, link: link}); err != nil {
 		return "", err
 	}
 
 	return shortURL, nil
 }
 
-// FindMaxID gets len of the file.
-func (fs *FileStorage) FindMaxID(ctx context.Context) (int, error) {
+// AddLinks adds the links under one lock and appends them by one write, the options and the visits left
+// of the links are saved before them. A link exists if its short URL is taken, by a stored link
+// or an earlier one of the batch.
+func (fs *FileStorage) AddLinks(ctx context.Context, links []storage.Link) ([]storage.BatchResult, error) {
 	if ctx.Err() != nil {
-		return 0, ctx.Err()
+		return nil, ctx.Err()
 	}
 
-	err := fs.Open()
-	if err != nil {
-		return 0, err
-	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 
-	defer fs.Close()
+	results := make([]storage.BatchResult, len(links))
+	fresh := make([]*entry, 0, len(links))
+	added := make(map[string]bool, len(links))
+	records := make([]any, 0, len(links))
+	created := time.Now().UTC().Truncate(time.Second)
 
-	buf := make([]byte, 32*1024)
-	count := 0
-	lineSep := []byte{'\n'}
+	for i, link := range links {
+		results[i].Short = link.Short
 
-	for {
-		c, err := fs.File.Read(buf)
-		count += bytes.Count(buf[:c], lineSep)
+		if _, stored := fs.links[link.Short]; stored || added[link.Short] {
+			results[i].Exists = true
+			continue
+		}
+
+		if err := fs.saveOptions(ctx, link); err != nil {
+			return nil, err
+		}
 
-		switch {
-		case errors.Is(err, io.EOF):
-			return count, nil
+		e := &entry{id: fs.seq + len(fresh) + 1,
+			link: storage.Link{Short: link.Short, Long: link.Long, Owner: link.Owner, CreatedAt: created}}
+		added[link.Short] = true
+		fresh = append(fresh, e)
+		records = append(records, newRecord(e))
+	}
 
-		case err != nil:
-			return count, err
+	if len(records) > 0 {
+		if err := fs.log.Append(records...); err != nil {
+			return nil, err
 		}
 	}
+
+	for _, e := range fresh {
+		fs.index(e)
+	}
+
+	return results, nil
+}
+
+// FindMaxID returns the last id given to a link.
+func (fs *FileStorage) FindMaxID(ctx context.Context) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	return fs.seq, nil
 }
 
 // GetLongLink gets a long link from the file.
@@ -140,149 +253,461 @@ func (fs *FileStorage) GetLongLink(ctx context.Context, shortURL string) (longUR
 		return "", ctx.Err()
 	}
 
-	err = fs.Open()
-	if err != nil {
-		return "", err
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	e, ok := fs.links[shortURL]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+
+	if e.link.Deleted {
+		return "", storage.ErrDeleted
+	}
+
+	if left, ok := fs.clicks.Left(shortURL); ok && left == 0 {
+		return "", storage.ErrExhausted
 	}
 
-	defer fs.Close()
+	return e.link.Long, nil
+}
+
+// GetAllLinksByCookie gets all links ([]schema.URL) by cookie in the order they were created.
+func (fs *FileStorage) GetAllLinksByCookie(ctx context.Context, cookie, baseURL string) ([]*shortener.UserURL, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 
-	scanner := bufio.NewScanner(fs.File)
+	fs.mu.RLock()
+	entries := make([]*entry, 0, len(fs.owners[cookie]))
+	for short := range fs.owners[cookie] {
+		entries = append(entries, fs.links[short])
+	}
+	fs.mu.RUnlock()
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		split := strings.Split(line, " - ")
+	sortEntries(entries)
 
-		if len(split) > 2 && split[1] == shortURL {
-			return split[2], nil
+	links := make([]*shortener.UserURL, 0, len(entries))
+	for _, e := range entries {
+		link := &shortener.UserURL{OriginalUrl: e.link.Long, ShortUrl: baseURL + e.link.Short, Deleted: e.link.Deleted}
+		if left, ok := fs.clicks.Left(e.link.Short); ok {
+			link.ClicksLeft = proto.Int32(int32(left))
 		}
+		links = append(links, link)
 	}
 
-	return longURL, errors.New("not found")
+	return links, nil
 }
 
-// GetAllLinksByCookie gets all links ([]schema.URL) by cookie.
-func (fs *FileStorage) GetAllLinksByCookie(ctx context.Context, cookie, baseURL string) ([]*shortener.UserURL, error) {
+// Ping checks that the file is still open.
+func (fs *FileStorage) Ping(ctx context.Context) error {
 	if ctx.Err() != nil {
-		return nil, ctx.Err()
+		return ctx.Err()
 	}
 
-	err := fs.Open()
-	if err != nil {
-		return nil, err
+	_, err := fs.log.Size()
+
+	return err
+}
+
+// MarkAsDeleted finds a URL of the owner and marks it as deleted.
+func (fs *FileStorage) MarkAsDeleted(ctx context.Context, shortURL, cookie string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	e, ok := fs.links[shortURL]
+	if !ok || e.link.Owner != cookie {
+		return storage.ErrNotFound
+	}
+
+	return fs.disable(e)
+}
+
+// Shutdown stops the compaction and closes the log.
+func (fs *FileStorage) Shutdown() error {
+	if fs.stop != nil {
+		close(fs.stop)
+		<-fs.done
+		fs.stop = nil
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	err := fs.log.Close()
+	if closeErr := fs.audit.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := fs.options.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := fs.clicks.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := fs.keys.Close(); err == nil {
+		err = closeErr
 	}
 
-	defer fs.Close()
+	return err
+}
 
-	scanner := bufio.NewScanner(fs.File)
-	var link = make([]*shortener.UserURL, 0)
+// URLsCount returns the number of URLs in the file.
+func (fs *FileStorage) URLsCount(ctx context.Context) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	return len(fs.links), nil
+}
+
+// UsersCount returns the number of users in the file.
+func (fs *FileStorage) UsersCount(ctx context.Context) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	return len(fs.owners), nil
+}
+
+// GetLink gets the record including deleted ones.
+func (fs *FileStorage) GetLink(ctx context.Context, shortURL string) (storage.Link, error) {
+	if ctx.Err() != nil {
+		return storage.Link{}, ctx.Err()
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		split := strings.Split(line, " - ")
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
 
-		if len(split) == 4 && split[3] == cookie && split[0] == "1" {
-			link = append(link, &shortener.UserURL{OriginalUrl: split[2], ShortUrl: baseURL + split[1]})
+	e, ok := fs.links[shortURL]
+	if !ok {
+		return storage.Link{}, storage.ErrNotFound
+	}
+
+	return fs.withOptions(ctx, e.link)
+}
+
+// DisableLink marks the link as deleted regardless of the owner.
+func (fs *FileStorage) DisableLink(ctx context.Context, shortURL string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	e, ok := fs.links[shortURL]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	return fs.disable(e)
+}
+
+// PurgeLink removes the record, then its options and visits left. The space is reclaimed by the compaction.
+func (fs *FileStorage) PurgeLink(ctx context.Context, shortURL string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.links[shortURL]; !ok {
+		return storage.ErrNotFound
+	}
+
+	if err := fs.log.Append(record{Op: opPurge, Short: shortURL}); err != nil {
+		return err
+	}
+
+	fs.purge(shortURL)
+	fs.stale += 2
+
+	return fs.saveOptions(ctx, storage.Link{Short: shortURL})
+}
+
+// Links calls fn for every record in the order they were created.
+func (fs *FileStorage) Links(ctx context.Context, fn func(storage.Link) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	fs.mu.RLock()
+	entries := fs.entries()
+	fs.mu.RUnlock()
+
+	for _, e := range entries {
+		link, err := fs.withOptions(ctx, e.link)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(link); err != nil {
+			return err
 		}
 	}
 
-	return link, nil
+	return nil
 }
 
-// Ping check for the presence of a file.
-func (fs *FileStorage) Ping(ctx context.Context) error {
+// ImportLink saves the options and the visits left of the record, then the record itself,
+// so the link is never served without them.
+func (fs *FileStorage) ImportLink(ctx context.Context, link storage.Link) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	err := fs.Open()
-	if err != nil {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.links[link.Short]; ok {
+		return service.ErrExists
+	}
+
+	if err := fs.saveOptions(ctx, link); err != nil {
 		return err
 	}
 
-	err = fs.Close()
+	// the options and the visits left are kept by their stores only
+	link.Options, link.ClicksLeft = storage.LinkOptions{}, nil
+
+	return fs.put(&entry{id: fs.seq + 1, link: link})
+}
+
+// Compact rewrites the log with the current links only.
+func (fs *FileStorage) Compact() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	entries := fs.entries()
+	err := fs.log.Rewrite(func(write func(v any) error) error {
+		if err := write(record{Op: opSeq, ID: fs.seq}); err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if err := write(newRecord(e)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("can't compact: %w", err)
 	}
 
+	fs.stale = 0
+
 	return nil
 }
 
-// MarkAsDeleted finds a URL and marks it as deleted.
-func (fs *FileStorage) MarkAsDeleted(shortURL, cookie string) error {
-	err := fs.OpenForWriteAt()
-	if err != nil {
-		return fmt.Errorf("can't open a file %w", err)
-	}
-	defer fs.Close()
-
-	scanner := bufio.NewScanner(fs.File)
-	var i int64 = 1
-	for scanner.Scan() {
-		line := scanner.Text()
-		split := strings.Split(line, " - ")
-		if len(split) > 2 && split[1] == shortURL && split[3] == cookie {
-			lineWithDeletedMark := "0" + line[1:] + "\n"
-			_, err = fs.File.WriteAt([]byte(lineWithDeletedMark), i-1)
-			if err != nil {
-				return fmt.Errorf("can't write a file %w", err)
-			}
+// compactor compacts the log when at least half of it is superseded.
+func (fs *FileStorage) compactor(interval time.Duration) {
+	defer close(fs.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fs.stop:
+			return
+		case <-ticker.C:
+		}
+
+		fs.mu.RLock()
+		worth := fs.stale > 0 && fs.stale >= len(fs.links)
+		fs.mu.RUnlock()
+
+		if !worth {
+			continue
+		}
+
+		if err := fs.Compact(); err != nil {
+			log.Println(err)
 		}
-		i += int64(1 + len(line))
 	}
+}
+
+// put appends the entry and indexes it, fs.mu must be locked.
+func (fs *FileStorage) put(e *entry) error {
+	if err := fs.log.Append(newRecord(e)); err != nil {
+		return err
+	}
+
+	fs.index(e)
+
 	return nil
 }
 
-// Shutdown closes the file.
-func (fs *FileStorage) Shutdown() error {
-	err := fs.Close()
-	if err != nil {
-		return fmt.Errorf("can't close a file %w", err)
+func (fs *FileStorage) disable(e *entry) error {
+	if e.link.Deleted {
+		return nil
+	}
+
+	disabled := *e
+	disabled.link.Deleted = true
+	if err := fs.log.Append(newRecord(&disabled)); err != nil {
+		return err
 	}
 
+	e.link.Deleted = true
+	fs.stale++
+
 	return nil
 }
 
-// URLsCount returns the number of URLs in the file.
-func (fs *FileStorage) URLsCount(ctx context.Context) (int, error) {
-	if ctx.Err() != nil {
-		return 0, ctx.Err()
+func (fs *FileStorage) index(e *entry) {
+	if old, ok := fs.links[e.link.Short]; ok {
+		fs.purge(old.link.Short)
+		fs.stale++
 	}
 
-	err := fs.Open()
-	if err != nil {
-		return 0, err
+	fs.links[e.link.Short] = e
+
+	if fs.owners[e.link.Owner] == nil {
+		fs.owners[e.link.Owner] = make(map[string]struct{})
 	}
-	defer fs.Close()
-	scanner := bufio.NewScanner(fs.File)
-	var count int
-	for ; scanner.Scan(); count++ {
+	fs.owners[e.link.Owner][e.link.Short] = struct{}{}
+
+	if e.id > fs.seq {
+		fs.seq = e.id
 	}
-	return count, nil
 }
 
-// UsersCount returns the number of users in the file.
-func (fs *FileStorage) UsersCount(ctx context.Context) (int, error) {
-	if ctx.Err() != nil {
-		return 0, ctx.Err()
+func (fs *FileStorage) purge(short string) {
+	e := fs.links[short]
+	delete(fs.links, short)
+
+	delete(fs.owners[e.link.Owner], short)
+	if len(fs.owners[e.link.Owner]) == 0 {
+		delete(fs.owners, e.link.Owner)
 	}
+}
 
-	err := fs.Open()
-	if err != nil {
-		return 0, err
+// replay applies a record read from the log.
+func (fs *FileStorage) replay(data []byte) error {
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return err
 	}
 
-	defer fs.Close()
+	switch rec.Op {
+	case opPut:
+		e := &entry{id: rec.ID, link: storage.Link{Short: rec.Short, Long: rec.Long, Owner: rec.Owner,
+			Deleted: rec.Deleted}}
+		if rec.Created != 0 {
+			e.link.CreatedAt = time.Unix(rec.Created, 0).UTC()
+		}
+		fs.index(e)
+	case opPurge:
+		if _, ok := fs.links[rec.Short]; ok {
+			fs.purge(rec.Short)
+			fs.stale += 2
+		}
+	case opSeq:
+		if rec.ID > fs.seq {
+			fs.seq = rec.ID
+		}
+	default:
+		return fmt.Errorf("unknown operation %q", rec.Op)
+	}
+
+	return nil
+}
+
+// entries returns the entries ordered by id, fs.mu must be locked.
+func (fs *FileStorage) entries() []*entry {
+	entries := make([]*entry, 0, len(fs.links))
+	for _, e := range fs.links {
+		entries = append(entries, e)
+	}
+
+	sortEntries(entries)
+
+	return entries
+}
+
+func sortEntries(entries []*entry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+}
+
+func newRecord(e *entry) record {
+	rec := record{Op: opPut, ID: e.id, Short: e.link.Short, Long: e.link.Long, Owner: e.link.Owner,
+		Deleted: e.link.Deleted}
+	if !e.link.CreatedAt.IsZero() {
+		rec.Created = e.link.CreatedAt.Unix()
+	}
+
+	return rec
+}
+
+// convertLegacy rewrites a file of "flag - short - long - cookie[ - created]" lines as a log.
+func convertLegacy(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("can't open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+
+	if _, ok := parseLine(scanner.Text()); !ok {
+		return nil
+	}
+
+	if _, err = file.Seek(0, 0); err != nil {
+		return err
+	}
+
+	return wal.WriteFile(path, func(write func(v any) error) error {
+		scanner = bufio.NewScanner(file)
+		for id := 1; scanner.Scan(); id++ {
+			link, ok := parseLine(scanner.Text())
+			if !ok {
+				return fmt.Errorf("can't convert %s: invalid line %d", path, id)
+			}
+
+			if err := write(newRecord(&entry{id: id, link: link})); err != nil {
+				return err
+			}
+		}
+
+		return scanner.Err()
+	})
+}
+
+// parseLine parses a line of the old format, flag 0 means deleted, created is a unix time.
+func parseLine(line string) (storage.Link, bool) {
+	split := strings.Split(line, " - ")
+	if len(split) != 4 && len(split) != 5 || split[0] != "0" && split[0] != "1" {
+		return storage.Link{}, false
+	}
 
-	scanner := bufio.NewScanner(fs.File)
-	var users = make(map[string]struct{}, 100)
-	for scanner.Scan() {
-		line := scanner.Text()
-		split := strings.Split(line, " - ")
-		if len(split) > 3 {
-			users[split[3]] = struct{}{}
+	link := storage.Link{Short: split[1], Long: split[2], Owner: split[3], Deleted: split[0] == "0"}
+	if len(split) == 5 {
+		created, err := strconv.ParseInt(split[4], 10, 64)
+		if err != nil {
+			return storage.Link{}, false
 		}
+		link.CreatedAt = time.Unix(created, 0).UTC()
 	}
 
-	return len(users), nil
+	return link, true
 }
@@ -2,12 +2,19 @@ package filestorage
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/service"
+	"url-shortener/internal/storage/storagetest"
 	shortener "url-shortener/pkg/api"
+
+	"github.com/stretchr/testify/assert"
 )
 
 var TestDB storage.IStorage
@@ -15,14 +22,18 @@ var TestDB storage.IStorage
 func TestMain(m *testing.M) {
 	// Write code here to run before tests
 	var err error
-	TestDB, err = NewFileStorage("test.txt")
+	TestDB, err = NewFileStorage(Config{Path: "test.txt"})
 	if err != nil {
 		log.Fatalf("Err temp file was not removed: %v", err)
 	}
 	// Run tests
 	c := m.Run()
-	if os.Remove("test.txt") != nil {
-		log.Fatalf("Err temp file was not removed: %v", err)
+	TestDB.Shutdown()
+	files := []string{"test.txt", "test.txt.audit", "test.txt.options", "test.txt.clicks", "test.txt.idempotency"}
+	for _, name := range files {
+		if os.Remove(name) != nil || os.Remove(name+".lock") != nil {
+			log.Fatalf("Err temp file %s was not removed", name)
+		}
 	}
 	os.Exit(c)
 }
@@ -189,7 +200,7 @@ func TestPostgres_MarkAsDeleted(t *testing.T) {
 	ctx := context.Background()
 	ShortURL := "qwe"
 	cookie := "qwsa"
-	TestDB.MarkAsDeleted(ShortURL, cookie)
+	TestDB.MarkAsDeleted(ctx, ShortURL, cookie)
 
 	_, err := TestDB.GetLongLink(ctx, ShortURL)
 	if err == nil {
@@ -204,3 +215,177 @@ func TestPostgres_Ping(t *testing.T) {
 		t.Errorf("Ping() error = %v", err)
 	}
 }
+
+func TestFileStorage_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.log")
+
+	open := func() *FileStorage {
+		t.Helper()
+
+		st, err := NewFileStorage(Config{Path: path, CompactInterval: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return st.(*FileStorage)
+	}
+
+	fs := open()
+	for _, link := range []storage.Link{
+		{Short: "a", Long: "https://a.ru/?q=x - y", Owner: "alice"},
+		{Short: "b", Long: "https://b.ru", Owner: "alice"},
+		{Short: "c", Long: "https://c.ru", Owner: "bob"},
+	} {
+		if _, err := fs.AddLink(ctx, link.Long, link.Short, link.Owner); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := fs.AddLink(ctx, "https://other.ru", "a", "bob")
+	assert.True(t, errors.Is(err, service.ErrExists), err)
+
+	assert.NoError(t, fs.MarkAsDeleted(ctx, "b", "alice"))
+	assert.NoError(t, fs.PurgeLink(ctx, "c"))
+	assert.NoError(t, fs.Shutdown())
+
+	check := func(fs *FileStorage) {
+		t.Helper()
+
+		long, err := fs.GetLongLink(ctx, "a")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://a.ru/?q=x - y", long)
+
+		_, err = fs.GetLongLink(ctx, "b")
+		assert.True(t, errors.Is(err, storage.ErrDeleted), err)
+
+		_, err = fs.GetLongLink(ctx, "c")
+		assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+
+		// ids are not reused after a purge
+		id, err := fs.FindMaxID(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, id)
+
+		links, err := fs.GetAllLinksByCookie(ctx, "alice", "http://localhost/")
+		assert.NoError(t, err)
+		assert.Equal(t, []*shortener.UserURL{
+			{OriginalUrl: "https://a.ru/?q=x - y", ShortUrl: "http://localhost/a"},
+			{OriginalUrl: "https://b.ru", ShortUrl: "http://localhost/b", Deleted: true},
+		}, links)
+	}
+
+	fs = open()
+	check(fs)
+
+	before, _ := os.Stat(path)
+	assert.NoError(t, fs.Compact())
+	after, _ := os.Stat(path)
+	assert.Less(t, after.Size(), before.Size())
+	assert.NoError(t, fs.Shutdown())
+
+	fs = open()
+	check(fs)
+
+	// a crash in the middle of a write leaves a torn record
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteString(`1234abcd {"op":"put","id":4,"short":"d"`)
+	file.Close()
+	assert.NoError(t, err)
+	assert.NoError(t, fs.Shutdown())
+
+	fs = open()
+	check(fs)
+
+	_, err = fs.AddLink(ctx, "https://d.ru", "d", "bob")
+	assert.NoError(t, err)
+	assert.NoError(t, fs.Shutdown())
+
+	fs = open()
+	defer fs.Shutdown()
+
+	long, err := fs.GetLongLink(ctx, "d")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://d.ru", long)
+}
+
+func TestNewFileStorage_Legacy(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urlshortener.txt")
+
+	legacy := "1 - zE - https://ya.ru - alice - 1700000000\n0 - Xz - https://go.dev - bob\n"
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := NewFileStorage(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Shutdown()
+
+	var links []storage.Link
+	err = st.(storage.IAdmin).Links(ctx, func(link storage.Link) error {
+		links = append(links, link)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.Link{
+		{Short: "zE", Long: "https://ya.ru", Owner: "alice", CreatedAt: time.Unix(1700000000, 0).UTC()},
+		{Short: "Xz", Long: "https://go.dev", Owner: "bob", Deleted: true},
+	}, links)
+
+	id, err := st.FindMaxID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, id)
+}
+
+func TestFileStorage_AddLinks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.log")
+
+	st, err := NewFileStorage(Config{Path: path, CompactInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = st.AddLink(ctx, "https://a.ru", "a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := st.AddLinks(ctx, []storage.Link{
+		{Short: "b", Long: "https://b.ru", Owner: "bob"},
+		{Short: "a", Long: "https://other.ru", Owner: "bob"},
+		{Short: "b", Long: "https://b2.ru", Owner: "bob"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.BatchResult{{Short: "b"}, {Short: "a", Exists: true}, {Short: "b", Exists: true}}, results)
+	assert.NoError(t, st.Shutdown())
+
+	st, err = NewFileStorage(Config{Path: path, CompactInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Shutdown()
+
+	long, err := st.GetLongLink(ctx, "b")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://b.ru", long)
+
+	id, err := st.FindMaxID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, id)
+}
+
+func TestFileStorage_Conformance(t *testing.T) {
+	st, err := NewFileStorage(Config{Path: filepath.Join(t.TempDir(), "links.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Shutdown()
+
+	storagetest.Run(t, st)
+}
@@ -0,0 +1,21 @@
+package filestorage
+
+import (
+	"context"
+	"url-shortener/internal/storage"
+)
+
+// ReserveKey saves the record unless the key is taken by a record that has not expired.
+func (fs *FileStorage) ReserveKey(ctx context.Context, rec storage.IdempotencyRecord) (storage.IdempotencyRecord, bool, error) {
+	return fs.keys.ReserveKey(ctx, rec)
+}
+
+// CompleteKey saves the response of the request with the key.
+func (fs *FileStorage) CompleteKey(ctx context.Context, key string, response []byte) error {
+	return fs.keys.CompleteKey(ctx, key, response)
+}
+
+// ReleaseKey removes the key.
+func (fs *FileStorage) ReleaseKey(ctx context.Context, key string) error {
+	return fs.keys.ReleaseKey(ctx, key)
+}
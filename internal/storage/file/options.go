@@ -0,0 +1,52 @@
+package filestorage
+
+import (
+	"context"
+	"url-shortener/internal/storage"
+)
+
+// GetLinkOptions returns the options of the link.
+func (fs *FileStorage) GetLinkOptions(ctx context.Context, shortURL string) (storage.LinkOptions, error) {
+	return fs.options.GetLinkOptions(ctx, shortURL)
+}
+
+// SetLinkOptions replaces the options of the link.
+func (fs *FileStorage) SetLinkOptions(ctx context.Context, shortURL string, opts storage.LinkOptions) error {
+	return fs.options.SetLinkOptions(ctx, shortURL, opts)
+}
+
+// withOptions adds the options and the visits left of the link kept by their stores.
+func (fs *FileStorage) withOptions(ctx context.Context, link storage.Link) (storage.Link, error) {
+	opts, err := fs.options.GetLinkOptions(ctx, link.Short)
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	link.Options = opts
+	if left, ok := fs.clicks.Left(link.Short); ok {
+		link.ClicksLeft = &left
+	}
+
+	return link, nil
+}
+
+// saveOptions saves the options and the visits left of the link that differ from the kept ones,
+// the zero ones drop them.
+func (fs *FileStorage) saveOptions(ctx context.Context, link storage.Link) error {
+	kept, err := fs.withOptions(ctx, storage.Link{Short: link.Short})
+	if err != nil {
+		return err
+	}
+
+	if kept.Options != link.Options {
+		if err = fs.options.SetLinkOptions(ctx, link.Short, link.Options); err != nil {
+			return err
+		}
+	}
+
+	if kept.MaxClicks() != link.MaxClicks() {
+		return fs.clicks.SetMaxClicks(ctx, link.Short, link.MaxClicks())
+	}
+
+	return nil
+}
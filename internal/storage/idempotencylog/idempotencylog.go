@@ -0,0 +1,225 @@
+// Package idempotencylog keeps the idempotency keys for the storages keeping their data in memory.
+//
+// The records are served from memory. The Store appends every change to a write-ahead log,
+// the log is rewritten with the records that have not expired when it is opened and when
+// the changes outnumber them.
+package idempotencylog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/wal"
+)
+
+var _ storage.IIdempotency = (*Store)(nil)
+
+// compactAfter the number of the changes since the last rewrite that allows the next one.
+const compactAfter = 1000
+
+// sweepInterval the period the expired records are removed from memory with.
+const sweepInterval = time.Minute
+
+// Store the idempotency records, safe for concurrent use.
+type Store struct {
+	mu      sync.Mutex
+	records map[string]storage.IdempotencyRecord
+	file    *wal.Log
+	// changes the records appended since the last rewrite.
+	changes   int
+	lastSweep time.Time
+}
+
+// record of the log, the record of a key replaces the earlier ones. Released removes it.
+type record struct {
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Response    []byte `json:"response,omitempty"`
+	Expires     int64  `json:"expires,omitempty"`
+	Released    bool   `json:"released,omitempty"`
+}
+
+// Open restores the Store from the file at path, creating it if needed.
+func Open(path string, policy wal.SyncPolicy) (*Store, error) {
+	if policy == "" {
+		policy = wal.SyncAlways
+	}
+
+	s := &Store{records: make(map[string]storage.IdempotencyRecord), lastSweep: time.Now()}
+
+	file, err := wal.Open(path, policy, func(data []byte) error {
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+
+		s.changes++
+		if r.Released {
+			delete(s.records, r.Key)
+			return nil
+		}
+
+		s.records[r.Key] = storage.IdempotencyRecord{Key: r.Key, Fingerprint: r.Fingerprint,
+			Response: r.Response, ExpiresAt: time.UnixMilli(r.Expires)}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't open the idempotency keys: %w", err)
+	}
+	s.file = file
+
+	s.sweep(time.Now())
+	if s.changes > len(s.records) {
+		if err = s.compact(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// ReserveKey saves the record unless the key is taken by a record that has not expired.
+func (s *Store) ReserveKey(ctx context.Context, rec storage.IdempotencyRecord) (storage.IdempotencyRecord, bool, error) {
+	if ctx.Err() != nil {
+		return storage.IdempotencyRecord{}, false, ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+		s.lastSweep = now
+	}
+
+	if found, ok := s.records[rec.Key]; ok && now.Before(found.ExpiresAt) {
+		return found, false, nil
+	}
+
+	if err := s.write(rec); err != nil {
+		return storage.IdempotencyRecord{}, false, fmt.Errorf("error reserving key: %w", err)
+	}
+
+	return rec, true, nil
+}
+
+// CompleteKey saves the response of the request with the key.
+func (s *Store) CompleteKey(ctx context.Context, key string, response []byte) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.records[key]
+	if !ok {
+		return nil
+	}
+	rec.Response = response
+
+	if err := s.write(rec); err != nil {
+		return fmt.Errorf("error saving key: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseKey removes the key.
+func (s *Store) ReleaseKey(ctx context.Context, key string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[key]; !ok {
+		return nil
+	}
+
+	if err := s.append(record{Key: key, Released: true}); err != nil {
+		return fmt.Errorf("error removing key: %w", err)
+	}
+	delete(s.records, key)
+	s.maybeCompact()
+
+	return nil
+}
+
+// Close closes the file of the Store.
+func (s *Store) Close() error {
+	return s.file.Close()
+}
+
+// write logs the record and keeps it, s.mu must be held.
+func (s *Store) write(rec storage.IdempotencyRecord) error {
+	err := s.append(record{Key: rec.Key, Fingerprint: rec.Fingerprint, Response: rec.Response,
+		Expires: rec.ExpiresAt.UnixMilli()})
+	if err != nil {
+		return err
+	}
+	s.records[rec.Key] = rec
+	s.maybeCompact()
+
+	return nil
+}
+
+// append logs the record, s.mu must be held.
+func (s *Store) append(r record) error {
+	if err := s.file.Append(r); err != nil {
+		return err
+	}
+	s.changes++
+
+	return nil
+}
+
+// maybeCompact rewrites the log when the changes outnumber the records, s.mu must be held.
+// A failed rewrite leaves the log as it is, the change is logged already.
+func (s *Store) maybeCompact() {
+	if s.changes < compactAfter || s.changes < 2*len(s.records) {
+		return
+	}
+
+	s.sweep(time.Now())
+	if err := s.compact(); err != nil {
+		log.Printf("idempotencylog: %v", err)
+	}
+}
+
+// sweep removes the records expired by now, s.mu must be held unless the Store is not opened yet.
+func (s *Store) sweep(now time.Time) {
+	for key, rec := range s.records {
+		if !now.Before(rec.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// compact rewrites the log with the records, s.mu must be held unless the Store is not opened yet.
+func (s *Store) compact() error {
+	err := s.file.Rewrite(func(write func(v any) error) error {
+		for _, rec := range s.records {
+			err := write(record{Key: rec.Key, Fingerprint: rec.Fingerprint, Response: rec.Response,
+				Expires: rec.ExpiresAt.UnixMilli()})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can't compact the idempotency keys: %w", err)
+	}
+	s.changes = len(s.records)
+
+	return nil
+}
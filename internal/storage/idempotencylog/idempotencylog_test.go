@@ -0,0 +1,82 @@
+package idempotencylog
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/wal"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keys")
+
+	s, err := Open(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	rec := storage.IdempotencyRecord{Key: "a", Fingerprint: "f", ExpiresAt: expires}
+
+	got, ok, err := s.ReserveKey(ctx, rec)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, rec, got)
+
+	_, ok, err = s.ReserveKey(ctx, storage.IdempotencyRecord{Key: "a", Fingerprint: "other", ExpiresAt: expires})
+	assert.NoError(t, err)
+	assert.False(t, ok, "the key is taken")
+
+	assert.NoError(t, s.CompleteKey(ctx, "a", []byte("response")))
+
+	_, _, err = s.ReserveKey(ctx, storage.IdempotencyRecord{Key: "released", ExpiresAt: expires})
+	assert.NoError(t, err)
+	assert.NoError(t, s.ReleaseKey(ctx, "released"))
+
+	_, _, err = s.ReserveKey(ctx, storage.IdempotencyRecord{Key: "expired", ExpiresAt: time.Now().Add(-time.Second)})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	s, err = Open(path, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+
+	got, ok, err = s.ReserveKey(ctx, storage.IdempotencyRecord{Key: "a", Fingerprint: "f", ExpiresAt: expires})
+	assert.NoError(t, err)
+	assert.False(t, ok, "the key is restored")
+	assert.Equal(t, storage.IdempotencyRecord{Key: "a", Fingerprint: "f", Response: []byte("response"), ExpiresAt: expires}, got)
+
+	// the log was compacted
+	assert.Len(t, s.records, 1)
+	assert.Equal(t, 1, s.changes)
+}
+
+func TestStore_Compact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keys")
+
+	s, err := Open(path, wal.SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	expires := time.Now().Add(time.Hour)
+	for i := 0; i < compactAfter; i++ {
+		key := fmt.Sprintf("k%d", i)
+		_, _, err = s.ReserveKey(ctx, storage.IdempotencyRecord{Key: key, ExpiresAt: expires})
+		assert.NoError(t, err)
+		assert.NoError(t, s.ReleaseKey(ctx, key))
+	}
+
+	assert.Empty(t, s.records)
+	assert.Less(t, s.changes, compactAfter, "the released keys are compacted away")
+}
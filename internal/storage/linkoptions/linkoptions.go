@@ -0,0 +1,128 @@
+// Package linkoptions implements storage.ILinkOptions for the storages keeping their data in memory.
+//
+// The options are served from memory. A persistent Store appends every change to a write-ahead log,
+// the log is rewritten with the current options when it is opened.
+package linkoptions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/wal"
+)
+
+var _ storage.ILinkOptions = (*Store)(nil)
+
+// Store the options of the links, safe for concurrent use.
+type Store struct {
+	mu      sync.RWMutex
+	options map[string]storage.LinkOptions
+	// file is nil if the store is kept in memory only.
+	file *wal.Log
+}
+
+// record of the log, the options of a link replace the earlier ones.
+type record struct {
+	Short   string              `json:"short"`
+	Options storage.LinkOptions `json:"options"`
+}
+
+// NewMemory returns a Store kept in memory only.
+func NewMemory() *Store {
+	return &Store{options: make(map[string]storage.LinkOptions)}
+}
+
+// Open restores the Store from the file at path, creating it if needed.
+func Open(path string, policy wal.SyncPolicy) (*Store, error) {
+	if policy == "" {
+		policy = wal.SyncAlways
+	}
+
+	s := NewMemory()
+	records := 0
+
+	file, err := wal.Open(path, policy, func(data []byte) error {
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+
+		records++
+		s.set(r.Short, r.Options)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't open the link options: %w", err)
+	}
+	s.file = file
+
+	if records > len(s.options) {
+		err = file.Rewrite(func(write func(v any) error) error {
+			for short, opts := range s.options {
+				if err := write(record{Short: short, Options: opts}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("can't compact the link options: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// GetLinkOptions returns the options of the link, the zero ones if they were not set.
+func (s *Store) GetLinkOptions(ctx context.Context, shortURL string) (storage.LinkOptions, error) {
+	if ctx.Err() != nil {
+		return storage.LinkOptions{}, ctx.Err()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.options[shortURL], nil
+}
+
+// SetLinkOptions replaces the options of the link.
+func (s *Store) SetLinkOptions(ctx context.Context, shortURL string, opts storage.LinkOptions) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file != nil {
+		if err := s.file.Append(record{Short: shortURL, Options: opts}); err != nil {
+			return fmt.Errorf("can't write the link options: %w", err)
+		}
+	}
+	s.set(shortURL, opts)
+
+	return nil
+}
+
+// set keeps only the links having options.
+func (s *Store) set(shortURL string, opts storage.LinkOptions) {
+	if opts == (storage.LinkOptions{}) {
+		delete(s.options, shortURL)
+		return
+	}
+
+	s.options[shortURL] = opts
+}
+
+// Close closes the file of a persistent Store.
+func (s *Store) Close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	return s.file.Close()
+}
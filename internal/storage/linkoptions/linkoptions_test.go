@@ -0,0 +1,45 @@
+package linkoptions
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"url-shortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "options")
+
+	s, err := Open(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, s.SetLinkOptions(ctx, "a", storage.LinkOptions{Title: "first"}))
+	assert.NoError(t, s.SetLinkOptions(ctx, "a", storage.LinkOptions{Title: "second", Preview: true, PasswordHash: "hash"}))
+	assert.NoError(t, s.SetLinkOptions(ctx, "b", storage.LinkOptions{Preview: true}))
+	assert.NoError(t, s.SetLinkOptions(ctx, "b", storage.LinkOptions{}))
+	assert.NoError(t, s.Close())
+
+	// the options are restored and the log is compacted
+	for i := 0; i < 2; i++ {
+		s, err = Open(path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts, err := s.GetLinkOptions(ctx, "a")
+		assert.NoError(t, err)
+		assert.Equal(t, storage.LinkOptions{Title: "second", Preview: true, PasswordHash: "hash"}, opts)
+
+		opts, err = s.GetLinkOptions(ctx, "b")
+		assert.NoError(t, err)
+		assert.Equal(t, storage.LinkOptions{}, opts)
+
+		assert.Len(t, s.options, 1)
+		assert.NoError(t, s.Close())
+	}
+}
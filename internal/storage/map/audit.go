@@ -0,0 +1,16 @@
+package mapstorage
+
+import (
+	"context"
+	"url-shortener/internal/storage"
+)
+
+// AppendAudit saves the entries to the audit log.
+func (s *MapStorage) AppendAudit(ctx context.Context, entries ...storage.AuditEntry) error {
+	return s.audit.AppendAudit(ctx, entries...)
+}
+
+// AuditEntries returns the entries of the audit log selected by the filter.
+func (s *MapStorage) AuditEntries(ctx context.Context, filter storage.AuditFilter) ([]storage.AuditEntry, error) {
+	return s.audit.AuditEntries(ctx, filter)
+}
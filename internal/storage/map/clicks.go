@@ -0,0 +1,13 @@
+package mapstorage
+
+import "context"
+
+// SetMaxClicks limits the visits of the link.
+func (s *MapStorage) SetMaxClicks(ctx context.Context, shortURL string, n int) error {
+	return s.clicks.SetMaxClicks(ctx, shortURL, n)
+}
+
+// UseClick takes a visit of the link.
+func (s *MapStorage) UseClick(ctx context.Context, shortURL string) (int, error) {
+	return s.clicks.UseClick(ctx, shortURL)
+}
@@ -3,20 +3,50 @@ package mapstorage
 import (
 	"context"
 	"errors"
+	"sort"
 	"sync"
+	"time"
 	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/auditlog"
+	"url-shortener/internal/storage/clicklimit"
 	"url-shortener/internal/storage/db/service"
+	"url-shortener/internal/storage/linkoptions"
+	"url-shortener/internal/storage/wal"
 	shortener "url-shortener/pkg/api"
+
+	"google.golang.org/protobuf/proto"
 )
 
 var (
-	_ storage.IStorage = (*MapStorage)(nil)
+	_ storage.IStorage     = (*MapStorage)(nil)
+	_ storage.IAdmin       = (*MapStorage)(nil)
+	_ storage.IAudit       = (*MapStorage)(nil)
+	_ storage.ILinkOptions = (*MapStorage)(nil)
+	_ storage.IClickLimit  = (*MapStorage)(nil)
 )
 
 // MapStorage struct with a map and mutex for concurent use.
+// A persistent MapStorage saves the map to a snapshot and logs the mutations made after it.
 type MapStorage struct {
 	mu        sync.RWMutex
 	container map[shortURL]data
+	// seq the order of the last added link.
+	seq int
+
+	// log is nil if the storage is not persistent.
+	log      *wal.Log
+	snapshot string
+	audit    *auditlog.Log
+	options  *linkoptions.Store
+	clicks   *clicklimit.Store
+
+	// snapshotMu serializes the snapshots.
+	snapshotMu sync.Mutex
+	// since the records logged while a snapshot is written, nil if none is.
+	since []record
+
+	stop chan struct{}
+	done chan struct{}
 }
 
 // MapStorageType ...
@@ -30,12 +60,16 @@ type data struct {
 	cookie  string
 	longURL string
 	deleted bool
+	created time.Time
+	// seq the order the link was added in.
+	seq int
 }
 
 // NewMapStorage constructor for storage.IStorage with map implementation.
 func NewMapStorage() storage.IStorage {
 	db := make(map[shortURL]data, 10)
-	return &MapStorage{container: db}
+	return &MapStorage{container: db, audit: auditlog.NewMemory(), options: linkoptions.NewMemory(),
+		clicks: clicklimit.NewMemory()}
 }
 
 // AddLink adds a link to the repository.
@@ -50,12 +84,67 @@ func (s *MapStorage) AddLink(ctx context.Context, longURL, ShortURL, cookie stri
 		return ShortURL, service.ErrExists
 	}
 
-	s.container[shortURL(ShortURL)] = data{cookie: cookie, longURL: longURL}
+	// drops the options left by an interrupted purge
+	if err := s.saveOptions(ctx, storage.Link{Short: ShortURL}); err != nil {
+		return "", err
+	}
+
+	err := s.put(ShortURL, data{cookie: cookie, longURL: longURL, created: time.Now().UTC(), seq: s.seq + 1})
+	if err != nil {
+		return "", err
+	}
 
 	return ShortURL, nil
 }
 
-// FindMaxID gets len of the repository.
+// AddLinks adds the links under one lock and logs them by one write, the options and the visits left
+// of the links are saved before them. A link exists if its short URL is taken, by a stored link
+// or an earlier one of the batch.
+func (s *MapStorage) AddLinks(ctx context.Context, links []storage.Link) ([]storage.BatchResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]storage.BatchResult, len(links))
+	fresh := make(map[string]data, len(links))
+	records := make([]any, 0, len(links))
+	created := time.Now().UTC()
+
+	for i, link := range links {
+		results[i].Short = link.Short
+
+		_, stored := s.container[shortURL(link.Short)]
+		if _, added := fresh[link.Short]; stored || added {
+			results[i].Exists = true
+			continue
+		}
+
+		if err := s.saveOptions(ctx, link); err != nil {
+			return nil, err
+		}
+
+		d := data{cookie: link.Owner, longURL: link.Long, created: created, seq: s.seq + len(records) + 1}
+		fresh[link.Short] = d
+		records = append(records, newRecord(link.Short, d))
+	}
+
+	if s.log != nil && len(records) > 0 {
+		if err := s.log.Append(records...); err != nil {
+			return nil, err
+		}
+	}
+
+	for short, d := range fresh {
+		s.set(short, d)
+	}
+
+	return results, nil
+}
+
+// FindMaxID returns the last id given to a link, the purged links keep theirs taken.
 func (s *MapStorage) FindMaxID(ctx context.Context) (int, error) {
 	if ctx.Err() != nil {
 		return 0, ctx.Err()
@@ -64,7 +153,7 @@ func (s *MapStorage) FindMaxID(ctx context.Context) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return len(s.container), nil
+	return s.seq, nil
 }
 
 // GetLongLink gets a long link from the repository.
@@ -78,43 +167,64 @@ func (s *MapStorage) GetLongLink(ctx context.Context, ShortURL string) (string,
 
 	record, ok := s.container[shortURL(ShortURL)]
 	if !ok {
-		return "", errors.New("короткой ссылки не существует")
+		return "", storage.ErrNotFound
 	}
 
 	if record.deleted {
 		return "", storage.ErrDeleted
 	}
 
+	if left, ok := s.clicks.Left(ShortURL); ok && left == 0 {
+		return "", storage.ErrExhausted
+	}
+
 	return record.longURL, nil
 }
 
-// GetAllLinksByCookie gets all links ([]schema.URL) by cookie.
+// GetAllLinksByCookie gets all links ([]schema.URL) by cookie in the order they were added.
 func (s *MapStorage) GetAllLinksByCookie(ctx context.Context, cookie, baseURL string) ([]*shortener.UserURL, error) {
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
 
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-	var links = make([]*shortener.UserURL, 0)
+	var seqs = make([]int, 0)
+	var links = make(map[int]*shortener.UserURL)
 
 	for short, dt := range s.container {
 		if dt.cookie == cookie {
-			links = append(links, &shortener.UserURL{OriginalUrl: dt.longURL, ShortUrl: baseURL + string(short)})
+			seqs = append(seqs, dt.seq)
+			links[dt.seq] = &shortener.UserURL{OriginalUrl: dt.longURL, ShortUrl: baseURL + string(short),
+				Deleted: dt.deleted}
+			if left, ok := s.clicks.Left(string(short)); ok {
+				links[dt.seq].ClicksLeft = proto.Int32(int32(left))
+			}
 		}
 	}
+	s.mu.RUnlock()
 
-	return links, nil
+	sort.Ints(seqs)
+
+	var ordered = make([]*shortener.UserURL, 0, len(seqs))
+	for _, seq := range seqs {
+		ordered = append(ordered, links[seq])
+	}
+
+	return ordered, nil
 }
 
 // MarkAsDeleted finds a URL and marks it as deleted.
-func (s *MapStorage) MarkAsDeleted(ShortURL, cookie string) error {
+func (s *MapStorage) MarkAsDeleted(ctx context.Context, ShortURL, cookie string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	Data, ok := s.container[shortURL(ShortURL)]
 	if !ok {
-		return errors.New("not found")
+		return storage.ErrNotFound
 	}
 
 	if cookie != Data.cookie {
@@ -122,9 +232,8 @@ func (s *MapStorage) MarkAsDeleted(ShortURL, cookie string) error {
 	}
 
 	Data.deleted = true
-	s.container[shortURL(ShortURL)] = Data
 
-	return nil
+	return s.put(ShortURL, Data)
 }
 
 // Ping checks connection with the repository.
@@ -140,10 +249,33 @@ func (s *MapStorage) Ping(ctx context.Context) error {
 	return nil
 }
 
-// Shutdown clears the repository.
+// Shutdown saves the snapshot of a persistent storage.
 func (s *MapStorage) Shutdown() error {
-	s.container = make(map[shortURL]data)
-	return nil
+	if s.log == nil {
+		return nil
+	}
+
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+
+	err := s.Snapshot()
+	if closeErr := s.log.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := s.audit.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := s.options.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := s.clicks.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
 }
 
 // URLsCount gets count of the repository.
@@ -174,3 +306,160 @@ func (s *MapStorage) UsersCount(ctx context.Context) (int, error) {
 
 	return len(users), nil
 }
+
+// GetLink gets the record including deleted ones.
+func (s *MapStorage) GetLink(ctx context.Context, ShortURL string) (storage.Link, error) {
+	if ctx.Err() != nil {
+		return storage.Link{}, ctx.Err()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.container[shortURL(ShortURL)]
+	if !ok {
+		return storage.Link{}, storage.ErrNotFound
+	}
+
+	return s.withOptions(ctx, record.link(ShortURL))
+}
+
+// DisableLink marks the link as deleted regardless of the owner.
+func (s *MapStorage) DisableLink(ctx context.Context, ShortURL string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.container[shortURL(ShortURL)]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	record.deleted = true
+
+	return s.put(ShortURL, record)
+}
+
+// PurgeLink removes the record, then its options and visits left.
+func (s *MapStorage) PurgeLink(ctx context.Context, ShortURL string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.container[shortURL(ShortURL)]; !ok {
+		return storage.ErrNotFound
+	}
+
+	if err := s.purge(ShortURL); err != nil {
+		return err
+	}
+
+	return s.saveOptions(ctx, storage.Link{Short: ShortURL})
+}
+
+// Links calls fn for every record ordered by the short URL.
+func (s *MapStorage) Links(ctx context.Context, fn func(storage.Link) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s.mu.RLock()
+	links := make([]storage.Link, 0, len(s.container))
+	for short, record := range s.container {
+		links = append(links, record.link(string(short)))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(links, func(i, j int) bool { return links[i].Short < links[j].Short })
+
+	for _, link := range links {
+		link, err := s.withOptions(ctx, link)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(link); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ImportLink saves the options and the visits left of the record, then the record itself,
+// so the link is never served without them.
+func (s *MapStorage) ImportLink(ctx context.Context, link storage.Link) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.container[shortURL(link.Short)]; ok {
+		return service.ErrExists
+	}
+
+	if err := s.saveOptions(ctx, link); err != nil {
+		return err
+	}
+
+	return s.put(link.Short, data{cookie: link.Owner, longURL: link.Long, deleted: link.Deleted,
+		created: link.CreatedAt, seq: s.seq + 1})
+}
+
+func (d data) link(short string) storage.Link {
+	return storage.Link{Short: short, Long: d.longURL, Owner: d.cookie, Deleted: d.deleted, CreatedAt: d.created}
+}
+
+// put logs and saves the link, s.mu must be locked.
+func (s *MapStorage) put(short string, d data) error {
+	if err := s.append(newRecord(short, d)); err != nil {
+		return err
+	}
+
+	s.set(short, d)
+
+	return nil
+}
+
+// purge logs and removes the link, s.mu must be locked.
+func (s *MapStorage) purge(short string) error {
+	if err := s.append(record{Op: opPurge, Short: short}); err != nil {
+		return err
+	}
+
+	delete(s.container, shortURL(short))
+
+	return nil
+}
+
+// append logs the record, s.mu must be locked.
+func (s *MapStorage) append(rec record) error {
+	if s.log == nil {
+		return nil
+	}
+
+	if err := s.log.Append(rec); err != nil {
+		return err
+	}
+
+	if s.since != nil {
+		s.since = append(s.since, rec)
+	}
+
+	return nil
+}
+
+func (s *MapStorage) set(short string, d data) {
+	s.container[shortURL(short)] = d
+	if d.seq > s.seq {
+		s.seq = d.seq
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"testing"
 	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/storagetest"
 	shortener "url-shortener/pkg/api"
 )
 
@@ -190,7 +191,7 @@ func TestPostgres_MarkAsDeleted(t *testing.T) {
 	ctx := context.Background()
 	ShortURL := "qwe"
 	cookie := "qwsa"
-	TestDB.MarkAsDeleted(ShortURL, cookie)
+	TestDB.MarkAsDeleted(ctx, ShortURL, cookie)
 
 	_, err := TestDB.GetLongLink(ctx, ShortURL)
 	if err == nil {
@@ -211,3 +212,40 @@ func TestPostgres_Shutdown(t *testing.T) {
 		t.Errorf("Shutdown() error = %v", err)
 	}
 }
+
+func TestMapStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, NewMapStorage())
+}
+
+func TestMapStorage_FindMaxID_Purged(t *testing.T) {
+	ctx := context.Background()
+	st := NewMapStorage().(*MapStorage)
+
+	for i, short := range []string{"a", "b"} {
+		if _, err := st.AddLink(ctx, "https://"+short+".com", short, "alice"); err != nil {
+			t.Fatal(err)
+		}
+
+		if id, err := st.FindMaxID(ctx); err != nil || id != i+1 {
+			t.Fatalf("FindMaxID() = %d, %v, want %d", id, err, i+1)
+		}
+	}
+
+	if err := st.PurgeLink(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next link must not get the id of "b" again
+	id, err := st.FindMaxID(ctx)
+	if err != nil || id != 2 {
+		t.Fatalf("FindMaxID() after PurgeLink() = %d, %v, want 2", id, err)
+	}
+
+	if _, err = st.AddLink(ctx, "https://c.com", "c", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err = st.FindMaxID(ctx); err != nil || id != 3 {
+		t.Errorf("FindMaxID() = %d, %v, want 3", id, err)
+	}
+}
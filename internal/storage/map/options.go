@@ -0,0 +1,52 @@
+package mapstorage
+
+import (
+	"context"
+	"url-shortener/internal/storage"
+)
+
+// GetLinkOptions returns the options of the link.
+func (s *MapStorage) GetLinkOptions(ctx context.Context, shortURL string) (storage.LinkOptions, error) {
+	return s.options.GetLinkOptions(ctx, shortURL)
+}
+
+// SetLinkOptions replaces the options of the link.
+func (s *MapStorage) SetLinkOptions(ctx context.Context, shortURL string, opts storage.LinkOptions) error {
+	return s.options.SetLinkOptions(ctx, shortURL, opts)
+}
+
+// withOptions adds the options and the visits left of the link kept by their stores.
+func (s *MapStorage) withOptions(ctx context.Context, link storage.Link) (storage.Link, error) {
+	opts, err := s.options.GetLinkOptions(ctx, link.Short)
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	link.Options = opts
+	if left, ok := s.clicks.Left(link.Short); ok {
+		link.ClicksLeft = &left
+	}
+
+	return link, nil
+}
+
+// saveOptions saves the options and the visits left of the link that differ from the kept ones,
+// the zero ones drop them.
+func (s *MapStorage) saveOptions(ctx context.Context, link storage.Link) error {
+	kept, err := s.withOptions(ctx, storage.Link{Short: link.Short})
+	if err != nil {
+		return err
+	}
+
+	if kept.Options != link.Options {
+		if err = s.options.SetLinkOptions(ctx, link.Short, link.Options); err != nil {
+			return err
+		}
+	}
+
+	if kept.MaxClicks() != link.MaxClicks() {
+		return s.clicks.SetMaxClicks(ctx, link.Short, link.MaxClicks())
+	}
+
+	return nil
+}
@@ -0,0 +1,275 @@
+package mapstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/auditlog"
+	"url-shortener/internal/storage/clicklimit"
+	"url-shortener/internal/storage/linkoptions"
+	"url-shortener/internal/storage/wal"
+)
+
+// DefaultSnapshotInterval the period of the snapshots if Config has none.
+const DefaultSnapshotInterval = 5 * time.Minute
+
+// ErrCorrupted occurs when the snapshot is damaged or incomplete.
+var ErrCorrupted = errors.New("corrupted snapshot")
+
+// Config of the persistent MapStorage.
+type Config struct {
+	// Path of the snapshot, the mutations after it are logged to Path + ".wal".
+	// The audit log is kept in Path + ".audit", the options of the links in Path + ".options"
+	// and the visits left of the limited links in Path + ".clicks".
+	Path string
+	// Interval the period of the snapshots, a negative one leaves only the one on Shutdown.
+	Interval time.Duration
+	// Sync fsync policy of the log, wal.SyncAlways is used if empty.
+	Sync wal.SyncPolicy
+}
+
+// Operations of the records.
+const (
+	opPut   = "put"
+	opPurge = "purge"
+	// opEnd closes the snapshot, a snapshot without it is incomplete.
+	opEnd = "end"
+)
+
+// record of the snapshot and the log.
+type record struct {
+	Op      string `json:"op"`
+	Seq     int    `json:"seq,omitempty"`
+	Short   string `json:"short,omitempty"`
+	Long    string `json:"long,omitempty"`
+	Owner   string `json:"owner,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Created int64  `json:"created,omitempty"`
+	// Count the number of links in the snapshot, only for opEnd.
+	Count int `json:"count,omitempty"`
+}
+
+// NewPersistentMapStorage restores MapStorage from the snapshot and the log of cfg.
+// The state is saved to the snapshot periodically and on Shutdown.
+func NewPersistentMapStorage(cfg Config) (storage.IStorage, error) {
+	if cfg.Sync == "" {
+		cfg.Sync = wal.SyncAlways
+	}
+
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultSnapshotInterval
+	}
+
+	s := &MapStorage{container: make(map[shortURL]data, 10), snapshot: cfg.Path}
+
+	if err := s.restore(); err != nil {
+		return nil, err
+	}
+
+	l, err := wal.Open(cfg.Path+".wal", cfg.Sync, s.replay)
+	if err != nil {
+		return nil, err
+	}
+	s.log = l
+
+	if s.audit, err = auditlog.Open(cfg.Path+".audit", cfg.Sync); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	if s.options, err = linkoptions.Open(cfg.Path+".options", cfg.Sync); err != nil {
+		s.audit.Close()
+		l.Close()
+		return nil, err
+	}
+
+	if s.clicks, err = clicklimit.Open(cfg.Path+".clicks", cfg.Sync); err != nil {
+		s.options.Close()
+		s.audit.Close()
+		l.Close()
+		return nil, err
+	}
+
+	if cfg.Interval > 0 {
+		s.stop, s.done = make(chan struct{}), make(chan struct{})
+		go s.snapshotter(cfg.Interval)
+	}
+
+	return s, nil
+}
+
+// Snapshot saves the state and empties the log.
+// The state is copied under the read lock and written without it, the records logged meanwhile
+// are kept in the log.
+func (s *MapStorage) Snapshot() error {
+	if s.log == nil {
+		return errors.New("the storage is not persistent")
+	}
+
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	s.mu.RLock()
+	records := make([]record, 0, len(s.container))
+	for short, d := range s.container {
+		records = append(records, newRecord(string(short), d))
+	}
+	seq := s.seq
+	// the writers that would log meanwhile wait for the read lock, the other snapshots for snapshotMu
+	s.since = []record{}
+	s.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Seq < records[j].Seq })
+
+	err := wal.WriteFile(s.snapshot, func(write func(v any) error) error {
+		for _, rec := range records {
+			if err := write(rec); err != nil {
+				return err
+			}
+		}
+
+		return write(record{Op: opEnd, Seq: seq, Count: len(records)})
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	since := s.since
+	s.since = nil
+
+	if err != nil {
+		return fmt.Errorf("can't save the snapshot: %w", err)
+	}
+
+	// the log is replayed over the snapshot if it is not emptied, the result is the same
+	err = s.log.Rewrite(func(write func(v any) error) error {
+		for _, rec := range since {
+			if err := write(rec); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can't empty the log: %w", err)
+	}
+
+	return nil
+}
+
+func (s *MapStorage) snapshotter(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.Snapshot(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// restore loads the snapshot if there is one.
+func (s *MapStorage) restore() error {
+	var (
+		count int
+		end   *record
+	)
+
+	err := wal.ReadFile(s.snapshot, func(data []byte) error {
+		if end != nil {
+			return errors.New("records after the end")
+		}
+
+		rec, err := decode(data)
+		if err != nil {
+			return err
+		}
+
+		switch rec.Op {
+		case opPut:
+			s.set(rec.Short, rec.data())
+			count++
+		case opEnd:
+			end = &rec
+		default:
+			return fmt.Errorf("unexpected operation %q", rec.Op)
+		}
+
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+
+	if end == nil || end.Count != count {
+		return fmt.Errorf("%w: %s is incomplete", ErrCorrupted, s.snapshot)
+	}
+
+	if end.Seq > s.seq {
+		s.seq = end.Seq
+	}
+
+	return nil
+}
+
+// replay applies a record of the log.
+func (s *MapStorage) replay(data []byte) error {
+	rec, err := decode(data)
+	if err != nil {
+		return err
+	}
+
+	switch rec.Op {
+	case opPut:
+		s.set(rec.Short, rec.data())
+	case opPurge:
+		delete(s.container, shortURL(rec.Short))
+	default:
+		return fmt.Errorf("unexpected operation %q", rec.Op)
+	}
+
+	return nil
+}
+
+func decode(data []byte) (record, error) {
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return record{}, err
+	}
+
+	return rec, nil
+}
+
+func newRecord(short string, d data) record {
+	rec := record{Op: opPut, Seq: d.seq, Short: short, Long: d.longURL, Owner: d.cookie, Deleted: d.deleted}
+	if !d.created.IsZero() {
+		rec.Created = d.created.UnixNano()
+	}
+
+	return rec
+}
+
+func (rec record) data() data {
+	d := data{cookie: rec.Owner, longURL: rec.Long, deleted: rec.Deleted, seq: rec.Seq}
+	if rec.Created != 0 {
+		d.created = time.Unix(0, rec.Created).UTC()
+	}
+
+	return d
+}
@@ -0,0 +1,225 @@
+package mapstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"url-shortener/internal/storage"
+	shortener "url-shortener/pkg/api"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openPersistent(t *testing.T, path string) *MapStorage {
+	t.Helper()
+
+	s, err := NewPersistentMapStorage(Config{Path: path, Interval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.(*MapStorage)
+}
+
+// crash closes the files of s without a snapshot, as the exit of the process does.
+func crash(s *MapStorage) {
+	s.log.Close()
+	s.audit.Close()
+	s.options.Close()
+	s.clicks.Close()
+}
+
+func TestPersistentMapStorage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.snapshot")
+
+	s := openPersistent(t, path)
+	for _, short := range []string{"b", "a", "c"} {
+		if _, err := s.AddLink(ctx, "https://"+short+".ru", short, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(s *MapStorage) {
+		t.Helper()
+
+		links, err := s.GetAllLinksByCookie(ctx, "alice", "/")
+		assert.NoError(t, err)
+		assert.Equal(t, []*shortener.UserURL{
+			{OriginalUrl: "https://b.ru", ShortUrl: "/b"},
+			{OriginalUrl: "https://a.ru", ShortUrl: "/a"},
+		}, links)
+
+		_, err = s.GetLongLink(ctx, "c")
+		assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+	}
+
+	if err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, s.PurgeLink(ctx, "c"))
+
+	// a crash: the purge is only in the log
+	crash(s)
+	s = openPersistent(t, path)
+	check(s)
+
+	assert.NoError(t, s.Shutdown())
+
+	wal, err := os.Stat(path + ".wal")
+	if assert.NoError(t, err) {
+		assert.Zero(t, wal.Size())
+	}
+
+	s = openPersistent(t, path)
+	check(s)
+	assert.NoError(t, s.Shutdown())
+}
+
+func TestMapStorage_SnapshotConcurrent(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.snapshot")
+
+	s := openPersistent(t, path)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < 50; i++ {
+				short := fmt.Sprintf("w%di%d", w, i)
+				if _, err := s.AddLink(ctx, "https://"+short+".ru", short, "alice"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	// the links added while a snapshot is written stay in the log
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, s.Snapshot())
+	}
+	wg.Wait()
+
+	// a crash: the links after the last snapshot are only in the log
+	crash(s)
+	s = openPersistent(t, path)
+	for w := 0; w < 4; w++ {
+		for i := 0; i < 50; i++ {
+			short := fmt.Sprintf("w%di%d", w, i)
+			long, err := s.GetLongLink(ctx, short)
+			if assert.NoError(t, err, short) {
+				assert.Equal(t, "https://"+short+".ru", long)
+			}
+		}
+	}
+	assert.NoError(t, s.Shutdown())
+}
+
+func TestNewPersistentMapStorage_Corrupted(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		damage func(data []byte) []byte
+	}{
+		{
+			name:   "flipped byte",
+			damage: func(data []byte) []byte { data[20] ^= 1; return data },
+		},
+		{
+			name: "last record cut",
+			damage: func(data []byte) []byte {
+				return data[:len(data)-3]
+			},
+		},
+		{
+			name: "end record missing",
+			damage: func(data []byte) []byte {
+				lines := 0
+				for i, b := range data {
+					if b == '\n' {
+						lines++
+					}
+					if lines == 2 {
+						return data[:i+1]
+					}
+				}
+				return data
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "links.snapshot")
+
+			s := openPersistent(t, path)
+			for _, short := range []string{"a", "b"} {
+				if _, err := s.AddLink(ctx, "https://"+short+".ru", short, "alice"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			assert.NoError(t, s.Shutdown())
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = os.WriteFile(path, tt.damage(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = NewPersistentMapStorage(Config{Path: path, Interval: -1})
+			assert.True(t, errors.Is(err, ErrCorrupted), err)
+		})
+	}
+}
+
+func TestMapStorage_AddLinks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.snapshot")
+
+	s := openPersistent(t, path)
+	if _, err := s.AddLink(ctx, "https://a.ru", "a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := s.AddLinks(ctx, []storage.Link{
+		{Short: "a", Long: "https://other.ru", Owner: "bob"},
+		{Short: "b", Long: "https://b.ru", Owner: "bob"},
+		{Short: "b", Long: "https://b2.ru", Owner: "bob"},
+		{Short: "c", Long: "https://c.ru", Owner: "bob"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.BatchResult{
+		{Short: "a", Exists: true},
+		{Short: "b"},
+		{Short: "b", Exists: true},
+		{Short: "c"},
+	}, results)
+
+	// the batch is in the log only
+	crash(s)
+	s = openPersistent(t, path)
+	defer s.Shutdown()
+
+	links, err := s.GetAllLinksByCookie(ctx, "bob", "/")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*shortener.UserURL{
+		{OriginalUrl: "https://b.ru", ShortUrl: "/b"},
+		{OriginalUrl: "https://c.ru", ShortUrl: "/c"},
+	}, links)
+
+	id, err := s.FindMaxID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, id)
+}
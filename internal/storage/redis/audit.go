@@ -0,0 +1,84 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"url-shortener/internal/storage"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// keyAudit sorted set of the JSON of the audit entries scored by their ids, it never expires.
+	keyAudit    = prefix + "audit"
+	keyAuditSeq = prefix + "audit:seq"
+)
+
+// AppendAudit saves the entries, the ids are given by INCRBY.
+func (r *RedisStorage) AppendAudit(ctx context.Context, entries ...storage.AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	last, err := r.client.IncrBy(ctx, keyAuditSeq, int64(len(entries))).Result()
+	if err != nil {
+		return fmt.Errorf("error saving audit entries: %w", err)
+	}
+
+	members := make([]redis.Z, len(entries))
+	for i, e := range entries {
+		e.ID, e.At = last-int64(len(entries)-i-1), e.At.UTC()
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		members[i] = redis.Z{Score: float64(e.ID), Member: data}
+	}
+
+	if err = r.client.ZAdd(ctx, keyAudit, members...).Err(); err != nil {
+		return fmt.Errorf("error saving audit entries: %w", err)
+	}
+
+	return nil
+}
+
+// AuditEntries returns the entries selected by the filter, they are read by scanBatch.
+func (r *RedisStorage) AuditEntries(ctx context.Context, filter storage.AuditFilter) ([]storage.AuditEntry, error) {
+	entries := make([]storage.AuditEntry, 0)
+	after := filter.AfterID
+
+	for {
+		page, err := r.client.ZRangeByScore(ctx, keyAudit, &redis.ZRangeBy{
+			Min:   "(" + strconv.FormatInt(after, 10),
+			Max:   "+inf",
+			Count: scanBatch,
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("error getting audit entries: %w", err)
+		}
+
+		for _, data := range page {
+			var e storage.AuditEntry
+			if err = json.Unmarshal([]byte(data), &e); err != nil {
+				return nil, fmt.Errorf("error getting audit entries: %w", err)
+			}
+			after = e.ID
+
+			if filter.Match(e) {
+				entries = append(entries, e)
+			}
+
+			if filter.Limit > 0 && len(entries) == filter.Limit {
+				return entries, nil
+			}
+		}
+
+		if len(page) < scanBatch {
+			return entries, nil
+		}
+	}
+}
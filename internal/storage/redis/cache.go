@@ -0,0 +1,256 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"url-shortener/internal/storage"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	_ storage.IStorage     = (*Cache)(nil)
+	_ storage.IAdmin       = (*Cache)(nil)
+	_ storage.IWrapper     = (*Cache)(nil)
+	_ storage.IClickLimit  = (*Cache)(nil)
+	_ storage.ILinkOptions = (*Cache)(nil)
+	_ storage.ITargets     = (*Cache)(nil)
+)
+
+// DefaultCacheTTL the ttl of the cached links if none is configured.
+const DefaultCacheTTL = 10 * time.Minute
+
+// Cached values, the JSON of the storage.Target follows the live mark.
+const (
+	cachedLive    = "1"
+	cachedDeleted = "0"
+)
+
+// Cache read-through Redis cache of GetLongLink and GetTarget in front of another storage.
+// The other methods are passed to the storage, the ones changing links drop the cached value.
+// Redis errors are logged and the storage is used instead.
+type Cache struct {
+	storage.IStorage
+	client redis.UniversalClient
+	ttl    time.Duration
+}
+
+// NewCache wraps next with the cache, the values expire after ttl, DefaultCacheTTL is used for zero.
+func NewCache(next storage.IStorage, client redis.UniversalClient, ttl time.Duration) storage.IStorage {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	return &Cache{IStorage: next, client: client, ttl: ttl}
+}
+
+func cacheKey(short string) string {
+	return prefix + "cache:" + short
+}
+
+// GetLongLink gets a long link from Redis or from the storage on a miss.
+func (c *Cache) GetLongLink(ctx context.Context, shortURL string) (string, error) {
+	t, err := c.GetTarget(ctx, shortURL)
+
+	return t.Long, err
+}
+
+// GetTarget gets the target of a link from Redis or from the storage on a miss.
+// A value cached in another format is a miss.
+func (c *Cache) GetTarget(ctx context.Context, shortURL string) (storage.Target, error) {
+	cached, err := c.client.Get(ctx, cacheKey(shortURL)).Result()
+	switch {
+	case err == nil && cached == cachedDeleted:
+		return storage.Target{}, storage.ErrDeleted
+	case err == nil && strings.HasPrefix(cached, cachedLive):
+		var t storage.Target
+		if json.Unmarshal([]byte(cached[len(cachedLive):]), &t) == nil && t.Long != "" {
+			return t, nil
+		}
+	case err != nil && !errors.Is(err, redis.Nil):
+		log.Println("redis cache: ", err)
+	}
+
+	t, err := storage.GetTarget(ctx, c.IStorage, shortURL)
+	switch {
+	case err == nil:
+		if b, err := json.Marshal(t); err == nil {
+			c.set(ctx, shortURL, cachedLive+string(b))
+		}
+	case errors.Is(err, storage.ErrDeleted):
+		c.set(ctx, shortURL, cachedDeleted)
+	}
+
+	return t, err
+}
+
+// MarkAsDeleted marks the link as deleted in the storage and drops it from the cache.
+func (c *Cache) MarkAsDeleted(ctx context.Context, shortURL, cookie string) error {
+	if err := c.IStorage.MarkAsDeleted(ctx, shortURL, cookie); err != nil {
+		return err
+	}
+
+	return c.forget(ctx, shortURL)
+}
+
+// Shutdown shuts the storage down and closes the client.
+func (c *Cache) Shutdown() error {
+	err := c.IStorage.Shutdown()
+	if closeErr := c.client.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Unwrap returns the cached storage.
+func (c *Cache) Unwrap() storage.IStorage {
+	return c.IStorage
+}
+
+// GetLink gets the record from the storage.
+func (c *Cache) GetLink(ctx context.Context, shortURL string) (storage.Link, error) {
+	admin, err := c.admin()
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	return admin.GetLink(ctx, shortURL)
+}
+
+// DisableLink disables the link in the storage and drops it from the cache.
+func (c *Cache) DisableLink(ctx context.Context, shortURL string) error {
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	if err = admin.DisableLink(ctx, shortURL); err != nil {
+		return err
+	}
+
+	return c.forget(ctx, shortURL)
+}
+
+// PurgeLink removes the link from the storage and the cache.
+func (c *Cache) PurgeLink(ctx context.Context, shortURL string) error {
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	if err = admin.PurgeLink(ctx, shortURL); err != nil {
+		return err
+	}
+
+	return c.forget(ctx, shortURL)
+}
+
+// Links calls fn for every record of the storage.
+func (c *Cache) Links(ctx context.Context, fn func(storage.Link) error) error {
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	return admin.Links(ctx, fn)
+}
+
+// ImportLink saves the record to the storage and drops a cached value of it.
+func (c *Cache) ImportLink(ctx context.Context, link storage.Link) error {
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	if err = admin.ImportLink(ctx, link); err != nil {
+		return err
+	}
+
+	return c.forget(ctx, link.Short)
+}
+
+// SetMaxClicks limits the visits of the link in the storage and drops it from the cache.
+func (c *Cache) SetMaxClicks(ctx context.Context, shortURL string, n int) error {
+	clicks, ok := storage.As[storage.IClickLimit](c.IStorage)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+
+	if err := clicks.SetMaxClicks(ctx, shortURL, n); err != nil {
+		return err
+	}
+
+	return c.forget(ctx, shortURL)
+}
+
+// UseClick takes a visit of the link in the storage, the link is dropped from the cache
+// when no visit is left. The visits are not limited if the storage can't limit them.
+func (c *Cache) UseClick(ctx context.Context, shortURL string) (int, error) {
+	clicks, ok := storage.As[storage.IClickLimit](c.IStorage)
+	if !ok {
+		return storage.Unlimited, nil
+	}
+
+	left, err := clicks.UseClick(ctx, shortURL)
+	if err == nil && left == 0 || errors.Is(err, storage.ErrExhausted) {
+		if forgetErr := c.forget(ctx, shortURL); forgetErr != nil {
+			log.Println("redis cache: ", forgetErr)
+		}
+	}
+
+	return left, err
+}
+
+// GetLinkOptions gets the options of the link from the storage.
+func (c *Cache) GetLinkOptions(ctx context.Context, shortURL string) (storage.LinkOptions, error) {
+	options, ok := storage.As[storage.ILinkOptions](c.IStorage)
+	if !ok {
+		return storage.LinkOptions{}, storage.ErrNotSupported
+	}
+
+	return options.GetLinkOptions(ctx, shortURL)
+}
+
+// SetLinkOptions replaces the options of the link in the storage and drops it from the cache.
+func (c *Cache) SetLinkOptions(ctx context.Context, shortURL string, opts storage.LinkOptions) error {
+	options, ok := storage.As[storage.ILinkOptions](c.IStorage)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+
+	if err := options.SetLinkOptions(ctx, shortURL, opts); err != nil {
+		return err
+	}
+
+	return c.forget(ctx, shortURL)
+}
+
+func (c *Cache) admin() (storage.IAdmin, error) {
+	admin, ok := storage.As[storage.IAdmin](c.IStorage)
+	if !ok {
+		return nil, storage.ErrNotSupported
+	}
+
+	return admin, nil
+}
+
+func (c *Cache) set(ctx context.Context, shortURL, value string) {
+	if err := c.client.Set(ctx, cacheKey(shortURL), value, c.ttl).Err(); err != nil {
+		log.Println("redis cache: ", err)
+	}
+}
+
+// forget drops the cached value, the change is already saved so a failure is only reported.
+func (c *Cache) forget(ctx context.Context, shortURL string) error {
+	if err := c.client.Del(ctx, cacheKey(shortURL)).Err(); err != nil {
+		return fmt.Errorf("the link is changed, but stays cached for up to %s: %w", c.ttl, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package redisstorage
+
+import (
+	"context"
+	"fmt"
+	"url-shortener/internal/storage"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The visits left of a limited link are the clicks field of its hash, so they expire with it.
+const fieldClicks = "clicks"
+
+// Results of useClick besides the visits left.
+const (
+	clicksUnlimited = -1
+	clicksExhausted = -2
+)
+
+// setClicks sets the clicks field of an existing link, 0 is returned for a missing one.
+var setClicks = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+if tonumber(ARGV[1]) < 0 then
+	redis.call('HDEL', KEYS[1], 'clicks')
+else
+	redis.call('HSET', KEYS[1], 'clicks', ARGV[1])
+end
+return 1
+`)
+
+// useClick decrements the clicks field if it is positive.
+var useClick = redis.NewScript(`
+local left = redis.call('HGET', KEYS[1], 'clicks')
+if not left then
+	return -1
+end
+if tonumber(left) <= 0 then
+	return -2
+end
+return redis.call('HINCRBY', KEYS[1], 'clicks', -1)
+`)
+
+// SetMaxClicks limits the visits of the link to n, storage.Unlimited removes the limit.
+func (r *RedisStorage) SetMaxClicks(ctx context.Context, shortURL string, n int) error {
+	found, err := setClicks.Run(ctx, r.client, []string{linkKey(shortURL)}, n).Int()
+	if err != nil {
+		return fmt.Errorf("error saving click limit: %w", err)
+	}
+
+	if found == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// UseClick takes a visit of the link by a script, Redis runs it atomically.
+func (r *RedisStorage) UseClick(ctx context.Context, shortURL string) (int, error) {
+	left, err := useClick.Run(ctx, r.client, []string{linkKey(shortURL)}).Int()
+	if err != nil {
+		return 0, fmt.Errorf("error using click: %w", err)
+	}
+
+	switch left {
+	case clicksUnlimited:
+		return storage.Unlimited, nil
+	case clicksExhausted:
+		return 0, storage.ErrExhausted
+	}
+
+	return left, nil
+}
@@ -0,0 +1,99 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"url-shortener/internal/storage"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// record JSON of an idempotency key.
+type record struct {
+	Fingerprint string `json:"fingerprint"`
+	Response    []byte `json:"response,omitempty"`
+	Expires     int64  `json:"expires"`
+}
+
+func idempotencyKey(key string) string {
+	return prefix + "idempotency:" + key
+}
+
+// ReserveKey saves the record by SET NX unless the key is taken, Redis expires the records.
+func (r *RedisStorage) ReserveKey(ctx context.Context, rec storage.IdempotencyRecord) (storage.IdempotencyRecord, bool, error) {
+	data, err := json.Marshal(record{Fingerprint: rec.Fingerprint, Expires: rec.ExpiresAt.UnixMilli()})
+	if err != nil {
+		return storage.IdempotencyRecord{}, false, err
+	}
+
+	key := idempotencyKey(rec.Key)
+	for {
+		ttl := time.Until(rec.ExpiresAt)
+		if ttl <= 0 {
+			return storage.IdempotencyRecord{}, false, errors.New("error reserving key: the record has expired")
+		}
+
+		err = r.client.SetArgs(ctx, key, data, redis.SetArgs{Mode: "NX", TTL: ttl}).Err()
+		if err == nil {
+			return rec, true, nil
+		} else if !errors.Is(err, redis.Nil) {
+			return storage.IdempotencyRecord{}, false, fmt.Errorf("error reserving key: %w", err)
+		}
+
+		stored, err := r.client.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			// expired or released in between
+			continue
+		} else if err != nil {
+			return storage.IdempotencyRecord{}, false, fmt.Errorf("error reserving key: %w", err)
+		}
+
+		var v record
+		if err = json.Unmarshal(stored, &v); err != nil {
+			return storage.IdempotencyRecord{}, false, fmt.Errorf("error reserving key: %w", err)
+		}
+
+		return storage.IdempotencyRecord{Key: rec.Key, Fingerprint: v.Fingerprint,
+			Response: v.Response, ExpiresAt: time.UnixMilli(v.Expires)}, false, nil
+	}
+}
+
+// CompleteKey saves the response of the request with the key keeping its TTL.
+func (r *RedisStorage) CompleteKey(ctx context.Context, key string, response []byte) error {
+	stored, err := r.client.Get(ctx, idempotencyKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error saving key: %w", err)
+	}
+
+	var v record
+	if err = json.Unmarshal(stored, &v); err != nil {
+		return fmt.Errorf("error saving key: %w", err)
+	}
+	v.Response = response
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	err = r.client.SetArgs(ctx, idempotencyKey(key), data, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("error saving key: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseKey removes the key.
+func (r *RedisStorage) ReleaseKey(ctx context.Context, key string) error {
+	if err := r.client.Del(ctx, idempotencyKey(key)).Err(); err != nil {
+		return fmt.Errorf("error removing key: %w", err)
+	}
+
+	return nil
+}
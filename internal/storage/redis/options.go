@@ -0,0 +1,60 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"url-shortener/internal/storage"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func optionsKey(short string) string {
+	return prefix + "options:" + short
+}
+
+// GetLinkOptions returns the options of the link, the zero ones if they were not set.
+func (r *RedisStorage) GetLinkOptions(ctx context.Context, shortURL string) (storage.LinkOptions, error) {
+	var opts storage.LinkOptions
+
+	data, err := r.client.Get(ctx, optionsKey(shortURL)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return opts, nil
+	} else if err != nil {
+		return opts, fmt.Errorf("error getting link options: %w", err)
+	}
+
+	if err = json.Unmarshal(data, &opts); err != nil {
+		return storage.LinkOptions{}, fmt.Errorf("error getting link options: %w", err)
+	}
+
+	return opts, nil
+}
+
+// SetLinkOptions replaces the options of the link, they expire with the link.
+func (r *RedisStorage) SetLinkOptions(ctx context.Context, shortURL string, opts storage.LinkOptions) error {
+	key := optionsKey(shortURL)
+	if opts == (storage.LinkOptions{}) {
+		return r.client.Del(ctx, key).Err()
+	}
+
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+
+	// a negative TTL means the link does not expire or does not exist
+	ttl, err := r.client.PTTL(ctx, linkKey(shortURL)).Result()
+	if err != nil {
+		return fmt.Errorf("error saving link options: %w", err)
+	} else if ttl < 0 {
+		ttl = 0
+	}
+
+	if err = r.client.Set(ctx, key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("error saving link options: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,566 @@
+// Package redisstorage implements storage.IStorage on Redis and a read-through Redis cache
+// in front of another storage.
+//
+// Keys of the storage:
+//
+//	shortener:link:<short>       hash of the link: id, long, owner, deleted, created, clicks of a limited link
+//	shortener:owner:<owner>      sorted set of the short URLs of the owner scored by id
+//	shortener:owners             set of the owners
+//	shortener:links              sorted set of every short URL scored by id
+//	shortener:seq                INCR counter of the ids
+//	shortener:idempotency:<key>  JSON of the idempotency record, expires with it
+//	shortener:audit              sorted set of the JSON of the audit entries scored by id
+//	shortener:audit:seq          INCRBY counter of the audit entry ids
+//	shortener:options:<short>    JSON of the options of the link, expires with the link
+//
+// Keys of one link are written by a pipeline, not a transaction, so they can live
+// on different nodes of a cluster. A link is claimed by a script setting its id and TTL
+// only if its hash is missing, the hash of a link is changed only by the scripts checking
+// it exists, so a link expired meanwhile is never written back without a TTL.
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/service"
+	shortener "url-shortener/pkg/api"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	_ storage.IStorage     = (*RedisStorage)(nil)
+	_ storage.IAdmin       = (*RedisStorage)(nil)
+	_ storage.IIdempotency = (*RedisStorage)(nil)
+	_ storage.IAudit       = (*RedisStorage)(nil)
+	_ storage.ILinkOptions = (*RedisStorage)(nil)
+	_ storage.IClickLimit  = (*RedisStorage)(nil)
+	_ storage.ITargets     = (*RedisStorage)(nil)
+)
+
+// RedisStorageType type for the Redis storage.
+const RedisStorageType storage.Type = "redis"
+
+const (
+	prefix    = "shortener:"
+	keyOwners = prefix + "owners"
+	keyLinks  = prefix + "links"
+	keySeq    = prefix + "seq"
+)
+
+// scanBatch the number of links read at once by Links.
+const scanBatch = 100
+
+// claimLink sets the id and the TTL in milliseconds of a missing link, 0 is returned for an existing one.
+var claimLink = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'id', ARGV[1])
+if tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// setLink sets the fields of an existing link, 0 is returned for a missing one.
+var setLink = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], unpack(ARGV))
+return 1
+`)
+
+// markDeleted marks the link as deleted, 0 is returned for a missing one or, if ARGV[1] is '1',
+// for the one of another owner than ARGV[2].
+var markDeleted = redis.NewScript(`
+local owner = redis.call('HGET', KEYS[1], 'owner')
+if not owner or (ARGV[1] == '1' and owner ~= ARGV[2]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'deleted', '1')
+return 1
+`)
+
+// RedisStorage struct with the Redis client.
+// It has methods for working with URLs.
+type RedisStorage struct {
+	client redis.UniversalClient
+	// ttl of the links, zero keeps them forever.
+	ttl time.Duration
+}
+
+// NewClient connects to Redis. addr is either a redis:// URL or a comma separated list
+// of host:port, several addresses connect to a cluster.
+func NewClient(addr string) (redis.UniversalClient, error) {
+	if strings.HasPrefix(addr, "redis://") || strings.HasPrefix(addr, "rediss://") {
+		opts, err := redis.ParseURL(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis URL: %w", err)
+		}
+
+		return redis.NewClient(opts), nil
+	}
+
+	if addr == "" {
+		return nil, errors.New("redis address is empty")
+	}
+
+	return redis.NewUniversalClient(&redis.UniversalOptions{Addrs: strings.Split(addr, ",")}), nil
+}
+
+// NewRedisStorage RedisStorage struct constructor.
+// Links expire after ttl, zero keeps them forever. Expired links stay in the counters
+// until GetAllLinksByCookie of their owner finds them missing.
+func NewRedisStorage(client redis.UniversalClient, ttl time.Duration) storage.IStorage {
+	return &RedisStorage{client: client, ttl: ttl}
+}
+
+func linkKey(short string) string {
+	return prefix + "link:" + short
+}
+
+func ownerKey(owner string) string {
+	return prefix + "owner:" + owner
+}
+
+// AddLink adds a link to the repository.
+func (r *RedisStorage) AddLink(ctx context.Context, longURL, shortURL, cookie string) (string, error) {
+	err := r.add(ctx, storage.Link{Short: shortURL, Long: longURL, Owner: cookie,
+		CreatedAt: time.Now().UTC().Truncate(time.Second)})
+	if errors.Is(err, service.ErrExists) {
+		return shortURL, err
+	} else if err != nil {
+		return "", err
+	}
+
+	return shortURL, nil
+}
+
+// AddLinks adds the links with their options and visits left one by one,
+// Redis has no transactions across the nodes of a cluster.
+// If a link fails, the ones added before it are purged. A link exists if its short URL is taken.
+func (r *RedisStorage) AddLinks(ctx context.Context, links []storage.Link) ([]storage.BatchResult, error) {
+	results := make([]storage.BatchResult, len(links))
+	created := time.Now().UTC().Truncate(time.Second)
+
+	for i, link := range links {
+		results[i].Short = link.Short
+
+		link.Deleted, link.CreatedAt = false, created
+		err := r.add(ctx, link)
+		if errors.Is(err, service.ErrExists) {
+			results[i].Exists = true
+			continue
+		} else if err == nil {
+			continue
+		}
+
+		// the links are purged even if ctx is done, the failed one may be half written
+		for j := i; j >= 0; j-- {
+			if !results[j].Exists {
+				r.PurgeLink(context.Background(), results[j].Short)
+			}
+		}
+
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// FindMaxID returns the last id given to a link.
+func (r *RedisStorage) FindMaxID(ctx context.Context) (int, error) {
+	id, err := r.client.Get(ctx, keySeq).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("error finding max id: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetLongLink gets a long link from the repository.
+func (r *RedisStorage) GetLongLink(ctx context.Context, shortURL string) (string, error) {
+	values, err := r.client.HMGet(ctx, linkKey(shortURL), targetFields...).Result()
+	if err != nil {
+		return "", fmt.Errorf("error getting long link: %w", err)
+	}
+
+	t, err := readTarget(values)
+
+	return t.Long, err
+}
+
+// GetTarget gets the link and its options by a single pipeline.
+func (r *RedisStorage) GetTarget(ctx context.Context, shortURL string) (storage.Target, error) {
+	var (
+		fields  *redis.SliceCmd
+		options *redis.StringCmd
+	)
+	_, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		fields = p.HMGet(ctx, linkKey(shortURL), targetFields...)
+		options = p.Get(ctx, optionsKey(shortURL))
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return storage.Target{}, fmt.Errorf("error getting link: %w", err)
+	}
+
+	t, err := readTarget(fields.Val())
+	if err != nil {
+		return storage.Target{}, err
+	}
+
+	if data, err := options.Bytes(); err == nil {
+		if err = json.Unmarshal(data, &t.Options); err != nil {
+			return storage.Target{}, fmt.Errorf("error getting link options: %w", err)
+		}
+	}
+
+	return t, nil
+}
+
+// targetFields the fields of the link hash read by readTarget.
+var targetFields = []string{"long", "deleted", fieldClicks}
+
+// readTarget returns the target of the values of targetFields, the errors are the ones of GetLongLink.
+func readTarget(values []any) (storage.Target, error) {
+	long, _ := values[0].(string)
+	if long == "" {
+		return storage.Target{}, storage.ErrNotFound
+	}
+
+	if deleted, _ := values[1].(string); deleted == "1" {
+		return storage.Target{}, storage.ErrDeleted
+	}
+
+	t := storage.Target{Long: long}
+	if clicks, ok := values[2].(string); ok && clicks != "" {
+		left, err := strconv.Atoi(clicks)
+		if err == nil && left <= 0 {
+			return storage.Target{}, storage.ErrExhausted
+		}
+		t.Limited = true
+	}
+
+	return t, nil
+}
+
+// GetAllLinksByCookie gets all links ([]schema.URL) by cookie in the order they were created.
+func (r *RedisStorage) GetAllLinksByCookie(ctx context.Context, cookie, baseURL string) ([]*shortener.UserURL, error) {
+	shorts, err := r.client.ZRange(ctx, ownerKey(cookie), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error getting links by cookie: %w", err)
+	}
+
+	links, missing, err := r.readLinks(ctx, shorts)
+	if err != nil {
+		return nil, fmt.Errorf("error getting links by cookie: %w", err)
+	}
+
+	if len(missing) > 0 {
+		if err = r.forget(ctx, cookie, missing); err != nil {
+			return nil, fmt.Errorf("error getting links by cookie: %w", err)
+		}
+	}
+
+	var urls = make([]*shortener.UserURL, 0, len(links))
+	for _, link := range links {
+		url := &shortener.UserURL{OriginalUrl: link.Long, ShortUrl: baseURL + link.Short, Deleted: link.Deleted}
+		if link.ClicksLeft != nil {
+			url.ClicksLeft = proto.Int32(int32(*link.ClicksLeft))
+		}
+		urls = append(urls, url)
+	}
+
+	return urls, nil
+}
+
+// Ping checks connection with Redis.
+func (r *RedisStorage) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// MarkAsDeleted finds a URL of the owner and marks it as deleted.
+func (r *RedisStorage) MarkAsDeleted(ctx context.Context, shortURL, cookie string) error {
+	return r.markDeleted(ctx, shortURL, true, cookie)
+}
+
+// Shutdown closes the client.
+func (r *RedisStorage) Shutdown() error {
+	return r.client.Close()
+}
+
+// URLsCount gets count of URLs in the repository.
+func (r *RedisStorage) URLsCount(ctx context.Context) (int, error) {
+	count, err := r.client.ZCard(ctx, keyLinks).Result()
+	return int(count), err
+}
+
+// UsersCount gets count of users in the repository.
+func (r *RedisStorage) UsersCount(ctx context.Context) (int, error) {
+	count, err := r.client.SCard(ctx, keyOwners).Result()
+	return int(count), err
+}
+
+// GetLink gets the record including deleted ones.
+func (r *RedisStorage) GetLink(ctx context.Context, shortURL string) (storage.Link, error) {
+	links, err := r.getLinks(ctx, []string{shortURL})
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("error getting link: %w", err)
+	}
+
+	if len(links) == 0 {
+		return storage.Link{}, storage.ErrNotFound
+	}
+
+	return links[0], nil
+}
+
+// DisableLink marks the link as deleted regardless of the owner.
+func (r *RedisStorage) DisableLink(ctx context.Context, shortURL string) error {
+	return r.markDeleted(ctx, shortURL, false, "")
+}
+
+// markDeleted marks the link as deleted by a script, so a link expiring meanwhile is not written back.
+func (r *RedisStorage) markDeleted(ctx context.Context, shortURL string, byOwner bool, owner string) error {
+	found, err := markDeleted.Run(ctx, r.client, []string{linkKey(shortURL)}, boolString(byOwner), owner).Int()
+	if err != nil {
+		return fmt.Errorf("error deleting link: %w", err)
+	}
+
+	if found == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// PurgeLink removes the link with its visits left, options and index entries.
+func (r *RedisStorage) PurgeLink(ctx context.Context, shortURL string) error {
+	link, err := r.GetLink(ctx, shortURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, linkKey(shortURL))
+		p.Del(ctx, optionsKey(shortURL))
+		p.ZRem(ctx, keyLinks, shortURL)
+		p.ZRem(ctx, ownerKey(link.Owner), shortURL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error purging link: %w", err)
+	}
+
+	return r.dropOwnerIfEmpty(ctx, link.Owner)
+}
+
+// Links calls fn for every record in the order they were created, expired links are skipped.
+func (r *RedisStorage) Links(ctx context.Context, fn func(storage.Link) error) error {
+	for start := int64(0); ; start += scanBatch {
+		shorts, err := r.client.ZRange(ctx, keyLinks, start, start+scanBatch-1).Result()
+		if err != nil {
+			return fmt.Errorf("error getting links: %w", err)
+		}
+
+		links, err := r.getLinks(ctx, shorts)
+		if err != nil {
+			return fmt.Errorf("error getting links: %w", err)
+		}
+
+		for _, link := range links {
+			if err = fn(link); err != nil {
+				return err
+			}
+		}
+
+		if len(shorts) < scanBatch {
+			return nil
+		}
+	}
+}
+
+// ImportLink saves the record with its options and visits left.
+func (r *RedisStorage) ImportLink(ctx context.Context, link storage.Link) error {
+	return r.add(ctx, link)
+}
+
+// add claims the short URL with a new id and writes the link with its options and indexes.
+func (r *RedisStorage) add(ctx context.Context, link storage.Link) error {
+	id, err := r.client.Incr(ctx, keySeq).Result()
+	if err != nil {
+		return fmt.Errorf("error adding link: %w", err)
+	}
+
+	key := linkKey(link.Short)
+
+	claimed, err := claimLink.Run(ctx, r.client, []string{key}, id, r.ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("error adding link: %w", err)
+	} else if claimed == 0 {
+		return service.ErrExists
+	}
+
+	fields := []any{"long", link.Long, "owner", link.Owner, "deleted", boolString(link.Deleted)}
+	if !link.CreatedAt.IsZero() {
+		fields = append(fields, "created", link.CreatedAt.Unix())
+	}
+	if link.ClicksLeft != nil {
+		fields = append(fields, fieldClicks, *link.ClicksLeft)
+	}
+
+	// the options may live on another node, they are written before the link is readable
+	if link.Options != (storage.LinkOptions{}) {
+		options, err := json.Marshal(link.Options)
+		if err != nil {
+			return fmt.Errorf("error adding link: %w", err)
+		}
+
+		if err = r.client.Set(ctx, optionsKey(link.Short), options, r.ttl).Err(); err != nil {
+			return fmt.Errorf("error adding link: %w", err)
+		}
+	}
+
+	written, err := setLink.Run(ctx, r.client, []string{key}, fields...).Int()
+	if err != nil {
+		return fmt.Errorf("error adding link: %w", err)
+	} else if written == 0 {
+		return errors.New("error adding link: it expired while being added")
+	}
+
+	_, err = r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZAdd(ctx, keyLinks, redis.Z{Score: float64(id), Member: link.Short})
+		p.ZAdd(ctx, ownerKey(link.Owner), redis.Z{Score: float64(id), Member: link.Short})
+		p.SAdd(ctx, keyOwners, link.Owner)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error adding link: %w", err)
+	}
+
+	return nil
+}
+
+// getLinks reads the links with their options in the order of shorts, missing ones are skipped.
+func (r *RedisStorage) getLinks(ctx context.Context, shorts []string) ([]storage.Link, error) {
+	links, _, err := r.readLinks(ctx, shorts)
+	if err != nil || len(links) == 0 {
+		return links, err
+	}
+
+	cmds := make([]*redis.StringCmd, len(links))
+	_, err = r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, link := range links {
+			cmds[i] = p.Get(ctx, optionsKey(link.Short))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	for i, cmd := range cmds {
+		data, err := cmd.Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal(data, &links[i].Options); err != nil {
+			return nil, err
+		}
+	}
+
+	return links, nil
+}
+
+// readLinks reads the links in the order of shorts and returns the missing ones separately.
+func (r *RedisStorage) readLinks(ctx context.Context, shorts []string) ([]storage.Link, []string, error) {
+	if len(shorts) == 0 {
+		return nil, nil, nil
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, len(shorts))
+	_, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, short := range shorts {
+			cmds[i] = p.HGetAll(ctx, linkKey(short))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var missing []string
+	links := make([]storage.Link, 0, len(shorts))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if fields["long"] == "" {
+			// expired or half written
+			missing = append(missing, shorts[i])
+			continue
+		}
+
+		link := storage.Link{Short: shorts[i], Long: fields["long"], Owner: fields["owner"],
+			Deleted: fields["deleted"] == "1"}
+		if created, err := strconv.ParseInt(fields["created"], 10, 64); err == nil {
+			link.CreatedAt = time.Unix(created, 0).UTC()
+		}
+		if left, err := strconv.Atoi(fields[fieldClicks]); err == nil {
+			link.ClicksLeft = &left
+		}
+
+		links = append(links, link)
+	}
+
+	return links, missing, nil
+}
+
+// forget removes expired links of the owner from the indexes.
+func (r *RedisStorage) forget(ctx context.Context, owner string, shorts []string) error {
+	members := make([]any, len(shorts))
+	for i, short := range shorts {
+		members[i] = short
+	}
+
+	_, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZRem(ctx, keyLinks, members...)
+		p.ZRem(ctx, ownerKey(owner), members...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.dropOwnerIfEmpty(ctx, owner)
+}
+
+func (r *RedisStorage) dropOwnerIfEmpty(ctx context.Context, owner string) error {
+	count, err := r.client.ZCard(ctx, ownerKey(owner)).Result()
+	if err != nil || count > 0 {
+		return err
+	}
+
+	return r.client.SRem(ctx, keyOwners, owner).Err()
+}
+
+func boolString(b bool) string {
+	if b {
+		return "1"
+	}
+
+	return "0"
+}
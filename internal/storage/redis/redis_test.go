@@ -0,0 +1,411 @@
+package redisstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/db/service"
+	mapstorage "url-shortener/internal/storage/map"
+	"url-shortener/internal/storage/storagetest"
+	shortener "url-shortener/pkg/api"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	t.Helper()
+
+	srv := miniredis.RunT(t)
+
+	client, err := NewClient("redis://" + srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return srv, client
+}
+
+func TestRedisStorage(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestClient(t)
+	st := NewRedisStorage(client, 0).(*RedisStorage)
+	defer st.Shutdown()
+
+	for _, short := range []string{"b", "a", "c"} {
+		got, err := st.AddLink(ctx, "https://"+short+".ru", short, "alice")
+		assert.NoError(t, err)
+		assert.Equal(t, short, got)
+	}
+
+	_, err := st.AddLink(ctx, "https://other.ru", "a", "bob")
+	assert.True(t, errors.Is(err, service.ErrExists), err)
+
+	long, err := st.GetLongLink(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://a.ru", long)
+
+	_, err = st.GetLongLink(ctx, "nope")
+	assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+
+	assert.True(t, errors.Is(st.MarkAsDeleted(ctx, "a", "bob"), storage.ErrNotFound))
+	assert.NoError(t, st.MarkAsDeleted(ctx, "a", "alice"))
+
+	_, err = st.GetLongLink(ctx, "a")
+	assert.True(t, errors.Is(err, storage.ErrDeleted), err)
+
+	assert.NoError(t, st.PurgeLink(ctx, "c"))
+
+	links, err := st.GetAllLinksByCookie(ctx, "alice", "/")
+	assert.NoError(t, err)
+	assert.Equal(t, []*shortener.UserURL{
+		{OriginalUrl: "https://b.ru", ShortUrl: "/b"},
+		{OriginalUrl: "https://a.ru", ShortUrl: "/a", Deleted: true},
+	}, links)
+
+	id, err := st.FindMaxID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, id, "the id of the rejected link is not reused")
+
+	urls, err := st.URLsCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, urls)
+
+	users, err := st.UsersCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, users)
+
+	assert.NoError(t, st.Ping(ctx))
+}
+
+func TestRedisStorage_AddLinks(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestClient(t)
+	st := NewRedisStorage(client, 0).(*RedisStorage)
+	defer st.Shutdown()
+
+	if _, err := st.AddLink(ctx, "https://a.ru", "a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := st.AddLinks(ctx, []storage.Link{
+		{Short: "b", Long: "https://b.ru", Owner: "bob"},
+		{Short: "a", Long: "https://other.ru", Owner: "bob"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.BatchResult{{Short: "b"}, {Short: "a", Exists: true}}, results)
+
+	links, err := st.GetAllLinksByCookie(ctx, "bob", "/")
+	assert.NoError(t, err)
+	assert.Equal(t, []*shortener.UserURL{{OriginalUrl: "https://b.ru", ShortUrl: "/b"}}, links)
+}
+
+func TestRedisStorage_TTL(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newTestClient(t)
+	st := NewRedisStorage(client, time.Minute)
+	defer st.Shutdown()
+
+	for _, short := range []string{"a", "b"} {
+		if _, err := st.AddLink(ctx, "https://"+short+".ru", short, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.True(t, srv.TTL(linkKey("a")) > 0, "the link is claimed with its TTL")
+
+	srv.FastForward(2 * time.Minute)
+
+	_, err := st.GetLongLink(ctx, "a")
+	assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+
+	// an expired link is not written back without a TTL
+	err = st.MarkAsDeleted(ctx, "a", "alice")
+	assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+	err = st.(*RedisStorage).DisableLink(ctx, "b")
+	assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+	assert.False(t, srv.Exists(linkKey("a")))
+	assert.False(t, srv.Exists(linkKey("b")))
+
+	links, err := st.GetAllLinksByCookie(ctx, "alice", "/")
+	assert.NoError(t, err)
+	assert.Empty(t, links)
+
+	users, err := st.UsersCount(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, users, "expired links are forgotten")
+}
+
+func TestRedisStorage_Admin(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestClient(t)
+	st := NewRedisStorage(client, 0).(*RedisStorage)
+	defer st.Shutdown()
+
+	want := []storage.Link{
+		{Short: "zE", Long: "https://ya.ru", Owner: "alice", CreatedAt: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{Short: "Xz", Long: "https://go.dev", Owner: "bob", Deleted: true},
+	}
+	for _, link := range want {
+		assert.NoError(t, st.ImportLink(ctx, link))
+	}
+
+	var got []storage.Link
+	err := st.Links(ctx, func(link storage.Link) error {
+		got = append(got, link)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	assert.NoError(t, st.DisableLink(ctx, "zE"))
+	link, err := st.GetLink(ctx, "zE")
+	assert.NoError(t, err)
+	assert.True(t, link.Deleted)
+
+	assert.True(t, errors.Is(st.DisableLink(ctx, "nope"), storage.ErrNotFound))
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newTestClient(t)
+
+	next := mapstorage.NewMapStorage()
+	cache := NewCache(next, client, time.Minute).(*Cache)
+
+	if _, err := cache.AddLink(ctx, "https://ya.ru", "zE", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	long, err := cache.GetLongLink(ctx, "zE")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", long)
+
+	cached, err := srv.Get(cacheKey("zE"))
+	assert.NoError(t, err)
+	assert.Equal(t, cachedLive+`{"long":"https://ya.ru","options":{}}`, cached)
+
+	// the options are cached along with the long link
+	assert.NoError(t, cache.SetLinkOptions(ctx, "zE", storage.LinkOptions{Preview: true}))
+	assert.False(t, srv.Exists(cacheKey("zE")), "SetLinkOptions drops the cached value")
+
+	target, err := cache.GetTarget(ctx, "zE")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Target{Long: "https://ya.ru", Options: storage.LinkOptions{Preview: true}}, target)
+
+	// a value of the earlier format is a miss
+	assert.NoError(t, srv.Set(cacheKey("zE"), cachedLive+"https://ya.ru"))
+	target, err = cache.GetTarget(ctx, "zE")
+	assert.NoError(t, err)
+	assert.True(t, target.Options.Preview)
+
+	// the cached value is served without the storage
+	assert.NoError(t, next.(storage.IAdmin).PurgeLink(ctx, "zE"))
+	long, err = cache.GetLongLink(ctx, "zE")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", long)
+
+	assert.NoError(t, cache.ImportLink(ctx, storage.Link{Short: "zE", Long: "https://go.dev", Owner: "alice"}))
+	assert.False(t, srv.Exists(cacheKey("zE")), "ImportLink drops the cached value")
+
+	long, err = cache.GetLongLink(ctx, "zE")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://go.dev", long)
+
+	assert.NoError(t, cache.MarkAsDeleted(ctx, "zE", "alice"))
+	_, err = cache.GetLongLink(ctx, "zE")
+	assert.True(t, errors.Is(err, storage.ErrDeleted), err)
+
+	cached, _ = srv.Get(cacheKey("zE"))
+	assert.Equal(t, cachedDeleted, cached)
+
+	// misses are not cached
+	_, err = cache.GetLongLink(ctx, "nope")
+	assert.True(t, errors.Is(err, storage.ErrNotFound), err)
+	assert.False(t, srv.Exists(cacheKey("nope")))
+
+	// the storage is used when Redis is down
+	srv.Close()
+	_, err = cache.AddLink(ctx, "https://a.ru", "a", "bob")
+	assert.NoError(t, err)
+
+	long, err = cache.GetLongLink(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://a.ru", long)
+}
+
+func TestRedisStorage_Idempotency(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newTestClient(t)
+	st := NewRedisStorage(client, 0).(*RedisStorage)
+	defer st.Shutdown()
+
+	rec := storage.IdempotencyRecord{Key: "k", Fingerprint: "a", ExpiresAt: time.Now().Add(time.Hour).Truncate(time.Millisecond)}
+	_, reserved, err := st.ReserveKey(ctx, rec)
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+	assert.True(t, srv.TTL(idempotencyKey("k")) > 0, "the key expires")
+
+	found, reserved, err := st.ReserveKey(ctx, storage.IdempotencyRecord{Key: "k", Fingerprint: "b", ExpiresAt: rec.ExpiresAt})
+	assert.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, rec, found)
+
+	assert.NoError(t, st.CompleteKey(ctx, "k", []byte("response")))
+	assert.True(t, srv.TTL(idempotencyKey("k")) > 0, "the key keeps its TTL")
+
+	found, _, err = st.ReserveKey(ctx, rec)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("response"), found.Response)
+
+	assert.NoError(t, st.ReleaseKey(ctx, "k"))
+	_, reserved, err = st.ReserveKey(ctx, rec)
+	assert.NoError(t, err)
+	assert.True(t, reserved, "released keys can be reserved")
+
+	srv.FastForward(2 * time.Hour)
+	_, reserved, err = st.ReserveKey(ctx, storage.IdempotencyRecord{Key: "k", Fingerprint: "b", ExpiresAt: time.Now().Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.True(t, reserved, "expired keys can be reserved")
+}
+
+func TestRedisStorage_Audit(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestClient(t)
+	st := NewRedisStorage(client, 0).(*RedisStorage)
+	defer st.Shutdown()
+	at := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	assert.NoError(t, st.AppendAudit(ctx,
+		storage.AuditEntry{At: at, Actor: "alice", Operation: "link.create", Target: "a", Outcome: storage.AuditSuccess},
+		storage.AuditEntry{At: at.Add(time.Minute), Actor: "bob", Operation: "link.create", Target: "b", Outcome: storage.AuditSuccess},
+	))
+	assert.NoError(t, st.AppendAudit(ctx,
+		storage.AuditEntry{At: at.Add(2 * time.Minute), Actor: "alice", Operation: "link.delete", Target: "a", Outcome: storage.AuditSuccess},
+	))
+
+	entries, err := st.AuditEntries(ctx, storage.AuditFilter{Actor: "alice"})
+	if assert.NoError(t, err) && assert.Len(t, entries, 2) {
+		assert.Equal(t, storage.AuditEntry{ID: 3, At: at.Add(2 * time.Minute), Actor: "alice",
+			Operation: "link.delete", Target: "a", Outcome: storage.AuditSuccess}, entries[1])
+	}
+
+	entries, err = st.AuditEntries(ctx, storage.AuditFilter{AfterID: 1, To: at.Add(2 * time.Minute)})
+	if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+		assert.Equal(t, "b", entries[0].Target)
+	}
+
+	entries, err = st.AuditEntries(ctx, storage.AuditFilter{Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestRedisStorage_LinkOptions(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newTestClient(t)
+	st := NewRedisStorage(client, time.Hour).(*RedisStorage)
+	defer st.Shutdown()
+
+	_, err := st.AddLink(ctx, "https://ya.ru", "a", "alice")
+	assert.NoError(t, err)
+
+	opts := storage.LinkOptions{Title: "Yandex", Preview: true, PasswordHash: "$2a$10$hash"}
+	assert.NoError(t, st.SetLinkOptions(ctx, "a", opts))
+	assert.True(t, srv.TTL(optionsKey("a")) > 0, "the options expire with the link")
+
+	found, err := st.GetLinkOptions(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, opts, found)
+
+	assert.NoError(t, st.SetLinkOptions(ctx, "a", storage.LinkOptions{}))
+	assert.False(t, srv.Exists(optionsKey("a")), "the zero options are removed")
+
+	found, err = st.GetLinkOptions(ctx, "missing")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.LinkOptions{}, found)
+}
+
+func TestRedisStorage_GetTarget(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestClient(t)
+	st := NewRedisStorage(client, 0).(*RedisStorage)
+	defer st.Shutdown()
+
+	_, err := st.AddLink(ctx, "https://ya.ru", "a", "alice")
+	assert.NoError(t, err)
+
+	target, err := st.GetTarget(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Target{Long: "https://ya.ru"}, target)
+
+	assert.NoError(t, st.SetLinkOptions(ctx, "a", storage.LinkOptions{Title: "Yandex", Preview: true}))
+	assert.NoError(t, st.SetMaxClicks(ctx, "a", 1))
+
+	target, err = st.GetTarget(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Target{Long: "https://ya.ru", Options: storage.LinkOptions{Title: "Yandex", Preview: true}, Limited: true}, target)
+
+	_, err = st.UseClick(ctx, "a")
+	assert.NoError(t, err)
+	_, err = st.GetTarget(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrExhausted)
+
+	assert.NoError(t, st.MarkAsDeleted(ctx, "a", "alice"))
+	_, err = st.GetTarget(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrDeleted)
+
+	_, err = st.GetTarget(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestRedisStorage_Clicks(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestClient(t)
+	st := NewRedisStorage(client, 0).(*RedisStorage)
+	defer st.Shutdown()
+
+	_, err := st.AddLink(ctx, "https://ya.ru", "a", "alice")
+	assert.NoError(t, err)
+	_, err = st.AddLink(ctx, "https://go.dev", "b", "alice")
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, st.SetMaxClicks(ctx, "missing", 1), storage.ErrNotFound)
+
+	left, err := st.UseClick(ctx, "b")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Unlimited, left)
+
+	assert.NoError(t, st.SetMaxClicks(ctx, "a", 1))
+
+	links, err := st.GetAllLinksByCookie(ctx, "alice", "")
+	if assert.NoError(t, err) && assert.Len(t, links, 2) {
+		assert.Equal(t, int32(1), links[0].GetClicksLeft())
+		assert.Nil(t, links[1].ClicksLeft)
+	}
+
+	left, err = st.UseClick(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, left)
+
+	_, err = st.UseClick(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrExhausted)
+
+	_, err = st.GetLongLink(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrExhausted)
+
+	assert.NoError(t, st.SetMaxClicks(ctx, "a", storage.Unlimited))
+	long, err := st.GetLongLink(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", long)
+}
+
+func TestRedisStorage_Conformance(t *testing.T) {
+	_, client := newTestClient(t)
+	st := NewRedisStorage(client, 0)
+	defer st.Shutdown()
+
+	storagetest.Run(t, st)
+}
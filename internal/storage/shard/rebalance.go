@@ -0,0 +1,77 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"url-shortener/internal/storage"
+)
+
+// Move of a link found on a shard other than its own.
+type Move struct {
+	Short string `json:"short"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// Rebalance moves the links to their shards after shards were added. Only the links placed
+// by the ring move, the ones with the shard encoded in the short URL stay where they are.
+// A link is copied first and then removed, so an interrupted run can be repeated.
+// report is called for every move, with dryRun nothing is changed.
+func (r *Router) Rebalance(ctx context.Context, dryRun bool, report func(Move)) (int, error) {
+	var moved int
+
+	for from := range r.shards {
+		source, err := r.admin(from)
+		if err != nil {
+			return moved, err
+		}
+
+		// the links are collected first, the storages can't be changed while they are read
+		var misplaced []storage.Link
+		err = source.Links(ctx, func(link storage.Link) error {
+			if r.locate(link.Short) != from {
+				misplaced = append(misplaced, link)
+			}
+			return nil
+		})
+		if err != nil {
+			return moved, fmt.Errorf("shard %s: %w", r.shards[from].Name, err)
+		}
+
+		for _, link := range misplaced {
+			to := r.locate(link.Short)
+			if report != nil {
+				report(Move{Short: link.Short, From: r.shards[from].Name, To: r.shards[to].Name})
+			}
+
+			if !dryRun {
+				if err = r.move(ctx, source, to, link); err != nil {
+					return moved, fmt.Errorf("can't move %s: %w", link.Short, err)
+				}
+			}
+
+			moved++
+		}
+	}
+
+	return moved, nil
+}
+
+func (r *Router) move(ctx context.Context, source storage.IAdmin, to int, link storage.Link) error {
+	target, err := r.admin(to)
+	if err != nil {
+		return err
+	}
+
+	// the link is already there after an interrupted run
+	if _, err = target.GetLink(ctx, link.Short); errors.Is(err, storage.ErrNotFound) {
+		err = target.ImportLink(ctx, link)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return source.PurgeLink(ctx, link.Short)
+}
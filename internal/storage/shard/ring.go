@@ -0,0 +1,73 @@
+package shard
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas the number of points of a shard on the ring.
+const DefaultReplicas = 128
+
+// Ring consistent hashing of keys to shards. A key belongs to the shard owning the first
+// point of the ring after the hash of the key, so adding a shard moves only the keys
+// that fall on its points.
+type Ring struct {
+	points []uint64
+	owners map[uint64]int
+}
+
+// NewRing places replicas points of every shard on the ring, the points depend on the names only.
+func NewRing(names []string, replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+
+	r := &Ring{owners: make(map[uint64]int, len(names)*replicas)}
+	for shard, name := range names {
+		for i := 0; i < replicas; i++ {
+			point := hash(name + "#" + strconv.Itoa(i))
+			if _, taken := r.owners[point]; taken {
+				continue
+			}
+
+			r.owners[point] = shard
+			r.points = append(r.points, point)
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+
+	return r
+}
+
+// Locate returns the index of the shard of the key.
+func (r *Ring) Locate(key string) int {
+	if len(r.points) == 0 {
+		return 0
+	}
+
+	h := hash(key)
+
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.owners[r.points[i]]
+}
+
+// hash is FNV-1a with the murmur3 finalizer, FNV alone spreads similar short keys poorly.
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
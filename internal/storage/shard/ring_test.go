@@ -0,0 +1,37 @@
+package shard
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	const keys = 10000
+
+	ring := NewRing([]string{"a", "b", "c"}, 0)
+
+	counts := make([]int, 3)
+	before := make([]int, keys)
+	for i := range before {
+		before[i] = ring.Locate(strconv.Itoa(i))
+		counts[before[i]]++
+	}
+
+	for shard, count := range counts {
+		assert.InDelta(t, keys/3, count, keys/10, "keys of shard %d", shard)
+	}
+
+	grown := NewRing([]string{"a", "b", "c", "d"}, 0)
+
+	var moved int
+	for i, shard := range before {
+		if now := grown.Locate(strconv.Itoa(i)); now != shard {
+			assert.Equal(t, 3, now, "keys move to the new shard only")
+			moved++
+		}
+	}
+
+	assert.InDelta(t, keys/4, moved, keys/10)
+}
@@ -0,0 +1,466 @@
+// Package shard spreads the links across several storages.
+//
+// A link belongs to the shard whose ID is encoded in its short URL if there is one, otherwise to
+// the shard its short URL is hashed to on a consistent hashing ring of the shard names. The ID
+// is derived from the name, so the order of the shards doesn't matter. The queries
+// not bound to one short URL are sent to every shard and their results are merged.
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"url-shortener/internal/storage"
+	shortener "url-shortener/pkg/api"
+	shortenalgorithm "url-shortener/pkg/shortenAlgorithm"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	_ storage.IStorage     = (*Router)(nil)
+	_ storage.IAdmin       = (*Router)(nil)
+	_ storage.ISharded     = (*Router)(nil)
+	_ storage.IAudit       = (*Router)(nil)
+	_ storage.ILinkOptions = (*Router)(nil)
+	_ storage.IClickLimit  = (*Router)(nil)
+)
+
+// Shard a storage of the router. The name places it on the ring and gives the ID encoded
+// in the short URLs, so it must stay the same.
+type Shard struct {
+	Name string
+	storage.IStorage
+}
+
+// Router implements storage.IStorage on top of the shards.
+type Router struct {
+	shards []Shard
+	ring   *Ring
+	// ids the index of the shard by its ID.
+	ids map[int]int
+	// encode the shard of the new links in their short URLs.
+	encode bool
+}
+
+// maxID bounds the shard IDs, so the short URLs stay short.
+const maxID = 1 << 16
+
+// ID returns the ID of the shard with the name.
+func ID(name string) int {
+	return int(hash("id#"+name) % maxID)
+}
+
+// New builds the router. If encode is true, the ID of the shard of a new link is encoded in its
+// short URL, so the link stays on it when shards are added or reordered.
+func New(shards []Shard, encode bool) (*Router, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("no shards")
+	}
+
+	names := make([]string, len(shards))
+	ids := make(map[int]int, len(shards))
+	seen := make(map[string]bool, len(shards))
+	for i, sh := range shards {
+		if sh.Name == "" || seen[sh.Name] {
+			return nil, fmt.Errorf("shard name %q is empty or not unique", sh.Name)
+		}
+
+		if other, taken := ids[ID(sh.Name)]; taken {
+			return nil, fmt.Errorf("shards %s and %s have the same ID, rename one", shards[other].Name, sh.Name)
+		}
+
+		seen[sh.Name] = true
+		names[i] = sh.Name
+		ids[ID(sh.Name)] = i
+	}
+
+	return &Router{shards: shards, ring: NewRing(names, DefaultReplicas), ids: ids, encode: encode}, nil
+}
+
+// errStop stops the iteration of the links.
+var errStop = errors.New("stop")
+
+// Verify checks that the first link with an encoded shard ID of every shard has the ID of the shard,
+// otherwise the shard was renamed and the links encoded with its old ID would not be found.
+func (r *Router) Verify(ctx context.Context) error {
+	for i, sh := range r.shards {
+		admin, err := r.admin(i)
+		if err != nil {
+			continue
+		}
+
+		err = admin.Links(ctx, func(link storage.Link) error {
+			id, ok := shortenalgorithm.GetShard(link.Short)
+			if !ok {
+				return nil
+			}
+
+			if id != ID(sh.Name) {
+				return fmt.Errorf("shard %s holds %s encoded with the shard ID %d, not %d: was the shard renamed?",
+					sh.Name, link.Short, id, ID(sh.Name))
+			}
+
+			return errStop
+		})
+		if err != nil && !errors.Is(err, errStop) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ShardFor returns the ID of the shard of a new link with the id.
+func (r *Router) ShardFor(id int) (int, bool) {
+	if !r.encode {
+		return 0, false
+	}
+
+	return ID(r.shards[r.ring.Locate(strconv.Itoa(id))].Name), true
+}
+
+// Locate returns the name of the shard of the short URL.
+func (r *Router) Locate(shortURL string) string {
+	return r.shards[r.locate(shortURL)].Name
+}
+
+func (r *Router) locate(shortURL string) int {
+	if id, ok := shortenalgorithm.GetShard(shortURL); ok {
+		if i, ok := r.ids[id]; ok {
+			return i
+		}
+	}
+
+	return r.ring.Locate(shortURL)
+}
+
+func (r *Router) shard(shortURL string) storage.IStorage {
+	return r.shards[r.locate(shortURL)].IStorage
+}
+
+// each calls fn for every shard concurrently and returns the first error.
+func (r *Router) each(ctx context.Context, fn func(ctx context.Context, i int, st storage.IStorage) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+
+	for i, sh := range r.shards {
+		i, sh := i, sh
+		g.Go(func() error {
+			if err := fn(ctx, i, sh.IStorage); err != nil {
+				return fmt.Errorf("shard %s: %w", sh.Name, err)
+			}
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// sum adds up the count of every shard.
+func (r *Router) sum(ctx context.Context, count func(storage.IStorage, context.Context) (int, error)) (int, error) {
+	counts := make([]int, len(r.shards))
+
+	err := r.each(ctx, func(ctx context.Context, i int, st storage.IStorage) (err error) {
+		counts[i], err = count(st, ctx)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var total int
+	for _, n := range counts {
+		total += n
+	}
+
+	return total, nil
+}
+
+// FindMaxID returns the sum of the ids of the shards, every link added to a shard increases it.
+func (r *Router) FindMaxID(ctx context.Context) (int, error) {
+	return r.sum(ctx, storage.IStorage.FindMaxID)
+}
+
+// AddLink adds a link to its shard.
+func (r *Router) AddLink(ctx context.Context, longURL, shortURL, cookie string) (string, error) {
+	return r.shard(shortURL).AddLink(ctx, longURL, shortURL, cookie)
+}
+
+// AddLinks adds the links to their shards, one shard after another. The shards don't share
+// a transaction, so if one fails the links added to the shards before it are purged.
+func (r *Router) AddLinks(ctx context.Context, links []storage.Link) ([]storage.BatchResult, error) {
+	byShard := make(map[int][]int, len(r.shards))
+	for i, link := range links {
+		sh := r.locate(link.Short)
+		byShard[sh] = append(byShard[sh], i)
+	}
+
+	results := make([]storage.BatchResult, len(links))
+	var done []int
+
+	for sh := range r.shards {
+		indexes := byShard[sh]
+		if len(indexes) == 0 {
+			continue
+		}
+
+		shardLinks := make([]storage.Link, len(indexes))
+		for j, i := range indexes {
+			shardLinks[j] = links[i]
+		}
+
+		shardResults, err := r.shards[sh].AddLinks(ctx, shardLinks)
+		if err != nil {
+			r.undo(done, byShard, results)
+			return nil, fmt.Errorf("shard %s: %w", r.shards[sh].Name, err)
+		}
+
+		for j, i := range indexes {
+			results[i] = shardResults[j]
+		}
+		done = append(done, sh)
+	}
+
+	return results, nil
+}
+
+// undo purges the links added to the shards.
+func (r *Router) undo(shards []int, byShard map[int][]int, results []storage.BatchResult) {
+	for _, sh := range shards {
+		admin, err := r.admin(sh)
+		if err != nil {
+			continue
+		}
+
+		for _, i := range byShard[sh] {
+			if !results[i].Exists {
+				admin.PurgeLink(context.Background(), results[i].Short)
+			}
+		}
+	}
+}
+
+// GetLongLink gets a long link from its shard.
+func (r *Router) GetLongLink(ctx context.Context, shortURL string) (string, error) {
+	return r.shard(shortURL).GetLongLink(ctx, shortURL)
+}
+
+// GetAllLinksByCookie gets the links of the owner from every shard, the links are grouped by shard.
+func (r *Router) GetAllLinksByCookie(ctx context.Context, cookie, baseURL string) ([]*shortener.UserURL, error) {
+	found := make([][]*shortener.UserURL, len(r.shards))
+
+	err := r.each(ctx, func(ctx context.Context, i int, st storage.IStorage) (err error) {
+		found[i], err = st.GetAllLinksByCookie(ctx, cookie, baseURL)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]*shortener.UserURL, 0)
+	for _, shardLinks := range found {
+		links = append(links, shardLinks...)
+	}
+
+	return links, nil
+}
+
+// Ping checks every shard.
+func (r *Router) Ping(ctx context.Context) error {
+	return r.each(ctx, func(ctx context.Context, _ int, st storage.IStorage) error {
+		return st.Ping(ctx)
+	})
+}
+
+// MarkAsDeleted marks the link as deleted on its shard.
+func (r *Router) MarkAsDeleted(ctx context.Context, shortURL, cookie string) error {
+	return r.shard(shortURL).MarkAsDeleted(ctx, shortURL, cookie)
+}
+
+// Shutdown shuts every shard down.
+func (r *Router) Shutdown() error {
+	var first error
+	for _, sh := range r.shards {
+		if err := sh.Shutdown(); err != nil && first == nil {
+			first = fmt.Errorf("shard %s: %w", sh.Name, err)
+		}
+	}
+
+	return first
+}
+
+// URLsCount gets count of URLs of every shard.
+func (r *Router) URLsCount(ctx context.Context) (int, error) {
+	return r.sum(ctx, storage.IStorage.URLsCount)
+}
+
+// UsersCount gets count of users. The links of a user are spread across the shards,
+// so the owners of every link are read to count each user once.
+func (r *Router) UsersCount(ctx context.Context) (int, error) {
+	owners := make(map[string]struct{})
+
+	err := r.Links(ctx, func(link storage.Link) error {
+		owners[link.Owner] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(owners), nil
+}
+
+// GetLink gets the record from its shard.
+func (r *Router) GetLink(ctx context.Context, shortURL string) (storage.Link, error) {
+	admin, err := r.admin(r.locate(shortURL))
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	return admin.GetLink(ctx, shortURL)
+}
+
+// DisableLink disables the link on its shard.
+func (r *Router) DisableLink(ctx context.Context, shortURL string) error {
+	admin, err := r.admin(r.locate(shortURL))
+	if err != nil {
+		return err
+	}
+
+	return admin.DisableLink(ctx, shortURL)
+}
+
+// PurgeLink removes the link from its shard.
+func (r *Router) PurgeLink(ctx context.Context, shortURL string) error {
+	admin, err := r.admin(r.locate(shortURL))
+	if err != nil {
+		return err
+	}
+
+	return admin.PurgeLink(ctx, shortURL)
+}
+
+// Links calls fn for every record of every shard, one shard after another.
+func (r *Router) Links(ctx context.Context, fn func(storage.Link) error) error {
+	for i := range r.shards {
+		admin, err := r.admin(i)
+		if err != nil {
+			return err
+		}
+
+		if err = admin.Links(ctx, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ImportLink saves the record to its shard.
+func (r *Router) ImportLink(ctx context.Context, link storage.Link) error {
+	admin, err := r.admin(r.locate(link.Short))
+	if err != nil {
+		return err
+	}
+
+	return admin.ImportLink(ctx, link)
+}
+
+// AppendAudit saves the entries to the first shard, the audit log is not spread,
+// so the ids of the entries stay ordered.
+func (r *Router) AppendAudit(ctx context.Context, entries ...storage.AuditEntry) error {
+	audit, err := r.audit()
+	if err != nil {
+		return err
+	}
+
+	return audit.AppendAudit(ctx, entries...)
+}
+
+// AuditEntries returns the entries of the first shard selected by the filter.
+func (r *Router) AuditEntries(ctx context.Context, filter storage.AuditFilter) ([]storage.AuditEntry, error) {
+	audit, err := r.audit()
+	if err != nil {
+		return nil, err
+	}
+
+	return audit.AuditEntries(ctx, filter)
+}
+
+func (r *Router) audit() (storage.IAudit, error) {
+	audit, ok := storage.As[storage.IAudit](r.shards[0].IStorage)
+	if !ok {
+		return nil, fmt.Errorf("shard %s: %w", r.shards[0].Name, storage.ErrNotSupported)
+	}
+
+	return audit, nil
+}
+
+// GetLinkOptions returns the options of the link from its shard.
+func (r *Router) GetLinkOptions(ctx context.Context, shortURL string) (storage.LinkOptions, error) {
+	options, err := r.options(r.locate(shortURL))
+	if err != nil {
+		return storage.LinkOptions{}, err
+	}
+
+	return options.GetLinkOptions(ctx, shortURL)
+}
+
+// SetLinkOptions saves the options of the link to its shard.
+func (r *Router) SetLinkOptions(ctx context.Context, shortURL string, opts storage.LinkOptions) error {
+	options, err := r.options(r.locate(shortURL))
+	if err != nil {
+		return err
+	}
+
+	return options.SetLinkOptions(ctx, shortURL, opts)
+}
+
+// SetMaxClicks limits the visits of the link on its shard.
+func (r *Router) SetMaxClicks(ctx context.Context, shortURL string, n int) error {
+	clicks, err := r.clicks(r.locate(shortURL))
+	if err != nil {
+		return err
+	}
+
+	return clicks.SetMaxClicks(ctx, shortURL, n)
+}
+
+// UseClick takes a visit of the link on its shard.
+func (r *Router) UseClick(ctx context.Context, shortURL string) (int, error) {
+	clicks, err := r.clicks(r.locate(shortURL))
+	if err != nil {
+		return 0, err
+	}
+
+	return clicks.UseClick(ctx, shortURL)
+}
+
+func (r *Router) clicks(i int) (storage.IClickLimit, error) {
+	clicks, ok := storage.As[storage.IClickLimit](r.shards[i].IStorage)
+	if !ok {
+		return nil, fmt.Errorf("shard %s: %w", r.shards[i].Name, storage.ErrNotSupported)
+	}
+
+	return clicks, nil
+}
+
+func (r *Router) options(i int) (storage.ILinkOptions, error) {
+	options, ok := storage.As[storage.ILinkOptions](r.shards[i].IStorage)
+	if !ok {
+		return nil, fmt.Errorf("shard %s: %w", r.shards[i].Name, storage.ErrNotSupported)
+	}
+
+	return options, nil
+}
+
+func (r *Router) admin(i int) (storage.IAdmin, error) {
+	admin, ok := storage.As[storage.IAdmin](r.shards[i].IStorage)
+	if !ok {
+		return nil, fmt.Errorf("shard %s: %w", r.shards[i].Name, storage.ErrNotSupported)
+	}
+
+	return admin, nil
+}
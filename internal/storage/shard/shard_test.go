@@ -0,0 +1,307 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"url-shortener/internal/storage"
+	mapstorage "url-shortener/internal/storage/map"
+	shortenalgorithm "url-shortener/pkg/shortenAlgorithm"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newShards(names ...string) []Shard {
+	shards := make([]Shard, len(names))
+	for i, name := range names {
+		shards[i] = Shard{Name: name, IStorage: mapstorage.NewMapStorage()}
+	}
+
+	return shards
+}
+
+func newTestRouter(t *testing.T, shards []Shard, encode bool) *Router {
+	t.Helper()
+
+	r, err := New(shards, encode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		shards []Shard
+	}{
+		{name: "no shards"},
+		{name: "duplicate name", shards: newShards("a", "a")},
+		{name: "empty name", shards: newShards("")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.shards, false)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards("a", "b", "c")
+	r := newTestRouter(t, shards, false)
+	defer r.Shutdown()
+
+	for i := 0; i < 30; i++ {
+		short := "link" + strconv.Itoa(i)
+		owner := "alice"
+		if i%3 == 0 {
+			owner = "bob"
+		}
+
+		_, err := r.AddLink(ctx, "https://"+short+".ru", short, owner)
+		assert.NoError(t, err)
+	}
+
+	for _, sh := range shards {
+		count, err := sh.URLsCount(ctx)
+		assert.NoError(t, err)
+		assert.NotZero(t, count, "shard %s is used", sh.Name)
+	}
+
+	long, err := r.GetLongLink(ctx, "link7")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://link7.ru", long)
+
+	_, err = shards[r.locate("link7")].GetLongLink(ctx, "link7")
+	assert.NoError(t, err, "the link is on its shard")
+
+	assert.NoError(t, r.MarkAsDeleted(ctx, "link3", "bob"))
+	_, err = r.GetLongLink(ctx, "link3")
+	assert.True(t, errors.Is(err, storage.ErrDeleted), err)
+
+	links, err := r.GetAllLinksByCookie(ctx, "bob", "/")
+	assert.NoError(t, err)
+	assert.Len(t, links, 10)
+
+	urls, err := r.URLsCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 30, urls)
+
+	users, err := r.UsersCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, users, "a user of several shards is counted once")
+
+	id, err := r.FindMaxID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 30, id)
+
+	assert.NoError(t, r.Ping(ctx))
+}
+
+func TestRouter_EncodedShard(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRouter(t, newShards("a", "b"), true)
+	defer r.Shutdown()
+
+	for id := 1; id <= 10; id++ {
+		shard, ok := r.ShardFor(id)
+		assert.True(t, ok)
+
+		short, err := shortenalgorithm.GetShardedName(id, shard)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = r.AddLink(ctx, "https://ya.ru/"+strconv.Itoa(id), short, "alice")
+		assert.NoError(t, err)
+		assert.Equal(t, shard, ID(r.Locate(short)))
+	}
+
+	_, ok := newTestRouter(t, newShards("a"), false).ShardFor(1)
+	assert.False(t, ok)
+}
+
+func TestRouter_Reordered(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards("a", "b", "c")
+	r := newTestRouter(t, shards, true)
+	defer r.Shutdown()
+
+	want := make(map[string]string)
+	for id := 1; id <= 30; id++ {
+		shard, _ := r.ShardFor(id)
+
+		short, err := shortenalgorithm.GetShardedName(id, shard)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want[short] = "https://ya.ru/" + strconv.Itoa(id)
+		if _, err = r.AddLink(ctx, want[short], short, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the shards are listed in another order
+	r = newTestRouter(t, []Shard{shards[2], shards[0], shards[1]}, true)
+	assert.NoError(t, r.Verify(ctx))
+
+	for short, long := range want {
+		got, err := r.GetLongLink(ctx, short)
+		assert.NoError(t, err)
+		assert.Equal(t, long, got)
+	}
+
+	// a shard is renamed, its links would be lost
+	renamed := []Shard{{Name: "x", IStorage: shards[0].IStorage}, shards[1], shards[2]}
+	assert.Error(t, newTestRouter(t, renamed, true).Verify(ctx))
+}
+
+func TestRouter_Rebalance(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards("a", "b")
+	r := newTestRouter(t, shards, false)
+
+	encoded, err := shortenalgorithm.GetShardedName(1, ID("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{encoded: "https://encoded.ru"}
+	opts := storage.LinkOptions{Title: "Moved", PasswordHash: "hash"}
+	if _, err = r.AddLink(ctx, want[encoded], encoded, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		short := fmt.Sprintf("link%d", i)
+		want[short] = "https://" + short + ".ru"
+
+		if _, err = r.AddLink(ctx, want[short], short, "alice"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = r.SetLinkOptions(ctx, short, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = r.SetMaxClicks(ctx, short, i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a shard is added
+	shards = append(shards, newShards("c")...)
+	r = newTestRouter(t, shards, false)
+	defer r.Shutdown()
+
+	var moves []Move
+	moved, err := r.Rebalance(ctx, true, func(m Move) { moves = append(moves, m) })
+	assert.NoError(t, err)
+	assert.Equal(t, len(moves), moved)
+	assert.NotZero(t, moved)
+
+	for _, m := range moves {
+		assert.Equal(t, "c", m.To, "the links move to the new shard only")
+		assert.NotEqual(t, encoded, m.Short, "the encoded shard is kept")
+	}
+
+	count, _ := shards[2].URLsCount(ctx)
+	assert.Zero(t, count, "nothing is moved by a dry run")
+
+	again, err := r.Rebalance(ctx, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, moved, again)
+
+	count, _ = shards[2].URLsCount(ctx)
+	assert.Equal(t, moved, count)
+
+	for short, long := range want {
+		got, err := r.GetLongLink(ctx, short)
+		assert.NoError(t, err)
+		assert.Equal(t, long, got)
+	}
+
+	// the moved links keep their options and visits left
+	for i := 0; i < 50; i++ {
+		link, err := r.GetLink(ctx, fmt.Sprintf("link%d", i))
+		if assert.NoError(t, err) {
+			assert.Equal(t, opts, link.Options)
+			assert.Equal(t, i+1, link.MaxClicks())
+		}
+	}
+
+	// the moved links keep their options and visits left
+	for i := 0; i < 50; i++ {
+		link, err := r.GetLink(ctx, fmt.Sprintf("link%d", i))
+		if assert.NoError(t, err) {
+			assert.Equal(t, opts, link.Options)
+			assert.Equal(t, i+1, link.MaxClicks())
+		}
+	}
+
+	links, err := r.GetAllLinksByCookie(ctx, "alice", "/")
+	assert.NoError(t, err)
+	assert.Len(t, links, len(want))
+
+	moved, err = r.Rebalance(ctx, false, nil)
+	assert.NoError(t, err)
+	assert.Zero(t, moved)
+}
+
+// failing fails every batch.
+type failing struct {
+	*mapstorage.MapStorage
+}
+
+func (failing) AddLinks(context.Context, []storage.Link) ([]storage.BatchResult, error) {
+	return nil, errors.New("failed")
+}
+
+func TestRouter_AddLinks(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards("a", "b", "c")
+	r := newTestRouter(t, shards, false)
+
+	var links []storage.Link
+	for i := 0; i < 30; i++ {
+		short := "link" + strconv.Itoa(i)
+		links = append(links, storage.Link{Short: short, Long: "https://" + short + ".ru", Owner: "alice"})
+	}
+
+	if _, err := r.AddLink(ctx, "https://link0.ru", "link0", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := r.AddLinks(ctx, links)
+	assert.NoError(t, err)
+	for i, res := range results {
+		assert.Equal(t, links[i].Short, res.Short)
+		assert.Equal(t, i == 0, res.Exists, res.Short)
+	}
+
+	urls, err := r.URLsCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 30, urls)
+
+	// the last shard fails, the links added to the others are purged
+	last := len(shards) - 1
+	shards[last].IStorage = failing{MapStorage: shards[last].IStorage.(*mapstorage.MapStorage)}
+	r = newTestRouter(t, shards, false)
+
+	for i := range links {
+		links[i].Short = "new" + links[i].Short
+	}
+
+	_, err = r.AddLinks(ctx, links)
+	assert.Error(t, err)
+
+	urls, err = r.URLsCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 30, urls)
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 	shortener "url-shortener/pkg/api"
 )
 
@@ -10,10 +11,14 @@ import (
 type IStorage interface {
 	FindMaxID(ctx context.Context) (int, error)
 	AddLink(ctx context.Context, longURL, shortURL, cookie string) (string, error)
+	// AddLinks adds the Short, Long, Owner, Options and ClicksLeft of every link at once: either all
+	// the new links are added or none, a link is never readable without its options and visits left.
+	// The links existing already are reported and left as is.
+	AddLinks(ctx context.Context, links []Link) ([]BatchResult, error)
 	GetLongLink(ctx context.Context, shortURL string) (longURL string, err error)
 	GetAllLinksByCookie(ctx context.Context, cookie string, baseURL string) (URLs []*shortener.UserURL, err error)
 	Ping(ctx context.Context) error
-	MarkAsDeleted(shortURL, cookie string) error
+	MarkAsDeleted(ctx context.Context, shortURL, cookie string) error
 	Shutdown() error
 	URLsCount(ctx context.Context) (int, error)
 	UsersCount(ctx context.Context) (int, error)
@@ -24,3 +29,266 @@ type Type string
 
 // ErrDeleted when URL was marked as deleted.
 var ErrDeleted = errors.New("URL was marked as deleted")
+
+// ErrNotFound when URL does not exist.
+var ErrNotFound = errors.New("URL not found")
+
+// ErrExhausted when URL was visited the maximum number of times.
+var ErrExhausted = errors.New("URL has no visits left")
+
+// ErrNotSupported when the storage does not implement an optional interface.
+var ErrNotSupported = errors.New("not supported by the storage")
+
+// Link a record of the storage.
+type Link struct {
+	Short   string `json:"short"`
+	Long    string `json:"long"`
+	Owner   string `json:"owner"`
+	Deleted bool   `json:"deleted"`
+	// CreatedAt is zero for links created before timestamps were stored.
+	CreatedAt time.Time `json:"created_at"`
+	// Options the settings of the link made by its owner.
+	Options LinkOptions `json:"options"`
+	// ClicksLeft the visits left of the link, nil if they are not limited.
+	ClicksLeft *int `json:"clicks_left,omitempty"`
+}
+
+// MaxClicks returns the visits left of the link, Unlimited if they are not limited.
+func (l Link) MaxClicks() int {
+	if l.ClicksLeft == nil {
+		return Unlimited
+	}
+
+	return *l.ClicksLeft
+}
+
+// BatchResult the outcome of adding a link of a batch.
+type BatchResult struct {
+	// Short the short URL of the link, the one of the existing link if Exists.
+	Short string
+	// Exists the link was not added, its short URL or long URL is taken.
+	Exists bool
+}
+
+// IAdmin interface for the operator tooling. It is implemented by every storage.
+type IAdmin interface {
+	// GetLink returns the record including deleted ones with its options and visits left.
+	GetLink(ctx context.Context, shortURL string) (Link, error)
+	// DisableLink marks the link as deleted regardless of the owner.
+	DisableLink(ctx context.Context, shortURL string) error
+	// PurgeLink removes the record with its options and visits left.
+	PurgeLink(ctx context.Context, shortURL string) error
+	// Links calls fn for every record until it returns an error.
+	Links(ctx context.Context, fn func(Link) error) error
+	// ImportLink saves the record as is, its options and visits left included.
+	ImportLink(ctx context.Context, link Link) error
+}
+
+// IWrapper is implemented by the storages decorating another one, like caches.
+type IWrapper interface {
+	// Unwrap returns the decorated storage.
+	Unwrap() IStorage
+}
+
+// As returns the first storage of the chain of wrappers starting at st that implements T.
+func As[T any](st IStorage) (T, bool) {
+	for st != nil {
+		if t, ok := st.(T); ok {
+			return t, true
+		}
+
+		w, ok := st.(IWrapper)
+		if !ok {
+			break
+		}
+		st = w.Unwrap()
+	}
+
+	var zero T
+
+	return zero, false
+}
+
+// ISharded is implemented by the storages spreading links across shards.
+type ISharded interface {
+	// ShardFor returns the ID of the shard of a new link with the id, false if the shard is not
+	// encoded in the short URLs.
+	ShardFor(id int) (int, bool)
+}
+
+// IdempotencyRecord a request made with an idempotency key.
+type IdempotencyRecord struct {
+	// Key identifies the request, it is up to 64 bytes long.
+	Key string
+	// Fingerprint of the payload of the request.
+	Fingerprint string
+	// Response of the request, nil while the request is in progress.
+	Response []byte
+	// ExpiresAt the key may be reused after this moment.
+	ExpiresAt time.Time
+}
+
+// IIdempotency is implemented by the storages keeping the idempotency keys.
+type IIdempotency interface {
+	// ReserveKey saves the record and returns true unless the key is taken by a record that
+	// has not expired, this record is returned then.
+	ReserveKey(ctx context.Context, rec IdempotencyRecord) (IdempotencyRecord, bool, error)
+	// CompleteKey saves the response of the request with the key.
+	CompleteKey(ctx context.Context, key string, response []byte) error
+	// ReleaseKey removes the key, so the request can be made again.
+	ReleaseKey(ctx context.Context, key string) error
+}
+
+// Outcomes of an AuditEntry.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEntry a record of the audit log, made for every mutating operation.
+type AuditEntry struct {
+	// ID is assigned by the storage, the entries are ordered by it.
+	ID int64     `json:"id"`
+	At time.Time `json:"at"`
+	// Actor the cookie of the user or the name of the operator tool.
+	Actor string `json:"actor"`
+	// IP of the client, empty if it is not known.
+	IP        string `json:"ip,omitempty"`
+	Operation string `json:"operation"`
+	// Target the short URL of the link or the id of the webhook.
+	Target   string `json:"target"`
+	OldValue string `json:"old_value,omitempty"`
+	NewValue string `json:"new_value,omitempty"`
+	// Outcome is AuditSuccess or AuditFailure.
+	Outcome string `json:"outcome"`
+	// Error why the operation failed.
+	Error string `json:"error,omitempty"`
+}
+
+// AuditFilter selects the entries of the audit log, the zero value selects all of them.
+type AuditFilter struct {
+	// From and To bound the time of the entries, To is exclusive. Zero ones are not bounds.
+	From, To time.Time
+	// Actor selects the entries of one actor if it is not empty.
+	Actor string
+	// AfterID selects the entries following the one with the id, for paging.
+	AfterID int64
+	// Limit the most entries to return, every entry if zero.
+	Limit int
+}
+
+// Match reports whether the entry is selected by the filter, the Limit is not taken into account.
+func (f AuditFilter) Match(e AuditEntry) bool {
+	switch {
+	case e.ID <= f.AfterID:
+		return false
+	case !f.From.IsZero() && e.At.Before(f.From):
+		return false
+	case !f.To.IsZero() && !e.At.Before(f.To):
+		return false
+	case f.Actor != "" && e.Actor != f.Actor:
+		return false
+	}
+
+	return true
+}
+
+// IAudit is implemented by the storages keeping the audit log. The log is append-only.
+type IAudit interface {
+	// AppendAudit saves the entries, their IDs are assigned by the storage.
+	AppendAudit(ctx context.Context, entries ...AuditEntry) error
+	// AuditEntries returns the entries selected by the filter in the order of their ids.
+	AuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+}
+
+// LinkOptions the settings of a link made by its owner.
+type LinkOptions struct {
+	// Title shown on the preview page of the link.
+	Title string `json:"title,omitempty"`
+	// Preview shows the preview page on every visit instead of redirecting.
+	Preview bool `json:"preview,omitempty"`
+	// PasswordHash the bcrypt hash of the password opening the link, empty for the open links.
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// ILinkOptions is implemented by the storages keeping the options of the links.
+type ILinkOptions interface {
+	// GetLinkOptions returns the options of the link, the zero ones if they were not set.
+	GetLinkOptions(ctx context.Context, shortURL string) (LinkOptions, error)
+	// SetLinkOptions replaces the options of the link.
+	SetLinkOptions(ctx context.Context, shortURL string, opts LinkOptions) error
+}
+
+// Unlimited the visits left of a link without a limit.
+const Unlimited = -1
+
+// IClickLimit is implemented by the storages limiting the visits of the links.
+// Their GetLongLink returns ErrExhausted for a link without visits left and
+// GetAllLinksByCookie reports the visits left of the limited links.
+type IClickLimit interface {
+	// SetMaxClicks limits the visits of the link to n, Unlimited removes the limit.
+	SetMaxClicks(ctx context.Context, shortURL string, n int) error
+	// UseClick takes a visit of the link atomically and returns the visits left, ErrExhausted if none was left.
+	// The visits of the links without a limit are not counted, Unlimited is returned for them.
+	UseClick(ctx context.Context, shortURL string) (int, error)
+}
+
+// Target what a visit of a link needs.
+type Target struct {
+	Long    string      `json:"long"`
+	Options LinkOptions `json:"options"`
+	// Limited the visits of the link are limited, a visit is taken by IClickLimit.UseClick.
+	Limited bool `json:"limited,omitempty"`
+}
+
+// ITargets is implemented by the storages reading the target of a link their own way,
+// like the caches keeping it and the replicas reading it from a replica.
+type ITargets interface {
+	// GetTarget returns the target of the link, the errors are the ones of GetLongLink.
+	GetTarget(ctx context.Context, shortURL string) (Target, error)
+}
+
+// GetTarget returns the target of the link from the first storage of the chain implementing ITargets,
+// it is read by a single IAdmin.GetLink otherwise.
+func GetTarget(ctx context.Context, st IStorage, shortURL string) (Target, error) {
+	if targets, ok := As[ITargets](st); ok {
+		return targets.GetTarget(ctx, shortURL)
+	}
+
+	admin, ok := As[IAdmin](st)
+	if !ok {
+		return getTarget(ctx, st, shortURL)
+	}
+
+	link, err := admin.GetLink(ctx, shortURL)
+	switch {
+	case err != nil:
+		return Target{}, err
+	case link.Deleted:
+		return Target{}, ErrDeleted
+	case link.MaxClicks() == 0:
+		return Target{}, ErrExhausted
+	}
+
+	return Target{Long: link.Long, Options: link.Options, Limited: link.ClicksLeft != nil}, nil
+}
+
+// getTarget reads the target of the link from a storage without IAdmin, its visits are limited
+// if the storage can limit them.
+func getTarget(ctx context.Context, st IStorage, shortURL string) (Target, error) {
+	long, err := st.GetLongLink(ctx, shortURL)
+	if err != nil {
+		return Target{}, err
+	}
+	t := Target{Long: long}
+
+	if options, ok := As[ILinkOptions](st); ok {
+		if t.Options, err = options.GetLinkOptions(ctx, shortURL); err != nil {
+			return Target{}, err
+		}
+	}
+
+	_, t.Limited = As[IClickLimit](st)
+
+	return t, nil
+}
@@ -0,0 +1,135 @@
+// Package storagetest checks that a storage.IStorage behaves like the other backends.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"url-shortener/internal/storage"
+	shortener "url-shortener/pkg/api"
+)
+
+// Run checks the links of st, st must be empty.
+func Run(t *testing.T, st storage.IStorage) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, link := range []storage.Link{
+		{Short: "conf-a", Long: "https://a.conformance.ru", Owner: "conf-alice"},
+		{Short: "conf-b", Long: "https://b.conformance.ru", Owner: "conf-alice"},
+		{Short: "conf-c", Long: "https://c.conformance.ru", Owner: "conf-bob"},
+	} {
+		if _, err := st.AddLink(ctx, link.Long, link.Short, link.Owner); err != nil {
+			t.Fatalf("AddLink(%s) error = %v", link.Short, err)
+		}
+	}
+
+	if long, err := st.GetLongLink(ctx, "conf-a"); err != nil || long != "https://a.conformance.ru" {
+		t.Errorf("GetLongLink() = %q, %v", long, err)
+	}
+
+	if _, err := st.GetLongLink(ctx, "conf-missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetLongLink() of a missing link error = %v, want %v", err, storage.ErrNotFound)
+	}
+
+	if err := st.MarkAsDeleted(ctx, "conf-a", "conf-alice"); err != nil {
+		t.Fatalf("MarkAsDeleted() error = %v", err)
+	}
+
+	if _, err := st.GetLongLink(ctx, "conf-a"); !errors.Is(err, storage.ErrDeleted) {
+		t.Errorf("GetLongLink() of a deleted link error = %v, want %v", err, storage.ErrDeleted)
+	}
+
+	// the deleted links are listed to the owner flagged, the ownership checks rely on it
+	links, err := st.GetAllLinksByCookie(ctx, "conf-alice", "/")
+	if err != nil {
+		t.Fatalf("GetAllLinksByCookie() error = %v", err)
+	}
+
+	sort.Slice(links, func(i, j int) bool { return links[i].ShortUrl < links[j].ShortUrl })
+	want := []*shortener.UserURL{
+		{OriginalUrl: "https://a.conformance.ru", ShortUrl: "/conf-a", Deleted: true},
+		{OriginalUrl: "https://b.conformance.ru", ShortUrl: "/conf-b"},
+	}
+	if len(links) != len(want) {
+		t.Fatalf("GetAllLinksByCookie() = %v, want %v", links, want)
+	}
+	for i := range want {
+		if links[i].OriginalUrl != want[i].OriginalUrl || links[i].ShortUrl != want[i].ShortUrl ||
+			links[i].Deleted != want[i].Deleted {
+			t.Errorf("GetAllLinksByCookie()[%d] = %v, want %v", i, links[i], want[i])
+		}
+	}
+
+	if links, err = st.GetAllLinksByCookie(ctx, "conf-nobody", "/"); err != nil || len(links) != 0 {
+		t.Errorf("GetAllLinksByCookie() of an unknown owner = %v, %v", links, err)
+	}
+
+	if admin, ok := st.(storage.IAdmin); ok {
+		runAdmin(t, st, admin)
+	}
+}
+
+// runAdmin checks that the options and the visits left of a link go along with it.
+func runAdmin(t *testing.T, st storage.IStorage, admin storage.IAdmin) {
+	t.Helper()
+	ctx := context.Background()
+
+	left := 2
+	want := storage.Link{Short: "conf-locked", Long: "https://locked.conformance.ru", Owner: "conf-carol",
+		Options: storage.LinkOptions{Title: "Locked", Preview: true, PasswordHash: "hash"}, ClicksLeft: &left}
+	if err := admin.ImportLink(ctx, want); err != nil {
+		t.Fatalf("ImportLink() error = %v", err)
+	}
+
+	got, err := admin.GetLink(ctx, want.Short)
+	if err != nil {
+		t.Fatalf("GetLink() error = %v", err)
+	}
+	if got.Options != want.Options || got.MaxClicks() != want.MaxClicks() {
+		t.Errorf("GetLink() = %+v, want the options %+v and %d visits left", got, want.Options, left)
+	}
+
+	listed := false
+	err = admin.Links(ctx, func(link storage.Link) error {
+		if link.Short == want.Short {
+			listed = link.Options == want.Options && link.MaxClicks() == want.MaxClicks()
+		}
+		return nil
+	})
+	if err != nil || !listed {
+		t.Errorf("Links() did not list %s with its options and visits left, error = %v", want.Short, err)
+	}
+
+	if err = admin.PurgeLink(ctx, want.Short); err != nil {
+		t.Fatalf("PurgeLink() error = %v", err)
+	}
+
+	// a link taking the short URL again starts without them
+	if err = admin.ImportLink(ctx, storage.Link{Short: want.Short, Long: want.Long, Owner: want.Owner}); err != nil {
+		t.Fatalf("ImportLink() error = %v", err)
+	}
+
+	if got, err = admin.GetLink(ctx, want.Short); err != nil {
+		t.Fatalf("GetLink() error = %v", err)
+	}
+	if got.Options != (storage.LinkOptions{}) || got.ClicksLeft != nil {
+		t.Errorf("GetLink() after PurgeLink() = %+v, want no options and no limit", got)
+	}
+
+	// AddLinks adds them along with the link too
+	added := want
+	added.Short, added.Long = "conf-added", "https://added.conformance.ru"
+	results, err := st.AddLinks(ctx, []storage.Link{added})
+	if err != nil || len(results) != 1 || results[0].Exists {
+		t.Fatalf("AddLinks() = %v, %v", results, err)
+	}
+
+	if got, err = admin.GetLink(ctx, added.Short); err != nil {
+		t.Fatalf("GetLink() error = %v", err)
+	}
+	if got.Options != want.Options || got.MaxClicks() != want.MaxClicks() {
+		t.Errorf("GetLink() of the added link = %+v, want the options %+v and %d visits left", got, want.Options, left)
+	}
+}
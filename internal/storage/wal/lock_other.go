@@ -0,0 +1,18 @@
+//go:build !unix
+
+package wal
+
+import (
+	"fmt"
+	"os"
+)
+
+// lock creates the lock file at path, the other systems are not protected from a second process.
+func lock(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s: %w", path, err)
+	}
+
+	return file, nil
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lock takes an exclusive lock of the file at path, another process holding it gives ErrInUse.
+// The lock is released when the returned file is closed.
+func lock(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s: %w", path, err)
+	}
+
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s is locked by another process", ErrInUse, path)
+		}
+		return nil, fmt.Errorf("can't lock %s: %w", path, err)
+	}
+
+	return file, nil
+}
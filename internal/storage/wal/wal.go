@@ -0,0 +1,406 @@
+// Package wal implements an append-only log of checksummed JSON records.
+//
+// Every record is a line "<crc32> <json>\n" where crc32 is the hex Castagnoli
+// checksum of the JSON. JSON never contains a raw newline, so any value can be stored.
+// A torn final record, left by a crash in the middle of a write, is dropped on Open.
+// A damaged record anywhere else is reported as ErrCorrupted.
+//
+// An open log holds an exclusive lock of the file "<path>.lock", so two processes,
+// e.g. the server and shortenerctl, never write the same log: the second Open fails with ErrInUse.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SyncPolicy defines when the appended records are flushed to the disk.
+type SyncPolicy string
+
+// Sync policies.
+const (
+	// SyncAlways flushes every record before Append returns.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval flushes the records once per SyncEvery, a crash loses up to SyncEvery of writes.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+// SyncEvery the period of SyncInterval.
+const SyncEvery = time.Second
+
+// ErrCorrupted occurs when a record in the middle of a file does not match its checksum.
+var ErrCorrupted = errors.New("corrupted record")
+
+// ErrInUse occurs when the log is opened by another process.
+var ErrInUse = errors.New("storage in use")
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumLen length of the hex checksum and the space after it.
+const checksumLen = 9
+
+// ParseSyncPolicy validates the policy, SyncAlways is used for the empty name.
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch p := SyncPolicy(name); p {
+	case "":
+		return SyncAlways, nil
+	case SyncAlways, SyncInterval, SyncNever:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown sync policy %q, use always, interval or never", name)
+}
+
+// logFile the operations of *os.File used by the Log.
+type logFile interface {
+	io.Writer
+	Truncate(size int64) error
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Close() error
+}
+
+// Log append-only file of records, safe for concurrent use.
+type Log struct {
+	mu     sync.Mutex
+	path   string
+	file   logFile
+	policy SyncPolicy
+	// size the end of the last appended record, a failed append is cut back to it.
+	size int64
+	// broken is set when a failed append could not be cut off, the next appends would follow it.
+	broken error
+	// dirty is true when there are records not flushed by SyncInterval yet.
+	dirty bool
+	// lock the locked file, closing it releases the log.
+	lock *os.File
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens the log at path, creating it if needed, and calls replay for every record.
+// A torn final record is cut off the file. The log opened by another process gives ErrInUse.
+func Open(path string, policy SyncPolicy, replay func(data []byte) error) (*Log, error) {
+	// the lock is taken on a file of its own, Rewrite replaces the file of the log
+	locked, err := lock(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		locked.Close()
+		return nil, fmt.Errorf("can't open %s: %w", path, err)
+	}
+
+	size, good, err := read(file, false, replay)
+	if err != nil {
+		file.Close()
+		locked.Close()
+		return nil, fmt.Errorf("can't replay %s: %w", path, err)
+	}
+
+	if good < size {
+		log.Printf("wal: %s: dropped a torn record at offset %d", path, good)
+
+		if err = file.Truncate(good); err != nil {
+			file.Close()
+			locked.Close()
+			return nil, fmt.Errorf("can't truncate %s: %w", path, err)
+		}
+	}
+
+	l := &Log{path: path, file: file, policy: policy, size: good, lock: locked}
+	if policy == SyncInterval {
+		l.stop, l.done = make(chan struct{}), make(chan struct{})
+		go l.syncer()
+	}
+
+	return l, nil
+}
+
+// Append writes the records encoded as JSON by one write and one sync.
+// A failed write or sync is cut off the file, so either all the records are appended or none.
+// If that fails too, the log refuses the next appends. A crash during the write leaves
+// a torn final record, Open drops it.
+func (l *Log) Append(vs ...any) error {
+	var lines []byte
+	for _, v := range vs {
+		line, err := encode(v)
+		if err != nil {
+			return err
+		}
+
+		lines = append(lines, line...)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.broken != nil {
+		return l.broken
+	}
+
+	if _, err := l.file.Write(lines); err != nil {
+		return l.undo(fmt.Errorf("can't append to %s: %w", l.path, err))
+	}
+
+	switch l.policy {
+	case SyncAlways:
+		if err := l.file.Sync(); err != nil {
+			return l.undo(fmt.Errorf("can't sync %s: %w", l.path, err))
+		}
+	case SyncInterval:
+		l.dirty = true
+	}
+
+	l.size += int64(len(lines))
+
+	return nil
+}
+
+// undo cuts the records of the failed append off the file, the log is broken if it can't.
+func (l *Log) undo(err error) error {
+	if truncErr := l.file.Truncate(l.size); truncErr != nil {
+		l.broken = fmt.Errorf("%s has a partial record, it can't be cut off: %v", l.path, truncErr)
+		return fmt.Errorf("%w, %v", err, l.broken)
+	}
+
+	return err
+}
+
+// Rewrite atomically replaces the records of the log with the ones written by fn.
+// Appends wait until the rewrite is done.
+func (l *Log) Rewrite(fn func(write func(v any) error) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := WriteFile(l.path, fn); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("can't reopen %s: %w", l.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("can't reopen %s: %w", l.path, err)
+	}
+
+	// the rewritten file has no partial records
+	l.file.Close()
+	l.file, l.size, l.broken, l.dirty = file, info.Size(), nil, false
+
+	return nil
+}
+
+// Size returns the size of the log in bytes.
+func (l *Log) Size() (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, err := l.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// Close flushes the records, closes the file and releases the lock.
+func (l *Log) Close() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.lock.Close()
+
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return fmt.Errorf("can't sync %s: %w", l.path, err)
+	}
+
+	return l.file.Close()
+}
+
+func (l *Log) syncer() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(SyncEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		l.mu.Lock()
+		if l.dirty {
+			if err := l.file.Sync(); err != nil {
+				log.Printf("wal: can't sync %s: %v", l.path, err)
+			} else {
+				l.dirty = false
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// WriteFile atomically replaces the file at path with the records written by fn.
+// The records are flushed to a temporary file that is renamed over path.
+func WriteFile(path string, fn func(write func(v any) error) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("can't create a file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	err = fn(func(v any) error {
+		line, err := encode(v)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(line)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = w.Flush(); err != nil {
+		return fmt.Errorf("can't write %s: %w", tmp.Name(), err)
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("can't sync %s: %w", tmp.Name(), err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("can't close %s: %w", tmp.Name(), err)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("can't replace %s: %w", path, err)
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// ReadFile calls fn for every record of the file written by WriteFile.
+// Unlike Open, a torn final record is an error too.
+func ReadFile(path string, fn func(data []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, _, err = read(file, true, fn); err != nil {
+		return fmt.Errorf("can't read %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// read calls fn for every record, it returns the file size and the end of the last good record.
+// A bad final record is only reported if strict is true.
+func read(file *os.File, strict bool, fn func(data []byte) error) (size, good int64, err error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	size = info.Size()
+
+	r := bufio.NewReader(file)
+	for good < size {
+		line, err := r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return size, good, err
+		}
+
+		data, ok := decode(line)
+		if !ok {
+			if !strict && good+int64(len(line)) == size {
+				return size, good, nil
+			}
+			return size, good, fmt.Errorf("%w at offset %d", ErrCorrupted, good)
+		}
+
+		if err = fn(data); err != nil {
+			return size, good, fmt.Errorf("record at offset %d: %w", good, err)
+		}
+		good += int64(len(line))
+	}
+
+	return size, good, nil
+}
+
+func encode(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("can't encode the record: %w", err)
+	}
+
+	line := make([]byte, 0, checksumLen+len(data)+1)
+	line = fmt.Appendf(line, "%08x ", crc32.Checksum(data, table))
+	line = append(line, data...)
+
+	return append(line, '\n'), nil
+}
+
+// decode returns the JSON of the line if the checksum matches.
+func decode(line []byte) ([]byte, bool) {
+	if len(line) <= checksumLen || line[len(line)-1] != '\n' || line[checksumLen-1] != ' ' {
+		return nil, false
+	}
+
+	var sum [4]byte
+	if _, err := hex.Decode(sum[:], line[:checksumLen-1]); err != nil {
+		return nil, false
+	}
+
+	data := bytes.TrimSuffix(line[checksumLen:], []byte{'\n'})
+	want := uint32(sum[0])<<24 | uint32(sum[1])<<16 | uint32(sum[2])<<8 | uint32(sum[3])
+
+	return data, crc32.Checksum(data, table) == want
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// some file systems do not support syncing directories
+	if err = d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return fmt.Errorf("can't sync %s: %w", dir, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type rec struct {
+	N int    `json:"n"`
+	S string `json:"s,omitempty"`
+}
+
+func replayAll(t *testing.T, path string) ([]string, *Log, error) {
+	t.Helper()
+
+	var got []string
+	l, err := Open(path, SyncAlways, func(data []byte) error {
+		got = append(got, string(data))
+		return nil
+	})
+
+	return got, l, err
+}
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+
+	_, l, err := replayAll(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []rec{{N: 1, S: "a - b\nc"}, {N: 2}} {
+		if err = l.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.NoError(t, l.Close())
+
+	got, l, err := replayAll(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{`{"n":1,"s":"a - b\nc"}`, `{"n":2}`}, got)
+
+	err = l.Rewrite(func(write func(v any) error) error {
+		return write(rec{N: 3})
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, l.Append(rec{N: 4}, rec{N: 5}))
+	assert.NoError(t, l.Close())
+
+	got, l, err = replayAll(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{`{"n":3}`, `{"n":4}`, `{"n":5}`}, got)
+	assert.NoError(t, l.Close())
+
+	matches, _ := filepath.Glob(path + ".*")
+	assert.Equal(t, []string{path + ".lock"}, matches, "temporary files are left")
+}
+
+func TestOpen_InUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+
+	_, l, err := replayAll(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = replayAll(t, path)
+	assert.True(t, errors.Is(err, ErrInUse), err)
+
+	assert.NoError(t, l.Close())
+
+	_, l, err = replayAll(t, path)
+	if assert.NoError(t, err) {
+		assert.NoError(t, l.Close())
+	}
+}
+
+func TestOpen_Damaged(t *testing.T) {
+	good, _ := encode(rec{N: 1})
+	other, _ := encode(rec{N: 2})
+	flipped := strings.Replace(string(other), `"n":2`, `"n":3`, 1)
+
+	tests := []struct {
+		name     string
+		data     string
+		want     []string
+		wantSize int64
+		wantErr  error
+	}{
+		{
+			name:     "torn final record",
+			data:     string(good) + string(other[:len(other)-5]),
+			want:     []string{`{"n":1}`},
+			wantSize: int64(len(good)),
+		},
+		{
+			name:     "final record without newline",
+			data:     string(good) + string(other[:len(other)-1]),
+			want:     []string{`{"n":1}`},
+			wantSize: int64(len(good)),
+		},
+		{
+			name:     "bad checksum of the final record",
+			data:     string(good) + flipped,
+			want:     []string{`{"n":1}`},
+			wantSize: int64(len(good)),
+		},
+		{
+			name:    "bad checksum in the middle",
+			data:    flipped + string(good),
+			wantErr: ErrCorrupted,
+		},
+		{
+			name:    "garbage in the middle",
+			data:    "1 - zE - https://ya.ru - alice\n" + string(good),
+			wantErr: ErrCorrupted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, l, err := replayAll(t, path)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			assert.Equal(t, tt.want, got)
+
+			size, err := l.Size()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSize, size)
+		})
+	}
+}
+
+// failingFile writes a half of the next record and fails, the truncation fails too if truncErr is set.
+type failingFile struct {
+	logFile
+	fail     bool
+	truncErr error
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	if !f.fail {
+		return f.logFile.Write(p)
+	}
+
+	n, _ := f.logFile.Write(p[:len(p)/2])
+	return n, errors.New("disk full")
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.truncErr != nil {
+		return f.truncErr
+	}
+
+	return f.logFile.Truncate(size)
+}
+
+func TestLog_Append_Failed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+
+	_, l, err := replayAll(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, l.Append(rec{N: 1}))
+
+	f := &failingFile{logFile: l.file, fail: true}
+	l.file = f
+	assert.Error(t, l.Append(rec{N: 2}, rec{N: 3}))
+
+	f.fail = false
+	assert.NoError(t, l.Append(rec{N: 4}), "the partial records are cut off")
+
+	f.fail, f.truncErr = true, errors.New("read-only file system")
+	assert.Error(t, l.Append(rec{N: 5}))
+
+	f.fail, f.truncErr = false, nil
+	assert.Error(t, l.Append(rec{N: 6}), "the log is broken")
+	assert.NoError(t, l.Close())
+
+	// only the final record may be torn, it is dropped
+	got, l, err := replayAll(t, path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{`{"n":1}`, `{"n":4}`}, got)
+		assert.NoError(t, l.Close())
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+
+	err := WriteFile(path, func(write func(v any) error) error {
+		return write(rec{N: 1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = ReadFile(path, func(data []byte) error {
+		got = append(got, string(data))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"n":1}`}, got)
+
+	data, _ := os.ReadFile(path)
+	if err = os.WriteFile(path, data[:len(data)-2], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = ReadFile(path, func([]byte) error { return nil })
+	assert.True(t, errors.Is(err, ErrCorrupted), err)
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for name, want := range map[string]SyncPolicy{"": SyncAlways, "interval": SyncInterval, "never": SyncNever} {
+		got, err := ParseSyncPolicy(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParseSyncPolicy("sometimes")
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+package transfer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"url-shortener/internal/storage"
+)
+
+// record the JSON Lines representation of storage.Link.
+type record struct {
+	Short     string     `json:"short"`
+	Long      string     `json:"long"`
+	Owner     string     `json:"owner"`
+	Deleted   bool       `json:"deleted"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+
+	Title        string `json:"title,omitempty"`
+	Preview      bool   `json:"preview,omitempty"`
+	PasswordHash string `json:"password_hash,omitempty"`
+	// ClicksLeft is nil for the links without a limit.
+	ClicksLeft *int `json:"clicks_left,omitempty"`
+}
+
+type jsonEncoder struct {
+	enc *json.Encoder
+}
+
+func newJSONEncoder(w io.Writer) *jsonEncoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	return &jsonEncoder{enc: enc}
+}
+
+func (e *jsonEncoder) encode(link storage.Link) error {
+	rec := record{Short: link.Short, Long: link.Long, Owner: link.Owner, Deleted: link.Deleted,
+		Title: link.Options.Title, Preview: link.Options.Preview, PasswordHash: link.Options.PasswordHash,
+		ClicksLeft: link.ClicksLeft}
+	if !link.CreatedAt.IsZero() {
+		rec.CreatedAt = &link.CreatedAt
+	}
+
+	return e.enc.Encode(rec)
+}
+
+func (e *jsonEncoder) flush() error {
+	return nil
+}
+
+type jsonDecoder struct {
+	dec *json.Decoder
+}
+
+func newJSONDecoder(r io.Reader) *jsonDecoder {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	return &jsonDecoder{dec: dec}
+}
+
+func (d *jsonDecoder) decode() (storage.Link, error) {
+	var rec record
+	if err := d.dec.Decode(&rec); err != nil {
+		return storage.Link{}, err
+	}
+
+	return newLink(rec)
+}
+
+// header columns of the CSV format.
+var header = []string{"short", "long", "owner", "deleted", "created_at", "title", "preview", "password_hash", "clicks_left"}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVEncoder(w io.Writer) *csvEncoder {
+	return &csvEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *csvEncoder) encode(link storage.Link) error {
+	if !e.header {
+		if err := e.w.Write(header); err != nil {
+			return err
+		}
+		e.header = true
+	}
+
+	var created, left string
+	if !link.CreatedAt.IsZero() {
+		created = link.CreatedAt.Format(time.RFC3339Nano)
+	}
+	if link.ClicksLeft != nil {
+		left = strconv.Itoa(*link.ClicksLeft)
+	}
+
+	opts := link.Options
+
+	return e.w.Write([]string{link.Short, link.Long, link.Owner, strconv.FormatBool(link.Deleted), created,
+		opts.Title, strconv.FormatBool(opts.Preview), opts.PasswordHash, left})
+}
+
+// flush writes the header if there were no links.
+func (e *csvEncoder) flush() error {
+	if !e.header {
+		if err := e.w.Write(header); err != nil {
+			return err
+		}
+	}
+
+	e.w.Flush()
+
+	return e.w.Error()
+}
+
+type csvDecoder struct {
+	r *csv.Reader
+	// columns maps the header columns to the indexes of the row.
+	columns map[string]int
+}
+
+func newCSVDecoder(r io.Reader) (*csvDecoder, error) {
+	d := &csvDecoder{r: csv.NewReader(r), columns: make(map[string]int, len(header))}
+	d.r.ReuseRecord = true
+
+	row, err := d.r.Read()
+	if errors.Is(err, io.EOF) {
+		return d, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("can't read the header: %w", err)
+	}
+
+	for i, column := range row {
+		d.columns[column] = i
+	}
+
+	for _, column := range []string{"short", "long"} {
+		if _, ok := d.columns[column]; !ok {
+			return nil, fmt.Errorf("the header has no %q column", column)
+		}
+	}
+
+	if len(d.columns) != len(row) {
+		return nil, errors.New("the header has duplicate columns")
+	}
+
+	for column := range d.columns {
+		if !known(column) {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+	}
+
+	return d, nil
+}
+
+func (d *csvDecoder) decode() (storage.Link, error) {
+	row, err := d.r.Read()
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	value := func(column string) string {
+		if i, ok := d.columns[column]; ok {
+			return row[i]
+		}
+		return ""
+	}
+
+	rec := record{Short: value("short"), Long: value("long"), Owner: value("owner"), Title: value("title"),
+		PasswordHash: value("password_hash")}
+
+	if deleted := value("deleted"); deleted != "" {
+		rec.Deleted, err = strconv.ParseBool(deleted)
+		if err != nil {
+			return storage.Link{}, fmt.Errorf("invalid deleted %q", deleted)
+		}
+	}
+
+	if preview := value("preview"); preview != "" {
+		rec.Preview, err = strconv.ParseBool(preview)
+		if err != nil {
+			return storage.Link{}, fmt.Errorf("invalid preview %q", preview)
+		}
+	}
+
+	if clicks := value("clicks_left"); clicks != "" {
+		left, err := strconv.Atoi(clicks)
+		if err != nil || left < 0 {
+			return storage.Link{}, fmt.Errorf("invalid clicks_left %q", clicks)
+		}
+		rec.ClicksLeft = &left
+	}
+
+	if created := value("created_at"); created != "" {
+		t, err := time.Parse(time.RFC3339Nano, created)
+		if err != nil {
+			return storage.Link{}, fmt.Errorf("invalid created_at %q", created)
+		}
+		rec.CreatedAt = &t
+	}
+
+	return newLink(rec)
+}
+
+func known(column string) bool {
+	for _, c := range header {
+		if c == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newLink(rec record) (storage.Link, error) {
+	if rec.Short == "" || rec.Long == "" {
+		return storage.Link{}, errors.New("short and long are required")
+	}
+
+	if rec.ClicksLeft != nil && *rec.ClicksLeft < 0 {
+		return storage.Link{}, errors.New("clicks_left can't be negative")
+	}
+
+	link := storage.Link{Short: rec.Short, Long: rec.Long, Owner: rec.Owner, Deleted: rec.Deleted,
+		Options:    storage.LinkOptions{Title: rec.Title, Preview: rec.Preview, PasswordHash: rec.PasswordHash},
+		ClicksLeft: rec.ClicksLeft}
+	if rec.CreatedAt != nil {
+		link.CreatedAt = *rec.CreatedAt
+	}
+
+	return link, nil
+}
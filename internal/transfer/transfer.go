@@ -0,0 +1,145 @@
+// Package transfer moves links between storages through JSON Lines and CSV.
+//
+// Links are read and written one by one, so the memory used does not depend
+// on the number of links as long as the storage streams them as well.
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"url-shortener/internal/storage"
+)
+
+// Format of the exported data.
+type Format string
+
+// Supported formats.
+const (
+	JSONLines Format = "jsonl"
+	CSV       Format = "csv"
+)
+
+// ErrFormat occurs when the format is not supported.
+var ErrFormat = errors.New("unknown format, use jsonl or csv")
+
+// ParseFormat returns the Format by name, JSON Lines is used for the empty name.
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "", JSONLines:
+		return JSONLines, nil
+	case CSV:
+		return CSV, nil
+	}
+
+	return "", ErrFormat
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv"
+	}
+
+	return "application/x-ndjson"
+}
+
+type encoder interface {
+	encode(link storage.Link) error
+	flush() error
+}
+
+type decoder interface {
+	// decode returns io.EOF when there are no links left.
+	decode() (storage.Link, error)
+}
+
+// Export writes every link of src to w. It returns the number of links written.
+func Export(ctx context.Context, src storage.IAdmin, w io.Writer, f Format) (int, error) {
+	buf := bufio.NewWriter(w)
+
+	var enc encoder
+	switch f {
+	case JSONLines:
+		enc = newJSONEncoder(buf)
+	case CSV:
+		enc = newCSVEncoder(buf)
+	default:
+		return 0, ErrFormat
+	}
+
+	var count int
+	err := src.Links(ctx, func(link storage.Link) error {
+		if err := enc.encode(link); err != nil {
+			return err
+		}
+		count++
+
+		return nil
+	})
+	if err != nil {
+		return count, fmt.Errorf("can't export link %d: %w", count+1, err)
+	}
+
+	if err = enc.flush(); err != nil {
+		return count, fmt.Errorf("can't export: %w", err)
+	}
+
+	return count, buf.Flush()
+}
+
+// Import saves every link read from r into dst. It returns the number of links saved,
+// the import stops on the first error.
+func Import(ctx context.Context, dst storage.IAdmin, r io.Reader, f Format) (int, error) {
+	buf := bufio.NewReader(r)
+
+	var (
+		dec decoder
+		err error
+	)
+
+	switch f {
+	case JSONLines:
+		dec = newJSONDecoder(buf)
+	case CSV:
+		dec, err = newCSVDecoder(buf)
+	default:
+		return 0, ErrFormat
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("can't import: %w", err)
+	}
+
+	var count int
+	for {
+		link, err := dec.decode()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		} else if err != nil {
+			return count, fmt.Errorf("can't read link %d: %w", count+1, err)
+		}
+
+		if err = dst.ImportLink(ctx, link); err != nil {
+			return count, fmt.Errorf("can't import %s: %w", link.Short, err)
+		}
+		count++
+	}
+}
+
+// Copy saves every link of src into dst. It returns the number of links saved.
+func Copy(ctx context.Context, dst, src storage.IAdmin) (int, error) {
+	var count int
+	err := src.Links(ctx, func(link storage.Link) error {
+		if err := dst.ImportLink(ctx, link); err != nil {
+			return fmt.Errorf("can't import %s: %w", link.Short, err)
+		}
+		count++
+
+		return nil
+	})
+
+	return count, err
+}
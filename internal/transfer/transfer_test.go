@@ -0,0 +1,201 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"url-shortener/internal/storage"
+	dbstorage "url-shortener/internal/storage/db"
+	filestorage "url-shortener/internal/storage/file"
+	mapstorage "url-shortener/internal/storage/map"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var links = []storage.Link{
+	{Short: "zE", Long: "https://ya.ru", Owner: "alice", CreatedAt: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)},
+	{Short: "Xz", Long: "https://go.dev/?a=1&b=2", Owner: "bob", Deleted: true,
+		CreatedAt: time.Date(2023, 2, 3, 4, 5, 6, 0, time.UTC)},
+	{Short: "legacy", Long: "https://example.com/a,b", Owner: "alice"},
+	{Short: "locked", Long: "https://example.com/secret", Owner: "bob", CreatedAt: time.Date(2023, 3, 4, 5, 6, 7, 0, time.UTC),
+		Options: storage.LinkOptions{Title: "Secret", Preview: true, PasswordHash: "$2a$10$hash"}, ClicksLeft: clicks(2)},
+}
+
+func clicks(n int) *int {
+	return &n
+}
+
+func newSource(t *testing.T) storage.IAdmin {
+	t.Helper()
+
+	src := mapstorage.NewMapStorage().(storage.IAdmin)
+	for _, link := range links {
+		if err := src.ImportLink(context.Background(), link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return src
+}
+
+func export(t *testing.T, src storage.IAdmin, f Format) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if _, err := Export(context.Background(), src, &buf, f); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestExport(t *testing.T) {
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{
+			format: JSONLines,
+			want: `{"short":"Xz","long":"https://go.dev/?a=1&b=2","owner":"bob","deleted":true,"created_at":"2023-02-03T04:05:06Z"}
+{"short":"legacy","long":"https://example.com/a,b","owner":"alice","deleted":false}
+{"short":"locked","long":"https://example.com/secret","owner":"bob","deleted":false,"created_at":"2023-03-04T05:06:07Z","title":"Secret","preview":true,"password_hash":"$2a$10$hash","clicks_left":2}
+{"short":"zE","long":"https://ya.ru","owner":"alice","deleted":false,"created_at":"2023-01-02T03:04:05Z"}
+`,
+		},
+		{
+			format: CSV,
+			want: `short,long,owner,deleted,created_at,title,preview,password_hash,clicks_left
+Xz,https://go.dev/?a=1&b=2,bob,true,2023-02-03T04:05:06Z,,false,,
+legacy,"https://example.com/a,b",alice,false,,,false,,
+locked,https://example.com/secret,bob,false,2023-03-04T05:06:07Z,Secret,true,$2a$10$hash,2
+zE,https://ya.ru,alice,false,2023-01-02T03:04:05Z,,false,,
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			assert.Equal(t, tt.want, export(t, newSource(t), tt.format))
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	for _, f := range []Format{JSONLines, CSV} {
+		t.Run(string(f), func(t *testing.T) {
+			data := export(t, newSource(t), f)
+
+			dst, err := filestorage.NewFileStorage(filestorage.Config{Path: filepath.Join(t.TempDir(), "links.txt")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			count, err := Import(context.Background(), dst.(storage.IAdmin), strings.NewReader(data), f)
+			assert.NoError(t, err)
+			assert.Equal(t, len(links), count)
+
+			for _, want := range links {
+				got, err := dst.(storage.IAdmin).GetLink(context.Background(), want.Short)
+				if assert.NoError(t, err) {
+					assert.Equal(t, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestImport_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    Format
+		data      string
+		wantCount int
+	}{
+		{
+			name:   "unknown format",
+			format: "xml",
+		},
+		{
+			name:   "unknown field",
+			format: JSONLines,
+			data:   `{"short":"a","long":"https://a.ru","id":1}`,
+		},
+		{
+			name:      "missing long",
+			format:    JSONLines,
+			data:      "{\"short\":\"a\",\"long\":\"https://a.ru\"}\n{\"short\":\"b\"}\n",
+			wantCount: 1,
+		},
+		{
+			name:   "header without short",
+			format: CSV,
+			data:   "long,owner\nhttps://a.ru,alice\n",
+		},
+		{
+			name:   "unknown column",
+			format: CSV,
+			data:   "short,long,id\na,https://a.ru,1\n",
+		},
+		{
+			name:   "invalid deleted",
+			format: CSV,
+			data:   "short,long,deleted\na,https://a.ru,maybe\n",
+		},
+		{
+			name:   "invalid clicks_left",
+			format: CSV,
+			data:   "short,long,clicks_left\na,https://a.ru,-1\n",
+		},
+		{
+			name:   "negative clicks_left",
+			format: JSONLines,
+			data:   `{"short":"a","long":"https://a.ru","clicks_left":-1}`,
+		},
+		{
+			name:   "invalid created_at",
+			format: CSV,
+			data:   "short,long,created_at\na,https://a.ru,yesterday\n",
+		},
+		{
+			name:      "duplicate short",
+			format:    CSV,
+			data:      "long,short\nhttps://a.ru,a\nhttps://b.ru,a\n",
+			wantCount: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := mapstorage.NewMapStorage().(storage.IAdmin)
+
+			count, err := Import(context.Background(), dst, strings.NewReader(tt.data), tt.format)
+			assert.Error(t, err)
+			assert.Equal(t, tt.wantCount, count)
+		})
+	}
+}
+
+func TestCopy(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "links.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	irs, err := dbstorage.NewRealStorage(db, "sqlite3", dbstorage.Options{Migrate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := irs.(storage.IAdmin)
+	t.Cleanup(func() { irs.Shutdown() })
+
+	src := newSource(t)
+
+	count, err := Copy(context.Background(), dst, src)
+	assert.NoError(t, err)
+	assert.Equal(t, len(links), count)
+
+	// sqlite3 keeps the order of insertion
+	assert.Equal(t, export(t, src, JSONLines), export(t, dst, JSONLines))
+}
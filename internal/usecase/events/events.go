@@ -0,0 +1,65 @@
+// Package events is the bus of the link lifecycle events published by the usecase layer.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Type of an event.
+type Type string
+
+// Event types.
+const (
+	// LinkCreated a link was created by its owner.
+	LinkCreated Type = "link.created"
+	// LinkUpdated a link was saved by an import, it may have not existed before.
+	LinkUpdated Type = "link.updated"
+	// LinkDeleted the owner asked to delete a link, Owner is the one who asked.
+	LinkDeleted Type = "link.deleted"
+	// LinkClicked a link was followed, Owner and Long are not known.
+	LinkClicked Type = "link.clicked"
+)
+
+// Event a change of a link.
+type Event struct {
+	Type  Type
+	Short string
+	Long  string
+	Owner string
+	At    time.Time
+}
+
+// Bus delivers the events to the subscribers, safe for concurrent use.
+// Subscribers are called synchronously in the goroutine of Publish, so they must not block.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers []func(Event)
+}
+
+// NewBus creates an instance of the Bus.
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe calls fn for every event published after.
+func (b *Bus) Subscribe(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// Publish delivers the event, a zero At is set to now.
+func (b *Bus) Publish(e Event) {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, fn := range b.subscribers {
+		fn(e)
+	}
+}
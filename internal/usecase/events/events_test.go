@@ -0,0 +1,28 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBus_Publish(t *testing.T) {
+	b := NewBus()
+
+	var first, second []Event
+	b.Subscribe(func(e Event) { first = append(first, e) })
+	b.Publish(Event{Type: LinkCreated, Short: "a"})
+	b.Subscribe(func(e Event) { second = append(second, e) })
+
+	at := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	b.Publish(Event{Type: LinkDeleted, Short: "a", At: at})
+
+	if len(first) != 2 || len(second) != 1 {
+		t.Fatalf("Publish() delivered %d and %d events, want 2 and 1", len(first), len(second))
+	}
+	if first[0].At.IsZero() {
+		t.Error("Publish() did not set At")
+	}
+	if !second[0].At.Equal(at) || second[0].Type != LinkDeleted {
+		t.Errorf("Publish() delivered %+v", second[0])
+	}
+}
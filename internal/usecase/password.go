@@ -0,0 +1,134 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxPasswordLength the longest password of a link in bytes, bcrypt ignores the rest.
+const MaxPasswordLength = 72
+
+const (
+	// MaxPasswordAttempts the wrong passwords a client may try in PasswordAttemptsWindow.
+	MaxPasswordAttempts = 5
+	// PasswordAttemptsWindow the period the wrong passwords of a client are counted in.
+	PasswordAttemptsWindow = time.Minute
+)
+
+var (
+	// ErrPasswordTooLong occurs when the password of a link is longer than MaxPasswordLength.
+	ErrPasswordTooLong = fmt.Errorf("password is longer than %d bytes", MaxPasswordLength)
+	// ErrPasswordRequired occurs when a protected link is opened without the password.
+	ErrPasswordRequired = errors.New("link is protected by a password")
+	// ErrWrongPassword occurs when a protected link is opened with a wrong password.
+	ErrWrongPassword = errors.New("wrong password")
+	// ErrTooManyAttempts occurs when the client tried MaxPasswordAttempts wrong passwords recently.
+	ErrTooManyAttempts = errors.New("too many wrong passwords, try again later")
+	// ErrUnknownClient occurs when a password is tried by a client without an IP, its attempts can't be counted.
+	ErrUnknownClient = errors.New("the password can't be checked for a client with an unknown address")
+)
+
+// checkPassword checks the password of a protected link, the wrong passwords are counted
+// per IP of the client. The attempt is taken before the password is compared, so the concurrent
+// attempts of a client can't exceed the limit, and given back if the password is right.
+func (uc UseCase) checkPassword(hash, password, client string) error {
+	if password == "" {
+		return ErrPasswordRequired
+	}
+
+	if client == "" {
+		return ErrUnknownClient
+	}
+
+	start, ok := uc.attempts.reserve(client, time.Now())
+	if !ok {
+		return ErrTooManyAttempts
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	uc.attempts.refund(client, start)
+
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) > MaxPasswordLength {
+		return "", ErrPasswordTooLong
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("can't hash the password: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+// maxThrottledClients the throttle forgets the clients with a passed window when this number is reached.
+const maxThrottledClients = 10000
+
+// throttle counts the wrong passwords of every client in fixed windows.
+type throttle struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	clients map[string]*attempts
+}
+
+type attempts struct {
+	failures int
+	start    time.Time
+}
+
+func newThrottle(limit int, window time.Duration) *throttle {
+	return &throttle{limit: limit, window: window, clients: make(map[string]*attempts)}
+}
+
+// reserve takes an attempt of the client and returns the start of its window, false if no attempt
+// is left. The attempt counts as a wrong password unless it is refunded.
+func (t *throttle) reserve(client string, now time.Time) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.clients[client]
+	if !ok || now.Sub(a.start) >= t.window {
+		if !ok && len(t.clients) >= maxThrottledClients {
+			t.evict(now)
+		}
+
+		a = &attempts{start: now}
+		t.clients[client] = a
+	}
+
+	if a.failures >= t.limit {
+		return time.Time{}, false
+	}
+	a.failures++
+
+	return a.start, true
+}
+
+// refund gives back the attempt of the client reserved in the window started at start.
+func (t *throttle) refund(client string, start time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if a, ok := t.clients[client]; ok && a.start.Equal(start) && a.failures > 0 {
+		a.failures--
+	}
+}
+
+// evict removes the clients with a passed window.
+func (t *throttle) evict(now time.Time) {
+	for client, a := range t.clients {
+		if now.Sub(a.start) >= t.window {
+			delete(t.clients, client)
+		}
+	}
+}
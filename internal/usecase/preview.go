@@ -0,0 +1,123 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+	"unicode/utf8"
+	"url-shortener/internal/audit"
+	"url-shortener/internal/storage"
+)
+
+// MaxTitleLength the longest title of a link, in runes.
+const MaxTitleLength = 200
+
+// ErrTitleTooLong occurs when the title of a link is longer than MaxTitleLength.
+var ErrTitleTooLong = fmt.Errorf("title is longer than %d characters", MaxTitleLength)
+
+// Preview what the preview page of a link shows.
+type Preview struct {
+	Short string
+	// Long and Domain are empty for the links with limited visits, see Limited.
+	Long   string
+	Domain string
+	// CreatedAt is zero for links created before timestamps were stored.
+	CreatedAt time.Time
+	Title     string
+	// ClicksLeft the visits left of the link, storage.Unlimited if they are not limited.
+	ClicksLeft int
+}
+
+// Limited reports whether the visits of the link are limited. The destination of such a link
+// is not previewed, it is revealed by a visit only, so the preview can't be used to skip the limit.
+func (p Preview) Limited() bool {
+	return p.ClicksLeft != storage.Unlimited
+}
+
+// GetPreview returns the preview of the link, storage.ErrDeleted if it was deleted,
+// storage.ErrExhausted if no visit is left and ErrPasswordRequired if it is protected.
+// The preview does not take a visit.
+func (uc UseCase) GetPreview(ctx context.Context, shortURL string) (Preview, error) {
+	longURL, err := uc.storage.GetLongLink(ctx, shortURL)
+	if err != nil {
+		return Preview{}, err
+	}
+	p := Preview{Short: shortURL, Long: longURL, ClicksLeft: storage.Unlimited}
+
+	if admin, ok := storage.As[storage.IAdmin](uc.storage); ok {
+		link, err := admin.GetLink(ctx, shortURL)
+		if err != nil {
+			return Preview{}, err
+		}
+		p.CreatedAt, p.ClicksLeft = link.CreatedAt, link.MaxClicks()
+	}
+
+	if p.Limited() {
+		p.Long = ""
+	} else if u, err := url.Parse(p.Long); err == nil {
+		p.Domain = u.Hostname()
+	}
+
+	opts, err := uc.options.GetLinkOptions(ctx, shortURL)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	if opts.PasswordHash != "" {
+		return Preview{}, ErrPasswordRequired
+	}
+	p.Title = opts.Title
+
+	return p, nil
+}
+
+// GetLinkOptions returns the options of the link, the zero ones if they were not set.
+func (uc UseCase) GetLinkOptions(ctx context.Context, shortURL string) (storage.LinkOptions, error) {
+	return uc.options.GetLinkOptions(ctx, shortURL)
+}
+
+// SetLinkOptions replaces the options of the link of the owner, the password of the link is kept.
+func (uc UseCase) SetLinkOptions(ctx context.Context, shortURL, cookie string, opts storage.LinkOptions) error {
+	err := uc.setLinkOptions(ctx, shortURL, cookie, opts)
+
+	entry := audit.Entry(audit.LinkOptions, shortURL, err)
+	entry.Actor = cookie
+	opts.PasswordHash = ""
+	if data, jsonErr := json.Marshal(opts); jsonErr == nil {
+		entry.NewValue = string(data)
+	}
+	uc.audit.Record(ctx, entry)
+
+	return err
+}
+
+func (uc UseCase) setLinkOptions(ctx context.Context, shortURL, cookie string, opts storage.LinkOptions) error {
+	if utf8.RuneCountInString(opts.Title) > MaxTitleLength {
+		return ErrTitleTooLong
+	}
+
+	admin, ok := storage.As[storage.IAdmin](uc.storage)
+	if !ok {
+		return fmt.Errorf("can't check the owner: %w", storage.ErrNotSupported)
+	}
+
+	link, err := admin.GetLink(ctx, shortURL)
+	switch {
+	case err != nil:
+		return err
+	case link.Owner != cookie:
+		return ErrNotOwner
+	case link.Deleted:
+		return storage.ErrDeleted
+	}
+
+	current, err := uc.options.GetLinkOptions(ctx, shortURL)
+	if err != nil {
+		return err
+	}
+	opts.PasswordHash = current.PasswordHash
+
+	return uc.options.SetLinkOptions(ctx, shortURL, opts)
+}
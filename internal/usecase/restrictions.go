@@ -0,0 +1,162 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"url-shortener/internal/storage"
+)
+
+// ErrInvalidMaxClicks occurs when the visits limit of a link is negative or too big.
+var ErrInvalidMaxClicks = fmt.Errorf("max clicks must be between 0 and %d", math.MaxInt32)
+
+// Restrictions of a link, the zero ones create an open link.
+type Restrictions struct {
+	// Password opens the link, empty if the link is not protected.
+	Password string
+	// MaxClicks the visits the link may be followed, 0 for no limit. 1 makes a one-time link.
+	MaxClicks int
+}
+
+// ErrRestrictedExists occurs when a restricted link is created for a long URL shortened before,
+// the existing link may be open so it is not returned.
+var ErrRestrictedExists = errors.New("the URL is already shortened, a restricted link can't be created for it")
+
+// CreateRestrictedLink is CreateLink of a link with the restrictions. The link is added along with
+// its restrictions by one AddLinks, so it is never served open. ErrRestrictedExists is returned
+// instead of the link of the long URL shortened before.
+func (uc UseCase) CreateRestrictedLink(ctx context.Context, longURL, cookie string, r Restrictions) (string, error) {
+	if r.MaxClicks < 0 || r.MaxClicks > math.MaxInt32 {
+		return "", ErrInvalidMaxClicks
+	}
+
+	if r == (Restrictions{}) {
+		return uc.CreateLink(ctx, longURL, cookie)
+	}
+
+	link := storage.Link{Long: longURL, Owner: cookie}
+
+	if r.Password != "" {
+		// the options kept in memory can't be added along with the link
+		if _, ok := storage.As[storage.ILinkOptions](uc.storage); !ok {
+			return "", fmt.Errorf("can't protect the link: %w", storage.ErrNotSupported)
+		}
+
+		hash, err := hashPassword(r.Password)
+		if err != nil {
+			return "", err
+		}
+		link.Options.PasswordHash = hash
+	}
+
+	if r.MaxClicks > 0 {
+		if _, ok := storage.As[storage.IClickLimit](uc.storage); !ok {
+			return "", fmt.Errorf("can't limit the visits: %w", storage.ErrNotSupported)
+		}
+		link.ClicksLeft = &r.MaxClicks
+	}
+
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	shortURL, err := uc.createRestrictedLink(ctx, link)
+	uc.created(ctx, shortURL, longURL, cookie, err)
+
+	return shortURL, err
+}
+
+func (uc UseCase) createRestrictedLink(ctx context.Context, link storage.Link) (string, error) {
+	id, err := uc.storage.FindMaxID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if link.Short, err = uc.shortName(id + 1); err != nil {
+		return "", err
+	}
+
+	results, err := uc.storage.AddLinks(ctx, []storage.Link{link})
+	if err != nil {
+		return "", err
+	}
+
+	if results[0].Exists {
+		return "", ErrRestrictedExists
+	}
+
+	return link.Short, nil
+}
+
+// ErrPreviewForced occurs when a link is followed without the preview page its owner set for every visit.
+var ErrPreviewForced = errors.New("the preview page is shown on every visit of the link")
+
+// OpenLink returns the long URL of the link, the password is checked if the link is protected
+// and a visit is taken if the visits are limited. storage.ErrExhausted is returned when none is left.
+// The wrong passwords are counted per client, the IP of the client derived by the handler.
+func (uc UseCase) OpenLink(ctx context.Context, shortURL, password, client string) (string, error) {
+	return uc.openLink(ctx, shortURL, password, client, true)
+}
+
+// openLink reads the link with its options once. ErrPreviewForced is returned before the password
+// is checked unless skipPreview.
+func (uc UseCase) openLink(ctx context.Context, shortURL, password, client string, skipPreview bool) (string, error) {
+	t, err := uc.target(ctx, shortURL)
+	if err != nil {
+		return "", err
+	}
+
+	if t.Options.Preview && !skipPreview {
+		return "", ErrPreviewForced
+	}
+
+	if t.Options.PasswordHash != "" {
+		if err = uc.checkPassword(t.Options.PasswordHash, password, client); err != nil {
+			return "", err
+		}
+	}
+
+	if t.Limited {
+		if err = uc.useClick(ctx, shortURL); err != nil {
+			return "", err
+		}
+	}
+
+	return t.Long, nil
+}
+
+// target returns the target of the link, its options are read from memory if the storage
+// can't keep them.
+func (uc UseCase) target(ctx context.Context, shortURL string) (storage.Target, error) {
+	if ctx.Err() != nil {
+		return storage.Target{}, ctx.Err()
+	}
+
+	t, err := storage.GetTarget(ctx, uc.storage, shortURL)
+	if err != nil {
+		return storage.Target{}, err
+	}
+
+	if _, ok := storage.As[storage.ILinkOptions](uc.storage); !ok {
+		if t.Options, err = uc.options.GetLinkOptions(ctx, shortURL); err != nil {
+			return storage.Target{}, err
+		}
+	}
+
+	return t, nil
+}
+
+// useClick takes a visit of the link, the visits are not limited if the storage can't limit them.
+func (uc UseCase) useClick(ctx context.Context, shortURL string) error {
+	clicks, ok := storage.As[storage.IClickLimit](uc.storage)
+	if !ok {
+		return nil
+	}
+
+	if _, err := clicks.UseClick(ctx, shortURL); err != nil && !errors.Is(err, storage.ErrNotSupported) {
+		return err
+	}
+
+	return nil
+}
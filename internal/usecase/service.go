@@ -1,15 +1,50 @@
 package usecase
 
 import (
+	"log"
+	"url-shortener/internal/audit"
 	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/linkoptions"
+	"url-shortener/internal/usecase/events"
+	"url-shortener/internal/webhook"
 )
 
 // UseCase logic layer main struct.
 type UseCase struct {
-	storage storage.IStorage
+	storage  storage.IStorage
+	events   *events.Bus
+	webhooks *webhook.Dispatcher
+	audit    *audit.Log
+	options  storage.ILinkOptions
+	attempts *throttle
 }
 
 // New the UseCase struct builder.
-func New(storage storage.IStorage) UseCase {
-	return UseCase{storage: storage}
+func New(st storage.IStorage) UseCase {
+	options, ok := storage.As[storage.ILinkOptions](st)
+	if !ok {
+		log.Println("usecase: the storage can't keep the link options, they are kept in memory")
+		options = linkoptions.NewMemory()
+	}
+
+	return UseCase{
+		storage:  st,
+		events:   events.NewBus(),
+		audit:    audit.New(st),
+		options:  options,
+		attempts: newThrottle(MaxPasswordAttempts, PasswordAttemptsWindow),
+	}
+}
+
+// Events returns the bus of the link lifecycle events.
+func (uc UseCase) Events() *events.Bus {
+	return uc.events
+}
+
+// WithWebhooks returns the UseCase managing the webhooks by d, d is subscribed to the events.
+func (uc UseCase) WithWebhooks(d *webhook.Dispatcher) UseCase {
+	uc.events.Subscribe(d.Publish)
+	uc.webhooks = d
+
+	return uc
 }
@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"golang.org/x/sync/errgroup"
+	"io"
 	"log"
+	"url-shortener/internal/audit"
 	"url-shortener/internal/schema"
-	"url-shortener/internal/storage/db/service"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/transfer"
+	"url-shortener/internal/usecase/events"
 	shortener "url-shortener/pkg/api"
 	shortenalgorithm "url-shortener/pkg/shortenAlgorithm"
 )
@@ -22,13 +25,55 @@ func (uc UseCase) GetLink(ctx context.Context, shortURL string) (longURL string,
 	return uc.storage.GetLongLink(ctx, shortURL)
 }
 
-// MarkAsDeleted calls storage method MarkAsDeleted.
-func (uc UseCase) MarkAsDeleted(shortURL, cookie string) {
-	err := uc.storage.MarkAsDeleted(shortURL, cookie)
+// FollowLink is OpenLink for a redirect, the link is published as clicked. ErrPreviewForced is returned
+// instead if the owner set the preview page for every visit, unless skipPreview.
+func (uc UseCase) FollowLink(ctx context.Context, shortURL, password, client string, skipPreview bool) (longURL string, err error) {
+	longURL, err = uc.openLink(ctx, shortURL, password, client, skipPreview)
+	if err == nil {
+		uc.events.Publish(events.Event{Type: events.LinkClicked, Short: shortURL, Long: longURL})
+	}
+
+	return longURL, err
+}
+
+// ErrNotOwner occurs when a user changes a link of another user.
+var ErrNotOwner = errors.New("link belongs to another user")
+
+// MarkAsDeleted marks the link of the owner as deleted. The link is checked to belong to the cookie
+// if the storage implements storage.IAdmin, the storages ignore the requests of the others otherwise.
+func (uc UseCase) MarkAsDeleted(ctx context.Context, shortURL, cookie string) error {
+	longURL, err := uc.markAsDeleted(ctx, shortURL, cookie)
+
+	entry := audit.Entry(audit.LinkDelete, shortURL, err)
+	entry.Actor, entry.OldValue = cookie, longURL
+	uc.audit.Record(ctx, entry)
+
 	if err != nil {
-		// TODO: add zap logger
-		log.Println("can't mark as deleted", err)
+		return err
 	}
+
+	uc.events.Publish(events.Event{Type: events.LinkDeleted, Short: shortURL, Owner: cookie})
+
+	return nil
+}
+
+// markAsDeleted returns the long URL of the deleted link if it is known.
+func (uc UseCase) markAsDeleted(ctx context.Context, shortURL, cookie string) (string, error) {
+	var longURL string
+
+	if admin, ok := storage.As[storage.IAdmin](uc.storage); ok {
+		link, err := admin.GetLink(ctx, shortURL)
+		if err != nil {
+			return "", err
+		}
+
+		if link.Owner != cookie {
+			return link.Long, ErrNotOwner
+		}
+		longURL = link.Long
+	}
+
+	return longURL, uc.storage.MarkAsDeleted(ctx, shortURL, cookie)
 }
 
 // CreateLink calls FindMaxID, GetShortName and then calls AddLink storage method to save the link.
@@ -37,6 +82,24 @@ func (uc UseCase) CreateLink(ctx context.Context, longURL, cookie string, chars
 		return "", ctx.Err()
 	}
 
+	shortURL, err := uc.createLink(ctx, longURL, cookie, chars...)
+	uc.created(ctx, shortURL, longURL, cookie, err)
+
+	return shortURL, err
+}
+
+// created records the creation of the link to the audit log and publishes it if it was created.
+func (uc UseCase) created(ctx context.Context, shortURL, longURL, cookie string, err error) {
+	entry := audit.Entry(audit.LinkCreate, shortURL, err)
+	entry.Actor, entry.NewValue = cookie, longURL
+	uc.audit.Record(ctx, entry)
+
+	if err == nil {
+		uc.events.Publish(events.Event{Type: events.LinkCreated, Short: shortURL, Long: longURL, Owner: cookie})
+	}
+}
+
+func (uc UseCase) createLink(ctx context.Context, longURL, cookie string, chars ...string) (string, error) {
 	id, err := uc.storage.FindMaxID(ctx)
 	if err != nil {
 		log.Println("can't find max id", err)
@@ -47,7 +110,7 @@ func (uc UseCase) CreateLink(ctx context.Context, longURL, cookie string, chars
 	if len(chars) > 0 {
 		shortURL = chars[0]
 	} else {
-		shortURL, err = shortenalgorithm.GetShortName(id + 1)
+		shortURL, err = uc.shortName(id + 1)
 		if err != nil {
 			return "", err
 		}
@@ -56,6 +119,17 @@ func (uc UseCase) CreateLink(ctx context.Context, longURL, cookie string, chars
 	return uc.storage.AddLink(ctx, longURL, shortURL, cookie)
 }
 
+// shortName generates the short URL of the id, the shard of a sharded storage is encoded in it.
+func (uc UseCase) shortName(id int) (string, error) {
+	if sharded, ok := storage.As[storage.ISharded](uc.storage); ok {
+		if shard, ok := sharded.ShardFor(id); ok {
+			return shortenalgorithm.GetShardedName(id, shard)
+		}
+	}
+
+	return shortenalgorithm.GetShortName(id)
+}
+
 // GetAllLinksByCookie calls storage method GetAllLinksByCookie and execute json from the response.
 func (uc UseCase) GetAllLinksByCookie(ctx context.Context, cookie, baseURL string) ([]*shortener.UserURL, error) {
 	if ctx.Err() != nil {
@@ -79,38 +153,149 @@ func (uc UseCase) Ping(ctx context.Context) error {
 	return uc.storage.Ping(ctx)
 }
 
-// Batch gets urls for process and processes every url in separate goroutines.
-func (uc UseCase) Batch(ctx context.Context, batchURLs []*shortener.LongAndShortURL, cookie, baseURL string) ([]*shortener.CharsAndShortURL, error) {
+// Batch modes.
+const (
+	// BatchAtomic an invalid url rejects the batch, either every new link is created or none.
+	BatchAtomic = "atomic"
+	// BatchBestEffort invalid urls are reported by their status, the others are created at once.
+	BatchBestEffort = "best-effort"
+)
+
+// Statuses of the urls of a batch.
+const (
+	StatusCreated  = "created"
+	StatusExisting = "existing"
+	StatusInvalid  = "invalid"
+)
+
+// ParseBatchMode validates the batch mode, BatchAtomic is used if empty.
+func ParseBatchMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return BatchAtomic, nil
+	case BatchAtomic, BatchBestEffort:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown batch mode %q, use %s or %s", mode, BatchAtomic, BatchBestEffort)
+}
+
+// BatchOptions options of Batch.
+type BatchOptions struct {
+	// Mode BatchAtomic or BatchBestEffort, BatchAtomic is used if empty.
+	Mode string
+	// Validate checks the original url, any non-empty one is accepted if nil.
+	Validate func(originalURL string) error
+}
+
+// BatchError the batch is rejected because of an invalid url.
+type BatchError struct {
+	// Index of the url in the batch.
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("[%d].%v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Batch creates the links of the urls at once, the correlation id of an url is its short name.
+// Every url gets its status, an existing one gets the short url of the stored link.
+func (uc UseCase) Batch(ctx context.Context, batchURLs []*shortener.LongAndShortURL, cookie, baseURL string,
+	opts BatchOptions) ([]*shortener.CharsAndShortURL, error) {
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
 
-	var resp = make([]*shortener.CharsAndShortURL, len(batchURLs))
+	mode, err := ParseBatchMode(opts.Mode)
+	if err != nil {
+		return nil, err
+	}
 
-	g, _ := errgroup.WithContext(ctx)
-	g.SetLimit(200)
+	resp := make([]*shortener.CharsAndShortURL, len(batchURLs))
+	links := make([]storage.Link, 0, len(batchURLs))
+	indexes := make([]int, 0, len(batchURLs))
+	seen := make(map[string]struct{}, len(batchURLs))
 
 	for i, pair := range batchURLs {
-		pair := pair
-		i := i
-		g.Go(func() error {
-			short, err := uc.CreateLink(ctx, pair.OriginalUrl, cookie, pair.CorrelationId)
-			if err != nil && !errors.Is(err, service.ErrExists) {
-				return err
+		if err = validateBatchURL(pair, seen, opts.Validate); err != nil {
+			if mode == BatchAtomic {
+				return nil, &BatchError{Index: i, Err: err}
 			}
 
-			resp[i] = &shortener.CharsAndShortURL{CorrelationId: pair.CorrelationId, ShortUrl: baseURL + short}
-			return nil
-		})
+			resp[i] = &shortener.CharsAndShortURL{CorrelationId: pair.GetCorrelationId(),
+				Status: StatusInvalid, Error: err.Error()}
+			continue
+		}
+
+		links = append(links, storage.Link{Short: pair.CorrelationId, Long: pair.OriginalUrl, Owner: cookie})
+		indexes = append(indexes, i)
 	}
 
-	if err := g.Wait(); err != nil {
+	if len(links) == 0 {
+		return resp, nil
+	}
+
+	results, err := uc.storage.AddLinks(ctx, links)
+	if err != nil {
+		entries := make([]storage.AuditEntry, len(links))
+		for j, link := range links {
+			entries[j] = audit.Entry(audit.LinkCreate, link.Short, err)
+			entries[j].Actor, entries[j].NewValue = cookie, link.Long
+		}
+		uc.audit.Record(ctx, entries...)
+
 		return nil, fmt.Errorf("can't batch: %w", err)
 	}
 
+	entries := make([]storage.AuditEntry, 0, len(links))
+	for j, i := range indexes {
+		status := StatusCreated
+		if results[j].Exists {
+			status = StatusExisting
+		} else {
+			uc.events.Publish(events.Event{Type: events.LinkCreated, Short: results[j].Short,
+				Long: links[j].Long, Owner: cookie})
+
+			entry := audit.Entry(audit.LinkCreate, results[j].Short, nil)
+			entry.Actor, entry.NewValue = cookie, links[j].Long
+			entries = append(entries, entry)
+		}
+
+		resp[i] = &shortener.CharsAndShortURL{CorrelationId: batchURLs[i].CorrelationId,
+			ShortUrl: baseURL + results[j].Short, Status: status}
+	}
+	uc.audit.Record(ctx, entries...)
+
 	return resp, nil
 }
 
+func validateBatchURL(pair *shortener.LongAndShortURL, seen map[string]struct{}, validate func(string) error) error {
+	switch {
+	case pair.GetCorrelationId() == "":
+		return errors.New("correlation_id: required")
+	case pair.GetOriginalUrl() == "":
+		return errors.New("original_url: required")
+	}
+
+	if _, ok := seen[pair.CorrelationId]; ok {
+		return errors.New("correlation_id: duplicate")
+	}
+	seen[pair.CorrelationId] = struct{}{}
+
+	if validate != nil {
+		if err := validate(pair.OriginalUrl); err != nil {
+			return fmt.Errorf("original_url: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // GetStats calls storage method GetUser.
 func (uc UseCase) GetStats(ctx context.Context) (stats schema.StatsResponse, err error) {
 	if ctx.Err() != nil {
@@ -129,3 +314,59 @@ func (uc UseCase) GetStats(ctx context.Context) (stats schema.StatsResponse, err
 
 	return stats, nil
 }
+
+// Export writes every link of the storage to w in the format.
+func (uc UseCase) Export(ctx context.Context, w io.Writer, f transfer.Format) (int, error) {
+	admin, ok := storage.As[storage.IAdmin](uc.storage)
+	if !ok {
+		return 0, fmt.Errorf("can't export: %w", storage.ErrNotSupported)
+	}
+
+	return transfer.Export(ctx, admin, w, f)
+}
+
+// Import saves every link read from r in the format into the storage.
+func (uc UseCase) Import(ctx context.Context, r io.Reader, f transfer.Format) (int, error) {
+	admin, ok := storage.As[storage.IAdmin](uc.storage)
+	if !ok {
+		return 0, fmt.Errorf("can't import: %w", storage.ErrNotSupported)
+	}
+
+	return transfer.Import(ctx, trackingAdmin{IAdmin: admin, uc: uc}, r, f)
+}
+
+// trackingAdmin records the imported links to the audit log and publishes them as updated.
+type trackingAdmin struct {
+	storage.IAdmin
+	uc UseCase
+}
+
+func (a trackingAdmin) ImportLink(ctx context.Context, link storage.Link) error {
+	old, err := a.IAdmin.GetLink(ctx, link.Short)
+	existed := err == nil
+
+	if err = a.IAdmin.ImportLink(ctx, link); err == nil {
+		a.uc.events.Publish(events.Event{Type: events.LinkUpdated, Short: link.Short, Long: link.Long, Owner: link.Owner})
+	}
+
+	operation := audit.LinkUpdate
+	switch {
+	case !existed:
+		operation = audit.LinkCreate
+	case old.Deleted && !link.Deleted:
+		operation = audit.LinkRestore
+	case !old.Deleted && link.Deleted:
+		operation = audit.LinkDelete
+	}
+
+	entry := audit.Entry(operation, link.Short, err)
+	entry.OldValue, entry.NewValue = old.Long, link.Long
+	a.uc.audit.Record(ctx, entry)
+
+	return err
+}
+
+// AuditLog returns the entries of the audit log selected by the filter.
+func (uc UseCase) AuditLog(ctx context.Context, filter storage.AuditFilter) ([]storage.AuditEntry, error) {
+	return uc.audit.Entries(ctx, filter)
+}
@@ -2,9 +2,25 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"url-shortener/internal/audit"
 	"url-shortener/internal/repository"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/cache"
+	"url-shortener/internal/transfer"
+	"url-shortener/internal/usecase/events"
 	shortener "url-shortener/pkg/api"
+	shortenalgorithm "url-shortener/pkg/shortenAlgorithm"
+
+	"google.golang.org/protobuf/proto"
 )
 
 func TestUseCase_Ping(t *testing.T) {
@@ -55,11 +71,64 @@ func TestUseCase_Batch(t *testing.T) {
 		},
 	}
 
-	_, err = uc.Batch(ctx, urls, "test", "test")
+	_, err = uc.Batch(ctx, urls, "test", "test", BatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestUseCase_Batch_Modes(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uc := New(repo)
+
+	if _, err = uc.CreateLink(ctx, "https://a.ru", "alice", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	urls := []*shortener.LongAndShortURL{
+		{CorrelationId: "a", OriginalUrl: "https://a.ru"},
+		{CorrelationId: "b", OriginalUrl: "https://b.ru"},
+		{CorrelationId: "b", OriginalUrl: "https://b2.ru"},
+		{CorrelationId: "c"},
+	}
+
+	_, err = uc.Batch(ctx, urls, "bob", "/", BatchOptions{})
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 2 {
+		t.Fatalf("Batch() error = %v, want BatchError of the url 2", err)
+	}
+
+	if _, err = uc.GetLink(ctx, "b"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetLink() error = %v, nothing is created by a rejected batch", err)
+	}
+
+	got, err := uc.Batch(ctx, urls, "bob", "/", BatchOptions{Mode: BatchBestEffort})
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	want := []*shortener.CharsAndShortURL{
+		{CorrelationId: "a", ShortUrl: "/a", Status: StatusExisting},
+		{CorrelationId: "b", ShortUrl: "/b", Status: StatusCreated},
+		{CorrelationId: "b", Status: StatusInvalid, Error: "correlation_id: duplicate"},
+		{CorrelationId: "c", Status: StatusInvalid, Error: "original_url: required"},
+	}
+	for i := range want {
+		if !proto.Equal(got[i], want[i]) {
+			t.Errorf("Batch()[%d] got = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if _, err = uc.Batch(ctx, urls, "bob", "/", BatchOptions{Mode: "some"}); err == nil {
+		t.Error("Batch() expected an error for an unknown mode")
+	}
 }
 
 func TestUseCase_GetAllLinksByCookie(t *testing.T) {
@@ -81,3 +150,525 @@ func TestUseCase_GetAllLinksByCookie(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestUseCase_CreateLink_Sharded(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	cfg := &repository.Config{
+		DriverName: "map",
+		Shards: []repository.Shard{
+			{Name: "a", DSN: filepath.Join(dir, "a.snapshot")},
+			{Name: "b", DSN: filepath.Join(dir, "b.snapshot")},
+		},
+		ShardCodes: true,
+		Cache:      cache.Config{Size: 10},
+	}
+
+	repo, err := repository.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Shutdown()
+
+	uc := New(repo)
+
+	short, err := uc.CreateLink(ctx, "https://ya.ru", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := shortenalgorithm.GetShard(short); !ok {
+		t.Errorf("CreateLink() = %v, the shard is not encoded", short)
+	}
+
+	long, err := uc.GetLink(ctx, short)
+	if err != nil || long != "https://ya.ru" {
+		t.Errorf("GetLink() = %v, %v", long, err)
+	}
+}
+
+func TestUseCase_Events(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uc := New(repo)
+
+	var got []events.Event
+	uc.Events().Subscribe(func(e events.Event) { got = append(got, e) })
+
+	short, err := uc.CreateLink(ctx, "https://ya.ru", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the existing link is not created again
+	_, err = uc.Batch(ctx, []*shortener.LongAndShortURL{
+		{CorrelationId: "go", OriginalUrl: "https://go.dev"},
+		{CorrelationId: short, OriginalUrl: "https://ya.ru"},
+	}, "alice", "http://localhost/", BatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = uc.FollowLink(ctx, short, "", "10.0.0.1", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = uc.FollowLink(ctx, "missing", "", "10.0.0.1", false); err == nil {
+		t.Fatal("FollowLink() expected an error for a missing link")
+	}
+
+	uc.MarkAsDeleted(ctx, short, "bob")
+	uc.MarkAsDeleted(ctx, short, "alice")
+
+	want := []events.Event{
+		{Type: events.LinkCreated, Short: short, Long: "https://ya.ru", Owner: "alice"},
+		{Type: events.LinkCreated, Short: "go", Long: "https://go.dev", Owner: "alice"},
+		{Type: events.LinkClicked, Short: short, Long: "https://ya.ru"},
+		{Type: events.LinkDeleted, Short: short, Owner: "alice"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(got), len(want), got)
+	}
+
+	for i := range want {
+		if got[i].At.IsZero() {
+			t.Errorf("event %d = %+v, At expected", i, got[i])
+		}
+
+		got[i].At = time.Time{}
+		if got[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestUseCase_Audit(t *testing.T) {
+	ctx := audit.WithActor(context.Background(), audit.Actor{IP: "10.0.0.1"})
+
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uc := New(repo)
+
+	short, err := uc.CreateLink(ctx, "https://ya.ru", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = uc.Batch(ctx, []*shortener.LongAndShortURL{
+		{CorrelationId: "go", OriginalUrl: "https://go.dev"},
+		{CorrelationId: short, OriginalUrl: "https://ya.ru"},
+	}, "alice", "http://localhost/", BatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = uc.MarkAsDeleted(ctx, short, "bob"); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("MarkAsDeleted() by another user error = %v, want %v", err, ErrNotOwner)
+	}
+	if err = uc.MarkAsDeleted(ctx, short, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := audit.WithActor(ctx, audit.Actor{ID: audit.ActorAdmin, IP: "10.0.0.2"})
+	body := `{"short":"` + short + `","long":"https://ya.ru","owner":"alice","deleted":false}` + "\n"
+	if _, err = uc.Import(admin, strings.NewReader(body), transfer.JSONLines); err == nil {
+		t.Error("Import() of an existing link expected an error")
+	}
+
+	entries, err := uc.AuditLog(ctx, storage.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []storage.AuditEntry{
+		{ID: 1, Actor: "alice", IP: "10.0.0.1", Operation: audit.LinkCreate, Target: short,
+			NewValue: "https://ya.ru", Outcome: storage.AuditSuccess},
+		{ID: 2, Actor: "alice", IP: "10.0.0.1", Operation: audit.LinkCreate, Target: "go",
+			NewValue: "https://go.dev", Outcome: storage.AuditSuccess},
+		{ID: 3, Actor: "bob", IP: "10.0.0.1", Operation: audit.LinkDelete, Target: short,
+			OldValue: "https://ya.ru", Outcome: storage.AuditFailure, Error: ErrNotOwner.Error()},
+		{ID: 4, Actor: "alice", IP: "10.0.0.1", Operation: audit.LinkDelete, Target: short,
+			OldValue: "https://ya.ru", Outcome: storage.AuditSuccess},
+		{ID: 5, Actor: audit.ActorAdmin, IP: "10.0.0.2", Operation: audit.LinkRestore, Target: short,
+			OldValue: "https://ya.ru", NewValue: "https://ya.ru", Outcome: storage.AuditFailure},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+
+	for i := range want {
+		if entries[i].At.IsZero() {
+			t.Errorf("entry %d = %+v, At expected", i, entries[i])
+		}
+
+		entries[i].At = time.Time{}
+		if i == len(want)-1 {
+			// the error of the storage is not checked
+			want[i].Error = entries[i].Error
+		}
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestUseCase_ProtectedLink(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uc := New(repo)
+
+	long := strings.Repeat("p", MaxPasswordLength+1)
+	if _, err = uc.CreateRestrictedLink(ctx, "https://ya.ru", "alice", Restrictions{Password: long}); !errors.Is(err, ErrPasswordTooLong) {
+		t.Fatalf("CreateRestrictedLink() error = %v, want %v", err, ErrPasswordTooLong)
+	}
+
+	short, err := uc.CreateRestrictedLink(ctx, "https://ya.ru", "alice", Restrictions{Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open, err := uc.CreateRestrictedLink(ctx, "https://go.dev", "alice", Restrictions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if longURL, err := uc.OpenLink(ctx, open, "", "10.0.0.1"); err != nil || longURL != "https://go.dev" {
+		t.Errorf("OpenLink() of an open link = %q, %v", longURL, err)
+	}
+
+	tests := []struct {
+		password string
+		want     string
+		wantErr  error
+	}{
+		{password: "", wantErr: ErrPasswordRequired},
+		{password: "wrong", wantErr: ErrWrongPassword},
+		{password: "secret", want: "https://ya.ru"},
+	}
+	for _, tt := range tests {
+		got, err := uc.OpenLink(ctx, short, tt.password, "10.0.0.1")
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("OpenLink(%q) = %q, %v, want %q, %v", tt.password, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if _, err = uc.GetPreview(ctx, short); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("GetPreview() error = %v, want %v", err, ErrPasswordRequired)
+	}
+
+	// the options set by the owner keep the password
+	if err = uc.SetLinkOptions(ctx, short, "alice", storage.LinkOptions{Title: "Yandex"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = uc.OpenLink(ctx, short, "", "10.0.0.1"); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("OpenLink() after SetLinkOptions() error = %v, want %v", err, ErrPasswordRequired)
+	}
+
+	// one wrong password was tried already
+	for i := 1; i < MaxPasswordAttempts; i++ {
+		if _, err = uc.OpenLink(ctx, short, "wrong", "10.0.0.1"); !errors.Is(err, ErrWrongPassword) {
+			t.Fatalf("OpenLink() attempt %d error = %v, want %v", i, err, ErrWrongPassword)
+		}
+	}
+	if _, err = uc.OpenLink(ctx, short, "secret", "10.0.0.1"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("OpenLink() after %d wrong passwords error = %v, want %v", MaxPasswordAttempts, err, ErrTooManyAttempts)
+	}
+
+	if _, err = uc.OpenLink(ctx, short, "secret", "10.0.0.2"); err != nil {
+		t.Errorf("OpenLink() by another client error = %v", err)
+	}
+
+	// the attempts of a client without an address can't be counted
+	if _, err = uc.OpenLink(ctx, short, "secret", ""); !errors.Is(err, ErrUnknownClient) {
+		t.Errorf("OpenLink() by a client without an IP error = %v, want %v", err, ErrUnknownClient)
+	}
+}
+
+func TestUseCase_CreateRestrictedLink(t *testing.T) {
+	ctx := context.Background()
+
+	// bolt keeps one link per long URL
+	repo, err := repository.New(&repository.Config{DriverName: "bolt", DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Shutdown() })
+
+	uc := New(repo)
+
+	open, err := uc.CreateLink(ctx, "https://ya.ru", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the open link must not be handed out as a protected one
+	short, err := uc.CreateRestrictedLink(ctx, "https://ya.ru", "bob", Restrictions{Password: "secret"})
+	if !errors.Is(err, ErrRestrictedExists) || short != "" {
+		t.Errorf("CreateRestrictedLink() of a shortened URL = %q, %v, want %v", short, err, ErrRestrictedExists)
+	}
+
+	short, err = uc.CreateRestrictedLink(ctx, "https://go.dev", "bob", Restrictions{Password: "secret", MaxClicks: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if short == open {
+		t.Errorf("CreateRestrictedLink() = %q, the short URL of the open link", short)
+	}
+
+	// the link is stored along with its restrictions
+	link, err := repo.(storage.IAdmin).GetLink(ctx, short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Options.PasswordHash == "" || link.MaxClicks() != 3 {
+		t.Errorf("GetLink() = %+v, want the password hash and 3 visits left", link)
+	}
+
+	if _, err = uc.OpenLink(ctx, short, "", "10.0.0.1"); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("OpenLink() without the password error = %v, want %v", err, ErrPasswordRequired)
+	}
+}
+
+func TestUseCase_MaxClicks(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uc := New(repo)
+
+	for _, n := range []int{-1, math.MaxInt32 + 1} {
+		if _, err = uc.CreateRestrictedLink(ctx, "https://ya.ru", "alice", Restrictions{MaxClicks: n}); !errors.Is(err, ErrInvalidMaxClicks) {
+			t.Errorf("CreateRestrictedLink(%d) error = %v, want %v", n, err, ErrInvalidMaxClicks)
+		}
+	}
+
+	once, err := uc.CreateRestrictedLink(ctx, "https://ya.ru", "alice", Restrictions{MaxClicks: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err := uc.GetPreview(ctx, once); err != nil {
+		t.Errorf("GetPreview() error = %v, the preview must not take a visit", err)
+	} else if p.Long != "" || p.Domain != "" || p.ClicksLeft != 1 {
+		t.Errorf("GetPreview() = %+v, the destination of a limited link must not be previewed", p)
+	}
+
+	if longURL, err := uc.FollowLink(ctx, once, "", "10.0.0.1", false); err != nil || longURL != "https://ya.ru" {
+		t.Errorf("FollowLink() = %q, %v", longURL, err)
+	}
+
+	if _, err = uc.FollowLink(ctx, once, "", "10.0.0.1", false); !errors.Is(err, storage.ErrExhausted) {
+		t.Errorf("FollowLink() of a used link error = %v, want %v", err, storage.ErrExhausted)
+	}
+
+	if _, err = uc.GetPreview(ctx, once); !errors.Is(err, storage.ErrExhausted) {
+		t.Errorf("GetPreview() of a used link error = %v, want %v", err, storage.ErrExhausted)
+	}
+
+	// the visits of the protected links are taken by the right password only
+	protected, err := uc.CreateRestrictedLink(ctx, "https://go.dev", "alice", Restrictions{Password: "secret", MaxClicks: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = uc.OpenLink(ctx, protected, "wrong", "10.0.0.1"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("OpenLink() error = %v, want %v", err, ErrWrongPassword)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		opened atomic.Int32
+	)
+	// the concurrent attempts of one client are throttled, the visits are taken by many
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(client string) {
+			defer wg.Done()
+
+			if _, err := uc.OpenLink(ctx, protected, "secret", client); err == nil {
+				opened.Add(1)
+			}
+		}(fmt.Sprintf("10.0.1.%d", i))
+	}
+	wg.Wait()
+
+	if opened.Load() != 10 {
+		t.Errorf("OpenLink() succeeded %d times, want 10", opened.Load())
+	}
+
+	links, err := uc.GetAllLinksByCookie(ctx, "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, link := range links {
+		if link.GetClicksLeft() != 0 {
+			t.Errorf("link %s has %d clicks left, want 0", link.GetShortUrl(), link.GetClicksLeft())
+		}
+		if link.ClicksLeft == nil {
+			t.Errorf("link %s has no clicks limit", link.GetShortUrl())
+		}
+	}
+}
+
+// countingClicks counts the visits taken of the storage it wraps.
+type countingClicks struct {
+	storage.IStorage
+	used atomic.Int32
+}
+
+func (c *countingClicks) SetMaxClicks(ctx context.Context, shortURL string, n int) error {
+	clicks, _ := storage.As[storage.IClickLimit](c.IStorage)
+
+	return clicks.SetMaxClicks(ctx, shortURL, n)
+}
+
+func (c *countingClicks) UseClick(ctx context.Context, shortURL string) (int, error) {
+	c.used.Add(1)
+
+	clicks, _ := storage.As[storage.IClickLimit](c.IStorage)
+
+	return clicks.UseClick(ctx, shortURL)
+}
+
+func (c *countingClicks) Unwrap() storage.IStorage {
+	return c.IStorage
+}
+
+func TestUseCase_FollowLink_Options(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := repository.New(&repository.Config{DriverName: "map", Cache: cache.Config{Size: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := &countingClicks{IStorage: repo}
+	uc := New(st)
+
+	short, err := uc.CreateLink(ctx, "https://ya.ru", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = uc.FollowLink(ctx, short, "", "10.0.0.1", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if st.used.Load() != 0 {
+		t.Errorf("UseClick() called %d times for a link without a limit", st.used.Load())
+	}
+
+	// the cached options are dropped by the change
+	if err = uc.SetLinkOptions(ctx, short, "alice", storage.LinkOptions{Preview: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = uc.FollowLink(ctx, short, "", "10.0.0.1", false); !errors.Is(err, ErrPreviewForced) {
+		t.Errorf("FollowLink() error = %v, want %v", err, ErrPreviewForced)
+	}
+
+	if longURL, err := uc.FollowLink(ctx, short, "", "10.0.0.1", true); err != nil || longURL != "https://ya.ru" {
+		t.Errorf("FollowLink() skipping the preview = %q, %v", longURL, err)
+	}
+
+	limited, err := uc.CreateRestrictedLink(ctx, "https://go.dev", "alice", Restrictions{MaxClicks: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = uc.FollowLink(ctx, limited, "", "10.0.0.1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if st.used.Load() != 1 {
+		t.Errorf("UseClick() called %d times, want 1", st.used.Load())
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	th := newThrottle(2, time.Minute)
+	now := time.Now()
+
+	if _, ok := th.reserve("a", now); !ok {
+		t.Error("reserve() of a new client = false")
+	}
+
+	start, ok := th.reserve("a", now.Add(time.Second))
+	if !ok {
+		t.Error("reserve() after 1 failure = false")
+	}
+	if _, ok = th.reserve("a", now.Add(time.Second)); ok {
+		t.Error("reserve() after 2 failures = true")
+	}
+	if _, ok = th.reserve("b", now); !ok {
+		t.Error("reserve() of another client = false")
+	}
+
+	// the right password gives the attempt back
+	th.refund("a", start)
+	if _, ok = th.reserve("a", now.Add(time.Second)); !ok {
+		t.Error("reserve() after a refund = false")
+	}
+
+	if _, ok = th.reserve("a", now.Add(time.Minute)); !ok {
+		t.Error("reserve() after the window = false")
+	}
+	if _, ok = th.reserve("a", now.Add(time.Minute)); !ok {
+		t.Error("reserve() after 1 failure in a new window = false")
+	}
+
+	// an attempt of a passed window is not refunded in the new one
+	th.refund("a", now)
+	if _, ok = th.reserve("a", now.Add(time.Minute)); ok {
+		t.Error("reserve() after a refund of a passed window = true")
+	}
+}
+
+func TestUseCase_CheckPassword_Burst(t *testing.T) {
+	uc := UseCase{attempts: newThrottle(MaxPasswordAttempts, PasswordAttemptsWindow)}
+
+	hash, err := hashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		wrong atomic.Int32
+	)
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if errors.Is(uc.checkPassword(hash, "guess", "10.0.0.1"), ErrWrongPassword) {
+				wrong.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wrong.Load() != MaxPasswordAttempts {
+		t.Errorf("%d passwords of a burst were compared, want %d", wrong.Load(), MaxPasswordAttempts)
+	}
+}
@@ -0,0 +1,60 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"url-shortener/internal/audit"
+	"url-shortener/internal/webhook"
+)
+
+// ErrWebhooksDisabled occurs when the UseCase was not given a webhook.Dispatcher.
+var ErrWebhooksDisabled = errors.New("webhooks are disabled")
+
+// RegisterWebhook registers the url to get the events of the links of the owner.
+// Events are the names of the events to deliver, every event if empty.
+func (uc UseCase) RegisterWebhook(ctx context.Context, owner, url string, events []string) (webhook.Webhook, error) {
+	if uc.webhooks == nil {
+		return webhook.Webhook{}, ErrWebhooksDisabled
+	}
+
+	w, err := uc.webhooks.Register(ctx, owner, url, events)
+
+	entry := audit.Entry(audit.WebhookRegister, w.ID, err)
+	entry.Actor, entry.NewValue = owner, url
+	uc.audit.Record(ctx, entry)
+
+	return w, err
+}
+
+// GetWebhooks returns the webhooks of the owner.
+func (uc UseCase) GetWebhooks(ctx context.Context, owner string) ([]webhook.Webhook, error) {
+	if uc.webhooks == nil {
+		return nil, ErrWebhooksDisabled
+	}
+
+	return uc.webhooks.List(ctx, owner)
+}
+
+// DeleteWebhook removes the webhook of the owner.
+func (uc UseCase) DeleteWebhook(ctx context.Context, owner, id string) error {
+	if uc.webhooks == nil {
+		return ErrWebhooksDisabled
+	}
+
+	err := uc.webhooks.Delete(ctx, owner, id)
+
+	entry := audit.Entry(audit.WebhookDelete, id, err)
+	entry.Actor = owner
+	uc.audit.Record(ctx, entry)
+
+	return err
+}
+
+// TestWebhook sends a ping event to the webhook of the owner.
+func (uc UseCase) TestWebhook(ctx context.Context, owner, id string) (webhook.TestResult, error) {
+	if uc.webhooks == nil {
+		return webhook.TestResult{}, ErrWebhooksDisabled
+	}
+
+	return uc.webhooks.Test(ctx, owner, id)
+}
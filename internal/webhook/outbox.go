@@ -0,0 +1,155 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Operations of the outbox records.
+const (
+	opWebhook  = "webhook"
+	opDelete   = "delete"
+	opDelivery = "delivery"
+	opAttempt  = "attempt"
+	opDone     = "done"
+	opClicked  = "clicked"
+)
+
+// record of the outbox.
+type record struct {
+	Op       string         `json:"op"`
+	Webhook  *webhookRecord `json:"webhook,omitempty"`
+	Delivery *delivery      `json:"delivery,omitempty"`
+	// ID of the deleted webhook or of the delivery.
+	ID       string    `json:"id,omitempty"`
+	Short    string    `json:"short,omitempty"`
+	Attempts int       `json:"attempts,omitempty"`
+	Next     time.Time `json:"next,omitempty"`
+	// At the time of the first click.
+	At time.Time `json:"at,omitempty"`
+}
+
+// webhookRecord a Webhook with its owner, the secret is derived from the key.
+type webhookRecord struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newWebhookRecord(w *Webhook) *webhookRecord {
+	return &webhookRecord{ID: w.ID, Owner: w.Owner, URL: w.URL, Events: w.Events, CreatedAt: w.CreatedAt}
+}
+
+// replay applies a record of the outbox.
+func (d *Dispatcher) replay(data []byte) error {
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return err
+	}
+
+	switch rec.Op {
+	case opWebhook:
+		if rec.Webhook == nil {
+			return fmt.Errorf("%s record without the webhook", rec.Op)
+		}
+
+		w := rec.Webhook
+		d.webhooks[w.ID] = &Webhook{ID: w.ID, Owner: w.Owner, URL: w.URL, Events: w.Events, CreatedAt: w.CreatedAt}
+	case opDelete:
+		d.deleteWebhook(rec.ID)
+	case opDelivery:
+		if rec.Delivery == nil {
+			return fmt.Errorf("%s record without the delivery", rec.Op)
+		}
+
+		d.pending[rec.Delivery.ID] = rec.Delivery
+	case opAttempt:
+		if dl, ok := d.pending[rec.ID]; ok {
+			dl.Attempts, dl.Next = rec.Attempts, rec.Next
+		}
+	case opDone:
+		delete(d.pending, rec.ID)
+	case opClicked:
+		// the clicks logged before their time was kept are remembered for a whole window
+		at := rec.At
+		if at.IsZero() {
+			at = time.Now()
+		}
+		d.clicked[rec.Short] = click{at: at, delivered: true}
+	default:
+		return fmt.Errorf("unknown operation %q", rec.Op)
+	}
+
+	return nil
+}
+
+// append writes the records to the outbox if there is one, d.mu must be held.
+func (d *Dispatcher) append(records ...any) error {
+	if d.outbox == nil {
+		return nil
+	}
+
+	if err := d.outbox.Append(records...); err != nil {
+		return fmt.Errorf("can't write the webhook outbox: %w", err)
+	}
+
+	return nil
+}
+
+// compact rewrites the outbox with the current webhooks, deliveries and clicked links,
+// the expired clicks are forgotten. d.mu must be held unless the Dispatcher is not started yet.
+func (d *Dispatcher) compact() error {
+	if d.outbox == nil {
+		return nil
+	}
+
+	d.sweep(time.Now())
+
+	webhooks := make([]*Webhook, 0, len(d.webhooks))
+	for _, w := range d.webhooks {
+		webhooks = append(webhooks, w)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt) })
+
+	deliveries := make([]*delivery, 0, len(d.pending))
+	for _, dl := range d.pending {
+		deliveries = append(deliveries, dl)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Next.Before(deliveries[j].Next) })
+
+	err := d.outbox.Rewrite(func(write func(v any) error) error {
+		for _, w := range webhooks {
+			if err := write(record{Op: opWebhook, Webhook: newWebhookRecord(w)}); err != nil {
+				return err
+			}
+		}
+
+		for _, dl := range deliveries {
+			if err := write(record{Op: opDelivery, Delivery: dl}); err != nil {
+				return err
+			}
+		}
+
+		for short, c := range d.clicked {
+			if !c.delivered {
+				continue
+			}
+
+			if err := write(record{Op: opClicked, Short: short, At: c.at}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can't compact the webhook outbox: %w", err)
+	}
+	d.finished = 0
+
+	return nil
+}
@@ -0,0 +1,314 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+	"url-shortener/internal/usecase/events"
+)
+
+// Headers of a delivery.
+const (
+	HeaderWebhook   = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	// HeaderSignature "sha256=" and the hex HMAC-SHA256 of the timestamp, a dot and the body.
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Payload the body of a delivery.
+type Payload struct {
+	// ID of the event, it is the same for every attempt.
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	// Data is nil for the ping event.
+	Data *LinkData `json:"data,omitempty"`
+}
+
+// LinkData the link of an event.
+type LinkData struct {
+	Short    string `json:"short"`
+	ShortURL string `json:"short_url"`
+	// OriginalURL is empty if it is not known.
+	OriginalURL string `json:"original_url,omitempty"`
+}
+
+// Secret derives the secret of the webhook from the key.
+func Secret(key []byte, id string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("webhook:" + id))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the signature of the body sent at the timestamp.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the body and the headers of a delivery are signed with the secret.
+func Verify(secret string, header http.Header, body []byte) bool {
+	want := Sign(secret, header.Get(HeaderTimestamp), body)
+	return hmac.Equal([]byte(want), []byte(header.Get(HeaderSignature)))
+}
+
+func (d *Dispatcher) payload(name string, e events.Event) ([]byte, error) {
+	p := Payload{ID: newID(), Type: name, CreatedAt: e.At.UTC()}
+	if e.Short != "" {
+		p.Data = &LinkData{Short: e.Short, ShortURL: d.cfg.BaseURL + e.Short, OriginalURL: e.Long}
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("can't encode the payload: %w", err)
+	}
+
+	return data, nil
+}
+
+var (
+	// errUnreachable the error of a test delivery without a response.
+	errUnreachable = errors.New("the webhook can't be reached")
+	// errNotPublic occurs when a delivery connects to an address that is not public.
+	errNotPublic = errors.New("the address of the webhook is not public")
+)
+
+// newClient returns the client of the deliveries. Unless cfg.AllowPrivate, it connects to
+// the public addresses only: the address is checked when connecting, after the name is resolved,
+// so a name resolving to another address later or a redirect can't reach the private network.
+func newClient(cfg Config) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !cfg.AllowPrivate {
+		dialer.Control = publicOnly
+		// a proxy would connect to the webhook instead of the dialer
+		transport.Proxy = nil
+	}
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: cfg.Timeout, Transport: transport}
+}
+
+// publicOnly is the net.Dialer Control refusing the addresses that are not public.
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !public(ip) {
+		return fmt.Errorf("%w: %s", errNotPublic, host)
+	}
+
+	return nil
+}
+
+// sharedAddresses the carrier-grade NAT range, RFC 6598.
+var sharedAddresses = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// public reports whether the ip is a public unicast address.
+func public(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddresses.Contains(ip)
+}
+
+// send posts the delivery to the webhook, a response other than 2xx is an error.
+func (d *Dispatcher) send(ctx context.Context, w *Webhook, dl *delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "url-shortener-webhooks")
+	req.Header.Set(HeaderWebhook, w.ID)
+	req.Header.Set(HeaderEvent, dl.Event)
+	req.Header.Set(HeaderDelivery, dl.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(Secret(d.cfg.Key, w.ID), timestamp, dl.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// dispatch sends the due deliveries by the workers until the Dispatcher is closed.
+func (d *Dispatcher) dispatch() {
+	defer d.wg.Done()
+
+	sem := make(chan struct{}, workers)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		due, wait := d.due(time.Now())
+		for i, dl := range due {
+			select {
+			case sem <- struct{}{}:
+			case <-d.ctx.Done():
+				d.release(due[i:])
+				return
+			}
+
+			d.wg.Add(1)
+			go func(dl *delivery) {
+				defer func() { <-sem }()
+				defer d.wg.Done()
+
+				d.attempt(dl)
+			}(dl)
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// due marks the deliveries due at now as being sent and returns them
+// with the time until the next one is due.
+func (d *Dispatcher) due(now time.Time) ([]*delivery, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var due []*delivery
+	wait := d.cfg.MaxBackoff
+
+	for _, dl := range d.pending {
+		if dl.sending {
+			continue
+		}
+
+		if !dl.Next.After(now) {
+			dl.sending = true
+			due = append(due, dl)
+		} else if until := dl.Next.Sub(now); until < wait {
+			wait = until
+		}
+	}
+
+	return due, wait
+}
+
+// release returns the deliveries not sent to the pending ones.
+func (d *Dispatcher) release(deliveries []*delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, dl := range deliveries {
+		dl.sending = false
+	}
+}
+
+// attempt sends the delivery once and saves the outcome.
+func (d *Dispatcher) attempt(dl *delivery) {
+	d.mu.Lock()
+	w, ok := d.webhooks[dl.Webhook]
+	var hook Webhook
+	if ok {
+		hook = *w
+	}
+	d.mu.Unlock()
+
+	var err error
+	if ok {
+		_, err = d.send(d.ctx, &hook, dl)
+		if d.ctx.Err() != nil {
+			// closing, the delivery is sent after a restart
+			d.release([]*delivery{dl})
+			return
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl.sending = false
+
+	if _, pending := d.pending[dl.ID]; !pending {
+		// the webhook was deleted
+		return
+	}
+
+	attempts := dl.Attempts + 1
+	if err != nil && attempts < d.cfg.MaxAttempts {
+		next := time.Now().Add(d.backoff(attempts))
+		if appendErr := d.append(record{Op: opAttempt, ID: dl.ID, Attempts: attempts, Next: next}); appendErr != nil {
+			log.Printf("webhook: %v", appendErr)
+		}
+		dl.Attempts, dl.Next = attempts, next
+		d.notify()
+
+		return
+	}
+
+	if err != nil {
+		log.Printf("webhook: %s to %s is dropped after %d attempts: %v", dl.Event, hook.URL, attempts, err)
+	}
+
+	if appendErr := d.append(record{Op: opDone, ID: dl.ID}); appendErr != nil {
+		log.Printf("webhook: %v", appendErr)
+	}
+	delete(d.pending, dl.ID)
+
+	d.finished++
+	if d.finished >= compactAfter {
+		if err = d.compact(); err != nil {
+			log.Printf("webhook: %v", err)
+		}
+	}
+}
+
+// backoff returns the delay after the attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.Backoff
+	for i := 1; i < attempts && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+
+	return delay
+}
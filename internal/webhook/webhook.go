@@ -0,0 +1,608 @@
+// Package webhook delivers the link lifecycle events to the endpoints registered by the users.
+//
+// The events of the usecase bus are turned into deliveries kept in an outbox, a write-ahead log
+// replayed on start, so they survive restarts. A delivery is a POST of the JSON payload signed
+// by HMAC-SHA256, failed ones are retried with exponential backoff.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/wal"
+	"url-shortener/internal/usecase/events"
+)
+
+// Events a webhook can subscribe to.
+const (
+	EventCreated    = "link.created"
+	EventUpdated    = "link.updated"
+	EventDeleted    = "link.deleted"
+	EventFirstClick = "link.first_click"
+	// EventPing is only sent by Test.
+	EventPing = "ping"
+)
+
+// eventNames the webhook events of the bus events.
+var eventNames = map[events.Type]string{
+	events.LinkCreated: EventCreated,
+	events.LinkUpdated: EventUpdated,
+	events.LinkDeleted: EventDeleted,
+	events.LinkClicked: EventFirstClick,
+}
+
+// Defaults of the Config.
+const (
+	DefaultMaxAttempts = 8
+	DefaultBackoff     = time.Second
+	DefaultMaxBackoff  = time.Hour
+	DefaultTimeout     = 10 * time.Second
+	DefaultClickWindow = 30 * 24 * time.Hour
+)
+
+// MaxWebhooks the most webhooks a user may register.
+const MaxWebhooks = 10
+
+// workers the number of deliveries sent at once.
+const workers = 4
+
+// compactAfter the number of finished deliveries that triggers the compaction of the outbox.
+const compactAfter = 1000
+
+// lookupTTL how long the clicked links of the owners without webhooks are not looked up again.
+const lookupTTL = time.Hour
+
+// sweepEvery the period the expired clicked links are forgotten with.
+const sweepEvery = time.Minute
+
+var (
+	// ErrNotFound occurs when the user has no webhook with the id.
+	ErrNotFound = errors.New("webhook not found")
+	// ErrInvalid occurs when the url or the events of a webhook are invalid.
+	ErrInvalid = errors.New("invalid webhook")
+	// ErrTooMany occurs when the user has registered MaxWebhooks already.
+	ErrTooMany = fmt.Errorf("a user can't register more than %d webhooks", MaxWebhooks)
+)
+
+// Config of the Dispatcher.
+type Config struct {
+	// Path of the outbox, the webhooks and the deliveries are kept in memory only if empty.
+	Path string
+	// Sync fsync policy of the outbox, wal.SyncAlways is used if empty.
+	Sync wal.SyncPolicy
+	// Key the secrets of the webhooks are derived from, see config.Config Key.
+	Key []byte
+	// BaseURL of the short URLs in the payloads.
+	BaseURL string
+	// MaxAttempts of a delivery, DefaultMaxAttempts if zero.
+	MaxAttempts int
+	// Backoff before the second attempt, doubled for every next one up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout of a delivery request.
+	Timeout time.Duration
+	// ClickWindow how long the first click of a link is remembered, DefaultClickWindow if zero.
+	// The next click after it is delivered as the first one again.
+	ClickWindow time.Duration
+	// AllowPrivate lets the deliveries reach the loopback, private and link-local addresses,
+	// for the tests and the endpoints inside the private network. They are refused otherwise.
+	AllowPrivate bool
+}
+
+// Webhook an endpoint registered by a user.
+type Webhook struct {
+	ID    string `json:"id"`
+	Owner string `json:"-"`
+	URL   string `json:"url"`
+	// Events the webhook is subscribed to, every event if empty.
+	Events []string `json:"events"`
+	// Secret the payloads are signed with, see Verify.
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (w *Webhook) subscribed(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TestResult the outcome of a test delivery.
+type TestResult struct {
+	// StatusCode of the response, zero if there was none.
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error,omitempty"`
+}
+
+// delivery a payload waiting to be delivered to a webhook.
+type delivery struct {
+	ID       string    `json:"id"`
+	Webhook  string    `json:"webhook"`
+	Event    string    `json:"event"`
+	Payload  []byte    `json:"payload"`
+	Attempts int       `json:"attempts"`
+	Next     time.Time `json:"next"`
+
+	// sending is true while a worker sends the delivery.
+	sending bool
+}
+
+// click of a link remembered by the Dispatcher.
+type click struct {
+	at time.Time
+	// delivered is true if the click was delivered as the first one, such clicks are written to the outbox.
+	delivered bool
+}
+
+// Dispatcher keeps the webhooks and delivers the events to them, safe for concurrent use.
+type Dispatcher struct {
+	cfg    Config
+	admin  storage.IAdmin
+	client *http.Client
+
+	mu       sync.Mutex
+	outbox   *wal.Log
+	webhooks map[string]*Webhook
+	pending  map[string]*delivery
+	// clicked the links followed already, only the first click is delivered.
+	// The links of the owners without webhooks are not delivered, they are not written to the outbox.
+	// The links are forgotten by sweep, so the map holds the clicks of the ClickWindow at most.
+	clicked map[string]click
+	// finished deliveries since the last compaction.
+	finished int
+
+	clicks chan events.Event
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New restores the outbox and starts delivering. The owners and the original URLs of the links
+// are looked up in st if it implements storage.IAdmin.
+func New(cfg Config, st storage.IStorage) (*Dispatcher, error) {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = DefaultBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.ClickWindow <= 0 {
+		cfg.ClickWindow = DefaultClickWindow
+	}
+	if cfg.Sync == "" {
+		cfg.Sync = wal.SyncAlways
+	}
+
+	d := &Dispatcher{
+		cfg:      cfg,
+		client:   newClient(cfg),
+		webhooks: make(map[string]*Webhook),
+		pending:  make(map[string]*delivery),
+		clicked:  make(map[string]click),
+		clicks:   make(chan events.Event, 1024),
+		wake:     make(chan struct{}, 1),
+	}
+	d.admin, _ = storage.As[storage.IAdmin](st)
+
+	if cfg.Path != "" {
+		outbox, err := wal.Open(cfg.Path, cfg.Sync, d.replay)
+		if err != nil {
+			return nil, fmt.Errorf("can't open the webhook outbox: %w", err)
+		}
+		d.outbox = outbox
+
+		if err = d.compact(); err != nil {
+			outbox.Close()
+			return nil, err
+		}
+	}
+
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	d.wg.Add(2)
+	go d.dispatch()
+	go d.watchClicks()
+
+	return d, nil
+}
+
+// Close stops the deliveries and closes the outbox, the pending deliveries are sent after a restart.
+func (d *Dispatcher) Close() error {
+	d.cancel()
+	d.wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.outbox == nil {
+		return nil
+	}
+
+	return d.outbox.Close()
+}
+
+// Register adds a webhook of the owner. Events are the names of the events to deliver, every event if empty.
+func (d *Dispatcher) Register(ctx context.Context, owner, rawURL string, names []string) (Webhook, error) {
+	if ctx.Err() != nil {
+		return Webhook{}, ctx.Err()
+	}
+
+	if err := validate(rawURL, names); err != nil {
+		return Webhook{}, err
+	}
+
+	w := &Webhook{ID: newID(), Owner: owner, URL: rawURL, Events: names, CreatedAt: time.Now().UTC()}
+	if w.Events == nil {
+		w.Events = []string{}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.owned(owner)) >= MaxWebhooks {
+		return Webhook{}, ErrTooMany
+	}
+
+	if err := d.append(record{Op: opWebhook, Webhook: newWebhookRecord(w)}); err != nil {
+		return Webhook{}, err
+	}
+	d.webhooks[w.ID] = w
+
+	return d.view(w), nil
+}
+
+// List returns the webhooks of the owner in the order they were registered.
+func (d *Dispatcher) List(ctx context.Context, owner string) ([]Webhook, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	owned := d.owned(owner)
+	list := make([]Webhook, 0, len(owned))
+	for _, w := range owned {
+		list = append(list, d.view(w))
+	}
+
+	return list, nil
+}
+
+// Delete removes the webhook of the owner, its pending deliveries are dropped.
+func (d *Dispatcher) Delete(ctx context.Context, owner, id string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w, ok := d.webhooks[id]
+	if !ok || w.Owner != owner {
+		return ErrNotFound
+	}
+
+	if err := d.append(record{Op: opDelete, ID: id}); err != nil {
+		return err
+	}
+	d.deleteWebhook(id)
+
+	return nil
+}
+
+// Test sends a ping event to the webhook of the owner once and reports the outcome.
+// The error of a request without a response is not told, so the outcome can't be used to probe the network.
+func (d *Dispatcher) Test(ctx context.Context, owner, id string) (TestResult, error) {
+	d.mu.Lock()
+	w, ok := d.webhooks[id]
+	var hook Webhook
+	if ok {
+		hook = *w
+	}
+	d.mu.Unlock()
+
+	if !ok || hook.Owner != owner {
+		return TestResult{}, ErrNotFound
+	}
+
+	payload, err := d.payload(EventPing, events.Event{At: time.Now()})
+	if err != nil {
+		return TestResult{}, err
+	}
+
+	code, err := d.send(ctx, &hook, &delivery{ID: newID(), Webhook: id, Event: EventPing, Payload: payload})
+	res := TestResult{StatusCode: code}
+	switch {
+	case err != nil && code == 0:
+		log.Printf("webhook: the test of %s failed: %v", id, err)
+		res.Error = errUnreachable.Error()
+	case err != nil:
+		res.Error = err.Error()
+	}
+
+	return res, nil
+}
+
+// Publish turns the event into the deliveries to the webhooks of the owner of the link.
+// It subscribes the Dispatcher to the usecase bus, the clicks are handled in background.
+func (d *Dispatcher) Publish(e events.Event) {
+	if e.Type == events.LinkClicked {
+		d.mu.Lock()
+		_, known := d.clicked[e.Short]
+		d.mu.Unlock()
+
+		if !known {
+			select {
+			case d.clicks <- e:
+			default:
+				log.Printf("webhook: the click of %s is dropped, the queue is full", e.Short)
+			}
+		}
+
+		return
+	}
+
+	name, ok := eventNames[e.Type]
+	if !ok || !d.hasWebhooks(e.Owner, name) {
+		return
+	}
+
+	if e.Type == events.LinkDeleted && d.admin != nil {
+		// only the owner can delete a link, others' requests are ignored by the storages
+		link, err := d.admin.GetLink(d.ctx, e.Short)
+		if err != nil || link.Owner != e.Owner {
+			return
+		}
+		e.Long = link.Long
+	}
+
+	if err := d.enqueue(name, e); err != nil {
+		log.Printf("webhook: can't enqueue %s of %s: %v", name, e.Short, err)
+	}
+}
+
+// watchClicks delivers the first click of every link and forgets the expired clicked links.
+func (d *Dispatcher) watchClicks() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(sweepEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case e := <-d.clicks:
+			if err := d.firstClick(e); err != nil {
+				log.Printf("webhook: can't handle the click of %s: %v", e.Short, err)
+			}
+		case now := <-ticker.C:
+			d.mu.Lock()
+			d.sweep(now)
+			d.mu.Unlock()
+		}
+	}
+}
+
+// sweep forgets the delivered clicks older than the ClickWindow and the other ones older than lookupTTL,
+// d.mu must be held.
+func (d *Dispatcher) sweep(now time.Time) {
+	for short, c := range d.clicked {
+		ttl := lookupTTL
+		if c.delivered {
+			ttl = d.cfg.ClickWindow
+		}
+
+		if now.Sub(c.at) > ttl {
+			delete(d.clicked, short)
+		}
+	}
+}
+
+func (d *Dispatcher) firstClick(e events.Event) error {
+	d.mu.Lock()
+	_, known := d.clicked[e.Short]
+	d.mu.Unlock()
+
+	if known {
+		return nil
+	}
+
+	if d.admin == nil {
+		d.mu.Lock()
+		d.clicked[e.Short] = click{at: time.Now()}
+		d.mu.Unlock()
+
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, d.cfg.Timeout)
+	defer cancel()
+
+	link, err := d.admin.GetLink(ctx, e.Short)
+	if err != nil {
+		return err
+	}
+	e.Owner, e.Long = link.Owner, link.Long
+
+	if !d.hasWebhooks(e.Owner, EventFirstClick) {
+		// the owner without webhooks is looked up again after lookupTTL or a restart
+		d.mu.Lock()
+		d.clicked[e.Short] = click{at: time.Now()}
+		d.mu.Unlock()
+
+		return nil
+	}
+
+	return d.enqueue(EventFirstClick, e)
+}
+
+// enqueue saves the deliveries of the event to the subscribed webhooks of its owner.
+func (d *Dispatcher) enqueue(name string, e events.Event) error {
+	payload, err := d.payload(name, e)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var (
+		records    []any
+		deliveries []*delivery
+	)
+
+	now := time.Now().UTC()
+	if name == EventFirstClick {
+		if d.clicked[e.Short].delivered {
+			return nil
+		}
+
+		records = append(records, record{Op: opClicked, Short: e.Short, At: now})
+	}
+
+	for _, w := range d.owned(e.Owner) {
+		if !w.subscribed(name) {
+			continue
+		}
+
+		dl := &delivery{ID: newID(), Webhook: w.ID, Event: name, Payload: payload, Next: time.Now()}
+		deliveries = append(deliveries, dl)
+		records = append(records, record{Op: opDelivery, Delivery: dl})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err = d.append(records...); err != nil {
+		return err
+	}
+
+	if name == EventFirstClick {
+		d.clicked[e.Short] = click{at: now, delivered: true}
+	}
+
+	for _, dl := range deliveries {
+		d.pending[dl.ID] = dl
+	}
+	d.notify()
+
+	return nil
+}
+
+func (d *Dispatcher) hasWebhooks(owner, event string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, w := range d.owned(owner) {
+		if w.subscribed(event) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// owned returns the webhooks of the owner in the order they were registered, d.mu must be held.
+func (d *Dispatcher) owned(owner string) []*Webhook {
+	var owned []*Webhook
+	for _, w := range d.webhooks {
+		if w.Owner == owner {
+			owned = append(owned, w)
+		}
+	}
+
+	sort.Slice(owned, func(i, j int) bool {
+		if !owned[i].CreatedAt.Equal(owned[j].CreatedAt) {
+			return owned[i].CreatedAt.Before(owned[j].CreatedAt)
+		}
+		return owned[i].ID < owned[j].ID
+	})
+
+	return owned
+}
+
+// deleteWebhook removes the webhook and its deliveries, d.mu must be held.
+func (d *Dispatcher) deleteWebhook(id string) {
+	delete(d.webhooks, id)
+
+	for key, dl := range d.pending {
+		if dl.Webhook == id {
+			delete(d.pending, key)
+		}
+	}
+}
+
+// view returns a copy of the webhook with its secret.
+func (d *Dispatcher) view(w *Webhook) Webhook {
+	v := *w
+	v.Events = append([]string{}, w.Events...)
+	v.Secret = Secret(d.cfg.Key, w.ID)
+
+	return v
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func validate(rawURL string, names []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalid)
+	}
+
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		switch name {
+		case EventCreated, EventUpdated, EventDeleted, EventFirstClick:
+		default:
+			return fmt.Errorf("%w: unknown event %q", ErrInvalid, name)
+		}
+
+		if seen[name] {
+			return fmt.Errorf("%w: duplicate event %q", ErrInvalid, name)
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,511 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+	"url-shortener/internal/storage"
+	mapstorage "url-shortener/internal/storage/map"
+	"url-shortener/internal/usecase/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKey = []byte("test-key")
+
+type received struct {
+	header  http.Header
+	body    []byte
+	payload Payload
+}
+
+// receiver starts a webhook endpoint answering with the status returned by status.
+func receiver(t *testing.T, status func() int) (*httptest.Server, chan received) {
+	t.Helper()
+
+	ch := make(chan received, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var p Payload
+		json.Unmarshal(body, &p)
+		ch <- received{header: r.Header.Clone(), body: body, payload: p}
+
+		w.WriteHeader(status())
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, ch
+}
+
+func ok() int { return http.StatusOK }
+
+func next(t *testing.T, ch chan received) received {
+	t.Helper()
+
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery")
+		return received{}
+	}
+}
+
+func none(t *testing.T, ch chan received) {
+	t.Helper()
+
+	select {
+	case r := <-ch:
+		t.Errorf("unexpected delivery of %s", r.payload.Type)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func newDispatcher(t *testing.T, cfg Config, st storage.IStorage) *Dispatcher {
+	t.Helper()
+
+	cfg.Key = testKey
+	cfg.BaseURL = "http://localhost:8080/"
+	// the receivers listen on the loopback
+	cfg.AllowPrivate = true
+
+	d, err := New(cfg, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	return d
+}
+
+func TestSign(t *testing.T) {
+	secret := Secret(testKey, "id")
+	assert.NotEqual(t, secret, Secret(testKey, "other"))
+	assert.NotEqual(t, secret, Secret([]byte("other"), "id"))
+
+	body := []byte(`{"type":"ping"}`)
+	header := http.Header{}
+	header.Set(HeaderTimestamp, "1700000000")
+	header.Set(HeaderSignature, Sign(secret, "1700000000", body))
+
+	assert.True(t, Verify(secret, header, body))
+	assert.False(t, Verify(secret, header, []byte(`{"type":"pong"}`)))
+	assert.False(t, Verify(Secret(testKey, "other"), header, body))
+
+	header.Set(HeaderTimestamp, "1700000001")
+	assert.False(t, Verify(secret, header, body))
+}
+
+func TestDispatcher_Register(t *testing.T) {
+	ctx := context.Background()
+	d := newDispatcher(t, Config{}, nil)
+
+	tests := []struct {
+		name    string
+		url     string
+		events  []string
+		wantErr error
+	}{
+		{name: "every event", url: "https://example.com/hook"},
+		{name: "events", url: "http://example.com/hook", events: []string{EventCreated, EventFirstClick}},
+		{name: "relative url", url: "/hook", wantErr: ErrInvalid},
+		{name: "scheme", url: "ftp://example.com/hook", wantErr: ErrInvalid},
+		{name: "unknown event", url: "https://example.com/hook", events: []string{"link.renamed"}, wantErr: ErrInvalid},
+		{name: "ping", url: "https://example.com/hook", events: []string{EventPing}, wantErr: ErrInvalid},
+		{name: "duplicate event", url: "https://example.com/hook", events: []string{EventCreated, EventCreated}, wantErr: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := d.Register(ctx, "alice", tt.url, tt.events)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.NotEmpty(t, w.ID)
+			assert.Equal(t, Secret(testKey, w.ID), w.Secret)
+			assert.Equal(t, len(tt.events), len(w.Events))
+		})
+	}
+
+	list, err := d.List(ctx, "alice")
+	assert.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "https://example.com/hook", list[0].URL)
+		assert.Equal(t, "http://example.com/hook", list[1].URL)
+	}
+
+	list, err = d.List(ctx, "bob")
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+
+	for i := 0; i < MaxWebhooks; i++ {
+		_, err = d.Register(ctx, "bob", "https://example.com/hook", nil)
+		assert.NoError(t, err)
+	}
+	_, err = d.Register(ctx, "bob", "https://example.com/hook", nil)
+	assert.True(t, errors.Is(err, ErrTooMany), err)
+
+	assert.True(t, errors.Is(d.Delete(ctx, "bob", list0(t, d, "alice").ID), ErrNotFound))
+	assert.NoError(t, d.Delete(ctx, "alice", list0(t, d, "alice").ID))
+	assert.True(t, errors.Is(d.Delete(ctx, "alice", "missing"), ErrNotFound))
+
+	list, err = d.List(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+}
+
+func list0(t *testing.T, d *Dispatcher, owner string) Webhook {
+	t.Helper()
+
+	list, err := d.List(context.Background(), owner)
+	if err != nil || len(list) == 0 {
+		t.Fatalf("List() = %v, %v", list, err)
+	}
+
+	return list[0]
+}
+
+func TestDispatcher_Publish(t *testing.T) {
+	ctx := context.Background()
+	srv, ch := receiver(t, ok)
+	d := newDispatcher(t, Config{}, nil)
+
+	all, err := d.Register(ctx, "alice", srv.URL, nil)
+	assert.NoError(t, err)
+
+	d.Publish(events.Event{Type: events.LinkCreated, Short: "abc", Long: "https://ya.ru", Owner: "alice", At: time.Now()})
+
+	r := next(t, ch)
+	assert.True(t, Verify(all.Secret, r.header, r.body))
+	assert.Equal(t, all.ID, r.header.Get(HeaderWebhook))
+	assert.Equal(t, EventCreated, r.header.Get(HeaderEvent))
+	assert.NotEmpty(t, r.header.Get(HeaderDelivery))
+	assert.Equal(t, EventCreated, r.payload.Type)
+	assert.Equal(t, &LinkData{Short: "abc", ShortURL: "http://localhost:8080/abc", OriginalURL: "https://ya.ru"}, r.payload.Data)
+
+	// the events of other owners and the events a webhook is not subscribed to are not delivered
+	_, err = d.Register(ctx, "bob", srv.URL, []string{EventDeleted})
+	assert.NoError(t, err)
+
+	d.Publish(events.Event{Type: events.LinkCreated, Short: "def", Owner: "bob"})
+	d.Publish(events.Event{Type: events.LinkCreated, Short: "def", Owner: "carol"})
+	none(t, ch)
+
+	// deleted webhooks get nothing
+	assert.NoError(t, d.Delete(ctx, "alice", all.ID))
+	d.Publish(events.Event{Type: events.LinkUpdated, Short: "abc", Owner: "alice"})
+	none(t, ch)
+}
+
+func TestDispatcher_Retry(t *testing.T) {
+	ctx := context.Background()
+
+	var calls int32
+	srv, ch := receiver(t, func() int {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusNoContent
+	})
+
+	d := newDispatcher(t, Config{Backoff: 10 * time.Millisecond, MaxAttempts: 3}, nil)
+	_, err := d.Register(ctx, "alice", srv.URL, nil)
+	assert.NoError(t, err)
+
+	d.Publish(events.Event{Type: events.LinkCreated, Short: "abc", Owner: "alice"})
+
+	first := next(t, ch)
+	for i := 0; i < 2; i++ {
+		r := next(t, ch)
+		assert.Equal(t, first.header.Get(HeaderDelivery), r.header.Get(HeaderDelivery))
+		assert.Equal(t, first.payload.ID, r.payload.ID)
+	}
+	none(t, ch)
+
+	// the delivery is dropped after MaxAttempts
+	atomic.StoreInt32(&calls, -10)
+	d.Publish(events.Event{Type: events.LinkCreated, Short: "def", Owner: "alice"})
+	for i := 0; i < 3; i++ {
+		next(t, ch)
+	}
+	none(t, ch)
+
+	d.mu.Lock()
+	assert.Empty(t, d.pending)
+	d.mu.Unlock()
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	d := &Dispatcher{cfg: Config{Backoff: time.Second, MaxBackoff: 5 * time.Second}}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: 2 * time.Second},
+		{attempts: 3, want: 4 * time.Second},
+		{attempts: 4, want: 5 * time.Second},
+		{attempts: 30, want: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDispatcher_Restart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "webhooks.log")
+
+	var down int32 = 1
+	srv, ch := receiver(t, func() int {
+		if atomic.LoadInt32(&down) == 1 {
+			return http.StatusBadGateway
+		}
+		return http.StatusOK
+	})
+
+	cfg := Config{Key: testKey, Path: path, Backoff: 100 * time.Millisecond, AllowPrivate: true}
+	d, err := New(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := d.Register(ctx, "alice", srv.URL, nil)
+	assert.NoError(t, err)
+
+	d.Publish(events.Event{Type: events.LinkCreated, Short: "abc", Owner: "alice"})
+	first := next(t, ch)
+	assert.NoError(t, d.Close())
+
+	atomic.StoreInt32(&down, 0)
+
+	d, err = New(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	list, err := d.List(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, []Webhook{hook}, list)
+
+	r := next(t, ch)
+	assert.Equal(t, first.header.Get(HeaderDelivery), r.header.Get(HeaderDelivery))
+	assert.Equal(t, first.body, r.body)
+	assert.True(t, Verify(hook.Secret, r.header, r.body))
+
+	// the delivery is done, it is not sent after the next restart
+	assert.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.pending) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, d.Close())
+
+	d, err = New(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	none(t, ch)
+}
+
+func TestDispatcher_FirstClick(t *testing.T) {
+	ctx := context.Background()
+	srv, ch := receiver(t, ok)
+
+	st := mapstorage.NewMapStorage()
+	_, err := st.AddLink(ctx, "https://ya.ru", "abc", "alice")
+	assert.NoError(t, err)
+	_, err = st.AddLink(ctx, "https://go.dev", "def", "bob")
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "webhooks.log")
+	d := newDispatcher(t, Config{Path: path}, st)
+
+	_, err = d.Register(ctx, "alice", srv.URL, []string{EventFirstClick})
+	assert.NoError(t, err)
+
+	d.Publish(events.Event{Type: events.LinkClicked, Short: "abc"})
+	d.Publish(events.Event{Type: events.LinkClicked, Short: "abc"})
+	d.Publish(events.Event{Type: events.LinkClicked, Short: "def"})
+	d.Publish(events.Event{Type: events.LinkClicked, Short: "missing"})
+
+	r := next(t, ch)
+	assert.Equal(t, EventFirstClick, r.payload.Type)
+	assert.Equal(t, &LinkData{Short: "abc", ShortURL: "http://localhost:8080/abc", OriginalURL: "https://ya.ru"}, r.payload.Data)
+	none(t, ch)
+
+	// the first click is remembered after a restart
+	assert.NoError(t, d.Close())
+	d = newDispatcher(t, Config{Path: path}, st)
+
+	d.Publish(events.Event{Type: events.LinkClicked, Short: "abc"})
+	none(t, ch)
+}
+
+func TestDispatcher_ClickWindow(t *testing.T) {
+	ctx := context.Background()
+	srv, ch := receiver(t, ok)
+
+	st := mapstorage.NewMapStorage()
+	_, err := st.AddLink(ctx, "https://ya.ru", "abc", "alice")
+	assert.NoError(t, err)
+	_, err = st.AddLink(ctx, "https://go.dev", "def", "bob")
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "webhooks.log")
+	d := newDispatcher(t, Config{Path: path, ClickWindow: time.Hour}, st)
+
+	_, err = d.Register(ctx, "alice", srv.URL, []string{EventFirstClick})
+	assert.NoError(t, err)
+
+	d.Publish(events.Event{Type: events.LinkClicked, Short: "abc"})
+	d.Publish(events.Event{Type: events.LinkClicked, Short: "def"})
+	next(t, ch)
+	assert.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.clicked) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	d.mu.Lock()
+	d.sweep(time.Now().Add(30 * time.Minute))
+	assert.Len(t, d.clicked, 2, "the clicks are remembered within the window")
+	d.sweep(time.Now().Add(2 * time.Hour))
+	assert.Empty(t, d.clicked, "the clicks are forgotten after the window")
+	d.mu.Unlock()
+
+	// the next click after the window is the first one again
+	d.Publish(events.Event{Type: events.LinkClicked, Short: "abc"})
+	next(t, ch)
+
+	// the time of the click is kept after a restart
+	assert.NoError(t, d.Close())
+	d = newDispatcher(t, Config{Path: path, ClickWindow: time.Hour}, st)
+
+	d.mu.Lock()
+	c := d.clicked["abc"]
+	d.sweep(time.Now().Add(2 * time.Hour))
+	assert.Empty(t, d.clicked)
+	d.mu.Unlock()
+	assert.True(t, c.delivered)
+	assert.WithinDuration(t, time.Now(), c.at, time.Minute)
+}
+
+func TestDispatcher_Deleted(t *testing.T) {
+	ctx := context.Background()
+	srv, ch := receiver(t, ok)
+
+	st := mapstorage.NewMapStorage()
+	_, err := st.AddLink(ctx, "https://ya.ru", "abc", "alice")
+	assert.NoError(t, err)
+
+	d := newDispatcher(t, Config{}, st)
+	for _, owner := range []string{"alice", "bob"} {
+		_, err = d.Register(ctx, owner, srv.URL, []string{EventDeleted})
+		assert.NoError(t, err)
+	}
+
+	// only the owner can delete the link
+	st.MarkAsDeleted(ctx, "abc", "bob")
+	d.Publish(events.Event{Type: events.LinkDeleted, Short: "abc", Owner: "bob"})
+	none(t, ch)
+
+	assert.NoError(t, st.MarkAsDeleted(ctx, "abc", "alice"))
+	d.Publish(events.Event{Type: events.LinkDeleted, Short: "abc", Owner: "alice"})
+
+	r := next(t, ch)
+	assert.Equal(t, EventDeleted, r.payload.Type)
+	assert.Equal(t, "https://ya.ru", r.payload.Data.OriginalURL)
+}
+
+func TestDispatcher_Test(t *testing.T) {
+	ctx := context.Background()
+
+	var status int32 = http.StatusOK
+	srv, ch := receiver(t, func() int { return int(atomic.LoadInt32(&status)) })
+
+	d := newDispatcher(t, Config{}, nil)
+	hook, err := d.Register(ctx, "alice", srv.URL, []string{EventCreated})
+	assert.NoError(t, err)
+
+	res, err := d.Test(ctx, "alice", hook.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, TestResult{StatusCode: http.StatusOK}, res)
+
+	r := next(t, ch)
+	assert.Equal(t, EventPing, r.payload.Type)
+	assert.Nil(t, r.payload.Data)
+	assert.True(t, Verify(hook.Secret, r.header, r.body))
+
+	atomic.StoreInt32(&status, http.StatusGone)
+	res, err = d.Test(ctx, "alice", hook.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGone, res.StatusCode)
+	assert.NotEmpty(t, res.Error)
+	next(t, ch)
+
+	_, err = d.Test(ctx, "bob", hook.ID)
+	assert.True(t, errors.Is(err, ErrNotFound), err)
+
+	// a failed test is not retried
+	none(t, ch)
+}
+
+func TestDispatcher_NotPublic(t *testing.T) {
+	ctx := context.Background()
+	srv, ch := receiver(t, ok)
+
+	d, err := New(Config{Key: testKey}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	hook, err := d.Register(ctx, "alice", srv.URL, nil)
+	assert.NoError(t, err)
+
+	// the loopback is refused when connecting, the reason is not told
+	res, err := d.Test(ctx, "alice", hook.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, TestResult{Error: errUnreachable.Error()}, res)
+	none(t, ch)
+}
+
+func TestPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"8.8.8.8":          true,
+		"2a00:1450::1":     true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		assert.Equal(t, want, public(net.ParseIP(addr)), addr)
+	}
+
+	assert.ErrorIs(t, publicOnly("tcp", "127.0.0.1:8081", nil), errNotPublic)
+	assert.NoError(t, publicOnly("tcp", "8.8.8.8:443", nil))
+}
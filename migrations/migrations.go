@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL migrations, so the binaries do not depend on the working directory.
+package migrations
+
+import "embed"
+
+// FS contains a directory of migrations per vendor: mysql, postgres and sqlite3.
+//
+//go:embed mysql postgres sqlite3
+var FS embed.FS
@@ -0,0 +1 @@
+ALTER TABLE links ADD COLUMN created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP;
\ No newline at end of file
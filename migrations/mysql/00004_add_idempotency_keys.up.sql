@@ -0,0 +1,8 @@
+CREATE TABLE idempotency_keys
+(
+    key_hash    VARCHAR(64) PRIMARY KEY,
+    fingerprint VARCHAR(64) NOT NULL,
+    response    LONGBLOB,
+    expires_at  BIGINT NOT NULL,
+    INDEX idempotency_keys_expires_at (expires_at)
+);
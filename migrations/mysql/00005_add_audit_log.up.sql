@@ -0,0 +1,15 @@
+CREATE TABLE audit_log
+(
+    id            BIGINT AUTO_INCREMENT PRIMARY KEY,
+    occurred_at   BIGINT       NOT NULL,
+    actor         VARCHAR(255) NOT NULL,
+    ip            VARCHAR(64)  NOT NULL,
+    operation     VARCHAR(64)  NOT NULL,
+    target        VARCHAR(255) NOT NULL,
+    old_value     TEXT         NOT NULL,
+    new_value     TEXT         NOT NULL,
+    outcome       VARCHAR(16)  NOT NULL,
+    error_message TEXT         NOT NULL,
+    INDEX audit_log_occurred_at (occurred_at),
+    INDEX audit_log_actor (actor, occurred_at)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE link_options
+(
+    short_url VARCHAR(255) PRIMARY KEY,
+    title     TEXT    NOT NULL,
+    preview   BOOLEAN NOT NULL
+);
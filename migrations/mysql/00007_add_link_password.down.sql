@@ -0,0 +1 @@
+ALTER TABLE link_options DROP COLUMN password_hash;
\ No newline at end of file
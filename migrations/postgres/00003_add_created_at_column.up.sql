@@ -0,0 +1 @@
+ALTER TABLE links ADD COLUMN created_at TIMESTAMPTZ DEFAULT now();
\ No newline at end of file
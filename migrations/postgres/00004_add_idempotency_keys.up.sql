@@ -0,0 +1,8 @@
+CREATE TABLE idempotency_keys
+(
+    key_hash    TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    response    BYTEA,
+    expires_at  BIGINT NOT NULL
+);
+CREATE INDEX idempotency_keys_expires_at ON idempotency_keys (expires_at);
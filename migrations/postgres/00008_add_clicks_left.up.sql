@@ -0,0 +1 @@
+ALTER TABLE links ADD COLUMN clicks_left INTEGER;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE links DROP COLUMN created_at;
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE audit_log
+(
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    occurred_at   INTEGER NOT NULL,
+    actor         TEXT    NOT NULL,
+    ip            TEXT    NOT NULL,
+    operation     TEXT    NOT NULL,
+    target        TEXT    NOT NULL,
+    old_value     TEXT    NOT NULL,
+    new_value     TEXT    NOT NULL,
+    outcome       TEXT    NOT NULL,
+    error_message TEXT    NOT NULL
+);
+CREATE INDEX audit_log_occurred_at ON audit_log (occurred_at);
+CREATE INDEX audit_log_actor ON audit_log (actor, occurred_at);
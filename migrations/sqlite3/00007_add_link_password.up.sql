@@ -0,0 +1 @@
+ALTER TABLE link_options ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
\ No newline at end of file
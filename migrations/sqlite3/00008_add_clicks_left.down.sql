@@ -0,0 +1 @@
+ALTER TABLE links DROP COLUMN clicks_left;
\ No newline at end of file
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client of the url-shortener. Implementations keep the session issued by the server,
+// so links created by a Client are listed by ListMine of the same Client.
+type Client interface {
+	// Shorten returns the short URL of longURL.
+	// ErrConflict is returned if longURL was already shortened, the short URL is returned along with it
+	// when the transport provides it.
+	Shorten(ctx context.Context, longURL string) (string, error)
+	// ShortenBatch shortens every item, results are matched by CorrelationID.
+	ShortenBatch(ctx context.Context, items []BatchItem) ([]BatchResult, error)
+	// Resolve returns the original URL by the short id or the short URL.
+	Resolve(ctx context.Context, short string) (string, error)
+	// ListMine returns links created in the session.
+	ListMine(ctx context.Context) ([]Link, error)
+	// Delete marks links of the session as deleted, it is done asynchronously by the server.
+	Delete(ctx context.Context, shorts ...string) error
+}
+
+// Link pair of the original and the short URL.
+type Link struct {
+	OriginalURL string `json:"original_url"`
+	ShortURL    string `json:"short_url"`
+}
+
+// BatchItem URL to shorten in a batch.
+type BatchItem struct {
+	CorrelationID string `json:"correlation_id"`
+	OriginalURL   string `json:"original_url"`
+}
+
+// BatchResult short URL of the BatchItem with the same CorrelationID.
+type BatchResult struct {
+	CorrelationID string `json:"correlation_id"`
+	ShortURL      string `json:"short_url"`
+	// Status "created" or "existing".
+	Status string `json:"status"`
+}
+
+// Config of a Client.
+type Config struct {
+	// Token session to start with, a new one is issued by the server if empty.
+	Token string
+	// Retries number of retries of transient errors, 0 disables retrying.
+	Retries int
+	// Backoff delay before the first retry, it doubles on every next retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// HTTPClient used by the REST client, a client with a cookie jar is created if nil.
+	HTTPClient *http.Client
+}
+
+// DefaultConfig retries transient errors three times starting with 100ms.
+var DefaultConfig = Config{
+	Retries:    3,
+	Backoff:    100 * time.Millisecond,
+	MaxBackoff: 2 * time.Second,
+}
+
+var (
+	// ErrConflict the URL was already shortened.
+	ErrConflict = errors.New("already exists")
+	// ErrNotFound the link does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrGone the link was deleted or has no visits left.
+	ErrGone = errors.New("deleted")
+)
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a copy of ctx sending key with the Shorten and ShortenBatch calls made with it.
+// The server replays its response for a repeated key, so these calls are only retried with a key:
+// without one a retry after the server created the links would create them again.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// idempotencyKeyFrom returns the key set by WithIdempotencyKey or an empty string.
+func idempotencyKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	return key
+}
+
+// Error is returned for unsuccessful responses.
+// Use errors.Is with ErrConflict, ErrNotFound and ErrGone to check the kind.
+type Error struct {
+	// Status HTTP status or gRPC code.
+	Status    int
+	Code      string
+	Message   string
+	RequestID string
+
+	kind error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "url-shortener: %d", e.Status)
+
+	if e.Code != "" {
+		b.WriteString(" " + e.Code)
+	}
+
+	if e.Message != "" {
+		b.WriteString(": " + e.Message)
+	}
+
+	if e.RequestID != "" {
+		b.WriteString(" (request " + e.RequestID + ")")
+	}
+
+	return b.String()
+}
+
+// Unwrap returns ErrConflict, ErrNotFound, ErrGone or nil.
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+// retry calls fn until it succeeds, returns a non-transient error or retries are exhausted.
+func retry(ctx context.Context, cfg Config, transient func(error) bool, fn func() error) error {
+	delay := cfg.Backoff
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= cfg.Retries || !transient(err) {
+			return err
+		}
+
+		// full jitter
+		wait := time.Duration(rand.Int63n(int64(delay) + 1))
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		delay *= 2
+		if cfg.MaxBackoff > 0 && delay > cfg.MaxBackoff {
+			delay = cfg.MaxBackoff
+		}
+	}
+}
+
+// shortID takes the id from the short URL, the id itself is returned as is.
+func shortID(short string) string {
+	short = strings.TrimRight(short, "/")
+	if i := strings.LastIndex(short, "/"); i >= 0 {
+		return short[i+1:]
+	}
+
+	return short
+}
@@ -0,0 +1,258 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"url-shortener/config"
+	"url-shortener/internal/access"
+	grpchandler "url-shortener/internal/handler/grpc"
+	resthandler "url-shortener/internal/handler/rest"
+	"url-shortener/internal/idempotency"
+	"url-shortener/internal/repository"
+	"url-shortener/internal/routes"
+	"url-shortener/internal/usecase"
+	shortener "url-shortener/pkg/api"
+)
+
+var testConfig = Config{Retries: 2, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+func newLogic(t *testing.T) usecase.UseCase {
+	t.Helper()
+
+	repo, err := repository.New(&repository.Config{DriverName: "map"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return usecase.New(repo)
+}
+
+func newRESTClient(t *testing.T) Client {
+	t.Helper()
+
+	conf := &config.Config{Key: []byte("test-key")}
+	logic := newLogic(t)
+
+	router := gin.New()
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	conf.BaseURL = srv.URL + "/"
+	idem := idempotency.NewWithBackend(idempotency.NewMemory(), 0)
+	routes.PublicRoutes(router.Group("/"), resthandler.NewHandler(conf, logic), idem)
+	routes.V2Routes(router.Group("/"), resthandler.NewHandlerV2(conf, logic, access.New(access.Config{})), idem)
+
+	return NewREST(srv.URL, testConfig)
+}
+
+func newGRPCClient(t *testing.T) Client {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{Key: []byte("test-key"), BaseURL: "http://" + lis.Addr().String() + "/"}
+
+	idem := idempotency.NewWithBackend(idempotency.NewMemory(), 0)
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(idem.UnaryServerInterceptor(grpchandler.IdempotentMethods...)))
+	shortener.RegisterShortenerServer(grpcServer, grpchandler.NewHandler(conf, newLogic(t)))
+
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return NewGRPC(shortener.NewShortenerClient(conn), testConfig)
+}
+
+func TestClient(t *testing.T) {
+	clients := map[string]func(t *testing.T) Client{
+		"rest": newRESTClient,
+		"grpc": newGRPCClient,
+	}
+
+	for name, newClient := range clients {
+		t.Run(name, func(t *testing.T) {
+			cl := newClient(t)
+			ctx := context.Background()
+
+			links, err := cl.ListMine(ctx)
+			if assert.NoError(t, err) {
+				assert.Empty(t, links)
+			}
+
+			short, err := cl.Shorten(ctx, "https://ya.ru")
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			long, err := cl.Resolve(ctx, short)
+			if assert.NoError(t, err) {
+				assert.Equal(t, "https://ya.ru", long)
+			}
+
+			batch := []BatchItem{
+				{CorrelationID: "first", OriginalURL: "https://vk.com"},
+				{CorrelationID: "second", OriginalURL: "https://go.dev"},
+			}
+
+			results, err := cl.ShortenBatch(WithIdempotencyKey(ctx, "batch-1"), batch)
+			if assert.NoError(t, err) && assert.Len(t, results, 2) {
+				assert.Equal(t, "first", results[0].CorrelationID)
+				assert.Equal(t, "created", results[0].Status)
+
+				// the repeated key gets the saved response
+				replayed, err := cl.ShortenBatch(WithIdempotencyKey(ctx, "batch-1"), batch)
+				if assert.NoError(t, err) {
+					assert.Equal(t, results, replayed)
+				}
+
+				long, err = cl.Resolve(ctx, results[1].ShortURL)
+				if assert.NoError(t, err) {
+					assert.Equal(t, "https://go.dev", long)
+				}
+			}
+
+			links, err = cl.ListMine(ctx)
+			if assert.NoError(t, err) {
+				assert.Len(t, links, 3)
+			}
+
+			_, err = cl.Resolve(ctx, "nope")
+			assert.True(t, errors.Is(err, ErrNotFound), "want ErrNotFound, got %v", err)
+
+			if !assert.NoError(t, cl.Delete(ctx, short)) {
+				return
+			}
+
+			assert.Eventually(t, func() bool {
+				_, err = cl.Resolve(ctx, short)
+				return errors.Is(err, ErrGone)
+			}, time.Second, 10*time.Millisecond, "want ErrGone, got %v", err)
+		})
+	}
+}
+
+func TestREST_Retry(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		wantErr  bool
+		wantCall int32
+	}{
+		{name: "with idempotency key", key: "k1", wantCall: 3},
+		{name: "without idempotency key", wantErr: true, wantCall: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, tt.key, r.Header.Get("Idempotency-Key"))
+
+				if atomic.AddInt32(&calls, 1) < 3 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+
+				w.Header().Set("Authorization", "issued-token")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"original_url":"https://ya.ru","short_url":"http://short/zE"}`))
+			}))
+			defer srv.Close()
+
+			cl := NewREST(srv.URL, testConfig)
+
+			ctx := context.Background()
+			if tt.key != "" {
+				ctx = WithIdempotencyKey(ctx, tt.key)
+			}
+
+			short, err := cl.Shorten(ctx, "https://ya.ru")
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, "http://short/zE", short)
+				assert.Equal(t, "issued-token", cl.Token())
+			}
+			assert.Equal(t, tt.wantCall, atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestREST_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		location string
+		want     error
+		wantCall int32
+	}{
+		{
+			name:     "conflict",
+			status:   http.StatusConflict,
+			body:     `{"code":"conflict","message":"link already exists","request_id":"r1"}`,
+			location: "http://short/zE",
+			want:     ErrConflict,
+			wantCall: 1,
+		},
+		{
+			name:     "gone",
+			status:   http.StatusGone,
+			body:     `{"code":"gone","message":"link was deleted","request_id":"r2"}`,
+			want:     ErrGone,
+			wantCall: 1,
+		},
+		{
+			name:     "retries exhausted",
+			status:   http.StatusTooManyRequests,
+			body:     `{"code":"rate_limited","message":"too many requests","request_id":"r3"}`,
+			wantCall: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				if tt.location != "" {
+					w.Header().Set("Location", tt.location)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			short, err := NewREST(srv.URL, testConfig).Shorten(context.Background(), "https://ya.ru")
+
+			var e *Error
+			if assert.True(t, errors.As(err, &e), "want *Error, got %v", err) {
+				assert.Equal(t, tt.status, e.Status)
+				assert.NotEmpty(t, e.RequestID)
+			}
+			if tt.want != nil {
+				assert.True(t, errors.Is(err, tt.want), "want %v, got %v", tt.want, err)
+			}
+			assert.Equal(t, tt.location, short)
+			assert.Equal(t, tt.wantCall, atomic.LoadInt32(&calls))
+		})
+	}
+}
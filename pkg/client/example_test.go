@@ -0,0 +1,27 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+func Example() {
+	cl := NewREST("http://127.0.0.1:8080", DefaultConfig)
+	ctx := context.Background()
+
+	short, err := cl.Shorten(ctx, "https://example.com/some/long/path")
+	if err != nil && !errors.Is(err, ErrConflict) {
+		log.Fatalf("Oops, couldn't shorten: %v", err)
+	}
+
+	fmt.Println("Short link received:", short)
+
+	links, err := cl.ListMine(ctx)
+	if err != nil {
+		log.Fatalf("Oops, couldn't list links: %v", err)
+	}
+
+	fmt.Println("Links of the session:", len(links))
+}
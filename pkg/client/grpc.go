@@ -0,0 +1,220 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"sync"
+	shortener "url-shortener/pkg/api"
+)
+
+var (
+	_ Client = (*GRPC)(nil)
+)
+
+// Reasons of the errdetails.ErrorInfo the server attaches to the errors of the links it no longer serves.
+const (
+	reasonDeleted   = "LINK_DELETED"
+	reasonExhausted = "LINK_EXHAUSTED"
+)
+
+// GRPC client over the generated shortener.ShortenerClient.
+type GRPC struct {
+	client shortener.ShortenerClient
+	cfg    Config
+
+	mu    sync.RWMutex
+	token string
+}
+
+// NewGRPC GRPC struct constructor.
+func NewGRPC(client shortener.ShortenerClient, cfg Config) *GRPC {
+	return &GRPC{client: client, cfg: cfg, token: cfg.Token}
+}
+
+// Token returns the current session.
+func (c *GRPC) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.token
+}
+
+// Shorten returns the short URL of longURL.
+func (c *GRPC) Shorten(ctx context.Context, longURL string) (string, error) {
+	var resp *shortener.CreateResponse
+
+	err := c.call(ctx, false, func(ctx context.Context, opts ...grpc.CallOption) (err error) {
+		resp, err = c.client.CreateApi(ctx, &shortener.CreateRequest{Url: longURL}, opts...)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.GetShortened(), nil
+}
+
+// ShortenBatch shortens every item.
+func (c *GRPC) ShortenBatch(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	req := &shortener.BatchRequest{Urls: make([]*shortener.LongAndShortURL, len(items))}
+	for i, item := range items {
+		req.Urls[i] = &shortener.LongAndShortURL{CorrelationId: item.CorrelationID, OriginalUrl: item.OriginalURL}
+	}
+
+	var resp *shortener.BatchResponse
+
+	err := c.call(ctx, false, func(ctx context.Context, opts ...grpc.CallOption) (err error) {
+		resp, err = c.client.Batch(ctx, req, opts...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(resp.GetUrls()))
+	for i, u := range resp.GetUrls() {
+		results[i] = BatchResult{CorrelationID: u.GetCorrelationId(), ShortURL: u.GetShortUrl(), Status: u.GetStatus()}
+	}
+
+	return results, nil
+}
+
+// Resolve returns the original URL.
+func (c *GRPC) Resolve(ctx context.Context, short string) (string, error) {
+	var resp *shortener.GetResponse
+
+	err := c.call(ctx, true, func(ctx context.Context, opts ...grpc.CallOption) (err error) {
+		resp, err = c.client.Get(ctx, &shortener.GetRequest{Shortened: shortID(short)}, opts...)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.GetOriginalUrl(), nil
+}
+
+// ListMine returns links created in the session.
+func (c *GRPC) ListMine(ctx context.Context) ([]Link, error) {
+	var resp *shortener.GetAllByCookieResponse
+
+	err := c.call(ctx, true, func(ctx context.Context, opts ...grpc.CallOption) (err error) {
+		resp, err = c.client.GetAll(ctx, &shortener.GetAllByCookieRequest{}, opts...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]Link, len(resp.GetUrls()))
+	for i, u := range resp.GetUrls() {
+		links[i] = Link{OriginalURL: u.GetOriginalUrl(), ShortURL: u.GetShortUrl()}
+	}
+
+	return links, nil
+}
+
+// Delete marks links of the session as deleted.
+func (c *GRPC) Delete(ctx context.Context, shorts ...string) error {
+	ids := make([]string, len(shorts))
+	for i, short := range shorts {
+		ids[i] = shortID(short)
+	}
+
+	return c.call(ctx, true, func(ctx context.Context, opts ...grpc.CallOption) error {
+		_, err := c.client.Delete(ctx, &shortener.DeleteRequest{ShortenedUrls: ids}, opts...)
+		return err
+	})
+}
+
+// call invokes fn with the session in the metadata, keeps the session issued by the server
+// and retries transient errors. The calls that are not idempotent send the idempotency key of ctx
+// and are only retried with one.
+func (c *GRPC) call(ctx context.Context, idempotent bool, fn func(ctx context.Context, opts ...grpc.CallOption) error) error {
+	transient := grpcTransient
+
+	if !idempotent {
+		if key := idempotencyKeyFrom(ctx); key != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "idempotency-key", key)
+		} else {
+			transient = grpcRejected
+		}
+	}
+
+	return retry(ctx, c.cfg, transient, func() error {
+		callCtx := ctx
+		if token := c.Token(); token != "" {
+			callCtx = metadata.AppendToOutgoingContext(ctx, "token", token)
+		}
+
+		var header metadata.MD
+		err := fn(callCtx, grpc.Header(&header))
+
+		if values := header.Get("token"); len(values) > 0 {
+			c.mu.Lock()
+			c.token = values[0]
+			c.mu.Unlock()
+		}
+
+		return grpcError(err)
+	})
+}
+
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	e := &Error{Status: int(st.Code()), Code: st.Code().String(), Message: st.Message()}
+
+	switch st.Code() {
+	case codes.AlreadyExists:
+		e.kind = ErrConflict
+	case codes.NotFound:
+		e.kind = ErrNotFound
+	case codes.FailedPrecondition:
+		e.kind = ErrGone
+	}
+
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok &&
+			(info.GetReason() == reasonDeleted || info.GetReason() == reasonExhausted) {
+			e.kind = ErrGone
+		}
+	}
+
+	return e
+}
+
+func grpcTransient(err error) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		return false
+	}
+
+	switch codes.Code(e.Status) {
+	case codes.Unavailable:
+		return !errors.Is(e, ErrGone)
+	case codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+
+	return false
+}
+
+// grpcRejected reports the errors of the calls rejected before they were handled,
+// only these are retried for the calls that are not idempotent.
+func grpcRejected(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && codes.Code(e.Status) == codes.ResourceExhausted
+}
@@ -0,0 +1,253 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	_ Client = (*REST)(nil)
+)
+
+// REST client of the /api/v2 API.
+type REST struct {
+	baseURL string
+	cfg     Config
+	http    *http.Client
+
+	mu    sync.RWMutex
+	token string
+}
+
+// NewREST REST struct constructor. baseURL is the address of the server, e.g. http://127.0.0.1:8080.
+func NewREST(baseURL string, cfg Config) *REST {
+	hc := cfg.HTTPClient
+	if hc == nil {
+		jar, _ := cookiejar.New(nil)
+		hc = &http.Client{Jar: jar}
+	}
+
+	return &REST{
+		baseURL: strings.TrimRight(baseURL, "/") + "/api/v2",
+		cfg:     cfg,
+		http:    hc,
+		token:   cfg.Token,
+	}
+}
+
+// Token returns the current session.
+func (c *REST) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.token
+}
+
+// Shorten returns the short URL of longURL.
+func (c *REST) Shorten(ctx context.Context, longURL string) (string, error) {
+	var link Link
+
+	header, err := c.do(ctx, http.MethodPost, "/links", map[string]string{"url": longURL}, &link)
+	if errors.Is(err, ErrConflict) {
+		return header.Get("Location"), err
+	} else if err != nil {
+		return "", err
+	}
+
+	return link.ShortURL, nil
+}
+
+// ShortenBatch shortens every item.
+func (c *REST) ShortenBatch(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	var results []BatchResult
+
+	_, err := c.do(ctx, http.MethodPost, "/links/batch", items, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Resolve returns the original URL.
+func (c *REST) Resolve(ctx context.Context, short string) (string, error) {
+	var link Link
+
+	_, err := c.do(ctx, http.MethodGet, "/links/"+url.PathEscape(shortID(short)), nil, &link)
+	if err != nil {
+		return "", err
+	}
+
+	return link.OriginalURL, nil
+}
+
+// ListMine returns links created in the session.
+func (c *REST) ListMine(ctx context.Context) ([]Link, error) {
+	if c.Token() == "" {
+		return []Link{}, nil
+	}
+
+	var links []Link
+
+	_, err := c.do(ctx, http.MethodGet, "/user/links", nil, &links)
+	if err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// Delete marks links of the session as deleted.
+func (c *REST) Delete(ctx context.Context, shorts ...string) error {
+	ids := make([]string, len(shorts))
+	for i, short := range shorts {
+		ids[i] = shortID(short)
+	}
+
+	_, err := c.do(ctx, http.MethodDelete, "/user/links", ids, nil)
+	return err
+}
+
+// do sends the request with retries and decodes the response into out.
+func (c *REST) do(ctx context.Context, method, path string, in, out interface{}) (http.Header, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("can't marshal request: %w", err)
+		}
+	}
+
+	key := idempotencyKeyFrom(ctx)
+
+	transient := restTransient
+	if method == http.MethodPost && key == "" {
+		transient = restRejected
+	}
+
+	var header http.Header
+
+	err := retry(ctx, c.cfg, transient, func() error {
+		var err error
+		header, err = c.send(ctx, method, path, key, body, out)
+		return err
+	})
+
+	return header, err
+}
+
+func (c *REST) send(ctx context.Context, method, path, key string, body []byte, out interface{}) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if token := c.Token(); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if token := resp.Header.Get("Authorization"); token != "" {
+		c.mu.Lock()
+		c.token = token
+		c.mu.Unlock()
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.Header, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp.Header, restError(resp, data)
+	}
+
+	if out == nil || len(data) == 0 {
+		return resp.Header, nil
+	}
+
+	if err = json.Unmarshal(data, out); err != nil {
+		return resp.Header, fmt.Errorf("can't unmarshal response: %w", err)
+	}
+
+	return resp.Header, nil
+}
+
+func restError(resp *http.Response, data []byte) error {
+	e := &Error{Status: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+
+	var envelope struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id"`
+	}
+	if json.Unmarshal(data, &envelope) == nil {
+		e.Code, e.Message = envelope.Code, envelope.Message
+		if envelope.RequestID != "" {
+			e.RequestID = envelope.RequestID
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusConflict:
+		e.kind = ErrConflict
+	case http.StatusNotFound:
+		e.kind = ErrNotFound
+	case http.StatusGone:
+		e.kind = ErrGone
+	}
+
+	return e
+}
+
+func restTransient(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		switch e.Status {
+		case http.StatusTooManyRequests, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// restRejected reports the errors of the requests rejected before they were handled,
+// only these are retried for the requests that are not idempotent.
+func restRejected(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == http.StatusTooManyRequests
+}
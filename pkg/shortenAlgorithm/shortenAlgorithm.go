@@ -6,12 +6,16 @@ const (
 	alphabet string = "AB1CDEFG2HIJKLM3NOPQRS4TUVW5XYZabc6defgh7ijklmn8opqrs9tuvw0xyz"
 )
 
-// GetShortName generates a short string equivalent for digit.
-func GetShortName(lastID int) (string, error) {
+func newHashID() (*hashids.HashID, error) {
 	hd := hashids.NewData()
 	hd.Salt = alphabet
 
-	h, err := hashids.NewWithData(hd)
+	return hashids.NewWithData(hd)
+}
+
+// GetShortName generates a short string equivalent for digit.
+func GetShortName(lastID int) (string, error) {
+	h, err := newHashID()
 	if err != nil {
 		return "", err
 	}
@@ -23,3 +27,28 @@ func GetShortName(lastID int) (string, error) {
 
 	return id, nil
 }
+
+// GetShardedName generates a short string equivalent for digit with the shard encoded in it.
+func GetShardedName(lastID, shard int) (string, error) {
+	h, err := newHashID()
+	if err != nil {
+		return "", err
+	}
+
+	return h.Encode([]int{lastID, shard})
+}
+
+// GetShard returns the shard encoded by GetShardedName, false if name has none.
+func GetShard(name string) (int, bool) {
+	h, err := newHashID()
+	if err != nil {
+		return 0, false
+	}
+
+	numbers, err := h.DecodeWithError(name)
+	if err != nil || len(numbers) != 2 {
+		return 0, false
+	}
+
+	return numbers[1], true
+}
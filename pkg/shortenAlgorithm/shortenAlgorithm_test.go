@@ -28,3 +28,31 @@ func TestGetShortName(t *testing.T) {
 		})
 	}
 }
+
+func TestGetShard(t *testing.T) {
+	sharded, err := GetShardedName(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, _ := GetShortName(1)
+
+	tests := []struct {
+		name      string
+		short     string
+		wantShard int
+		wantOK    bool
+	}{
+		{name: "sharded", short: sharded, wantShard: 3, wantOK: true},
+		{name: "plain", short: plain},
+		{name: "custom", short: "my-link"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shard, ok := GetShard(tt.short)
+			if shard != tt.wantShard || ok != tt.wantOK {
+				t.Errorf("GetShard() got = %v, %v, want %v, %v", shard, ok, tt.wantShard, tt.wantOK)
+			}
+		})
+	}
+}